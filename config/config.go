@@ -5,20 +5,52 @@ import (
 )
 
 type QKVConfig struct {
-	Address            string `toml:"address"`
-	Auth               string `toml:"auth"`
-	LogFile            string `toml:"logfile"`
-	LogLevel           string `toml:"loglevel"`
-	Maxproc            int    `toml:"maxproc"`
-	TTLCheckerLoop     int    `toml:"ttl_checker_loop"`
-	TTLCheckerInterval int    `toml:"ttl_checker_interval"`
+	Address              string `toml:"address"`
+	Auth                 string `toml:"auth"`
+	LogFile              string `toml:"logfile"`
+	LogLevel             string `toml:"loglevel"`
+	Maxproc              int    `toml:"maxproc"`
+	TTLCheckerLoop       int    `toml:"ttl_checker_loop"`
+	TTLCheckerInterval   int    `toml:"ttl_checker_interval"`
+	GCBatch              int    `toml:"gc_batch"`
+	GCInterval           int    `toml:"gc_interval"`
+	LeaseTimeout         int    `toml:"lease_timeout"`
+	PubSubPollInterval   int    `toml:"pubsub_poll_interval"`
+	PubSubRetention      int    `toml:"pubsub_retention"`
+	NotifyKeyspaceEvents string `toml:"notify_keyspace_events"`
+	TxnRetryLimit        int    `toml:"txn_retry_limit"`
+	TxnRetryBackoff      int    `toml:"txn_retry_backoff"`
+	TxnRetryMaxBackoff   int    `toml:"txn_retry_max_backoff"`
+	StatusAddress        string `toml:"status_address"`
+	Databases            int    `toml:"databases"`
+	DBMapReloadInterval  int    `toml:"db_map_reload_interval"`
+	QuotaInterval        int    `toml:"quota_interval"`
+	ACLReloadInterval    int    `toml:"acl_reload_interval"`
+	TLSAddress           string `toml:"tls_address"`
+	TLSCertFile          string `toml:"tls_cert_file"`
+	TLSKeyFile           string `toml:"tls_key_file"`
+	TLSCAFile            string `toml:"tls_ca_file"`
+	TLSAuthClients       string `toml:"tls_auth_clients"`
 }
 type TikvConfig struct {
-	Pds string `toml:"pds"`
+	Pds            string   `toml:"pds"`
+	PdEndpoints    []string `toml:"pd_endpoints"`
+	CAPath         string   `toml:"ca_path"`
+	CertPath       string   `toml:"cert_path"`
+	KeyPath        string   `toml:"key_path"`
+	DialTimeout    int      `toml:"dial_timeout"`
+	ConnectTimeout int      `toml:"connect_timeout"`
+}
+type TenantConfig struct {
+	Name     string `toml:"name"`
+	Password string `toml:"password"`
+	MaxKeys  int64  `toml:"max_keys"`
+	MaxBytes int64  `toml:"max_bytes"`
 }
 type Config struct {
-	QKV  QKVConfig  `toml:"qkv"`
-	Tikv TikvConfig `toml:"tikv"`
+	QKV     QKVConfig      `toml:"qkv"`
+	Tikv    TikvConfig     `toml:"tikv"`
+	Tenants []TenantConfig `toml:"tenants"`
 }
 
 func InitConfig(configFile string) (conf *Config) {
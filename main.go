@@ -5,13 +5,16 @@ import (
 	"os"
 
 	"github.com/chuangyou/qkv/config"
+	"github.com/chuangyou/qkv/metrics"
 	"github.com/chuangyou/qkv/server"
+	"github.com/chuangyou/qkv/tidis"
 	log "github.com/sirupsen/logrus"
 	caller "github.com/xdxiaodong/logrus-hook-caller"
 )
 
 var (
-	ConfigFile = flag.String("c", "./config.toml", "config filename")
+	ConfigFile  = flag.String("c", "./config.toml", "config filename")
+	MigrateZSet = flag.Bool("migrate-zset", false, "convert zsets stored with integer scores to float scores, build the rank index of the older zsets and exit")
 )
 
 func main() {
@@ -33,12 +36,32 @@ func main() {
 	if conf.QKV.LogLevel == "info" {
 		log.SetLevel(log.InfoLevel)
 	}
+	if *MigrateZSet {
+		tdb, err := tidis.NewTidis(conf)
+		if err != nil {
+			panic(err)
+		}
+		migrated, err := tdb.MigrateZSetScores()
+		if err != nil {
+			log.Fatalf("migrate zset scores failed after %d keys: %v", migrated, err)
+		}
+		log.Infof("migrated %d zset keys to float scores with the rank index", migrated)
+		return
+	}
 	qkvServer, err := server.NewServer(conf)
 	if err != nil {
 		panic(err)
 	}
 	go qkvServer.Start()
 	go qkvServer.TTLCheck()
-	InitSignal()
+	go qkvServer.GC()
+	go qkvServer.Quota()
+	go qkvServer.ACL()
+	go qkvServer.DBMap()
+	go qkvServer.PubSub()
+	if conf.QKV.StatusAddress != "" {
+		go metrics.Serve(conf.QKV.StatusAddress)
+	}
+	InitSignal(qkvServer)
 
 }
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	//TxnRetryCounter counts auto-commit transaction retries, by result
+	TxnRetryCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "qkv",
+			Subsystem: "txn",
+			Name:      "retry_total",
+			Help:      "Counter of auto-commit transaction retries.",
+		}, []string{"result"})
+	//WorkerLeaderGauge 1 if this instance holds the lease of the background worker
+	WorkerLeaderGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "qkv",
+			Subsystem: "worker",
+			Name:      "leader",
+			Help:      "Whether this instance holds the lease of the background worker.",
+		}, []string{"worker"})
+	//ExpiredKeysCounter counts keys removed by the ttl checker
+	ExpiredKeysCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "qkv",
+			Subsystem: "ttl_checker",
+			Name:      "expired_keys_total",
+			Help:      "Counter of expired keys removed by the ttl checker.",
+		})
+	//ExpireLagGauge age of the oldest expired key the ttl checker has not removed yet
+	ExpireLagGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "qkv",
+			Subsystem: "ttl_checker",
+			Name:      "lag_seconds",
+			Help:      "Age of the oldest expired key not removed yet, 0 when there is no backlog.",
+		})
+	//ExpireBacklogGauge expired keys waiting for the ttl checker, capped at one scan
+	ExpireBacklogGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "qkv",
+			Subsystem: "ttl_checker",
+			Name:      "backlog_keys",
+			Help:      "Expired keys not removed yet, counted up to 10000.",
+		})
+	//TenantKeysGauge keys of the tenant at the last repair of its usage counters by the quota worker
+	TenantKeysGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "qkv",
+			Subsystem: "tenant",
+			Name:      "keys",
+			Help:      "Keys of the tenant at the last repair of its usage counters by the quota worker.",
+		}, []string{"tenant"})
+	//TenantBytesGauge bytes of the keys and values of the tenant at the last repair of its usage counters by the quota worker
+	TenantBytesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "qkv",
+			Subsystem: "tenant",
+			Name:      "bytes",
+			Help:      "Bytes of the keys and values of the tenant at the last repair of its usage counters by the quota worker.",
+		}, []string{"tenant"})
+	//GCPurgedCounter counts member keys purged by the gc
+	GCPurgedCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "qkv",
+			Subsystem: "gc",
+			Name:      "purged_keys_total",
+			Help:      "Counter of member keys of deleted collections purged by the gc.",
+		})
+)
+
+func init() {
+	prometheus.MustRegister(TxnRetryCounter)
+	prometheus.MustRegister(WorkerLeaderGauge)
+	prometheus.MustRegister(ExpiredKeysCounter)
+	prometheus.MustRegister(ExpireLagGauge)
+	prometheus.MustRegister(ExpireBacklogGauge)
+	prometheus.MustRegister(GCPurgedCounter)
+	prometheus.MustRegister(TenantKeysGauge)
+	prometheus.MustRegister(TenantBytesGauge)
+}
+
+//Serve expose the metrics on http://addr/metrics
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Errorf("metrics http.ListenAndServe(\"%s\") error(%v)", addr, err)
+	}
+}
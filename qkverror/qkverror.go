@@ -5,18 +5,71 @@ import (
 )
 
 var (
-	ErrorServerNoAuthNeed = errors.New("client sent auth, but server no password")
-	ErrorAuthFailed       = errors.New("client sent a invalid password ")
-	ErrorNoAuth           = errors.New("client no authentication")
-	ErrorCommand          = errors.New("command invalid")
-	ErrorCommandParams    = errors.New("command params invalid")
-	ErrorUnknownType      = errors.New("unknown response data type")
-	ErrorKeyEmpty         = errors.New("key can't be empty")
-	ErrorServerInternal   = errors.New("server internal error")
-	ErrorTypeNotMatch     = errors.New("key type not match")
-	ErrorInvalidMeta      = errors.New("invalid key meta")
-	ErrorInvalidRawData   = errors.New("invalid raw data")
-	ErrorWrongType        = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
-	ErrorNotInteger       = errors.New("value is not an integer or out of range")
-	ErrorOutOfRange       = errors.New("index out of range")
+	ErrorServerNoAuthNeed       = errors.New("client sent auth, but server no password")
+	ErrorAuthFailed             = errors.New("client sent a invalid password ")
+	ErrorNoAuth                 = errors.New("client no authentication")
+	ErrorCommand                = errors.New("command invalid")
+	ErrorCommandParams          = errors.New("command params invalid")
+	ErrorUnknownType            = errors.New("unknown response data type")
+	ErrorKeyEmpty               = errors.New("key can't be empty")
+	ErrorServerInternal         = errors.New("server internal error")
+	ErrorTypeNotMatch           = errors.New("key type not match")
+	ErrorInvalidMeta            = errors.New("invalid key meta")
+	ErrorInvalidRawData         = errors.New("invalid raw data")
+	ErrorWrongType              = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrorNotInteger             = errors.New("value is not an integer or out of range")
+	ErrorNotFloat               = errors.New("value is not a valid float")
+	ErrorMinMaxNotFloat         = errors.New("min or max is not a float")
+	ErrorScoreNaN               = errors.New("resulting score is not a number (NaN)")
+	ErrorOutOfRange             = errors.New("index out of range")
+	ErrorNoSuchKey              = errors.New("no such key")
+	ErrorInvalidCursor          = errors.New("invalid cursor")
+	ErrorNestedMulti            = errors.New("MULTI calls can not be nested")
+	ErrorWatchInMulti           = errors.New("WATCH inside MULTI is not allowed")
+	ErrorWatchedKeyModified     = errors.New("watched key modified")
+	ErrorTimeoutNotFloat        = errors.New("timeout is not a float or out of range")
+	ErrorTimeoutNegative        = errors.New("timeout is negative")
+	ErrorSubscribeInMulti       = errors.New("SUBSCRIBE inside MULTI is not allowed")
+	ErrorSubscribedContext      = errors.New("only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING are allowed in this context")
+	ErrorNotifyKeyspaceEvents   = errors.New("invalid notify_keyspace_events")
+	ErrorStreamIDInvalid        = errors.New("Invalid stream ID specified as stream command argument")
+	ErrorStreamIDSmall          = errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	ErrorStreamIDZero           = errors.New("The ID specified in XADD must be greater than 0-0")
+	ErrorStreamFields           = errors.New("wrong number of fields and values for stream entry")
+	ErrorStreamNoKey            = errors.New("The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	ErrorBusyGroup              = errors.New("BUSYGROUP Consumer Group name already exists")
+	ErrorNoGroup                = errors.New("NOGROUP No such key or consumer group")
+	ErrorBitOffset              = errors.New("bit offset is not an integer or out of range")
+	ErrorBitValue               = errors.New("bit is not an integer or out of range")
+	ErrorBitfieldType           = errors.New("Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	ErrorBitopNot               = errors.New("BITOP NOT must be called with a single source key.")
+	ErrorInvalidHLL             = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	ErrorCorruptedHLL           = errors.New("INVALIDOBJ Corrupted HLL object detected")
+	ErrorGeoPosition            = errors.New("invalid longitude,latitude pair")
+	ErrorGeoMember              = errors.New("could not decode requested zset member")
+	ErrorGeoUnit                = errors.New("unsupported unit provided. please use M, KM, FT, MI")
+	ErrorOffsetRange            = errors.New("offset is out of range")
+	ErrorStringSize             = errors.New("string exceeds maximum allowed size (proto-max-bulk-len)")
+	ErrorIncrNaN                = errors.New("increment would produce NaN or Infinity")
+	ErrorInvalidExpire          = errors.New("invalid expire time in command")
+	ErrorRankZero               = errors.New("RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+	ErrorHashNotFloat           = errors.New("hash value is not a float")
+	ErrorNumFields              = errors.New("the numfields parameter must match the number of arguments")
+	ErrorInvalidDB              = errors.New("DB index is out of range")
+	ErrorSameObject             = errors.New("source and destination objects are the same")
+	ErrorNotInMulti             = errors.New("command not allowed inside MULTI")
+	ErrorReservedKey            = errors.New("keys and channels starting with the bytes 0xfc to 0xfe are reserved")
+	ErrorTenantName             = errors.New("tenant names must be unique, not default, 1 to 255 letters, digits, '_' or '-'")
+	ErrorTenantPassword         = errors.New("tenant passwords must be unique, not empty and not the auth of the config")
+	ErrorACLRule                = errors.New("Error in ACL SETUSER modifier: Syntax error")
+	ErrorACLUser                = errors.New("the username is empty, contains spaces or is the default user or a tenant")
+	ErrorNoPermission           = errors.New("NOPERM this user has no permissions to run this command")
+	ErrorNoKeyPermission        = errors.New("NOPERM this user has no permissions to access one of the keys used as arguments")
+	ErrorTLSAuthClients         = errors.New("tls_auth_clients must be no, optional or yes, with a tls_ca_file unless no")
+	ErrorTLSCAFile              = errors.New("no certificate found in tls_ca_file")
+	ErrorTikvSecurity           = errors.New("tikv ca_path, cert_path and key_path must be set together")
+	ErrorTikvNoPd               = errors.New("no pd endpoint in tikv pds or pd_endpoints")
+	ErrorQuotaExceeded          = errors.New("OOM command not allowed when the tenant quota is exceeded")
+	ErrorDeleteRangeUnsupported = errors.New("the store does not support delete range")
+	ErrorReservedKeysInUse      = errors.New("db 0 has keys starting with the bytes 0xfc to 0xfe written by a previous release, rename or delete them with that release before upgrading")
 )
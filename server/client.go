@@ -5,9 +5,12 @@ import (
 	"context"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/chuangyou/qkv/qkverror"
 	"github.com/chuangyou/qkv/tidis"
+	"github.com/chuangyou/qkv/utils"
 	"github.com/pingcap/tidb/kv"
 	"github.com/siddontang/goredis"
 	log "github.com/sirupsen/logrus"
@@ -32,10 +35,27 @@ type Client struct {
 	isTxn   bool
 	txn     kv.Transaction
 	respTxn []interface{}
+	watched [][]byte
+	//db the database selected by SELECT, the keys of the commands are put in its namespace
+	db int
+	//tenant authenticated by AUTH, nil for the keys outside of the tenants
+	tenant *tidis.Tenant
+	//user the ACL user authenticated by AUTH, empty for the default user and the tenants
+	user string
+	//pub/sub state. While the client is subscribed its replies and messages are queued in pushes and written
+	//by pushLoop, so that a publisher never waits for the socket of a subscriber. pushing is guarded by wmu.
+	pubsub   *PubSub
+	channels map[string]bool
+	patterns map[string]bool
+	pushes   chan interface{}
+	pushing  bool
+	wmu      sync.Mutex
+	//closed is closed when the connection is done
+	closed chan struct{}
 }
 
 //NewClient new a client for process redis protocol request
-func NewClient(conn net.Conn, tdb *tidis.Tidis, auth string) *Client {
+func NewClient(conn net.Conn, tdb *tidis.Tidis, auth string, pubsub *PubSub) *Client {
 	client := new(Client)
 	client.conn = conn
 	client.auth = auth
@@ -44,6 +64,10 @@ func NewClient(conn net.Conn, tdb *tidis.Tidis, auth string) *Client {
 	client.r = goredis.NewRespReader(client.br)
 	client.w = goredis.NewRespWriter(client.bw)
 	client.tdb = tdb
+	client.pubsub = pubsub
+	client.channels = make(map[string]bool)
+	client.patterns = make(map[string]bool)
+	client.closed = make(chan struct{})
 	return client
 }
 
@@ -62,34 +86,59 @@ func (c *Client) ProcessRequest(req [][]byte) (err error) {
 	}
 	if c.cmd != "AUTH" {
 		if !c.isAuth {
-			c.FlushResp(qkverror.ErrorNoAuth)
+			//the user of a subscribed client may have been removed, pushLoop writes its replies
+			c.flushLocked(qkverror.ErrorNoAuth)
 			return nil
 		}
 	}
 	log.Debugf("command: %s argc:%d", c.cmd, len(c.args))
+	//a subscribed client only manages its subscriptions
+	if c.subscriptionCount() > 0 {
+		switch c.cmd {
+		case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE":
+		case "PING":
+			c.flushLocked("PONG")
+			return nil
+		default:
+			c.flushLocked(qkverror.ErrorSubscribedContext)
+			return nil
+		}
+	}
 	switch c.cmd {
 	case "AUTH":
-		if len(c.args) != 1 {
+		if len(c.args) != 1 && len(c.args) != 2 {
 			c.FlushResp(qkverror.ErrorCommandParams)
+			return nil
 		}
-		if c.auth == "" {
+		if c.auth == "" && len(c.tdb.Tenants()) == 0 && len(c.args) == 1 {
 			c.FlushResp(qkverror.ErrorServerNoAuthNeed)
-		} else if string(c.args[0]) != c.auth {
+		} else if tenant, user, ok := c.authenticate(); !ok {
 			c.isAuth = false
+			c.tenant = nil
+			c.user = ""
 			c.FlushResp(qkverror.ErrorAuthFailed)
 		} else {
 			c.isAuth = true
+			c.tenant = tenant
+			c.user = user
 			c.w.FlushString("OK")
 		}
 		return nil
 	case "MULTI":
 		log.Debugf("client transaction")
-		c.txn, err = c.tdb.NewTxn()
-		if err != nil {
-			c.resetTxn()
-			c.w.FlushBulk(nil)
+		if c.isTxn {
+			c.w.FlushError(qkverror.ErrorNestedMulti)
 			return nil
 		}
+		//WATCH already started the transaction at its timestamp
+		if c.txn == nil {
+			c.txn, err = c.tdb.NewTxn()
+			if err != nil {
+				c.resetTxn()
+				c.w.FlushBulk(nil)
+				return nil
+			}
+		}
 		c.isTxn = true
 		c.cmds = []Command{}
 		c.respTxn = []interface{}{}
@@ -99,18 +148,29 @@ func (c *Client) ProcessRequest(req [][]byte) (err error) {
 	case "EXEC":
 		log.Debugf("command length : %d  txn:%v", len(c.cmds), c.isTxn)
 		if len(c.cmds) == 0 || !c.isTxn {
+			if c.txn != nil {
+				c.txn.Rollback()
+			}
 			c.w.FlushBulk(nil)
 			c.resetTxn()
 			return nil
 		}
 		for _, cmd := range c.cmds {
 			log.Debugf("execute command: %s", cmd.cmd)
+			if cmd.cmd == "UNWATCH" {
+				//the watched keys are checked below anyway and dropped after EXEC, as in redis
+				c.Resp("OK")
+				continue
+			}
 			c.cmd = cmd.cmd
 			c.args = cmd.args
 			if err = c.execute(); err != nil {
 				break
 			}
 		}
+		if err == nil && len(c.watched) > 0 {
+			err = c.checkWatched()
+		}
 		if err != nil {
 			c.txn.Rollback()
 			c.w.FlushBulk(nil)
@@ -118,6 +178,9 @@ func (c *Client) ProcessRequest(req [][]byte) (err error) {
 			err = c.txn.Commit(context.Background())
 			if err == nil {
 				c.w.FlushArray(c.respTxn)
+			} else if err == qkverror.ErrorQuotaExceeded {
+				//the writes of the transaction would take the tenant over its quota
+				c.w.FlushError(err)
 			} else {
 				c.w.FlushBulk(nil)
 			}
@@ -126,12 +189,65 @@ func (c *Client) ProcessRequest(req [][]byte) (err error) {
 		return nil
 	case "DISCARD":
 		// discard transactional commands
-		if c.isTxn {
+		if c.txn != nil {
 			err = c.txn.Rollback()
 		}
 		c.w.FlushString("OK")
 		c.resetTxn()
 		return err
+	case "WATCH":
+		if len(c.args) < 1 {
+			c.FlushResp(qkverror.ErrorCommandParams)
+			return nil
+		}
+		if c.isTxn {
+			c.w.FlushError(qkverror.ErrorWatchInMulti)
+			return nil
+		}
+		//the transaction start timestamp is the point watched keys are compared against
+		if c.txn == nil {
+			c.txn, err = c.tdb.NewTxn()
+			if err != nil {
+				c.resetTxn()
+				c.FlushResp(err)
+				return nil
+			}
+		}
+		if err = c.checkPermission(); err != nil {
+			c.FlushResp(err)
+			return nil
+		}
+		for _, key := range c.args {
+			key, err = c.keyInDB(key)
+			if err != nil {
+				c.FlushResp(err)
+				return nil
+			}
+			c.watched = append(c.watched, key)
+		}
+		c.w.FlushString("OK")
+		return nil
+	case "UNWATCH":
+		if c.isTxn {
+			//queued like in redis, where it does not unwatch the keys of the transaction
+			c.cmds = append(c.cmds, Command{cmd: c.cmd, args: c.args})
+			c.w.FlushString("QUEUED")
+			return nil
+		}
+		if c.txn != nil {
+			c.txn.Rollback()
+			c.txn = nil
+		}
+		c.watched = nil
+		c.w.FlushString("OK")
+		return nil
+	case "SUBSCRIBE", "UNSUBSCRIBE", "PSUBSCRIBE", "PUNSUBSCRIBE":
+		if err = c.checkPermission(); err != nil {
+			c.flushLocked(err)
+			return nil
+		}
+		c.subscribeCommand()
+		return nil
 	case "PING":
 		if len(c.args) != 0 {
 			c.FlushResp(qkverror.ErrorCommandParams)
@@ -140,6 +256,11 @@ func (c *Client) ProcessRequest(req [][]byte) (err error) {
 		return nil
 	}
 	if c.isTxn {
+		//checked again when EXEC runs it
+		if err = c.checkPermission(); err != nil {
+			c.FlushResp(err)
+			return nil
+		}
 		command = Command{cmd: c.cmd, args: c.args}
 		c.cmds = append(c.cmds, command)
 		log.Debugf("command:%s added to transaction queue, queue size:%d", c.cmd, len(c.cmds))
@@ -186,6 +307,33 @@ func (c *Client) Resp(resp interface{}) error {
 
 	return err
 }
+
+//flushLocked write resp to a client that may receive pub/sub messages, after the messages queued before it
+func (c *Client) flushLocked(resp interface{}) (err error) {
+	if c.pushing {
+		c.pushes <- resp
+		return
+	}
+	return c.writeResp(resp)
+}
+
+//writeResp write and flush a reply outside of the transactions
+func (c *Client) writeResp(resp interface{}) (err error) {
+	switch v := resp.(type) {
+	case []interface{}:
+		err = c.w.WriteArray(v)
+	case string:
+		err = c.w.WriteString(v)
+	case error:
+		err = c.w.WriteError(v)
+	default:
+		err = qkverror.ErrorUnknownType
+	}
+	if err != nil {
+		return
+	}
+	return c.w.Flush()
+}
 func (c *Client) GetTxn() kv.Transaction {
 	if c.isTxn {
 		return c.txn
@@ -195,17 +343,44 @@ func (c *Client) GetTxn() kv.Transaction {
 }
 func (c *Client) resetTxn() {
 	c.isTxn = false
+	c.txn = nil
+	c.watched = nil
 	c.cmds = []Command{}
 	c.respTxn = []interface{}{}
 }
+
+//checkWatched abort the transaction if the meta of a watched key changed after WATCH. The metas are also locked,
+//every write locks the meta of its key too, so any write after WATCH, even one leaving the meta unchanged,
+//conflicts with the commit in TiKV. A SWAPDB after WATCH changes the keys in the databases, it aborts it too.
+func (c *Client) checkWatched() (err error) {
+	var (
+		modified bool
+		keys     []kv.Key
+	)
+	modified, err = c.tdb.KeysModified(c.txn, append([][]byte{c.tdb.DBMapKey(c.tenant)}, c.watched...))
+	if err != nil {
+		return
+	}
+	if modified {
+		err = qkverror.ErrorWatchedKeyModified
+		return
+	}
+	keys = make([]kv.Key, len(c.watched))
+	for i, key := range c.watched {
+		keys[i] = key
+	}
+	return c.txn.LockKeys(keys...)
+}
 func (c *Client) execute() error {
 	var err error
 	if len(c.cmd) == 0 {
 		err = qkverror.ErrorCommand
 	} else if f, ok := getCommandFunc(c.cmd); !ok {
 		err = qkverror.ErrorCommand
-	} else {
-		err = f(c)
+	} else if err = c.checkPermission(); err == nil {
+		if err = c.argsInDB(); err == nil {
+			err = f(c)
+		}
 	}
 	if err != nil && !c.isTxn {
 		c.w.FlushError(err)
@@ -213,3 +388,129 @@ func (c *Client) execute() error {
 	c.w.Flush()
 	return err
 }
+
+//watchDisconnect detect the client closing the connection while it is blocked, gone is closed when it does.
+//The connection is peeked so the next request pipelined by the client is left in the buffer, stop must be called
+//before the connection is read again.
+func (c *Client) watchDisconnect() (gone <-chan struct{}, stop func()) {
+	var (
+		closed = make(chan struct{})
+		done   = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		_, err := c.br.Peek(1)
+		if ne, ok := err.(net.Error); err != nil && !(ok && ne.Timeout()) {
+			close(closed)
+		}
+	}()
+	stop = func() {
+		//wake the peek up, the timeout is not kept by the reader
+		c.conn.SetReadDeadline(time.Now())
+		<-done
+		c.conn.SetReadDeadline(time.Time{})
+	}
+	return closed, stop
+}
+
+//argsInDB put the key arguments of the command in the namespace of the database of the client
+func (c *Client) argsInDB() (err error) {
+	var (
+		keys []int
+		args [][]byte
+	)
+	keys = getCommandKeys(c.cmd, c.args)
+	if len(keys) == 0 {
+		return
+	}
+	//the arguments of a queued command are kept as they are
+	args = make([][]byte, len(c.args))
+	copy(args, c.args)
+	for _, i := range keys {
+		args[i], err = c.keyInDB(args[i])
+		if err != nil {
+			return
+		}
+	}
+	c.args = args
+	return
+}
+
+//keyInDB key in the namespace of the database of the client, the keys of db 0 can't look like the internal keys
+//or the keys of another database or of a tenant
+func (c *Client) keyInDB(key []byte) ([]byte, error) {
+	if utils.IsReservedKey(key) {
+		return nil, qkverror.ErrorReservedKey
+	}
+	return c.tdb.Key(c.tenant, c.db, key), nil
+}
+
+//namespace the namespace of the database of the client
+func (c *Client) namespace() []byte {
+	return c.tdb.Namespace(c.tenant, c.db)
+}
+
+//authenticate AUTH [name] password. The password of the config is the password of the keys outside of the tenants,
+//its user is "default" as in redis, a tenant is found by its name and password or by its password only.
+//Any other name is an ACL user, it also uses the keys outside of the tenants.
+func (c *Client) authenticate() (tenant *tidis.Tenant, user string, ok bool) {
+	var (
+		name     string
+		password string
+		err      error
+	)
+	if len(c.args) == 2 {
+		name, password = string(c.args[0]), string(c.args[1])
+	} else {
+		password = string(c.args[0])
+	}
+	if (name == "" || name == "default") && c.auth != "" && password == c.auth {
+		return nil, "", true
+	}
+	if len(c.args) == 2 && name == "" {
+		return nil, "", false
+	}
+	if tenant, ok = c.tdb.Authenticate(name, password); ok || name == "" {
+		return
+	}
+	ok, err = c.tdb.ACLAuthenticate(name, password)
+	if err != nil {
+		log.Warnf("acl authenticate %s failed, %s", name, err.Error())
+		ok = false
+	}
+	return nil, name, ok
+}
+
+//checkPermission refuse the command, or one of its keys, the ACL user of the client is not allowed to use.
+//A user deleted or disabled since AUTH is logged out.
+func (c *Client) checkPermission() error {
+	var (
+		user *tidis.ACLUser
+		ok   bool
+	)
+	if c.user == "" {
+		return nil
+	}
+	user, ok = c.tdb.ACLUser(c.user)
+	if !ok || !user.Enabled {
+		c.isAuth = false
+		c.user = ""
+		return qkverror.ErrorNoAuth
+	}
+	//WATCH only needs the permission of its keys, any user may ask who it is
+	if c.cmd != "WATCH" && !c.isWhoami() && !user.CanRun(c.cmd, getCommandCategories(c.cmd)) {
+		return qkverror.ErrorNoPermission
+	}
+	for _, i := range getCommandKeys(c.cmd, c.args) {
+		if !user.CanAccess(c.args[i]) {
+			return qkverror.ErrorNoKeyPermission
+		}
+	}
+	return nil
+}
+
+//userKey key of the database of the client without its namespace, for the replies naming a key
+func (c *Client) userKey(key []byte) []byte {
+	_, key = utils.DecodeDBKey(key)
+	return key
+}
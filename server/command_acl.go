@@ -0,0 +1,240 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/tidis"
+)
+
+//aclCategories the categories of the command rules of ACL SETUSER
+var aclCategories = map[string]bool{
+	"all":        true,
+	"read":       true,
+	"write":      true,
+	"admin":      true,
+	"pubsub":     true,
+	"connection": true,
+}
+
+func init() {
+	commandRegister("ACL", aclCommand)
+}
+
+//ACL SETUSER username [rule ...] | GETUSER username | LIST | DELUSER username [username ...] | WHOAMI
+func aclCommand(c *Client) (err error) {
+	var (
+		user       *tidis.ACLUser
+		users      []*tidis.ACLUser
+		names      []string
+		rules      []string
+		deleted    int64
+		resp       []interface{}
+		subcommand string
+	)
+	if len(c.args) < 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	subcommand = strings.ToUpper(string(c.args[0]))
+	if subcommand == "WHOAMI" {
+		if len(c.args) != 1 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		return c.Resp([]byte(c.whoami()))
+	}
+	//the users are shared by all the tenants, a tenant only manages its own keys
+	if c.tenant != nil {
+		err = qkverror.ErrorNoPermission
+		return
+	}
+	switch subcommand {
+	case "SETUSER":
+		if len(c.args) < 2 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		if c.isTxn {
+			err = qkverror.ErrorNotInMulti
+			return
+		}
+		if err = c.checkACLName(string(c.args[1])); err != nil {
+			return
+		}
+		for _, arg := range c.args[2:] {
+			if err = checkACLRule(string(arg)); err != nil {
+				return
+			}
+			rules = append(rules, string(arg))
+		}
+		err = c.tdb.SetACLUser(nil, string(c.args[1]), rules)
+		if err != nil {
+			return
+		}
+		return c.Resp("OK")
+	case "GETUSER":
+		if len(c.args) != 2 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		user, err = c.tdb.GetACLUser(c.GetTxn(), string(c.args[1]))
+		if err != nil {
+			return
+		}
+		if user == nil {
+			return c.Resp(nil)
+		}
+		return c.Resp(aclUserInfo(user))
+	case "LIST":
+		if len(c.args) != 1 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		users, err = c.tdb.ACLUsers(c.GetTxn())
+		if err != nil {
+			return
+		}
+		resp = make([]interface{}, 0, len(users)+1)
+		if c.auth == "" {
+			resp = append(resp, []byte("user default on nopass ~* +@all"))
+		} else {
+			resp = append(resp, []byte("user default on #"+tidis.HashPassword(c.auth)+" ~* +@all"))
+		}
+		for _, user = range users {
+			resp = append(resp, []byte(aclUserRules(user)))
+		}
+		return c.Resp(resp)
+	case "DELUSER":
+		if len(c.args) < 2 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		if c.isTxn {
+			err = qkverror.ErrorNotInMulti
+			return
+		}
+		for _, arg := range c.args[1:] {
+			if string(arg) == "default" {
+				err = qkverror.ErrorACLUser
+				return
+			}
+			names = append(names, string(arg))
+		}
+		deleted, err = c.tdb.DelACLUsers(nil, names)
+		if err != nil {
+			return
+		}
+		return c.Resp(deleted)
+	default:
+		err = qkverror.ErrorCommandParams
+		return
+	}
+}
+
+//whoami the user of the client, the name of its tenant or "default"
+func (c *Client) whoami() string {
+	if c.tenant != nil {
+		return c.tenant.Name
+	}
+	if c.user != "" {
+		return c.user
+	}
+	return "default"
+}
+
+//isWhoami whether the command of the client is ACL WHOAMI
+func (c *Client) isWhoami() bool {
+	return c.cmd == "ACL" && len(c.args) == 1 && strings.ToUpper(string(c.args[0])) == "WHOAMI"
+}
+
+//checkACLName an ACL user can't be named as the default user or a tenant, AUTH would find them first
+func (c *Client) checkACLName(name string) error {
+	if name == "" || name == "default" || strings.ContainsAny(name, " \t\r\n") {
+		return qkverror.ErrorACLUser
+	}
+	for _, tenant := range c.tdb.Tenants() {
+		if tenant.Name == name {
+			return qkverror.ErrorACLUser
+		}
+	}
+	return nil
+}
+
+//checkACLRule the commands and categories of the command rules must exist
+func checkACLRule(rule string) error {
+	var (
+		name string
+	)
+	if len(rule) < 2 || (rule[0] != '+' && rule[0] != '-') {
+		//the other rules are checked by tidis
+		return nil
+	}
+	name = strings.ToLower(rule[1:])
+	if name[0] == '@' {
+		if !aclCategories[name[1:]] {
+			return qkverror.ErrorACLRule
+		}
+		return nil
+	}
+	if _, ok := getCommandFunc(strings.ToUpper(name)); ok {
+		return nil
+	}
+	if _, ok := commandCategories[strings.ToUpper(name)]; ok {
+		return nil
+	}
+	return qkverror.ErrorACLRule
+}
+
+//aclUserInfo the reply of ACL GETUSER
+func aclUserInfo(user *tidis.ACLUser) []interface{} {
+	var (
+		flags     = make([]interface{}, 0, 2)
+		passwords = make([]interface{}, 0, len(user.Passwords))
+		keys      []string
+	)
+	if user.Enabled {
+		flags = append(flags, []byte("on"))
+	} else {
+		flags = append(flags, []byte("off"))
+	}
+	if user.NoPass {
+		flags = append(flags, []byte("nopass"))
+	}
+	for _, p := range user.Passwords {
+		passwords = append(passwords, []byte(p))
+	}
+	for _, k := range user.Keys {
+		keys = append(keys, "~"+k)
+	}
+	return []interface{}{
+		[]byte("flags"), flags,
+		[]byte("passwords"), passwords,
+		[]byte("commands"), []byte(strings.Join(user.Commands, " ")),
+		[]byte("keys"), []byte(strings.Join(keys, " ")),
+	}
+}
+
+//aclUserRules the user as a line of ACL LIST, the rules that would create it
+func aclUserRules(user *tidis.ACLUser) string {
+	var (
+		rules []string
+	)
+	rules = append(rules, "user", user.Name)
+	if user.Enabled {
+		rules = append(rules, "on")
+	} else {
+		rules = append(rules, "off")
+	}
+	if user.NoPass {
+		rules = append(rules, "nopass")
+	}
+	for _, p := range user.Passwords {
+		rules = append(rules, "#"+p)
+	}
+	for _, k := range user.Keys {
+		rules = append(rules, "~"+k)
+	}
+	rules = append(rules, user.Commands...)
+	return strings.Join(rules, " ")
+}
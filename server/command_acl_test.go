@@ -0,0 +1,113 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/chuangyou/qkv/tidis"
+	"github.com/siddontang/goredis"
+)
+
+func TestACL(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("default", "ACL", "WHOAMI")
+	c.expect("OK", "ACL", "SETUSER", "alice", "on", ">secret", "~app:*", "+@read", "+set")
+	c.expect([]interface{}{
+		"flags", strs("on"),
+		"passwords", strs(tidis.HashPassword("secret")),
+		"commands", "-@all +@read +set",
+		"keys", "~app:*",
+	}, "ACL", "GETUSER", "alice")
+	c.expect(nil, "ACL", "GETUSER", "bob")
+	c.expect(strs("user default on #"+tidis.HashPassword(testAuth)+" ~* +@all", "user alice on #"+tidis.HashPassword("secret")+" ~app:* -@all +@read +set"), "ACL", "LIST")
+	c.expectError("Syntax error", "ACL", "SETUSER", "alice", "+nosuchcommand")
+	c.expectError("Syntax error", "ACL", "SETUSER", "alice", "+@nosuchcategory")
+	c.expectError("username", "ACL", "SETUSER", "default")
+
+	a := s.dialNoAuth()
+	defer a.Close()
+	a.expectError("invalid password", "AUTH", "alice", "wrong")
+	a.expect("OK", "AUTH", "alice", "secret")
+	a.expect("alice", "ACL", "WHOAMI")
+	a.expect("OK", "SET", "app:1", "v")
+	a.expect("v", "GET", "app:1")
+	a.expectError("NOPERM", "GET", "other")
+	a.expectError("NOPERM", "DEL", "app:1")
+	a.expectError("NOPERM", "ACL", "LIST")
+
+	c.expect("OK", "ACL", "SETUSER", "alice", "off")
+	a.expectError("no authentication", "GET", "app:1")
+	c.expect(int64(1), "ACL", "DELUSER", "alice", "bob")
+	c.expect(nil, "ACL", "GETUSER", "alice")
+	c.expectError("username", "ACL", "DELUSER", "default")
+}
+
+//TestACLReservedKeys the users are not user keys of db 0
+func TestACLReservedKeys(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("OK", "ACL", "SETUSER", "admin", "on", ">pw", "allkeys", "allcommands")
+	for _, key := range []string{"pacl:admin", "acl:admin", "pquota:t", "pgc", "pttl_checker", "ppubsub"} {
+		c.expect("OK", "SET", key, "x")
+		c.expect(int64(1), "DEL", key)
+	}
+	c.expectError("reserved", "SET", "\xfc\x70acl:admin", "x")
+	c.expect(strs("user default on #"+tidis.HashPassword(testAuth)+" ~* +@all", "user admin on #"+tidis.HashPassword("pw")+" ~* +@all"), "ACL", "LIST")
+	a := s.dialNoAuth()
+	defer a.Close()
+	a.expect("OK", "AUTH", "admin", "pw")
+}
+
+//the replies to a subscribed client whose user is removed are written between its messages, never inside one
+func TestACLDeletedSubscriber(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("OK", "ACL", "SETUSER", "alice", "on", ">secret", "~*", "+@all")
+	sub := s.dialNoAuth()
+	defer sub.Close()
+	sub.expect("OK", "AUTH", "alice", "secret")
+	sub.expect([]interface{}{"subscribe", "news", int64(1)}, "SUBSCRIBE", "news")
+	c.expect(int64(1), "ACL", "DELUSER", "alice")
+
+	const published = 200
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < published; i++ {
+			c.integer("PUBLISH", "news", strings.Repeat("x", 1024))
+		}
+	}()
+	const commands = 20
+	sub.send("SUBSCRIBE", "other")
+	for i := 1; i < commands; i++ {
+		sub.send("PING")
+	}
+	messages, errors := 0, 0
+	for messages+errors < published+commands {
+		switch got := sub.receive().(type) {
+		case []interface{}:
+			if !equalReply(got, strs("message", "news", strings.Repeat("x", 1024))) {
+				t.Fatalf("message: got %#v", got)
+			}
+			messages++
+		case goredis.Error:
+			if !strings.Contains(string(got), "no authentication") {
+				t.Fatalf("reply: got %q", got)
+			}
+			errors++
+		default:
+			t.Fatalf("reply: got %#v", got)
+		}
+	}
+	<-done
+	if messages != published || errors != commands {
+		t.Fatalf("%d messages and %d errors", messages, errors)
+	}
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/tidis"
+	"github.com/chuangyou/qkv/utils"
+)
+
+func init() {
+	commandRegister("BITCOUNT", bitCountCommand)
+	commandRegister("BITFIELD", bitFieldCommand)
+	commandRegister("BITOP", bitOpCommand)
+	commandRegister("BITPOS", bitPosCommand)
+	commandRegister("GETBIT", getBitCommand)
+	commandRegister("SETBIT", setBitCommand)
+}
+
+//BITCOUNT key [start end [BYTE|BIT]]
+func bitCountCommand(c *Client) (err error) {
+	var (
+		start   int64
+		end     int64 = -1
+		bitMode bool
+		count   int64
+	)
+	if len(c.args) != 1 && len(c.args) != 3 && len(c.args) != 4 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	if len(c.args) > 1 {
+		start, end, bitMode, err = parseBitRange(c.args[1], c.args[2], c.args[3:])
+		if err != nil {
+			return
+		}
+	}
+	count, err = c.tdb.BitCount(c.GetTxn(), c.args[0], start, end, bitMode)
+	if err != nil {
+		return
+	}
+	return c.Resp(count)
+}
+
+//BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]
+func bitFieldCommand(c *Client) (err error) {
+	var (
+		ops      []tidis.BitfieldOp
+		op       tidis.BitfieldOp
+		overflow = tidis.BitfieldWrap
+		resp     []interface{}
+	)
+	if len(c.args) < 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	for i := 1; i < len(c.args); i++ {
+		switch strings.ToUpper(string(c.args[i])) {
+		case "GET":
+			op.Kind = tidis.BitfieldGet
+		case "SET":
+			op.Kind = tidis.BitfieldSet
+		case "INCRBY":
+			op.Kind = tidis.BitfieldIncrby
+		case "OVERFLOW":
+			if i+1 >= len(c.args) {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			switch strings.ToUpper(string(c.args[i+1])) {
+			case "WRAP":
+				overflow = tidis.BitfieldWrap
+			case "SAT":
+				overflow = tidis.BitfieldSat
+			case "FAIL":
+				overflow = tidis.BitfieldFail
+			default:
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			i++
+			continue
+		default:
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		if (op.Kind == tidis.BitfieldGet && i+2 >= len(c.args)) || (op.Kind != tidis.BitfieldGet && i+3 >= len(c.args)) {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		op.Signed, op.Bits, err = parseBitfieldType(c.args[i+1])
+		if err != nil {
+			return
+		}
+		op.Offset, err = parseBitfieldOffset(c.args[i+2], op.Bits)
+		if err != nil {
+			return
+		}
+		op.Value = 0
+		if op.Kind != tidis.BitfieldGet {
+			op.Value, err = utils.StrBytesToInt64(c.args[i+3])
+			if err != nil {
+				err = qkverror.ErrorNotInteger
+				return
+			}
+			i++
+		}
+		op.Overflow = overflow
+		ops = append(ops, op)
+		i += 2
+	}
+	resp, err = c.tdb.BitField(c.GetTxn(), c.args[0], ops)
+	if err != nil {
+		return
+	}
+	return c.Resp(resp)
+}
+
+//BITOP AND|OR|XOR|NOT destkey key [key ...]
+func bitOpCommand(c *Client) (err error) {
+	var (
+		op   byte
+		size int64
+	)
+	if len(c.args) < 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	switch strings.ToUpper(string(c.args[0])) {
+	case "AND":
+		op = tidis.BitopAnd
+	case "OR":
+		op = tidis.BitopOr
+	case "XOR":
+		op = tidis.BitopXor
+	case "NOT":
+		op = tidis.BitopNot
+	default:
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	size, err = c.tdb.BitOp(c.GetTxn(), op, c.args[1], c.args[2:]...)
+	if err != nil {
+		return
+	}
+	return c.Resp(size)
+}
+
+//BITPOS key bit [start [end [BYTE|BIT]]]
+func bitPosCommand(c *Client) (err error) {
+	var (
+		bit      int64
+		start    int64
+		end      int64 = -1
+		endGiven bool
+		bitMode  bool
+		pos      int64
+	)
+	if len(c.args) < 2 || len(c.args) > 5 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	bit, err = utils.StrBytesToInt64(c.args[1])
+	if err != nil || (bit != 0 && bit != 1) {
+		err = qkverror.ErrorBitValue
+		return
+	}
+	switch len(c.args) {
+	case 3:
+		start, err = utils.StrBytesToInt64(c.args[2])
+		if err != nil {
+			err = qkverror.ErrorNotInteger
+			return
+		}
+	case 4, 5:
+		start, end, bitMode, err = parseBitRange(c.args[2], c.args[3], c.args[4:])
+		if err != nil {
+			return
+		}
+		endGiven = true
+	}
+	pos, err = c.tdb.BitPos(c.GetTxn(), c.args[0], byte(bit), start, end, endGiven, bitMode)
+	if err != nil {
+		return
+	}
+	return c.Resp(pos)
+}
+func getBitCommand(c *Client) (err error) {
+	var (
+		offset uint64
+		bit    int64
+	)
+	if len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	offset, err = strconv.ParseUint(string(c.args[1]), 10, 64)
+	if err != nil {
+		err = qkverror.ErrorBitOffset
+		return
+	}
+	bit, err = c.tdb.GetBit(c.GetTxn(), c.args[0], offset)
+	if err != nil {
+		return
+	}
+	return c.Resp(bit)
+}
+
+//SETBIT key offset value, returns the original bit value
+func setBitCommand(c *Client) (err error) {
+	var (
+		offset uint64
+		value  int64
+		old    int64
+	)
+	if len(c.args) != 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	offset, err = strconv.ParseUint(string(c.args[1]), 10, 64)
+	if err != nil {
+		err = qkverror.ErrorBitOffset
+		return
+	}
+	value, err = utils.StrBytesToInt64(c.args[2])
+	if err != nil || (value != 0 && value != 1) {
+		err = qkverror.ErrorBitValue
+		return
+	}
+	old, err = c.tdb.SetBit(c.GetTxn(), c.args[0], offset, value == 1)
+	if err != nil {
+		return
+	}
+	return c.Resp(old)
+}
+
+//parseBitRange start end [BYTE|BIT] of BITCOUNT and BITPOS
+func parseBitRange(startArg, endArg []byte, unit [][]byte) (start, end int64, bitMode bool, err error) {
+	start, err = utils.StrBytesToInt64(startArg)
+	if err != nil {
+		err = qkverror.ErrorNotInteger
+		return
+	}
+	end, err = utils.StrBytesToInt64(endArg)
+	if err != nil {
+		err = qkverror.ErrorNotInteger
+		return
+	}
+	if len(unit) == 0 {
+		return
+	}
+	switch strings.ToUpper(string(unit[0])) {
+	case "BYTE":
+	case "BIT":
+		bitMode = true
+	default:
+		err = qkverror.ErrorCommandParams
+	}
+	return
+}
+
+//parseBitfieldType i1 to i64 or u1 to u63
+func parseBitfieldType(arg []byte) (signed bool, bits uint, err error) {
+	var (
+		n uint64
+	)
+	if len(arg) < 2 || (arg[0] != 'i' && arg[0] != 'u') {
+		err = qkverror.ErrorBitfieldType
+		return
+	}
+	signed = arg[0] == 'i'
+	n, err = strconv.ParseUint(string(arg[1:]), 10, 8)
+	if err != nil || n == 0 || (signed && n > 64) || (!signed && n > 63) {
+		err = qkverror.ErrorBitfieldType
+		return
+	}
+	bits = uint(n)
+	return
+}
+
+//parseBitfieldOffset a bit offset, or #N for the N-th integer of the type
+func parseBitfieldOffset(arg []byte, bits uint) (offset uint64, err error) {
+	var (
+		multiply bool
+	)
+	if len(arg) > 0 && arg[0] == '#' {
+		multiply = true
+		arg = arg[1:]
+	}
+	offset, err = strconv.ParseUint(string(arg), 10, 64)
+	if err != nil {
+		err = qkverror.ErrorBitOffset
+		return
+	}
+	if multiply {
+		if offset > tidis.BitmapMaxOffset/uint64(bits) {
+			err = qkverror.ErrorBitOffset
+			return
+		}
+		offset = offset * uint64(bits)
+	}
+	if offset+uint64(bits)-1 > tidis.BitmapMaxOffset {
+		err = qkverror.ErrorBitOffset
+	}
+	return
+}
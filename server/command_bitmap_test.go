@@ -0,0 +1,70 @@
+package server
+
+import "testing"
+
+func TestBitmap(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(0), "SETBIT", "b", 7, 1)
+	c.expect(int64(1), "SETBIT", "b", 7, 1)
+	c.expect(int64(0), "SETBIT", "b", 100000, 1)
+	c.expect(int64(1), "GETBIT", "b", 7)
+	c.expect(int64(0), "GETBIT", "b", 8)
+	c.expect(int64(1), "GETBIT", "b", 100000)
+	c.expect(int64(0), "GETBIT", "b", 1<<31)
+	c.expect(int64(0), "GETBIT", "none", 1)
+	c.expectError("", "SETBIT", "b", 1<<32, 1)
+	c.expectError("", "SETBIT", "b", 1, 2)
+	c.expect(int64(2), "BITCOUNT", "b")
+	c.expect(int64(1), "BITCOUNT", "b", 0, 0)
+	c.expect(int64(1), "BITCOUNT", "b", 100, -1, "BIT")
+	c.expect(int64(7), "BITPOS", "b", 1)
+	c.expect(int64(0), "BITPOS", "b", 0)
+	c.expect(int64(100000), "BITPOS", "b", 1, 1)
+	c.expect(int64(-1), "BITPOS", "none", 1)
+	//the whole value is read back as a string, the unwritten chunks are zeros
+	if v, ok := c.do("GET", "b").(string); !ok || len(v) != 100000/8+1 || v[0] != 1 {
+		t.Fatalf("GET of a bitmap: %d bytes", len(v))
+	}
+
+	//a string is read as a bitmap and converted by the first write
+	c.expect("OK", "SET", "s", "\xf0")
+	c.expect(int64(4), "BITCOUNT", "s")
+	c.expect(int64(1), "SETBIT", "s", 0, 0)
+	c.expect("\x70", "GET", "s")
+	c.expect(int64(4), "APPEND", "s", "abc")
+	c.expect("\x70abc", "GET", "s")
+
+	c.expect("OK", "SET", "x", "\xff\x0f")
+	c.expect("OK", "SET", "y", "\x0f")
+	c.expect(int64(2), "BITOP", "AND", "d", "x", "y")
+	c.expect("\x0f\x00", "GET", "d")
+	c.expect(int64(2), "BITOP", "OR", "d", "x", "y")
+	c.expect("\xff\x0f", "GET", "d")
+	c.expect(int64(2), "BITOP", "XOR", "d", "x", "y")
+	c.expect("\xf0\x0f", "GET", "d")
+	c.expect(int64(2), "BITOP", "NOT", "d", "x")
+	c.expect("\x00\xf0", "GET", "d")
+	c.expectError("", "BITOP", "NOT", "d", "x", "y")
+	c.expect(int64(0), "BITOP", "AND", "d", "none")
+	c.expect(int64(0), "EXISTS", "d")
+}
+
+func TestBitfield(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect([]interface{}{int64(0), int64(255)}, "BITFIELD", "f", "SET", "u8", 0, 255, "GET", "u8", 0)
+	c.expect([]interface{}{int64(-1)}, "BITFIELD", "f", "GET", "i8", 0)
+	c.expect([]interface{}{int64(4)}, "BITFIELD", "f", "INCRBY", "u8", 0, 5)
+	c.expect([]interface{}{int64(255)}, "BITFIELD", "f", "OVERFLOW", "SAT", "INCRBY", "u8", 0, 300)
+	c.expect([]interface{}{nil, int64(255)}, "BITFIELD", "f", "OVERFLOW", "FAIL", "INCRBY", "u8", 0, 1, "GET", "u8", 0)
+	c.expect([]interface{}{int64(0), int64(7)}, "BITFIELD", "f", "SET", "u4", "#2", 7, "GET", "u4", 8)
+	c.expect([]interface{}{int64(0)}, "BITFIELD", "f", "GET", "u16", 1<<20)
+	c.expect([]interface{}{}, "BITFIELD", "f")
+	c.expectError("", "BITFIELD", "f", "GET", "u64", 0)
+	c.expectError("", "BITFIELD", "f", "GET", "x8", 0)
+}
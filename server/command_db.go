@@ -0,0 +1,152 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+)
+
+func init() {
+	commandRegister("FLUSHALL", flushAllCommand)
+	commandRegister("FLUSHDB", flushDBCommand)
+	commandRegister("MOVE", moveCommand)
+	commandRegister("SELECT", selectCommand)
+	commandRegister("SWAPDB", swapDBCommand)
+}
+
+//SELECT index
+func selectCommand(c *Client) (err error) {
+	var (
+		db int
+	)
+	if len(c.args) != 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	db, err = c.parseDB(c.args[0])
+	if err != nil {
+		return
+	}
+	c.db = db
+	return c.Resp("OK")
+}
+
+//MOVE key db
+func moveCommand(c *Client) (err error) {
+	var (
+		db  int
+		key []byte
+		ret int64
+	)
+	if len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	db, err = c.parseDB(c.args[1])
+	if err != nil {
+		return
+	}
+	if db == c.db {
+		err = qkverror.ErrorSameObject
+		return
+	}
+	//the key argument is already in the namespace of the selected db
+	key = c.userKey(c.args[0])
+	ret, err = c.tdb.Move(c.GetTxn(), c.args[0], c.tdb.Key(c.tenant, db, key))
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+
+//SWAPDB index1 index2
+func swapDBCommand(c *Client) (err error) {
+	var (
+		db1, db2 int
+	)
+	if len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	if c.isTxn {
+		err = qkverror.ErrorNotInMulti
+		return
+	}
+	db1, err = c.parseDB(c.args[0])
+	if err != nil {
+		return
+	}
+	db2, err = c.parseDB(c.args[1])
+	if err != nil {
+		return
+	}
+	err = c.tdb.SwapDB(c.tenant, db1, db2)
+	if err != nil {
+		return
+	}
+	return c.Resp("OK")
+}
+
+//FLUSHDB [ASYNC|SYNC]
+func flushDBCommand(c *Client) (err error) {
+	err = c.parseFlushArgs()
+	if err != nil {
+		return
+	}
+	err = c.tdb.FlushDB(c.namespace())
+	if err != nil {
+		return
+	}
+	return c.Resp("OK")
+}
+
+//FLUSHALL [ASYNC|SYNC], the databases of the tenant of the client
+func flushAllCommand(c *Client) (err error) {
+	err = c.parseFlushArgs()
+	if err != nil {
+		return
+	}
+	err = c.tdb.FlushAll(c.tenant)
+	if err != nil {
+		return
+	}
+	return c.Resp("OK")
+}
+
+//parseFlushArgs ASYNC and SYNC are accepted for compatibility, the keys are deleted by the delete range of the store either way
+func (c *Client) parseFlushArgs() (err error) {
+	if len(c.args) > 1 {
+		return qkverror.ErrorCommandParams
+	}
+	if len(c.args) == 1 {
+		switch strings.ToUpper(string(c.args[0])) {
+		case "ASYNC", "SYNC":
+		default:
+			return qkverror.ErrorCommandParams
+		}
+	}
+	//the keys are deleted in several transactions
+	if c.isTxn {
+		return qkverror.ErrorNotInMulti
+	}
+	return
+}
+
+//parseDB a db index between 0 and the number of databases
+func (c *Client) parseDB(arg []byte) (db int, err error) {
+	var (
+		n int64
+	)
+	n, err = utils.StrBytesToInt64(arg)
+	if err != nil {
+		err = qkverror.ErrorNotInteger
+		return
+	}
+	if n < 0 || n >= int64(c.tdb.Databases()) {
+		err = qkverror.ErrorInvalidDB
+		return
+	}
+	db = int(n)
+	return
+}
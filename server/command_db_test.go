@@ -0,0 +1,93 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestSelect(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("OK", "SET", "k", "db0")
+	c.expect("OK", "SELECT", "1")
+	c.expect(nil, "GET", "k")
+	c.expect("OK", "SET", "k", "db1")
+	c.expectError("", "SELECT", "16")
+	c.expectError("", "SELECT", "x")
+	c.expect("OK", "SELECT", "0")
+	c.expect("db0", "GET", "k")
+	c.expect([]interface{}{"0", strs("k")}, "SCAN", "0")
+}
+
+func TestMove(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("OK", "SET", "k", "v")
+	c.expect(int64(1), "MOVE", "k", "2")
+	c.expect(nil, "GET", "k")
+	c.expect(int64(0), "MOVE", "k", "2")
+	c.expectError("", "MOVE", "k", "0")
+	c.expect("OK", "SELECT", "2")
+	c.expect("v", "GET", "k")
+}
+
+func TestSwapFlushDB(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("OK", "SET", "a", "db0")
+	c.expect(int64(1), "PEXPIRE", "a", "3600000")
+	c.expect(int64(2), "RPUSH", "l", "x", "y")
+	c.expect("OK", "SELECT", "1")
+	c.expect("OK", "SET", "b", "db1")
+	c.expect("OK", "SWAPDB", "0", "1")
+	c.expect(nil, "GET", "b")
+	c.expect("db0", "GET", "a")
+	c.expect(strs("x", "y"), "LRANGE", "l", "0", "-1")
+	if ttl := c.integer("PTTL", "a"); ttl <= 0 {
+		t.Fatalf("pttl after swapdb: %d", ttl)
+	}
+	c.expect("OK", "SELECT", "0")
+	c.expect("db1", "GET", "b")
+	c.expect(nil, "GET", "a")
+
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "SWAPDB", "0", "1")
+	c.expect(nil, "EXEC")
+
+	//a SWAPDB after WATCH changes the watched keys
+	other := s.dial()
+	defer other.Close()
+	c.expect("OK", "WATCH", "b")
+	other.expect("OK", "SWAPDB", "0", "1")
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "GET", "b")
+	c.expect(nil, "EXEC")
+	c.expect(nil, "GET", "b")
+	other.expect("OK", "SWAPDB", "0", "1")
+	c.expect("db1", "GET", "b")
+
+	c.expect("OK", "FLUSHDB")
+	c.expect(nil, "GET", "b")
+	c.expect("OK", "SELECT", "1")
+	c.expect("db0", "GET", "a")
+	c.expect("OK", "FLUSHALL")
+	c.expect(nil, "GET", "a")
+	c.expect(int64(0), "LLEN", "l")
+}
+
+func TestReservedKeys(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	for _, b := range []byte{0xfc, 0xfd, 0xfe} {
+		c.expectError("reserved", "SET", string([]byte{b, 'k'}), "v")
+		c.expectError("reserved", "GET", string([]byte{b, 'k'}))
+	}
+	c.expect("OK", "SET", string([]byte{0xff, 'k'}), "v")
+}
@@ -0,0 +1,374 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/tidis"
+	"github.com/chuangyou/qkv/utils"
+)
+
+func init() {
+	commandRegister("GEOADD", geoAddCommand)
+	commandRegister("GEODIST", geoDistCommand)
+	commandRegister("GEOPOS", geoPosCommand)
+	commandRegister("GEORADIUS", geoRadiusCommand)
+	commandRegister("GEOSEARCH", geoSearchCommand)
+}
+
+//geoSearchArgs the options of GEOSEARCH and GEORADIUS
+type geoSearchArgs struct {
+	shape     tidis.GeoShape
+	unit      float64
+	order     byte
+	count     int
+	any       bool
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	store     []byte
+	storeDist bool
+}
+
+//GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func geoAddCommand(c *Client) (err error) {
+	var (
+		nx, xx, ch bool
+		i          int
+		points     []tidis.GeoPoint
+		point      tidis.GeoPoint
+		ret        int64
+	)
+	if len(c.args) < 4 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	for i = 1; i < len(c.args); i++ {
+		switch strings.ToUpper(string(c.args[i])) {
+		case "NX":
+			nx = true
+			continue
+		case "XX":
+			xx = true
+			continue
+		case "CH":
+			ch = true
+			continue
+		}
+		break
+	}
+	if (nx && xx) || len(c.args) == i || (len(c.args)-i)%3 != 0 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	for ; i < len(c.args); i += 3 {
+		point.Longitude, point.Latitude, err = parseGeoPosition(c.args[i], c.args[i+1])
+		if err != nil {
+			return
+		}
+		point.Member = c.args[i+2]
+		points = append(points, point)
+	}
+	ret, err = c.tdb.GeoAdd(c.GetTxn(), c.args[0], nx, xx, ch, points...)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+
+//GEODIST key member1 member2 [M|KM|FT|MI]
+func geoDistCommand(c *Client) (err error) {
+	var (
+		unit float64 = 1
+		dist float64
+		ok   bool
+	)
+	if len(c.args) != 3 && len(c.args) != 4 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	if len(c.args) == 4 {
+		unit, err = parseGeoUnit(c.args[3])
+		if err != nil {
+			return
+		}
+	}
+	dist, ok, err = c.tdb.GeoDist(c.GetTxn(), c.args[0], c.args[1], c.args[2])
+	if err != nil {
+		return
+	}
+	if !ok {
+		return c.Resp(nil)
+	}
+	return c.Resp([]byte(strconv.FormatFloat(dist/unit, 'f', 4, 64)))
+}
+
+//GEOPOS key [member ...]
+func geoPosCommand(c *Client) (err error) {
+	var (
+		points []*tidis.GeoPoint
+		resp   []interface{}
+	)
+	if len(c.args) < 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	points, err = c.tdb.GeoPos(c.GetTxn(), c.args[0], c.args[1:]...)
+	if err != nil {
+		return
+	}
+	resp = make([]interface{}, len(points))
+	for i, point := range points {
+		if point != nil {
+			resp[i] = []interface{}{geoCoordBytes(point.Longitude), geoCoordBytes(point.Latitude)}
+		}
+	}
+	return c.Resp(resp)
+}
+
+//GEORADIUS key longitude latitude radius M|KM|FT|MI [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT count [ANY]] [ASC|DESC]
+//[STORE key] [STOREDIST key]
+func geoRadiusCommand(c *Client) (err error) {
+	var (
+		args geoSearchArgs
+	)
+	if len(c.args) < 5 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	args.shape.Longitude, args.shape.Latitude, err = parseGeoPosition(c.args[1], c.args[2])
+	if err != nil {
+		return
+	}
+	args.shape.Radius, args.unit, err = parseGeoDistance(c.args[3], c.args[4])
+	if err != nil {
+		return
+	}
+	err = parseGeoSearchOptions(c.args[5:], &args, false)
+	if err != nil {
+		return
+	}
+	return geoSearchResp(c, &args)
+}
+
+//GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius M|KM|FT|MI|BYBOX width height M|KM|FT|MI
+//[ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func geoSearchCommand(c *Client) (err error) {
+	var (
+		args geoSearchArgs
+	)
+	if len(c.args) < 4 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	err = parseGeoSearchOptions(c.args[1:], &args, true)
+	if err != nil {
+		return
+	}
+	return geoSearchResp(c, &args)
+}
+
+//geoSearchResp run the search of GEOSEARCH or GEORADIUS and reply the results or the number of members stored
+func geoSearchResp(c *Client, args *geoSearchArgs) (err error) {
+	var (
+		results []tidis.GeoResult
+		stored  int64
+		resp    []interface{}
+		item    []interface{}
+	)
+	//the nearest ones are returned with COUNT unless ANY is given
+	if args.order == tidis.GeoSortNone && args.count > 0 && !args.any {
+		args.order = tidis.GeoSortAsc
+	}
+	if args.store != nil {
+		stored, err = c.tdb.GeoSearchStore(c.GetTxn(), args.store, c.args[0], args.shape, args.order, args.count, args.any, args.storeDist, args.unit)
+		if err != nil {
+			return
+		}
+		return c.Resp(stored)
+	}
+	results, err = c.tdb.GeoSearch(c.GetTxn(), c.args[0], args.shape, args.order, args.count, args.any)
+	if err != nil {
+		return
+	}
+	resp = make([]interface{}, len(results))
+	for i, result := range results {
+		if !args.withDist && !args.withHash && !args.withCoord {
+			resp[i] = result.Member
+			continue
+		}
+		item = []interface{}{result.Member}
+		if args.withDist {
+			item = append(item, []byte(strconv.FormatFloat(result.Dist/args.unit, 'f', 4, 64)))
+		}
+		if args.withHash {
+			item = append(item, int64(result.Hash))
+		}
+		if args.withCoord {
+			item = append(item, []interface{}{geoCoordBytes(result.Longitude), geoCoordBytes(result.Latitude)})
+		}
+		resp[i] = item
+	}
+	return c.Resp(resp)
+}
+
+//parseGeoSearchOptions the options after the key of GEOSEARCH (search is set) or after the unit of GEORADIUS
+func parseGeoSearchOptions(options [][]byte, args *geoSearchArgs, search bool) (err error) {
+	var (
+		from, by bool
+		count    int64
+	)
+	for i := 0; i < len(options); i++ {
+		switch strings.ToUpper(string(options[i])) {
+		case "WITHCOORD":
+			args.withCoord = true
+		case "WITHDIST":
+			args.withDist = true
+		case "WITHHASH":
+			args.withHash = true
+		case "ASC":
+			args.order = tidis.GeoSortAsc
+		case "DESC":
+			args.order = tidis.GeoSortDesc
+		case "ANY":
+			args.any = true
+		case "COUNT":
+			if i+1 >= len(options) {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			count, err = utils.StrBytesToInt64(options[i+1])
+			if err != nil || count <= 0 {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			args.count = int(count)
+			i++
+		case "STORE", "STOREDIST":
+			if search || i+1 >= len(options) {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			args.storeDist = strings.ToUpper(string(options[i])) == "STOREDIST"
+			args.store = options[i+1]
+			i++
+		case "FROMMEMBER":
+			if !search || from || i+1 >= len(options) {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			args.shape.Member = options[i+1]
+			from = true
+			i++
+		case "FROMLONLAT":
+			if !search || from || i+2 >= len(options) {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			args.shape.Longitude, args.shape.Latitude, err = parseGeoPosition(options[i+1], options[i+2])
+			if err != nil {
+				return
+			}
+			from = true
+			i += 2
+		case "BYRADIUS":
+			if !search || by || i+2 >= len(options) {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			args.shape.Radius, args.unit, err = parseGeoDistance(options[i+1], options[i+2])
+			if err != nil {
+				return
+			}
+			by = true
+			i += 2
+		case "BYBOX":
+			if !search || by || i+3 >= len(options) {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			args.shape.Width, args.unit, err = parseGeoDistance(options[i+1], options[i+3])
+			if err != nil {
+				return
+			}
+			args.shape.Height, _, err = parseGeoDistance(options[i+2], options[i+3])
+			if err != nil {
+				return
+			}
+			args.shape.Box = true
+			by = true
+			i += 3
+		default:
+			err = qkverror.ErrorCommandParams
+			return
+		}
+	}
+	if (search && (!from || !by)) || (args.any && args.count == 0) {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	//STORE only keeps the members
+	if args.store != nil && (args.withCoord || args.withDist || args.withHash) {
+		err = qkverror.ErrorCommandParams
+	}
+	return
+}
+
+//parseGeoPosition longitude latitude
+func parseGeoPosition(longArg, latArg []byte) (longitude, latitude float64, err error) {
+	longitude, err = utils.StrBytesToFloat64(longArg)
+	if err != nil {
+		err = qkverror.ErrorNotFloat
+		return
+	}
+	latitude, err = utils.StrBytesToFloat64(latArg)
+	if err != nil {
+		err = qkverror.ErrorNotFloat
+		return
+	}
+	if !utils.GeoValid(longitude, latitude) {
+		err = qkverror.ErrorGeoPosition
+	}
+	return
+}
+
+//parseGeoDistance a distance and its unit, returns the distance in meters and the meters of the unit
+func parseGeoDistance(distArg, unitArg []byte) (meters, unit float64, err error) {
+	meters, err = utils.StrBytesToFloat64(distArg)
+	if err != nil || meters < 0 {
+		err = qkverror.ErrorNotFloat
+		return
+	}
+	unit, err = parseGeoUnit(unitArg)
+	meters = meters * unit
+	return
+}
+
+//parseGeoUnit meters of M, KM, FT or MI
+func parseGeoUnit(arg []byte) (unit float64, err error) {
+	switch strings.ToLower(string(arg)) {
+	case "m":
+		unit = 1
+	case "km":
+		unit = 1000
+	case "ft":
+		unit = 0.3048
+	case "mi":
+		unit = 1609.34
+	default:
+		err = qkverror.ErrorGeoUnit
+	}
+	return
+}
+
+//geoCoordBytes a coordinate with 17 decimals and the trailing zeros removed, like redis
+func geoCoordBytes(v float64) []byte {
+	var (
+		s string
+	)
+	s = strconv.FormatFloat(v, 'f', 17, 64)
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	return []byte(s)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+)
+
+//near whether the float reply got is within delta of want
+func near(got interface{}, want, delta float64) bool {
+	s, ok := got.(string)
+	if !ok {
+		return false
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	return err == nil && f >= want-delta && f <= want+delta
+}
+
+func TestGeo(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(2), "GEOADD", "Sicily", 13.361389, 38.115556, "Palermo", 15.087269, 37.502669, "Catania")
+	c.expect(int64(0), "GEOADD", "Sicily", "NX", 13, 38, "Palermo")
+	c.expect(int64(0), "GEOADD", "Sicily", "XX", 13, 38, "Agrigento")
+	c.expect(int64(1), "GEOADD", "Sicily", "CH", 13.583333, 37.316667, "Agrigento")
+	c.expectError("", "GEOADD", "Sicily", 200, 38, "x")
+	c.expectError("", "GEOADD", "Sicily", "NX", "XX", 13, 38, "x")
+
+	if d := c.do("GEODIST", "Sicily", "Palermo", "Catania"); !near(d, 166274.1516, 0.01) {
+		t.Fatalf("GEODIST: got %#v", d)
+	}
+	if d := c.do("GEODIST", "Sicily", "Palermo", "Catania", "KM"); !near(d, 166.2742, 0.001) {
+		t.Fatalf("GEODIST KM: got %#v", d)
+	}
+	c.expect(nil, "GEODIST", "Sicily", "Palermo", "none")
+
+	pos := c.array("GEOPOS", "Sicily", "Palermo", "none")
+	if len(pos) != 2 || pos[1] != nil {
+		t.Fatalf("GEOPOS: got %#v", pos)
+	}
+	if p, ok := pos[0].([]interface{}); !ok || !near(p[0], 13.361389, 1e-5) || !near(p[1], 38.115556, 1e-5) {
+		t.Fatalf("GEOPOS Palermo: got %#v", pos[0])
+	}
+
+	c.expect(strs("Catania", "Agrigento", "Palermo"), "GEORADIUS", "Sicily", 15, 37, 200, "KM", "ASC")
+	c.expect(strs("Palermo", "Agrigento"), "GEORADIUS", "Sicily", 15, 37, 200, "KM", "DESC", "COUNT", 2)
+	got := c.array("GEORADIUS", "Sicily", 15, 37, 100, "KM", "WITHDIST")
+	if len(got) != 1 {
+		t.Fatalf("GEORADIUS WITHDIST: got %#v", got)
+	}
+	if r := got[0].([]interface{}); r[0] != "Catania" || !near(r[1], 56.4413, 0.001) {
+		t.Fatalf("GEORADIUS WITHDIST: got %#v", r)
+	}
+
+	c.expect(strs("Catania", "Agrigento", "Palermo"), "GEOSEARCH", "Sicily", "FROMLONLAT", 15, 37, "BYBOX", 400, 400, "KM", "ASC")
+	c.expect(strs("Palermo", "Agrigento"), "GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", 100, "KM", "ASC")
+	c.expect([]interface{}{}, "GEOSEARCH", "Sicily", "FROMLONLAT", 0, 0, "BYRADIUS", 1, "KM")
+	c.expectError("", "GEOSEARCH", "Sicily", "FROMMEMBER", "none", "BYRADIUS", 1, "KM")
+	c.expect([]interface{}{}, "GEORADIUS", "none", 15, 37, 200, "KM")
+	//the points are members of a zset
+	c.expect(int64(3), "ZCARD", "Sicily")
+}
@@ -1,23 +1,40 @@
 package server
 
 import (
+	"math"
+	"strings"
+	"time"
+
 	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/tidis"
 	"github.com/chuangyou/qkv/utils"
 )
 
 func init() {
 	commandRegister("HDEL", hdelCommand)
 	commandRegister("HEXISTS", hexistsCommand)
+	commandRegister("HEXPIRE", hexpireCommand)
+	commandRegister("HEXPIREAT", hexpireAtCommand)
+	commandRegister("HEXPIRETIME", hexpireTimeCommand)
 	commandRegister("HGET", hgetCommand)
 	commandRegister("HGETALL", hgetallCommand)
 	commandRegister("HINCRBY", hincrbyCommand)
+	commandRegister("HINCRBYFLOAT", hincrbyFloatCommand)
 	commandRegister("HKEYS", hkeysCommand)
 	commandRegister("HLEN", hlenCommand)
 	commandRegister("HMGET", hmgetCommand)
 	commandRegister("HMSET", hmsetCommand)
+	commandRegister("HPERSIST", hpersistCommand)
+	commandRegister("HPEXPIRE", hpexpireCommand)
+	commandRegister("HPEXPIREAT", hpexpireAtCommand)
+	commandRegister("HPEXPIRETIME", hpexpireTimeCommand)
+	commandRegister("HPTTL", hpttlCommand)
+	commandRegister("HRANDFIELD", hrandFieldCommand)
+	commandRegister("HSCAN", hscanCommand)
 	commandRegister("HSET", hsetCommand)
 	commandRegister("HSETNX", hsetnxCommand)
 	commandRegister("HSTRLEN", hstrlenCommand)
+	commandRegister("HTTL", httlCommand)
 	commandRegister("HVALS", hvalsCommand)
 }
 func hdelCommand(c *Client) (err error) {
@@ -214,3 +231,270 @@ func hvalsCommand(c *Client) (err error) {
 	}
 	return c.Resp(value)
 }
+func hscanCommand(c *Client) (err error) {
+	var (
+		next  []byte
+		resp  []interface{}
+		match []byte
+		count int64
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	match, count, _, err = parseScanArgs(c.args[2:], false)
+	if err != nil {
+		return
+	}
+	next, resp, err = c.tdb.HScan(c.GetTxn(), c.args[0], c.args[1], match, count)
+	if err != nil {
+		return
+	}
+	return c.Resp([]interface{}{next, resp})
+}
+
+//HINCRBYFLOAT key field increment
+func hincrbyFloatCommand(c *Client) (err error) {
+	var (
+		step  float64
+		value []byte
+	)
+	if len(c.args) != 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	step, err = utils.StrBytesToFloat64(c.args[2])
+	if err != nil {
+		err = qkverror.ErrorNotFloat
+		return
+	}
+	value, err = c.tdb.HIncrByFloat(c.GetTxn(), c.args[0], c.args[1], step)
+	if err != nil {
+		return
+	}
+	return c.Resp(value)
+}
+
+//HRANDFIELD key [count [WITHVALUES]]
+func hrandFieldCommand(c *Client) (err error) {
+	var (
+		count      int64 = 1
+		withValues bool
+		resp       []interface{}
+	)
+	if len(c.args) < 1 || len(c.args) > 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	if len(c.args) > 1 {
+		count, err = utils.StrBytesToInt64(c.args[1])
+		if err != nil {
+			return
+		}
+	}
+	if len(c.args) == 3 {
+		if strings.ToUpper(string(c.args[2])) != "WITHVALUES" {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		withValues = true
+	}
+	resp, err = c.tdb.HRandField(c.GetTxn(), c.args[0], count, withValues)
+	if err != nil {
+		return
+	}
+	//a single field without count
+	if len(c.args) == 1 {
+		if len(resp) == 0 {
+			return c.Resp(nil)
+		}
+		return c.Resp(resp[0])
+	}
+	return c.Resp(resp)
+}
+
+//HEXPIRE key seconds [NX|XX|GT|LT] FIELDS numfields field [field ...]
+func hexpireCommand(c *Client) (err error) {
+	return hexpireResp(c, 1000, false)
+}
+
+//HPEXPIRE key milliseconds [NX|XX|GT|LT] FIELDS numfields field [field ...]
+func hpexpireCommand(c *Client) (err error) {
+	return hexpireResp(c, 1, false)
+}
+
+//HEXPIREAT key unix-time-seconds [NX|XX|GT|LT] FIELDS numfields field [field ...]
+func hexpireAtCommand(c *Client) (err error) {
+	return hexpireResp(c, 1000, true)
+}
+
+//HPEXPIREAT key unix-time-milliseconds [NX|XX|GT|LT] FIELDS numfields field [field ...]
+func hpexpireAtCommand(c *Client) (err error) {
+	return hexpireResp(c, 1, true)
+}
+
+//HTTL key FIELDS numfields field [field ...]
+func httlCommand(c *Client) (err error) {
+	return hexpireTimeResp(c, 1000, true)
+}
+
+//HPTTL key FIELDS numfields field [field ...]
+func hpttlCommand(c *Client) (err error) {
+	return hexpireTimeResp(c, 1, true)
+}
+
+//HEXPIRETIME key FIELDS numfields field [field ...]
+func hexpireTimeCommand(c *Client) (err error) {
+	return hexpireTimeResp(c, 1000, false)
+}
+
+//HPEXPIRETIME key FIELDS numfields field [field ...]
+func hpexpireTimeCommand(c *Client) (err error) {
+	return hexpireTimeResp(c, 1, false)
+}
+
+//HPERSIST key FIELDS numfields field [field ...]
+func hpersistCommand(c *Client) (err error) {
+	var (
+		fields [][]byte
+		ret    []int64
+	)
+	if len(c.args) < 4 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	fields, err = parseHashFields(c.args[1:])
+	if err != nil {
+		return
+	}
+	ret, err = c.tdb.HPersist(c.GetTxn(), c.args[0], fields...)
+	if err != nil {
+		return
+	}
+	return c.Resp(hashFieldsResp(ret))
+}
+
+//hexpireResp set the ttl of the fields, the time argument is in unit ms and relative to now unless at is set
+func hexpireResp(c *Client, unit int64, at bool) (err error) {
+	var (
+		n        int64
+		now      int64
+		expireAt int64
+		cond     = tidis.HExpireAlways
+		i        = 2
+		fields   [][]byte
+		ret      []int64
+	)
+	if len(c.args) < 5 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	n, err = utils.StrBytesToInt64(c.args[1])
+	if err != nil {
+		err = qkverror.ErrorNotInteger
+		return
+	}
+	if n < 0 || n > math.MaxInt64/unit {
+		err = qkverror.ErrorInvalidExpire
+		return
+	}
+	expireAt = n * unit
+	if !at {
+		now = time.Now().UnixNano() / 1000 / 1000
+		if expireAt > math.MaxInt64-now {
+			err = qkverror.ErrorInvalidExpire
+			return
+		}
+		expireAt = expireAt + now
+	}
+	//0 is no ttl, an expire time in the past deletes the fields
+	if expireAt == 0 {
+		expireAt = 1
+	}
+	switch strings.ToUpper(string(c.args[2])) {
+	case "NX":
+		cond = tidis.HExpireNX
+	case "XX":
+		cond = tidis.HExpireXX
+	case "GT":
+		cond = tidis.HExpireGT
+	case "LT":
+		cond = tidis.HExpireLT
+	}
+	if cond != tidis.HExpireAlways {
+		i++
+	}
+	fields, err = parseHashFields(c.args[i:])
+	if err != nil {
+		return
+	}
+	ret, err = c.tdb.HExpire(c.GetTxn(), c.args[0], expireAt, cond, fields...)
+	if err != nil {
+		return
+	}
+	return c.Resp(hashFieldsResp(ret))
+}
+
+//hexpireTimeResp reply the expire time of the fields in unit ms, or the time left with ttl
+func hexpireTimeResp(c *Client, unit int64, ttl bool) (err error) {
+	var (
+		fields [][]byte
+		ret    []int64
+		now    int64
+	)
+	if len(c.args) < 4 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	fields, err = parseHashFields(c.args[1:])
+	if err != nil {
+		return
+	}
+	ret, err = c.tdb.HExpireTime(c.GetTxn(), c.args[0], fields...)
+	if err != nil {
+		return
+	}
+	now = time.Now().UnixNano() / 1000 / 1000
+	for i, expireAt := range ret {
+		if expireAt < 0 {
+			continue
+		}
+		if ttl {
+			expireAt = expireAt - now
+			if expireAt < 0 {
+				expireAt = 0
+			}
+			//rounded like TTL
+			ret[i] = (expireAt + unit/2) / unit
+			continue
+		}
+		ret[i] = expireAt / unit
+	}
+	return c.Resp(hashFieldsResp(ret))
+}
+
+//parseHashFields FIELDS numfields field [field ...]
+func parseHashFields(args [][]byte) (fields [][]byte, err error) {
+	var (
+		n int64
+	)
+	if len(args) < 3 || strings.ToUpper(string(args[0])) != "FIELDS" {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	n, err = utils.StrBytesToInt64(args[1])
+	if err != nil || n <= 0 || n != int64(len(args)-2) {
+		err = qkverror.ErrorNumFields
+		return
+	}
+	return args[2:], nil
+}
+
+//hashFieldsResp a reply with an integer per field
+func hashFieldsResp(ret []int64) (resp []interface{}) {
+	resp = make([]interface{}, len(ret))
+	for i, v := range ret {
+		resp[i] = v
+	}
+	return
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHashCommands(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(1), "HSET", "h", "a", "1")
+	c.expect(int64(0), "HSET", "h", "a", "2")
+	c.expect("2", "HGET", "h", "a")
+	c.expect(nil, "HGET", "h", "none")
+	c.expect("OK", "HMSET", "h", "b", "3", "c", "4")
+	c.expect([]interface{}{"2", nil, "4"}, "HMGET", "h", "a", "none", "c")
+	c.expect(int64(3), "HLEN", "h")
+	c.expect(int64(1), "HEXISTS", "h", "b")
+	c.expect(int64(0), "HEXISTS", "h", "none")
+	c.expect(int64(0), "HSETNX", "h", "a", "x")
+	c.expect(int64(1), "HSETNX", "h", "d", "5")
+	c.expect(int64(1), "HSTRLEN", "h", "d")
+	c.expect(int64(7), "HINCRBY", "h", "d", 2)
+	c.expect(int64(-1), "HINCRBY", "h", "e", -1)
+	c.expectError("", "HINCRBY", "h", "d", "x")
+	c.expectSorted(strs("a", "b", "c", "d", "e"), "HKEYS", "h")
+	c.expectSorted(strs("2", "3", "4", "7", "-1"), "HVALS", "h")
+	c.expect(int64(2), "HDEL", "h", "a", "e", "none")
+	c.expectSorted(strs("b", "3", "c", "4", "d", "7"), "HGETALL", "h")
+	c.expect(int64(3), "HDEL", "h", "b", "c", "d")
+	c.expect(int64(0), "HLEN", "h")
+	c.expect([]interface{}{}, "HGETALL", "h")
+
+	c.expect("OK", "SET", "s", "v")
+	c.expectError("WRONGTYPE", "HSET", "s", "a", "1")
+}
+
+func TestHashFloatRandom(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("10.5", "HINCRBYFLOAT", "h", "f", 10.5)
+	c.expect("10", "HINCRBYFLOAT", "h", "f", -0.5)
+	c.expect(int64(1), "HSET", "h", "s", "x")
+	c.expectError("", "HINCRBYFLOAT", "h", "s", 1)
+	c.expectError("", "HINCRBYFLOAT", "h", "f", "x")
+
+	c.expect("OK", "HMSET", "r", "a", "1", "b", "2", "c", "3")
+	if f, ok := c.do("HRANDFIELD", "r").(string); !ok || f < "a" || f > "c" {
+		t.Fatalf("HRANDFIELD: got %#v", f)
+	}
+	if got := sorted(c.array("HRANDFIELD", "r", 2)); len(got) != 2 || got[0] == got[1] {
+		t.Fatalf("HRANDFIELD 2: got %v", got)
+	}
+	c.expectSorted(strs("a", "b", "c"), "HRANDFIELD", "r", 10)
+	if got := c.array("HRANDFIELD", "r", -5, "WITHVALUES"); len(got) != 10 {
+		t.Fatalf("HRANDFIELD -5 WITHVALUES: got %#v", got)
+	}
+	c.expect(nil, "HRANDFIELD", "none")
+	c.expect([]interface{}{}, "HRANDFIELD", "none", 2)
+}
+
+func TestHashFieldTTL(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("OK", "HMSET", "h", "a", "1", "b", "2", "c", "3")
+	c.expect([]interface{}{int64(1), int64(-2)}, "HEXPIRE", "h", 100, "FIELDS", 2, "a", "none")
+	c.expect([]interface{}{int64(0)}, "HEXPIRE", "h", 100, "NX", "FIELDS", 1, "a")
+	c.expect([]interface{}{int64(0)}, "HEXPIRE", "h", 100, "XX", "FIELDS", 1, "b")
+	c.expect([]interface{}{int64(1)}, "HEXPIRE", "h", 200, "GT", "FIELDS", 1, "a")
+	c.expect([]interface{}{int64(0)}, "HEXPIRE", "h", 100, "GT", "FIELDS", 1, "a")
+	if ttl := c.array("HTTL", "h", "FIELDS", 3, "a", "b", "none"); ttl[0].(int64) <= 100 || ttl[1] != int64(-1) || ttl[2] != int64(-2) {
+		t.Fatalf("HTTL: got %#v", ttl)
+	}
+	if ttl := c.array("HPTTL", "h", "FIELDS", 1, "a"); ttl[0].(int64) <= 100000 {
+		t.Fatalf("HPTTL: got %#v", ttl)
+	}
+	at := time.Now().Add(time.Hour).Unix()
+	c.expect([]interface{}{int64(1)}, "HEXPIREAT", "h", at, "FIELDS", 1, "b")
+	c.expect([]interface{}{at}, "HEXPIRETIME", "h", "FIELDS", 1, "b")
+	c.expect([]interface{}{at * 1000}, "HPEXPIRETIME", "h", "FIELDS", 1, "b")
+	c.expect([]interface{}{int64(1), int64(-1), int64(-2)}, "HPERSIST", "h", "FIELDS", 3, "b", "c", "none")
+	c.expect([]interface{}{int64(-1)}, "HTTL", "h", "FIELDS", 1, "b")
+	c.expectError("", "HEXPIRE", "h", 100, "FIELDS", 2, "a")
+	c.expect([]interface{}{int64(-2)}, "HTTL", "none", "FIELDS", 1, "a")
+
+	//an expired field is gone, a time in the past deletes the field
+	c.expect([]interface{}{int64(1)}, "HPEXPIRE", "h", 1, "FIELDS", 1, "c")
+	c.expect([]interface{}{int64(2)}, "HPEXPIREAT", "h", 1, "FIELDS", 1, "b")
+	time.Sleep(10 * time.Millisecond)
+	c.expect(nil, "HGET", "h", "c")
+	c.expect(nil, "HGET", "h", "b")
+	c.expectSorted(strs("a", "1"), "HGETALL", "h")
+	c.expect(int64(1), "HLEN", "h")
+	c.expect(int64(1), "HSET", "h", "c", "4")
+	c.expect([]interface{}{int64(-1)}, "HTTL", "h", "FIELDS", 1, "c")
+}
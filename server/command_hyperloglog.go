@@ -0,0 +1,56 @@
+package server
+
+import (
+	"github.com/chuangyou/qkv/qkverror"
+)
+
+func init() {
+	commandRegister("PFADD", pfAddCommand)
+	commandRegister("PFCOUNT", pfCountCommand)
+	commandRegister("PFMERGE", pfMergeCommand)
+}
+
+//PFADD key [element ...]
+func pfAddCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) < 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.PFAdd(c.GetTxn(), c.args[0], c.args[1:]...)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+
+//PFCOUNT key [key ...]
+func pfCountCommand(c *Client) (err error) {
+	var (
+		count int64
+	)
+	if len(c.args) < 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	count, err = c.tdb.PFCount(c.GetTxn(), c.args...)
+	if err != nil {
+		return
+	}
+	return c.Resp(count)
+}
+
+//PFMERGE destkey [sourcekey ...]
+func pfMergeCommand(c *Client) (err error) {
+	if len(c.args) < 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	err = c.tdb.PFMerge(c.GetTxn(), c.args[0], c.args[1:]...)
+	if err != nil {
+		return
+	}
+	return c.Resp("OK")
+}
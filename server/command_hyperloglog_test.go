@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(1), "PFADD", "h1")
+	c.expect(int64(0), "PFADD", "h1")
+	c.expect(int64(0), "PFCOUNT", "h1")
+	c.expect(int64(1), "PFADD", "h1", "a", "b", "c")
+	c.expect(int64(0), "PFADD", "h1", "a", "b")
+	c.expect(int64(3), "PFCOUNT", "h1")
+	if v, ok := c.do("GET", "h1").(string); !ok || !strings.HasPrefix(v, "HYLL") {
+		t.Fatalf("GET of a HyperLogLog: %q", v)
+	}
+	c.expect(int64(0), "PFCOUNT", "none")
+
+	//large enough to be converted to the dense encoding
+	for i := 0; i < 5000; i += 100 {
+		args := []interface{}{"h2"}
+		for j := i; j < i+100; j++ {
+			args = append(args, fmt.Sprintf("m%d", j))
+		}
+		c.do("PFADD", args...)
+	}
+	if n := c.integer("PFCOUNT", "h2"); n < 4900 || n > 5100 {
+		t.Fatalf("PFCOUNT of 5000 elements: %d", n)
+	}
+	if n := c.integer("PFCOUNT", "h1", "h2"); n < 4900 || n > 5100 {
+		t.Fatalf("PFCOUNT of the union: %d", n)
+	}
+	c.expect("OK", "PFMERGE", "h3", "h1", "h2")
+	if n := c.integer("PFCOUNT", "h3"); n < 4900 || n > 5100 {
+		t.Fatalf("PFCOUNT after PFMERGE: %d", n)
+	}
+	c.expect("OK", "PFMERGE", "h4")
+	c.expect(int64(0), "PFCOUNT", "h4")
+
+	c.expect("OK", "SET", "s", "not a hll")
+	c.expectError("WRONGTYPE", "PFADD", "s", "a")
+	c.expectError("WRONGTYPE", "PFCOUNT", "s")
+	c.expect(int64(1), "RPUSH", "l", "a")
+	c.expectError("WRONGTYPE", "PFMERGE", "h4", "l")
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+)
+
+func init() {
+	commandRegister("EXISTS", existsCommand)
+	commandRegister("TYPE", typeCommand)
+	commandRegister("PERSIST", persistCommand)
+	commandRegister("RENAME", renameCommand)
+	commandRegister("RENAMENX", renamenxCommand)
+	commandRegister("COPY", copyCommand)
+	commandRegister("TOUCH", touchCommand)
+	commandRegister("UNLINK", unlinkCommand)
+	commandRegister("SCAN", scanCommand)
+}
+func existsCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) < 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.Exists(c.GetTxn(), c.args...)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+func typeCommand(c *Client) (err error) {
+	var (
+		typeName string
+	)
+	if len(c.args) != 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	typeName, err = c.tdb.Type(c.GetTxn(), c.args[0])
+	if err != nil {
+		return
+	}
+	return c.Resp(typeName)
+}
+func persistCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) != 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.Persist(c.GetTxn(), c.args[0])
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+func renameCommand(c *Client) (err error) {
+	if len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	err = c.tdb.Rename(c.GetTxn(), c.args[0], c.args[1])
+	if err != nil {
+		return
+	}
+	return c.Resp("OK")
+}
+func renamenxCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.RenameNX(c.GetTxn(), c.args[0], c.args[1])
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+func copyCommand(c *Client) (err error) {
+	var (
+		ret     int64
+		replace bool
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	for i := 2; i < len(c.args); i++ {
+		if strings.ToUpper(string(c.args[i])) == "REPLACE" {
+			replace = true
+		} else {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+	}
+	ret, err = c.tdb.Copy(c.GetTxn(), c.args[0], c.args[1], replace)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+func touchCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) < 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.Touch(c.GetTxn(), c.args...)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+func unlinkCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) < 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.Unlink(c.GetTxn(), c.args...)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+func scanCommand(c *Client) (err error) {
+	var (
+		next     []byte
+		keys     []interface{}
+		match    []byte
+		count    int64
+		typeName string
+	)
+	if len(c.args) < 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	match, count, typeName, err = parseScanArgs(c.args[1:], true)
+	if err != nil {
+		return
+	}
+	next, keys, err = c.tdb.Scan(c.GetTxn(), c.namespace(), c.args[0], match, count, typeName)
+	if err != nil {
+		return
+	}
+	return c.Resp([]interface{}{next, keys})
+}
+
+//parseScanArgs parses [MATCH pattern] [COUNT count] [TYPE type] of the scan commands
+func parseScanArgs(args [][]byte, withType bool) (match []byte, count int64, typeName string, err error) {
+	for i := 0; i < len(args); i = i + 2 {
+		if i+1 >= len(args) {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			match = args[i+1]
+		case "COUNT":
+			count, err = utils.StrBytesToInt64(args[i+1])
+			if err != nil || count <= 0 {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+		case "TYPE":
+			if !withType {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			typeName = strings.ToLower(string(args[i+1]))
+		default:
+			err = qkverror.ErrorCommandParams
+			return
+		}
+	}
+	return
+}
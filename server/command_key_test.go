@@ -0,0 +1,123 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestKeyCommands(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("OK", "SET", "str", "v")
+	c.expect(int64(2), "RPUSH", "list", "a", "b")
+	c.expect(int64(1), "SADD", "set", "a")
+	c.expect(int64(1), "HSET", "hash", "f", "v")
+	c.expect(int64(1), "ZADD", "zset", 1, "a")
+	for key, typ := range map[string]string{"str": "string", "list": "list", "set": "set", "hash": "hash", "zset": "zset", "none": "none"} {
+		c.expect(typ, "TYPE", key)
+	}
+	c.expect(int64(3), "EXISTS", "str", "list", "none", "str")
+	c.expect(int64(2), "TOUCH", "str", "list", "none")
+
+	c.expect(int64(1), "EXPIRE", "str", 100)
+	c.expect(int64(1), "PERSIST", "str")
+	c.expect(int64(0), "PERSIST", "str")
+	c.expect(int64(-1), "TTL", "str")
+	c.expect(int64(0), "PERSIST", "none")
+
+	c.expect(int64(1), "EXPIRE", "list", 100)
+	c.expect("OK", "RENAME", "list", "list2")
+	c.expect(int64(0), "EXISTS", "list")
+	c.expect(strs("a", "b"), "LRANGE", "list2", 0, -1)
+	if ttl := c.integer("TTL", "list2"); ttl <= 0 || ttl > 100 {
+		t.Fatalf("TTL after RENAME: %d", ttl)
+	}
+	c.expectError("", "RENAME", "none", "x")
+	c.expect(int64(0), "RENAMENX", "list2", "set")
+	c.expect(int64(1), "RENAMENX", "list2", "list")
+	c.expect("OK", "RENAME", "hash", "set")
+	c.expect("hash", "TYPE", "set")
+
+	c.expect(int64(1), "COPY", "zset", "zset2")
+	c.expect(int64(0), "COPY", "zset", "str")
+	c.expect(int64(1), "COPY", "zset", "str", "REPLACE")
+	c.expect(int64(1), "ZADD", "zset", 2, "b")
+	c.expect(strs("a"), "ZRANGE", "zset2", 0, -1)
+	c.expect(strs("a"), "ZRANGE", "str", 0, -1)
+	c.expect(int64(0), "COPY", "none", "x")
+	c.expectError("", "COPY", "zset", "x", "DB")
+
+	c.expect(int64(2), "UNLINK", "zset", "zset2", "none")
+	c.expect(int64(0), "EXISTS", "zset", "zset2")
+}
+
+//scanAll run a scan command from cursor 0 until the cursor is back to 0, returns all the items.
+//The key of HSCAN, SSCAN and ZSCAN is the first of args.
+func (c *testClient) scanAll(cmd string, args ...interface{}) (items []interface{}) {
+	c.t.Helper()
+	var key []interface{}
+	if cmd != "SCAN" {
+		key, args = args[:1], args[1:]
+	}
+	cursor := "0"
+	for i := 0; i < 1000; i++ {
+		got := c.array(cmd, append(append(append([]interface{}{}, key...), cursor), args...)...)
+		if len(got) != 2 {
+			c.t.Fatalf("%s: got %#v", cmd, got)
+		}
+		items = append(items, got[1].([]interface{})...)
+		if cursor = got[0].(string); cursor == "0" {
+			return
+		}
+	}
+	c.t.Fatalf("%s: the cursor never came back to 0", cmd)
+	return
+}
+
+func TestScan(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	var keys []string
+	for i := 0; i < 25; i++ {
+		keys = append(keys, fmt.Sprintf("key:%02d", i))
+		c.expect("OK", "SET", keys[i], "v")
+	}
+	c.expect(int64(1), "SADD", "set", "a")
+	if got := sorted(c.scanAll("SCAN", "COUNT", 4)); len(got) != 26 {
+		t.Fatalf("SCAN: got %v", got)
+	}
+	if got := sorted(c.scanAll("SCAN", "MATCH", "key:1*", "COUNT", 3)); !reflect.DeepEqual(got, keys[10:20]) {
+		t.Fatalf("SCAN MATCH: got %v", got)
+	}
+	if got := sorted(c.scanAll("SCAN", "TYPE", "set")); !reflect.DeepEqual(got, []string{"set"}) {
+		t.Fatalf("SCAN TYPE: got %v", got)
+	}
+	c.expectError("", "SCAN", 0, "COUNT", 0)
+
+	for i := 0; i < 10; i++ {
+		c.expect(int64(1), "HSET", "hash", fmt.Sprintf("f%d", i), i)
+		c.expect(int64(1), "SADD", "set", fmt.Sprintf("m%d", i))
+		c.expect(int64(1), "ZADD", "zset", i, fmt.Sprintf("m%d", i))
+	}
+	if got := c.scanAll("HSCAN", "hash", "COUNT", 3); len(got) != 20 {
+		t.Fatalf("HSCAN: got %v", got)
+	}
+	if got := c.scanAll("HSCAN", "hash", "MATCH", "f1"); !equalReply(got, strs("f1", "1")) {
+		t.Fatalf("HSCAN MATCH: got %v", got)
+	}
+	if got := sorted(c.scanAll("SSCAN", "set", "COUNT", 3)); len(got) != 11 {
+		t.Fatalf("SSCAN: got %v", got)
+	}
+	if got := c.scanAll("ZSCAN", "zset", "MATCH", "m[12]"); !equalReply(got, strs("m1", "1", "m2", "2")) {
+		t.Fatalf("ZSCAN MATCH: got %v", got)
+	}
+	if got := c.scanAll("ZSCAN", "none"); len(got) != 0 {
+		t.Fatalf("ZSCAN of a missing key: got %v", got)
+	}
+	c.expectError("", "SSCAN", "set", 0, "TYPE", "set")
+}
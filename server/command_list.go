@@ -1,20 +1,39 @@
 package server
 
 import (
+	"io"
+	"math"
+	"strings"
+	"time"
+
 	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/tidis"
 	"github.com/chuangyou/qkv/utils"
 )
 
+//blockPollInterval blocked clients check their keys at least this often, for items pushed through other instances
+var blockPollInterval = 100 * time.Millisecond
+
 func init() {
+	commandRegister("BLMOVE", bLMoveCommand)
+	commandRegister("BLPOP", bLPopCommand)
+	commandRegister("BRPOP", bRPopCommand)
 	commandRegister("LINDEX", lIndexCommand)
+	commandRegister("LINSERT", lInsertCommand)
 	commandRegister("LLEN", lLenCommand)
+	commandRegister("LMOVE", lMoveCommand)
 	commandRegister("LPOP", lPopCommand)
+	commandRegister("LPOS", lPosCommand)
 	commandRegister("LPUSH", lPushCommand)
+	commandRegister("LPUSHX", lPushXCommand)
 	commandRegister("LRANGE", lRangeComamnd)
+	commandRegister("LREM", lRemCommand)
 	commandRegister("LSET", lSetComamnd)
 	commandRegister("LTRIM", lTrimCommand)
 	commandRegister("RPOP", rPopCommand)
+	commandRegister("RPOPLPUSH", rPopLPushCommand)
 	commandRegister("RPUSH", rPushCommand)
+	commandRegister("RPUSHX", rPushXCommand)
 }
 func lIndexCommand(c *Client) (err error) {
 	var (
@@ -36,6 +55,32 @@ func lIndexCommand(c *Client) (err error) {
 	}
 	return c.Resp(value)
 }
+
+//LINSERT key BEFORE|AFTER pivot element
+func lInsertCommand(c *Client) (err error) {
+	var (
+		ret    int64
+		before bool
+	)
+	if len(c.args) != 4 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	switch strings.ToUpper(string(c.args[1])) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+		before = false
+	default:
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.LInsert(c.GetTxn(), c.args[0], before, c.args[2], c.args[3])
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
 func lLenCommand(c *Client) (err error) {
 	var (
 		ret int64
@@ -51,6 +96,31 @@ func lLenCommand(c *Client) (err error) {
 	}
 	return c.Resp(ret)
 }
+
+//LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func lMoveCommand(c *Client) (err error) {
+	var (
+		srcDirec, destDirec uint8
+		value               []byte
+	)
+	if len(c.args) != 4 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	srcDirec, err = parseListDirection(c.args[2])
+	if err != nil {
+		return
+	}
+	destDirec, err = parseListDirection(c.args[3])
+	if err != nil {
+		return
+	}
+	value, err = c.tdb.LMove(c.GetTxn(), c.args[0], c.args[1], srcDirec, destDirec)
+	if err != nil {
+		return
+	}
+	return c.Resp(value)
+}
 func lPopCommand(c *Client) (err error) {
 	var (
 		value []byte
@@ -66,6 +136,71 @@ func lPopCommand(c *Client) (err error) {
 	}
 	return c.Resp(value)
 }
+
+//LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func lPosCommand(c *Client) (err error) {
+	var (
+		rank      int64 = 1
+		count     int64
+		maxlen    int64
+		withCount bool
+		value     int64
+		positions []int64
+		resp      []interface{}
+	)
+	if len(c.args) < 2 || len(c.args)%2 != 0 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	for i := 2; i < len(c.args); i = i + 2 {
+		value, err = utils.StrBytesToInt64(c.args[i+1])
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(string(c.args[i])) {
+		case "RANK":
+			if value == 0 {
+				err = qkverror.ErrorRankZero
+				return
+			}
+			rank = value
+		case "COUNT":
+			if value < 0 {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			count = value
+			withCount = true
+		case "MAXLEN":
+			if value < 0 {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			maxlen = value
+		default:
+			err = qkverror.ErrorCommandParams
+			return
+		}
+	}
+	if !withCount {
+		count = 1
+	}
+	positions, err = c.tdb.LPos(c.GetTxn(), c.args[0], c.args[1], rank, count, maxlen)
+	if err != nil {
+		return
+	}
+	if !withCount {
+		if len(positions) == 0 {
+			return c.Resp(nil)
+		}
+		return c.Resp(positions[0])
+	}
+	resp = make([]interface{}, len(positions))
+	for i, pos := range positions {
+		resp[i] = pos
+	}
+	return c.Resp(resp)
+}
 func lPushCommand(c *Client) (err error) {
 	var (
 		ret int64
@@ -81,6 +216,20 @@ func lPushCommand(c *Client) (err error) {
 	}
 	return c.Resp(ret)
 }
+func lPushXCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.LPushX(c.GetTxn(), c.args[0], utils.LHeadDirection, c.args[1:]...)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
 func lRangeComamnd(c *Client) (err error) {
 	var (
 		start, end int64
@@ -106,6 +255,27 @@ func lRangeComamnd(c *Client) (err error) {
 	}
 	return c.Resp(value)
 }
+
+//LREM key count element
+func lRemCommand(c *Client) (err error) {
+	var (
+		count int64
+		ret   int64
+	)
+	if len(c.args) != 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	count, err = utils.StrBytesToInt64(c.args[1])
+	if err != nil {
+		return
+	}
+	ret, err = c.tdb.LRem(c.GetTxn(), c.args[0], count, c.args[2])
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
 func lSetComamnd(c *Client) (err error) {
 	var (
 		index int64
@@ -161,6 +331,22 @@ func rPopCommand(c *Client) (err error) {
 	}
 	return c.Resp(value)
 }
+
+//RPOPLPUSH source destination
+func rPopLPushCommand(c *Client) (err error) {
+	var (
+		value []byte
+	)
+	if len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	value, err = c.tdb.LMove(c.GetTxn(), c.args[0], c.args[1], utils.LTailDirection, utils.LHeadDirection)
+	if err != nil {
+		return
+	}
+	return c.Resp(value)
+}
 func rPushCommand(c *Client) (err error) {
 	var (
 		ret int64
@@ -176,3 +362,174 @@ func rPushCommand(c *Client) (err error) {
 	}
 	return c.Resp(ret)
 }
+func rPushXCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.LPushX(c.GetTxn(), c.args[0], utils.LTailDirection, c.args[1:]...)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+func bLPopCommand(c *Client) (err error) {
+	return c.bPop(utils.LHeadDirection)
+}
+func bRPopCommand(c *Client) (err error) {
+	return c.bPop(utils.LTailDirection)
+}
+
+//bPop BLPOP/BRPOP key [key ...] timeout
+func (c *Client) bPop(direc uint8) (err error) {
+	var (
+		timeout time.Duration
+		key     []byte
+		item    []byte
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	timeout, err = parseBlockTimeout(c.args[len(c.args)-1])
+	if err != nil {
+		return
+	}
+	key, item, err = c.block(c.args[:len(c.args)-1], timeout, func(key []byte) ([]byte, error) {
+		return c.tdb.LPop(c.GetTxn(), key, direc)
+	})
+	if err != nil {
+		return
+	}
+	if item == nil {
+		return c.Resp([]interface{}(nil))
+	}
+	return c.Resp([]interface{}{c.userKey(key), item})
+}
+
+//BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func bLMoveCommand(c *Client) (err error) {
+	var (
+		srcDirec, destDirec uint8
+		timeout             time.Duration
+		item                []byte
+	)
+	if len(c.args) != 5 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	srcDirec, err = parseListDirection(c.args[2])
+	if err != nil {
+		return
+	}
+	destDirec, err = parseListDirection(c.args[3])
+	if err != nil {
+		return
+	}
+	timeout, err = parseBlockTimeout(c.args[4])
+	if err != nil {
+		return
+	}
+	_, item, err = c.block(c.args[:1], timeout, func(key []byte) ([]byte, error) {
+		return c.tdb.LMove(c.GetTxn(), key, c.args[1], srcDirec, destDirec)
+	})
+	if err != nil {
+		return
+	}
+	return c.Resp(item)
+}
+
+//block pop from the first non empty list of keys, waiting up to timeout (0 waits forever) for an item.
+//Inside MULTI it never waits. Clients blocked on a key are served in the order they blocked,
+//a client closing the connection stops waiting without popping.
+func (c *Client) block(keys [][]byte, timeout time.Duration, pop func(key []byte) ([]byte, error)) (key, item []byte, err error) {
+	var (
+		waiter  *tidis.Waiter
+		expired <-chan time.Time
+		poll    *time.Ticker
+		gone    <-chan struct{}
+		stop    func()
+	)
+	for _, key = range keys {
+		item, err = pop(key)
+		if err != nil || item != nil {
+			return
+		}
+	}
+	key = nil
+	if c.isTxn {
+		return
+	}
+	waiter = c.tdb.BlockOn(keys...)
+	defer c.tdb.Unblock(waiter)
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	poll = time.NewTicker(blockPollInterval)
+	defer poll.Stop()
+	gone, stop = c.watchDisconnect()
+	defer stop()
+	for {
+		select {
+		case <-expired:
+			return
+		case <-gone:
+			err = io.EOF
+			return
+		case <-waiter.Notify():
+		case <-poll.C:
+		}
+		for _, key = range keys {
+			if !c.tdb.IsFirstWaiter(waiter, key) {
+				continue
+			}
+			select {
+			case <-gone:
+				key, err = nil, io.EOF
+				return
+			default:
+			}
+			item, err = pop(key)
+			if err != nil || item != nil {
+				return
+			}
+		}
+		key = nil
+	}
+}
+
+//parseBlockTimeout timeout of the blocking commands in seconds, decimals allowed
+func parseBlockTimeout(arg []byte) (timeout time.Duration, err error) {
+	var (
+		seconds float64
+	)
+	seconds, err = utils.StrBytesToFloat64(arg)
+	if err != nil || seconds*float64(time.Second) > math.MaxInt64 {
+		err = qkverror.ErrorTimeoutNotFloat
+		return
+	}
+	if seconds < 0 {
+		err = qkverror.ErrorTimeoutNegative
+		return
+	}
+	timeout = time.Duration(seconds * float64(time.Second))
+	return
+}
+
+//parseListDirection LEFT is the head of a list, RIGHT the tail
+func parseListDirection(arg []byte) (direc uint8, err error) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		direc = utils.LHeadDirection
+	case "RIGHT":
+		direc = utils.LTailDirection
+	default:
+		err = qkverror.ErrorCommandParams
+	}
+	return
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListPushPop(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(2), "LPUSH", "l", "b", "a")
+	c.expect(int64(4), "RPUSH", "l", "c", "d")
+	c.expect(strs("a", "b", "c", "d"), "LRANGE", "l", 0, -1)
+	c.expect(int64(4), "LLEN", "l")
+	c.expect("a", "LPOP", "l")
+	c.expect("d", "RPOP", "l")
+	c.expect(strs("b", "c"), "LRANGE", "l", 0, -1)
+	c.expect("c", "RPOP", "l")
+	c.expect("b", "LPOP", "l")
+	c.expect(nil, "LPOP", "l")
+	c.expect(int64(0), "LLEN", "l")
+	c.expect("OK", "SET", "s", "v")
+	c.expectError("WRONGTYPE", "LPUSH", "s", "a")
+}
+
+func TestListIndex(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(5), "RPUSH", "l", "a", "b", "c", "d", "e")
+	c.expect("a", "LINDEX", "l", 0)
+	c.expect("e", "LINDEX", "l", -1)
+	c.expect(nil, "LINDEX", "l", 10)
+	c.expect("OK", "LSET", "l", 1, "B")
+	c.expect("OK", "LSET", "l", -1, "E")
+	c.expectError("", "LSET", "l", 10, "x")
+	c.expect(strs("B", "c"), "LRANGE", "l", 1, 2)
+	c.expect(strs("d", "E"), "LRANGE", "l", -2, 10)
+	c.expect([]interface{}{}, "LRANGE", "l", 6, 10)
+	c.expect("OK", "LTRIM", "l", 1, -2)
+	c.expect(strs("B", "c", "d"), "LRANGE", "l", 0, -1)
+	c.expect("OK", "LTRIM", "l", 5, 10)
+	c.expect(int64(0), "LLEN", "l")
+}
+
+//waitBlocked wait for the clients to block on their keys
+func waitBlocked() {
+	time.Sleep(50 * time.Millisecond)
+}
+
+//the blocked clients are woken up by the pushes of MULTI/EXEC and LMOVE, not by polling
+func TestBlockingWake(t *testing.T) {
+	defer func(interval time.Duration) { blockPollInterval = interval }(blockPollInterval)
+	blockPollInterval = time.Minute
+	s := newTestServer(t, nil)
+	defer s.Close()
+	b := s.dial()
+	defer b.Close()
+	c := s.dial()
+	defer c.Close()
+	b.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	b.send("BLPOP", "l", 0)
+	waitBlocked()
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "RPUSH", "l", "a")
+	c.expect([]interface{}{int64(1)}, "EXEC")
+	if got := b.receive(); !equalReply(got, strs("l", "a")) {
+		t.Fatalf("BLPOP after EXEC: got %#v", got)
+	}
+
+	c.expect(int64(1), "RPUSH", "src", "b")
+	b.send("BRPOP", "dest", 0)
+	waitBlocked()
+	c.expect("b", "LMOVE", "src", "dest", "LEFT", "RIGHT")
+	if got := b.receive(); !equalReply(got, strs("dest", "b")) {
+		t.Fatalf("BRPOP after LMOVE: got %#v", got)
+	}
+
+	b.send("XREAD", "BLOCK", 0, "STREAMS", "s", "$")
+	waitBlocked()
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "XADD", "s", "1-1", "f", "v")
+	c.expect(strs("1-1"), "EXEC")
+	if got := b.receive(); !equalReply(got, []interface{}{[]interface{}{"s", []interface{}{[]interface{}{"1-1", strs("f", "v")}}}}) {
+		t.Fatalf("XREAD after EXEC: got %#v", got)
+	}
+}
+
+//a blocked client closing its connection does not pop the items pushed after it left
+func TestBlockingDisconnect(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	b := s.dial()
+	c := s.dial()
+	defer c.Close()
+
+	b.send("BLPOP", "l", 0)
+	waitBlocked()
+	b.Close()
+	waitBlocked()
+	c.expect(int64(1), "RPUSH", "l", "a")
+	time.Sleep(2 * blockPollInterval)
+	c.expect(strs("a"), "LRANGE", "l", 0, -1)
+
+	//a client pipelining a command after the blocking one is still served
+	b = s.dial()
+	defer b.Close()
+	b.send("BLPOP", "l2", 0)
+	b.send("PING")
+	waitBlocked()
+	c.expect(int64(1), "RPUSH", "l2", "x")
+	if got := b.receive(); !equalReply(got, strs("l2", "x")) {
+		t.Fatalf("BLPOP: got %#v", got)
+	}
+	if got := b.receive(); !equalReply(got, "PONG") {
+		t.Fatalf("PING: got %#v", got)
+	}
+}
+
+func TestBlockingPop(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	b1 := s.dial()
+	defer b1.Close()
+	b2 := s.dial()
+	defer b2.Close()
+
+	c.expect(int64(2), "RPUSH", "l2", "a", "b")
+	c.expect(strs("l2", "a"), "BLPOP", "l1", "l2", 1)
+	c.expect(strs("l2", "b"), "BRPOP", "l1", "l2", 1)
+	c.expect(nil, "BLPOP", "l1", 0.1)
+	c.expectError("", "BLPOP", "l1", -1)
+
+	//the clients blocked on a key are served in the order they blocked
+	b1.send("BLPOP", "l1", 0)
+	waitBlocked()
+	b2.send("BLPOP", "l1", 0)
+	waitBlocked()
+	c.expect(int64(2), "RPUSH", "l1", "x", "y")
+	if got := b1.receive(); !equalReply(got, strs("l1", "x")) {
+		t.Fatalf("first client: got %#v", got)
+	}
+	if got := b2.receive(); !equalReply(got, strs("l1", "y")) {
+		t.Fatalf("second client: got %#v", got)
+	}
+
+	b1.send("BLMOVE", "src", "dest", "RIGHT", "LEFT", 0)
+	waitBlocked()
+	c.expect(int64(2), "RPUSH", "src", "a", "b")
+	if got := b1.receive(); !equalReply(got, "b") {
+		t.Fatalf("BLMOVE: got %#v", got)
+	}
+	c.expect(strs("b"), "LRANGE", "dest", 0, -1)
+	c.expect(nil, "BLMOVE", "none", "dest", "LEFT", "LEFT", 0.1)
+
+	//inside MULTI the blocking commands never wait
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "BLPOP", "none", 0)
+	c.expect([]interface{}{nil}, "EXEC")
+}
+
+func TestListEdit(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(0), "LPUSHX", "l", "a")
+	c.expect(int64(0), "RPUSHX", "l", "a")
+	c.expect(int64(0), "EXISTS", "l")
+	c.expect(int64(3), "RPUSH", "l", "a", "b", "a")
+	c.expect(int64(4), "LPUSHX", "l", "c")
+	c.expect(int64(5), "RPUSHX", "l", "d")
+	c.expect(strs("c", "a", "b", "a", "d"), "LRANGE", "l", 0, -1)
+
+	c.expect(int64(6), "LINSERT", "l", "BEFORE", "b", "x")
+	c.expect(int64(7), "LINSERT", "l", "AFTER", "d", "y")
+	c.expect(int64(-1), "LINSERT", "l", "AFTER", "none", "y")
+	c.expect(int64(0), "LINSERT", "none", "AFTER", "a", "y")
+	c.expect(strs("c", "a", "x", "b", "a", "d", "y"), "LRANGE", "l", 0, -1)
+
+	c.expect(int64(1), "LPOS", "l", "a")
+	c.expect(int64(4), "LPOS", "l", "a", "RANK", 2)
+	c.expect(int64(4), "LPOS", "l", "a", "RANK", -1)
+	c.expect([]interface{}{int64(1), int64(4)}, "LPOS", "l", "a", "COUNT", 0)
+	c.expect([]interface{}{int64(1)}, "LPOS", "l", "a", "COUNT", 0, "MAXLEN", 3)
+	c.expect(nil, "LPOS", "l", "none")
+	c.expect([]interface{}{}, "LPOS", "l", "none", "COUNT", 1)
+	c.expectError("", "LPOS", "l", "a", "RANK", 0)
+
+	c.expect(int64(1), "LREM", "l", -1, "a")
+	c.expect(strs("c", "a", "x", "b", "d", "y"), "LRANGE", "l", 0, -1)
+	c.expect(int64(1), "RPUSH", "l2", "a")
+	c.expect(int64(1), "LREM", "l", 0, "a")
+	c.expect(int64(0), "LREM", "l", 0, "a")
+
+	c.expect("y", "RPOPLPUSH", "l", "l2")
+	c.expect(strs("y", "a"), "LRANGE", "l2", 0, -1)
+	c.expect("y", "LMOVE", "l2", "l2", "LEFT", "RIGHT")
+	c.expect(strs("a", "y"), "LRANGE", "l2", 0, -1)
+	c.expect(nil, "RPOPLPUSH", "none", "l2")
+	c.expect("OK", "SET", "s", "v")
+	c.expectError("WRONGTYPE", "RPOPLPUSH", "l", "s")
+	c.expect(strs("c", "x", "b", "d"), "LRANGE", "l", 0, -1)
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+)
+
+func init() {
+	commandRegister("PUBLISH", publishCommand)
+	commandRegister("PUBSUB", pubsubCommand)
+}
+
+//PUBLISH channel message, returns the number of clients of this instance that received the message. The subscribers
+//of the other instances get it through the pub/sub log and are not counted, like PUBLISH in a redis cluster.
+func publishCommand(c *Client) (err error) {
+	var (
+		receivers int64
+		channel   []byte
+	)
+	if len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	channel, err = c.channelInTenant(c.args[0])
+	if err != nil {
+		return
+	}
+	err = c.tdb.Publish(c.GetTxn(), channel, c.args[1])
+	if err != nil {
+		return
+	}
+	//inside MULTI the message is delivered after commit through the pub/sub log
+	if !c.isTxn {
+		receivers = c.pubsub.Publish(channel, c.args[1])
+	}
+	return c.Resp(receivers)
+}
+
+//PUBSUB CHANNELS [pattern] | NUMSUB [channel ...] | NUMPAT, about the subscribers of this instance
+func pubsubCommand(c *Client) (err error) {
+	var (
+		resp    []interface{}
+		channel []byte
+	)
+	if len(c.args) < 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	switch strings.ToUpper(string(c.args[0])) {
+	case "CHANNELS":
+		if len(c.args) > 2 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		if len(c.args) == 2 {
+			return c.Resp(c.pubsub.Channels(c.tenant.Prefix(), c.args[1]))
+		}
+		return c.Resp(c.pubsub.Channels(c.tenant.Prefix(), nil))
+	case "NUMSUB":
+		resp = make([]interface{}, 0, 2*(len(c.args)-1))
+		for _, arg := range c.args[1:] {
+			channel, err = c.channelInTenant(arg)
+			if err != nil {
+				return
+			}
+			resp = append(resp, arg, c.pubsub.NumSub(channel))
+		}
+		return c.Resp(resp)
+	case "NUMPAT":
+		if len(c.args) != 1 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		return c.Resp(c.pubsub.NumPat(c.tenant.Prefix()))
+	default:
+		err = qkverror.ErrorCommandParams
+		return
+	}
+}
+
+//subscribeCommand SUBSCRIBE, UNSUBSCRIBE, PSUBSCRIBE and PUNSUBSCRIBE, the client is in subscribed state
+//while it has at least one subscription.
+func (c *Client) subscribeCommand() {
+	var (
+		names [][]byte
+		name  []byte
+		kind  string
+		user  []byte
+		err   error
+	)
+	if c.isTxn {
+		c.flushLocked(qkverror.ErrorSubscribeInMulti)
+		return
+	}
+	for _, arg := range c.args {
+		name, err = c.channelInTenant(arg)
+		if err != nil {
+			c.flushLocked(err)
+			return
+		}
+		names = append(names, name)
+	}
+	switch c.cmd {
+	case "SUBSCRIBE", "PSUBSCRIBE":
+		if len(names) == 0 {
+			c.flushLocked(qkverror.ErrorCommandParams)
+			return
+		}
+	case "UNSUBSCRIBE":
+		if len(names) == 0 {
+			names = subscriptionNames(c.channels)
+		}
+	case "PUNSUBSCRIBE":
+		if len(names) == 0 {
+			names = subscriptionNames(c.patterns)
+		}
+	}
+	kind = strings.ToLower(c.cmd)
+	if len(names) == 0 {
+		//unsubscribe without any subscription
+		c.flushLocked([]interface{}{[]byte(kind), nil, c.subscriptionCount()})
+		return
+	}
+	if c.cmd == "SUBSCRIBE" || c.cmd == "PSUBSCRIBE" {
+		c.startPushes()
+	}
+	//the last reply is queued before the client leaves the subscribed state
+	defer func() {
+		if c.subscriptionCount() == 0 {
+			c.stopPushes()
+		}
+	}()
+	for _, name := range names {
+		switch c.cmd {
+		case "SUBSCRIBE":
+			c.pubsub.Subscribe(c, name)
+			c.channels[string(name)] = true
+		case "UNSUBSCRIBE":
+			c.pubsub.Unsubscribe(c, name)
+			delete(c.channels, string(name))
+		case "PSUBSCRIBE":
+			c.pubsub.PSubscribe(c, name)
+			c.patterns[string(name)] = true
+		case "PUNSUBSCRIBE":
+			c.pubsub.PUnsubscribe(c, name)
+			delete(c.patterns, string(name))
+		}
+		//the names are replied without the prefix of the tenant
+		_, user = utils.SplitTenant(name)
+		if err = c.flushLocked([]interface{}{[]byte(kind), user, c.subscriptionCount()}); err != nil {
+			return
+		}
+	}
+}
+
+//channelInTenant the channel or pattern name in the namespace of the tenant of the client
+func (c *Client) channelInTenant(name []byte) ([]byte, error) {
+	if utils.IsReservedKey(name) {
+		return nil, qkverror.ErrorReservedKey
+	}
+	return append(append([]byte{}, c.tenant.Prefix()...), name...), nil
+}
+
+//subscriptionCount channels and patterns the client subscribed to
+func (c *Client) subscriptionCount() int64 {
+	return int64(len(c.channels) + len(c.patterns))
+}
+func subscriptionNames(subs map[string]bool) (names [][]byte) {
+	for name := range subs {
+		names = append(names, []byte(name))
+	}
+	return
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chuangyou/qkv/config"
+)
+
+func TestPubSub(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	sub := s.dial()
+	defer sub.Close()
+	c := s.dial()
+	defer c.Close()
+
+	sub.expect([]interface{}{"subscribe", "news", int64(1)}, "SUBSCRIBE", "news")
+	sub.expect([]interface{}{"psubscribe", "n*", int64(2)}, "PSUBSCRIBE", "n*")
+	sub.expect("PONG", "PING")
+	sub.expectError("", "GET", "k")
+
+	c.expect(int64(2), "PUBLISH", "news", "hello")
+	if got := sub.receive(); !equalReply(got, strs("message", "news", "hello")) {
+		t.Fatalf("message: got %#v", got)
+	}
+	if got := sub.receive(); !equalReply(got, strs("pmessage", "n*", "news", "hello")) {
+		t.Fatalf("pmessage: got %#v", got)
+	}
+	c.expect(int64(0), "PUBLISH", "other", "hello")
+	c.expect(strs("news"), "PUBSUB", "CHANNELS")
+	c.expect([]interface{}{"news", int64(1), "other", int64(0)}, "PUBSUB", "NUMSUB", "news", "other")
+	c.expect(int64(1), "PUBSUB", "NUMPAT")
+
+	sub.expect([]interface{}{"unsubscribe", "news", int64(1)}, "UNSUBSCRIBE")
+	sub.expect([]interface{}{"punsubscribe", "n*", int64(0)}, "PUNSUBSCRIBE", "n*")
+	//back to the normal replies
+	c.expect(int64(0), "PUBLISH", "news", "hello")
+	sub.expect("OK", "SET", "k", "v")
+	sub.expect("v", "GET", "k")
+}
+
+func TestPubSubLog(t *testing.T) {
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.QKV.PubSubPollInterval = 10
+		conf.QKV.PubSubRetention = 1100
+		conf.QKV.LeaseTimeout = 1000
+	})
+	defer s.Close()
+	s.PubSub()
+	sub := s.dial()
+	defer sub.Close()
+	c := s.dial()
+	defer c.Close()
+
+	//a user key shaped like the keys of the pub/sub log
+	key := "q" + strings.Repeat("\x01", 16)
+	c.expect("OK", "SET", key, "v")
+	sub.expect([]interface{}{"subscribe", "news", int64(1)}, "SUBSCRIBE", "news")
+	//inside MULTI the message is delivered by the poller
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "PUBLISH", "news", "hello")
+	c.expect([]interface{}{int64(0)}, "EXEC")
+	if got := sub.receive(); !equalReply(got, strs("message", "news", "hello")) {
+		t.Fatalf("message: got %#v", got)
+	}
+	//the purge of the log keeps the user keys
+	time.Sleep(2500 * time.Millisecond)
+	c.expect("v", "GET", key)
+	c.expect(int64(1), "PUBLISH", "news", "again")
+	if got := sub.receive(); !equalReply(got, strs("message", "news", "again")) {
+		t.Fatalf("message: got %#v", got)
+	}
+}
+
+//a subscriber that does not read its messages is disconnected, it never blocks the publisher
+func TestPubSubSlowSubscriber(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	sub := s.dial()
+	defer sub.Close()
+	c := s.dial()
+	defer c.Close()
+
+	sub.expect([]interface{}{"subscribe", "news", int64(1)}, "SUBSCRIBE", "news")
+	message := strings.Repeat("x", 16*1024)
+	done := make(chan int64)
+	go func() {
+		var last int64 = 1
+		for i := 0; i < 4*pushQueueSize && last > 0; i++ {
+			last = c.integer("PUBLISH", "news", message)
+		}
+		done <- last
+	}()
+	select {
+	case last := <-done:
+		if last != 0 {
+			t.Fatalf("the slow subscriber is still subscribed")
+		}
+	case <-time.After(60 * time.Second):
+		t.Fatalf("publish blocked by a slow subscriber")
+	}
+	c.expect([]interface{}{"news", int64(0)}, "PUBSUB", "NUMSUB", "news")
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.QKV.PubSubPollInterval = 10
+		conf.QKV.NotifyKeyspaceEvents = "KEl$g"
+	})
+	defer s.Close()
+	s.PubSub()
+	sub := s.dial()
+	defer sub.Close()
+	c := s.dial()
+	defer c.Close()
+
+	sub.expect([]interface{}{"subscribe", "__keyspace@0__:k", int64(1)}, "SUBSCRIBE", "__keyspace@0__:k")
+	sub.expect([]interface{}{"psubscribe", "__keyevent@1__:*", int64(2)}, "PSUBSCRIBE", "__keyevent@1__:*")
+	c.expect("OK", "SET", "k", "v")
+	if got := sub.receive(); !equalReply(got, strs("message", "__keyspace@0__:k", "set")) {
+		t.Fatalf("keyspace event: got %#v", got)
+	}
+	//the hash class is not enabled
+	c.expect(int64(1), "HSET", "k2", "f", "v")
+	c.expect("OK", "SELECT", 1)
+	c.expect(int64(1), "RPUSH", "l", "a")
+	if got := sub.receive(); !equalReply(got, strs("pmessage", "__keyevent@1__:*", "__keyevent@1__:rpush", "l")) {
+		t.Fatalf("keyevent: got %#v", got)
+	}
+	//nothing is published for a discarded transaction
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "DEL", "l")
+	c.expect("OK", "DISCARD")
+	c.expect(int64(1), "DEL", "l")
+	if got := sub.receive(); !equalReply(got, strs("pmessage", "__keyevent@1__:*", "__keyevent@1__:del", "l")) {
+		t.Fatalf("keyevent: got %#v", got)
+	}
+}
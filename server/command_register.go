@@ -1,9 +1,150 @@
 package server
 
+import (
+	"strconv"
+	"strings"
+)
+
 type CommandFunc func(c *Client) error
 
+//KeysFunc the positions of the key arguments of a command
+type KeysFunc func(args [][]byte) []int
+
 var commands = make(map[string]CommandFunc)
 
+//commandKeys the key arguments of the commands whose keys are not just the first argument,
+//nil for the commands without keys
+var commandKeys = map[string]KeysFunc{
+	"ACL":          nil,
+	"BITOP":        keyRange(1, 0, 1),
+	"BLMOVE":       keyAt(0, 1),
+	"BLPOP":        keyRange(0, 1, 1),
+	"BRPOP":        keyRange(0, 1, 1),
+	"COPY":         keyAt(0, 1),
+	"DEL":          keyRange(0, 0, 1),
+	"EXISTS":       keyRange(0, 0, 1),
+	"FLUSHALL":     nil,
+	"FLUSHDB":      nil,
+	"GEORADIUS":    geoRadiusKeys,
+	"LMOVE":        keyAt(0, 1),
+	"MGET":         keyRange(0, 0, 1),
+	"MSET":         keyRange(0, 0, 2),
+	"MSETNX":       keyRange(0, 0, 2),
+	"PFCOUNT":      keyRange(0, 0, 1),
+	"PFMERGE":      keyRange(0, 0, 1),
+	"PSUBSCRIBE":   nil,
+	"PUBLISH":      nil,
+	"PUBSUB":       nil,
+	"PUNSUBSCRIBE": nil,
+	"RENAME":       keyAt(0, 1),
+	"RENAMENX":     keyAt(0, 1),
+	"RPOPLPUSH":    keyAt(0, 1),
+	"SCAN":         nil,
+	"SDIFF":        keyRange(0, 0, 1),
+	"SDIFFSTORE":   keyRange(0, 0, 1),
+	"SELECT":       nil,
+	"SINTER":       keyRange(0, 0, 1),
+	"SINTERCARD":   numKeys(0, false),
+	"SINTERSTORE":  keyRange(0, 0, 1),
+	"SMOVE":        keyAt(0, 1),
+	"SUBSCRIBE":    nil,
+	"SUNION":       keyRange(0, 0, 1),
+	"SUNIONSTORE":  keyRange(0, 0, 1),
+	"SWAPDB":       nil,
+	"TOUCH":        keyRange(0, 0, 1),
+	"UNLINK":       keyRange(0, 0, 1),
+	"UNSUBSCRIBE":  nil,
+	"WATCH":        keyRange(0, 0, 1),
+	"XGROUP":       keyAt(1),
+	"XREAD":        streamKeys,
+	"XREADGROUP":   streamKeys,
+	"ZDIFF":        numKeys(0, false),
+	"ZINTERSTORE":  numKeys(1, true),
+	"ZRANGESTORE":  keyAt(0, 1),
+	"ZUNIONSTORE":  numKeys(1, true),
+}
+
+//commandCategories the ACL categories of the commands that are not just @write
+var commandCategories = map[string][]string{
+	"ACL":              {"admin"},
+	"BITCOUNT":         {"read"},
+	"BITPOS":           {"read"},
+	"EXISTS":           {"read"},
+	"FLUSHALL":         {"admin", "write"},
+	"FLUSHDB":          {"admin", "write"},
+	"GEODIST":          {"read"},
+	"GEOPOS":           {"read"},
+	"GEOSEARCH":        {"read"},
+	"GET":              {"read"},
+	"GETBIT":           {"read"},
+	"GETRANGE":         {"read"},
+	"HEXISTS":          {"read"},
+	"HEXPIRETIME":      {"read"},
+	"HGET":             {"read"},
+	"HGETALL":          {"read"},
+	"HKEYS":            {"read"},
+	"HLEN":             {"read"},
+	"HMGET":            {"read"},
+	"HPEXPIRETIME":     {"read"},
+	"HPTTL":            {"read"},
+	"HRANDFIELD":       {"read"},
+	"HSCAN":            {"read"},
+	"HSTRLEN":          {"read"},
+	"HTTL":             {"read"},
+	"HVALS":            {"read"},
+	"LINDEX":           {"read"},
+	"LLEN":             {"read"},
+	"LPOS":             {"read"},
+	"LRANGE":           {"read"},
+	"MGET":             {"read"},
+	"PFCOUNT":          {"read"},
+	"PSUBSCRIBE":       {"pubsub"},
+	"PTTL":             {"read"},
+	"PUBLISH":          {"pubsub"},
+	"PUBSUB":           {"pubsub"},
+	"PUNSUBSCRIBE":     {"pubsub"},
+	"SCAN":             {"read"},
+	"SCARD":            {"read"},
+	"SDIFF":            {"read"},
+	"SELECT":           {"connection"},
+	"SINTER":           {"read"},
+	"SINTERCARD":       {"read"},
+	"SISMEMBER":        {"read"},
+	"SMEMBERS":         {"read"},
+	"SMISMEMBER":       {"read"},
+	"SRANDMEMBER":      {"read"},
+	"SSCAN":            {"read"},
+	"STRLEN":           {"read"},
+	"SUBSCRIBE":        {"pubsub"},
+	"SUNION":           {"read"},
+	"SWAPDB":           {"admin", "write"},
+	"TOUCH":            {"read"},
+	"TTL":              {"read"},
+	"TYPE":             {"read"},
+	"UNSUBSCRIBE":      {"pubsub"},
+	"XLEN":             {"read"},
+	"XPENDING":         {"read"},
+	"XRANGE":           {"read"},
+	"XREAD":            {"read"},
+	"XREVRANGE":        {"read"},
+	"ZCARD":            {"read"},
+	"ZCOUNT":           {"read"},
+	"ZDIFF":            {"read"},
+	"ZLEXCOUNT":        {"read"},
+	"ZMSCORE":          {"read"},
+	"ZRANDMEMBER":      {"read"},
+	"ZRANGE":           {"read"},
+	"ZRANGEBYLEX":      {"read"},
+	"ZRANGEBYSCORE":    {"read"},
+	"ZRANK":            {"read"},
+	"ZREVRANGE":        {"read"},
+	"ZREVRANGEBYLEX":   {"read"},
+	"ZREVRANGEBYSCORE": {"read"},
+	"ZREVRANK":         {"read"},
+	"ZSCAN":            {"read"},
+	"ZSCORE":           {"read"},
+}
+
 func commandRegister(commandName string, f CommandFunc) {
 	if _, ok := commands[commandName]; ok {
 		return
@@ -14,3 +155,96 @@ func getCommandFunc(commandName string) (f CommandFunc, ok bool) {
 	f, ok = commands[commandName]
 	return
 }
+
+//getCommandCategories the ACL categories of commandName
+func getCommandCategories(commandName string) []string {
+	if categories, ok := commandCategories[commandName]; ok {
+		return categories
+	}
+	return []string{"write"}
+}
+
+//getCommandKeys the positions of the key arguments of commandName called with args
+func getCommandKeys(commandName string, args [][]byte) []int {
+	f, ok := commandKeys[commandName]
+	if !ok {
+		if len(args) == 0 {
+			return nil
+		}
+		return []int{0}
+	}
+	if f == nil {
+		return nil
+	}
+	return f(args)
+}
+
+//keyRange the arguments from first to the last but skipLast, step apart
+func keyRange(first, skipLast, step int) KeysFunc {
+	return func(args [][]byte) (keys []int) {
+		for i := first; i < len(args)-skipLast; i = i + step {
+			keys = append(keys, i)
+		}
+		return
+	}
+}
+
+//keyAt the arguments at positions
+func keyAt(positions ...int) KeysFunc {
+	return func(args [][]byte) (keys []int) {
+		for _, i := range positions {
+			if i < len(args) {
+				keys = append(keys, i)
+			}
+		}
+		return
+	}
+}
+
+//numKeys [destination] numkeys key [key ...] ..., pos is the position of numkeys
+func numKeys(pos int, dest bool) KeysFunc {
+	return func(args [][]byte) (keys []int) {
+		var (
+			n   int
+			err error
+		)
+		if dest && len(args) > 0 {
+			keys = append(keys, 0)
+		}
+		if len(args) <= pos {
+			return
+		}
+		n, err = strconv.Atoi(string(args[pos]))
+		if err != nil {
+			return
+		}
+		for i := pos + 1; i < len(args) && i <= pos+n; i++ {
+			keys = append(keys, i)
+		}
+		return
+	}
+}
+
+//geoRadiusKeys GEORADIUS key ... [STORE key] [STOREDIST key]
+func geoRadiusKeys(args [][]byte) (keys []int) {
+	keys = []int{0}
+	for i := 5; i < len(args)-1; i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "STORE", "STOREDIST":
+			keys = append(keys, i+1)
+			i++
+		}
+	}
+	return
+}
+
+//streamKeys XREAD ... STREAMS key [key ...] id [id ...]
+func streamKeys(args [][]byte) (keys []int) {
+	for i := range args {
+		if strings.ToUpper(string(args[i])) == "STREAMS" {
+			//the first half of the arguments after STREAMS
+			return keyRange(i+1, (len(args)-i)/2, 1)(args)
+		}
+	}
+	return
+}
@@ -1,7 +1,10 @@
 package server
 
 import (
+	"strings"
+
 	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
 )
 
 func init() {
@@ -10,11 +13,18 @@ func init() {
 	commandRegister("SDIFF", sdiffCommand)
 	commandRegister("SDIFFSTORE", sdiffStoreCommand)
 	commandRegister("SINTER", sinterCommand)
+	commandRegister("SINTERCARD", sinterCardCommand)
 	commandRegister("SINTERSTORE", sinterStoreCommand)
 	commandRegister("SISMEMBER", sismerCommand)
 	commandRegister("SMEMBERS", smembersCommand)
+	commandRegister("SMISMEMBER", smisMemberCommand)
+	commandRegister("SMOVE", smoveCommand)
+	commandRegister("SPOP", spopCommand)
+	commandRegister("SRANDMEMBER", srandMemberCommand)
 	commandRegister("SREM", sremCommand)
+	commandRegister("SSCAN", sscanCommand)
 	commandRegister("SUNION", sunionCommand)
+	commandRegister("SUNIONSTORE", sunionStoreCommand)
 }
 func saddCommand(c *Client) (err error) {
 	var (
@@ -156,3 +166,169 @@ func sunionCommand(c *Client) (err error) {
 	}
 	return c.Resp(value)
 }
+func sscanCommand(c *Client) (err error) {
+	var (
+		next  []byte
+		resp  []interface{}
+		match []byte
+		count int64
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	match, count, _, err = parseScanArgs(c.args[2:], false)
+	if err != nil {
+		return
+	}
+	next, resp, err = c.tdb.SScan(c.GetTxn(), c.args[0], c.args[1], match, count)
+	if err != nil {
+		return
+	}
+	return c.Resp([]interface{}{next, resp})
+}
+func sunionStoreCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+	} else {
+		ret, err = c.tdb.SUnionStore(c.GetTxn(), c.args[0], c.args[1:]...)
+		if err != nil {
+			return
+		}
+	}
+	return c.Resp(ret)
+}
+
+//SPOP key [count]
+func spopCommand(c *Client) (err error) {
+	var (
+		count int64 = 1
+		value []interface{}
+	)
+	if len(c.args) != 1 && len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	if len(c.args) == 2 {
+		count, err = utils.StrBytesToInt64(c.args[1])
+		if err != nil || count < 0 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+	}
+	value, err = c.tdb.SPop(c.GetTxn(), c.args[0], count)
+	if err != nil {
+		return
+	}
+	//a single member without count
+	if len(c.args) == 1 {
+		if len(value) == 0 {
+			return c.Resp(nil)
+		}
+		return c.Resp(value[0])
+	}
+	return c.Resp(value)
+}
+
+//SRANDMEMBER key [count]
+func srandMemberCommand(c *Client) (err error) {
+	var (
+		count int64 = 1
+		value []interface{}
+	)
+	if len(c.args) != 1 && len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	if len(c.args) == 2 {
+		count, err = utils.StrBytesToInt64(c.args[1])
+		if err != nil {
+			return
+		}
+	}
+	value, err = c.tdb.SRandMember(c.GetTxn(), c.args[0], count)
+	if err != nil {
+		return
+	}
+	if len(c.args) == 1 {
+		if len(value) == 0 {
+			return c.Resp(nil)
+		}
+		return c.Resp(value[0])
+	}
+	return c.Resp(value)
+}
+
+//SMOVE source destination member
+func smoveCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) != 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.SMove(c.GetTxn(), c.args[0], c.args[1], c.args[2])
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+
+//SMISMEMBER key member [member ...]
+func smisMemberCommand(c *Client) (err error) {
+	var (
+		value []interface{}
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	value, err = c.tdb.SMIsMember(c.GetTxn(), c.args[0], c.args[1:]...)
+	if err != nil {
+		return
+	}
+	return c.Resp(value)
+}
+
+//SINTERCARD numkeys key [key ...] [LIMIT limit]
+func sinterCardCommand(c *Client) (err error) {
+	var (
+		numKeys int64
+		limit   int64
+		ret     int64
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	numKeys, err = utils.StrBytesToInt64(c.args[0])
+	if err != nil || numKeys <= 0 || numKeys > int64(len(c.args)-1) {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	switch int64(len(c.args)) - 1 - numKeys {
+	case 0:
+	case 2:
+		if strings.ToUpper(string(c.args[numKeys+1])) != "LIMIT" {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		limit, err = utils.StrBytesToInt64(c.args[numKeys+2])
+		if err != nil || limit < 0 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+	default:
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.SInterCard(c.GetTxn(), limit, c.args[1:numKeys+1]...)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSetCommands(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(3), "SADD", "s1", "a", "b", "c")
+	c.expect(int64(1), "SADD", "s1", "a", "d")
+	c.expect(int64(4), "SCARD", "s1")
+	c.expect(int64(1), "SISMEMBER", "s1", "a")
+	c.expect(int64(0), "SISMEMBER", "s1", "x")
+	c.expectSorted(strs("a", "b", "c", "d"), "SMEMBERS", "s1")
+	c.expect(int64(1), "SREM", "s1", "d", "x")
+	c.expect(int64(3), "SADD", "s2", "b", "c", "e")
+	c.expectSorted(strs("a"), "SDIFF", "s1", "s2")
+	c.expectSorted(strs("b", "c"), "SINTER", "s1", "s2")
+	c.expectSorted(strs("a", "b", "c", "e"), "SUNION", "s1", "s2")
+	c.expect(int64(1), "SDIFFSTORE", "d", "s1", "s2")
+	c.expectSorted(strs("a"), "SMEMBERS", "d")
+	c.expect(int64(2), "SINTERSTORE", "d", "s1", "s2")
+	c.expectSorted(strs("b", "c"), "SMEMBERS", "d")
+	c.expectSorted(strs(), "SINTER", "s1", "none")
+	c.expect(int64(0), "SCARD", "none")
+	c.expect(int64(3), "SREM", "s1", "a", "b", "c")
+	c.expect(int64(0), "SCARD", "s1")
+	c.expect("OK", "SET", "k", "v")
+	c.expectError("WRONGTYPE", "SADD", "k", "a")
+}
+
+func TestSetRandom(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	//members sharing a long prefix and members of different lengths
+	members := []string{"a", "ab", "abc", "b", "zzzzzzzzzzzz", "prefix-000000001", "prefix-000000002", "prefix-000000003"}
+	for _, member := range members {
+		c.expect(int64(1), "SADD", "s", member)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 400 && len(seen) < len(members); i++ {
+		got := c.array("SRANDMEMBER", "s", 3)
+		if len(got) != 3 {
+			t.Fatalf("SRANDMEMBER 3: got %#v", got)
+		}
+		picked := make(map[string]bool)
+		for _, member := range got {
+			if picked[member.(string)] {
+				t.Fatalf("SRANDMEMBER 3 picked %q twice", member)
+			}
+			picked[member.(string)] = true
+			seen[member.(string)] = true
+		}
+	}
+	if len(seen) != len(members) {
+		t.Fatalf("members never picked: got %v", seen)
+	}
+	if got := c.array("SRANDMEMBER", "s", -20); len(got) != 20 {
+		t.Fatalf("SRANDMEMBER -20: got %#v", got)
+	}
+	c.expectSorted(strs(members...), "SRANDMEMBER", "s", 100)
+	c.expect(nil, "SRANDMEMBER", "none")
+	c.expect([]interface{}{}, "SRANDMEMBER", "none", 2)
+
+	for i := len(members); i > 0; i-- {
+		c.expect(int64(i), "SCARD", "s")
+		c.do("SPOP", "s")
+	}
+	c.expect(int64(0), "EXISTS", "s")
+	c.expect(nil, "SPOP", "s")
+
+	for i := 0; i < 10; i++ {
+		c.expect(int64(1), "SADD", "big", fmt.Sprintf("m%d", i))
+	}
+	if got := c.array("SPOP", "big", 7); len(got) != 7 {
+		t.Fatalf("SPOP 7: got %#v", got)
+	}
+	c.expect(int64(3), "SCARD", "big")
+	if got := c.array("SPOP", "big", 7); len(got) != 3 {
+		t.Fatalf("SPOP 7 of 3: got %#v", got)
+	}
+	c.expect(int64(0), "EXISTS", "big")
+}
+
+func TestSetMove(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(3), "SADD", "s1", "a", "b", "c")
+	c.expect(int64(2), "SADD", "s2", "c", "d")
+	c.expect(int64(1), "SMOVE", "s1", "s2", "a")
+	c.expect(int64(0), "SMOVE", "s1", "s2", "x")
+	c.expectSorted(strs("b", "c"), "SMEMBERS", "s1")
+	c.expectSorted(strs("a", "c", "d"), "SMEMBERS", "s2")
+	c.expect([]interface{}{int64(1), int64(0), int64(1)}, "SMISMEMBER", "s2", "a", "b", "d")
+	c.expect(int64(1), "SINTERCARD", 2, "s1", "s2")
+	c.expect(int64(2), "SINTERCARD", 1, "s2", "LIMIT", 2)
+	c.expect(int64(4), "SUNIONSTORE", "u", "s1", "s2")
+	c.expectSorted(strs("a", "b", "c", "d"), "SMEMBERS", "u")
+	c.expect("OK", "SET", "k", "v")
+	c.expectError("WRONGTYPE", "SMOVE", "s1", "k", "b")
+	c.expectSorted(strs("b", "c"), "SMEMBERS", "s1")
+}
@@ -0,0 +1,668 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/tidis"
+	"github.com/chuangyou/qkv/utils"
+)
+
+func init() {
+	commandRegister("XACK", xAckCommand)
+	commandRegister("XADD", xAddCommand)
+	commandRegister("XDEL", xDelCommand)
+	commandRegister("XGROUP", xGroupCommand)
+	commandRegister("XLEN", xLenCommand)
+	commandRegister("XPENDING", xPendingCommand)
+	commandRegister("XRANGE", xRangeCommand)
+	commandRegister("XREAD", xReadCommand)
+	commandRegister("XREADGROUP", xReadGroupCommand)
+	commandRegister("XREVRANGE", xRevRangeCommand)
+	commandRegister("XTRIM", xTrimCommand)
+}
+func xAckCommand(c *Client) (err error) {
+	var (
+		ids []tidis.StreamID
+		ret int64
+	)
+	if len(c.args) < 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ids, err = parseStreamIDs(c.args[2:])
+	if err != nil {
+		return
+	}
+	ret, err = c.tdb.XAck(c.GetTxn(), c.args[0], c.args[1], ids...)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+
+//XADD key [NOMKSTREAM] [MAXLEN [=|~] count] *|id field value [field value ...]
+func xAddCommand(c *Client) (err error) {
+	var (
+		nomkstream      bool
+		maxlen          int64 = -1
+		i               int
+		id              tidis.StreamID
+		autoMs, autoSeq bool
+		added           tidis.StreamID
+		ok              bool
+	)
+	if len(c.args) < 4 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	for i = 1; i < len(c.args); i++ {
+		switch strings.ToUpper(string(c.args[i])) {
+		case "NOMKSTREAM":
+			nomkstream = true
+			continue
+		case "MAXLEN":
+			maxlen, i, err = parseStreamMaxlen(c.args, i)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		break
+	}
+	if len(c.args)-i < 3 || (len(c.args)-i)%2 != 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	switch {
+	case string(c.args[i]) == "*":
+		autoMs = true
+	case bytes.HasSuffix(c.args[i], []byte("-*")):
+		autoSeq = true
+		id, err = parseStreamID(c.args[i][:len(c.args[i])-2], 0)
+	default:
+		id, err = parseStreamID(c.args[i], 0)
+	}
+	if err != nil {
+		return
+	}
+	added, ok, err = c.tdb.XAdd(c.GetTxn(), c.args[0], id, autoMs, autoSeq, c.args[i+1:], maxlen, nomkstream)
+	if err != nil {
+		return
+	}
+	if !ok {
+		return c.Resp(nil)
+	}
+	return c.Resp(added.Bytes())
+}
+func xDelCommand(c *Client) (err error) {
+	var (
+		ids []tidis.StreamID
+		ret int64
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ids, err = parseStreamIDs(c.args[1:])
+	if err != nil {
+		return
+	}
+	ret, err = c.tdb.XDel(c.GetTxn(), c.args[0], ids...)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+
+//XGROUP CREATE key group id|$ [MKSTREAM] | SETID key group id|$ | DESTROY key group | DELCONSUMER key group consumer
+func xGroupCommand(c *Client) (err error) {
+	var (
+		id       tidis.StreamID
+		fromLast bool
+		mkstream bool
+		ret      int64
+	)
+	if len(c.args) < 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	switch strings.ToUpper(string(c.args[0])) {
+	case "CREATE", "SETID":
+		if len(c.args) < 4 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		if string(c.args[3]) == "$" {
+			fromLast = true
+		} else if id, err = parseStreamID(c.args[3], 0); err != nil {
+			return
+		}
+		if strings.ToUpper(string(c.args[0])) == "SETID" {
+			if len(c.args) != 4 {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			err = c.tdb.XGroupSetID(c.GetTxn(), c.args[1], c.args[2], id, fromLast)
+		} else {
+			for _, arg := range c.args[4:] {
+				if strings.ToUpper(string(arg)) != "MKSTREAM" {
+					err = qkverror.ErrorCommandParams
+					return
+				}
+				mkstream = true
+			}
+			err = c.tdb.XGroupCreate(c.GetTxn(), c.args[1], c.args[2], id, fromLast, mkstream)
+		}
+		if err != nil {
+			return
+		}
+		return c.Resp("OK")
+	case "DESTROY":
+		if len(c.args) != 3 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		ret, err = c.tdb.XGroupDestroy(c.GetTxn(), c.args[1], c.args[2])
+	case "DELCONSUMER":
+		if len(c.args) != 4 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		ret, err = c.tdb.XGroupDelConsumer(c.GetTxn(), c.args[1], c.args[2], c.args[3])
+	default:
+		err = qkverror.ErrorCommandParams
+	}
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+func xLenCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) != 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.XLen(c.GetTxn(), c.args[0])
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+
+//XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func xPendingCommand(c *Client) (err error) {
+	var (
+		args       [][]byte
+		minIdle    int64
+		start, end tidis.StreamID
+		empty      bool
+		count      int64
+		consumer   []byte
+		pending    []tidis.StreamPending
+		resp       []interface{}
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	if len(c.args) == 2 {
+		return c.xPendingSummary()
+	}
+	args = c.args[2:]
+	if strings.ToUpper(string(args[0])) == "IDLE" {
+		if len(args) < 2 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		minIdle, err = utils.StrBytesToInt64(args[1])
+		if err != nil {
+			return
+		}
+		args = args[2:]
+	}
+	if len(args) != 3 && len(args) != 4 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	start, end, empty, err = parseStreamRange(args[0], args[1])
+	if err != nil {
+		return
+	}
+	count, err = utils.StrBytesToInt64(args[2])
+	if err != nil {
+		return
+	}
+	if len(args) == 4 {
+		consumer = args[3]
+	}
+	resp = make([]interface{}, 0)
+	if empty || count <= 0 {
+		return c.Resp(resp)
+	}
+	pending, err = c.tdb.XPending(c.GetTxn(), c.args[0], c.args[1], start, end, count, consumer, minIdle)
+	if err != nil {
+		return
+	}
+	for _, p := range pending {
+		resp = append(resp, []interface{}{p.ID.Bytes(), p.Consumer, p.Idle, p.Deliveries})
+	}
+	return c.Resp(resp)
+}
+
+//xPendingSummary number of pending entries, the smallest and greatest pending id and the number of entries of each consumer
+func (c *Client) xPendingSummary() (err error) {
+	var (
+		pending   []tidis.StreamPending
+		counts    map[string]int64
+		consumers []string
+		resp      []interface{}
+	)
+	pending, err = c.tdb.XPending(c.GetTxn(), c.args[0], c.args[1], tidis.StreamIDMin, tidis.StreamIDMax, 0, nil, 0)
+	if err != nil {
+		return
+	}
+	if len(pending) == 0 {
+		return c.Resp([]interface{}{int64(0), nil, nil, nil})
+	}
+	counts = make(map[string]int64)
+	for _, p := range pending {
+		if counts[string(p.Consumer)] == 0 {
+			consumers = append(consumers, string(p.Consumer))
+		}
+		counts[string(p.Consumer)]++
+	}
+	sort.Strings(consumers)
+	resp = make([]interface{}, 0, len(consumers))
+	for _, consumer := range consumers {
+		resp = append(resp, []interface{}{[]byte(consumer), []byte(strconv.FormatInt(counts[consumer], 10))})
+	}
+	return c.Resp([]interface{}{int64(len(pending)), pending[0].ID.Bytes(), pending[len(pending)-1].ID.Bytes(), resp})
+}
+func xRangeCommand(c *Client) (err error) {
+	return c.xRange(false)
+}
+func xRevRangeCommand(c *Client) (err error) {
+	return c.xRange(true)
+}
+
+//xRange XRANGE key start end [COUNT count], XREVRANGE key end start [COUNT count]
+func (c *Client) xRange(reverse bool) (err error) {
+	var (
+		start, end tidis.StreamID
+		empty      bool
+		count      int64
+		entries    []tidis.StreamEntry
+	)
+	if len(c.args) != 3 && len(c.args) != 5 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	if reverse {
+		start, end, empty, err = parseStreamRange(c.args[2], c.args[1])
+	} else {
+		start, end, empty, err = parseStreamRange(c.args[1], c.args[2])
+	}
+	if err != nil {
+		return
+	}
+	if len(c.args) == 5 {
+		if strings.ToUpper(string(c.args[3])) != "COUNT" {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		count, err = utils.StrBytesToInt64(c.args[4])
+		if err != nil {
+			return
+		}
+		if count <= 0 {
+			empty = true
+		}
+	}
+	if empty {
+		return c.Resp(utils.EmptyListInterfaces)
+	}
+	entries, err = c.tdb.XRange(c.GetTxn(), c.args[0], start, end, count, reverse)
+	if err != nil {
+		return
+	}
+	return c.Resp(streamEntriesResp(entries))
+}
+
+//XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func xReadCommand(c *Client) (err error) {
+	var (
+		count   int64
+		timeout time.Duration
+		keys    [][]byte
+		ids     [][]byte
+		after   []tidis.StreamID
+		resp    []interface{}
+	)
+	count, timeout, _, keys, ids, err = parseStreamRead(c.args)
+	if err != nil {
+		return
+	}
+	after = make([]tidis.StreamID, len(keys))
+	for i := range keys {
+		if string(ids[i]) == "$" {
+			//entries added from now on
+			after[i], err = c.tdb.XLastID(c.GetTxn(), keys[i])
+		} else {
+			after[i], err = parseStreamID(ids[i], 0)
+		}
+		if err != nil {
+			return
+		}
+	}
+	resp, err = c.blockStreams(keys, timeout, func() (resp []interface{}, err error) {
+		var (
+			start   tidis.StreamID
+			ok      bool
+			entries []tidis.StreamEntry
+		)
+		for i := range keys {
+			if start, ok = after[i].Next(); !ok {
+				continue
+			}
+			entries, err = c.tdb.XRange(c.GetTxn(), keys[i], start, tidis.StreamIDMax, count, false)
+			if err != nil {
+				return
+			}
+			if len(entries) > 0 {
+				resp = append(resp, []interface{}{c.userKey(keys[i]), streamEntriesResp(entries)})
+			}
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	return c.Resp(resp)
+}
+
+//XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func xReadGroupCommand(c *Client) (err error) {
+	var (
+		count   int64
+		timeout time.Duration
+		noack   bool
+		keys    [][]byte
+		ids     [][]byte
+		after   []tidis.StreamID
+		newOnly []bool
+		resp    []interface{}
+	)
+	if len(c.args) < 6 || strings.ToUpper(string(c.args[0])) != "GROUP" {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	count, timeout, noack, keys, ids, err = parseStreamRead(c.args[3:])
+	if err != nil {
+		return
+	}
+	after = make([]tidis.StreamID, len(keys))
+	newOnly = make([]bool, len(keys))
+	for i := range keys {
+		if string(ids[i]) == ">" {
+			newOnly[i] = true
+			continue
+		}
+		//only the clients waiting for new entries block
+		timeout = -1
+		after[i], err = parseStreamID(ids[i], 0)
+		if err != nil {
+			return
+		}
+	}
+	resp, err = c.blockStreams(keys, timeout, func() (resp []interface{}, err error) {
+		var (
+			entries []tidis.StreamEntry
+		)
+		for i := range keys {
+			entries, err = c.tdb.XReadGroup(c.GetTxn(), keys[i], c.args[1], c.args[2], after[i], newOnly[i], count, noack)
+			if err != nil {
+				return
+			}
+			if len(entries) > 0 || !newOnly[i] {
+				resp = append(resp, []interface{}{c.userKey(keys[i]), streamEntriesResp(entries)})
+			}
+		}
+		return
+	})
+	if err != nil {
+		return
+	}
+	return c.Resp(resp)
+}
+
+//XTRIM key MAXLEN [=|~] count
+func xTrimCommand(c *Client) (err error) {
+	var (
+		maxlen int64
+		next   int
+		ret    int64
+	)
+	if len(c.args) < 3 || strings.ToUpper(string(c.args[1])) != "MAXLEN" {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	maxlen, next, err = parseStreamMaxlen(c.args, 1)
+	if err != nil {
+		return
+	}
+	if next != len(c.args)-1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.XTrim(c.GetTxn(), c.args[0], maxlen)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+
+//blockStreams call read until it returns entries, waiting up to timeout for entries added to keys.
+//A negative timeout does not wait and 0 waits forever, the client never waits inside MULTI.
+//A client closing the connection stops waiting without reading, so XREADGROUP does not deliver entries to it.
+func (c *Client) blockStreams(keys [][]byte, timeout time.Duration, read func() ([]interface{}, error)) (resp []interface{}, err error) {
+	var (
+		waiter  *tidis.Waiter
+		expired <-chan time.Time
+		poll    *time.Ticker
+		gone    <-chan struct{}
+		stop    func()
+	)
+	resp, err = read()
+	if err != nil || len(resp) > 0 || timeout < 0 || c.isTxn {
+		return
+	}
+	waiter = c.tdb.BlockOn(keys...)
+	defer c.tdb.Unblock(waiter)
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	//entries added through other instances do not wake the client up
+	poll = time.NewTicker(blockPollInterval)
+	defer poll.Stop()
+	gone, stop = c.watchDisconnect()
+	defer stop()
+	for {
+		select {
+		case <-expired:
+			return
+		case <-gone:
+			err = io.EOF
+			return
+		case <-waiter.Notify():
+		case <-poll.C:
+		}
+		resp, err = read()
+		if err != nil || len(resp) > 0 {
+			return
+		}
+	}
+}
+
+//parseStreamRead [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...],
+//timeout is negative without BLOCK
+func parseStreamRead(args [][]byte) (count int64, timeout time.Duration, noack bool, keys, ids [][]byte, err error) {
+	var (
+		ms int64
+	)
+	timeout = -1
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "COUNT", "BLOCK":
+			if i+1 >= len(args) {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			ms, err = utils.StrBytesToInt64(args[i+1])
+			if err != nil {
+				return
+			}
+			if strings.ToUpper(string(args[i])) == "COUNT" {
+				count = ms
+			} else if ms < 0 {
+				err = qkverror.ErrorTimeoutNegative
+				return
+			} else {
+				timeout = time.Duration(ms) * time.Millisecond
+			}
+			i++
+		case "NOACK":
+			noack = true
+		case "STREAMS":
+			args = args[i+1:]
+			if len(args) == 0 || len(args)%2 != 0 {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			keys, ids = args[:len(args)/2], args[len(args)/2:]
+			return
+		default:
+			err = qkverror.ErrorCommandParams
+			return
+		}
+	}
+	err = qkverror.ErrorCommandParams
+	return
+}
+
+//parseStreamMaxlen MAXLEN [=|~] count at args[i], returns the index of count
+func parseStreamMaxlen(args [][]byte, i int) (maxlen int64, next int, err error) {
+	next = i + 1
+	if next < len(args) && (string(args[next]) == "=" || string(args[next]) == "~") {
+		//entries are trimmed exactly
+		next++
+	}
+	if next >= len(args) {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	maxlen, err = utils.StrBytesToInt64(args[next])
+	if err != nil {
+		return
+	}
+	if maxlen < 0 {
+		err = qkverror.ErrorCommandParams
+	}
+	return
+}
+
+//parseStreamRange start and end of XRANGE, - and + are the smallest and greatest ids and ( excludes the id.
+//empty is set when the range can not contain any entry.
+func parseStreamRange(startArg, endArg []byte) (start, end tidis.StreamID, empty bool, err error) {
+	var (
+		ok bool
+	)
+	switch {
+	case string(startArg) == "-":
+		start = tidis.StreamIDMin
+	case len(startArg) > 0 && startArg[0] == '(':
+		if start, err = parseStreamID(startArg[1:], 0); err != nil {
+			return
+		}
+		start, ok = start.Next()
+		empty = !ok
+	default:
+		start, err = parseStreamID(startArg, 0)
+	}
+	if err != nil {
+		return
+	}
+	switch {
+	case string(endArg) == "+":
+		end = tidis.StreamIDMax
+	case len(endArg) > 0 && endArg[0] == '(':
+		if end, err = parseStreamID(endArg[1:], tidis.StreamIDMax.Seq); err != nil {
+			return
+		}
+		end, ok = end.Prev()
+		empty = empty || !ok
+	default:
+		end, err = parseStreamID(endArg, tidis.StreamIDMax.Seq)
+	}
+	return
+}
+
+//parseStreamID ms-seq or ms, seq is missingSeq when it is left out
+func parseStreamID(arg []byte, missingSeq uint64) (id tidis.StreamID, err error) {
+	var (
+		parts []string
+	)
+	parts = strings.SplitN(string(arg), "-", 2)
+	id.Ms, err = strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		err = qkverror.ErrorStreamIDInvalid
+		return
+	}
+	id.Seq = missingSeq
+	if len(parts) == 2 {
+		id.Seq, err = strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			err = qkverror.ErrorStreamIDInvalid
+		}
+	}
+	return
+}
+func parseStreamIDs(args [][]byte) (ids []tidis.StreamID, err error) {
+	ids = make([]tidis.StreamID, len(args))
+	for i, arg := range args {
+		ids[i], err = parseStreamID(arg, 0)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+//streamEntriesResp id and field value pairs of each entry
+func streamEntriesResp(entries []tidis.StreamEntry) (resp []interface{}) {
+	var (
+		fields []interface{}
+	)
+	resp = make([]interface{}, len(entries))
+	for i, entry := range entries {
+		fields = nil
+		if entry.Fields != nil {
+			fields = make([]interface{}, len(entry.Fields))
+			for j, field := range entry.Fields {
+				fields[j] = field
+			}
+		}
+		resp[i] = []interface{}{entry.ID.Bytes(), fields}
+	}
+	return
+}
@@ -0,0 +1,99 @@
+package server
+
+import "testing"
+
+//entry the reply of a stream entry
+func entry(id string, fields ...string) []interface{} {
+	return []interface{}{id, strs(fields...)}
+}
+
+func TestStream(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("1-1", "XADD", "s", "1-1", "a", "1")
+	c.expect("1-2", "XADD", "s", "1-*", "b", "2")
+	c.expect("2-0", "XADD", "s", "2", "c", "3", "d", "4")
+	c.expectError("", "XADD", "s", "1-5", "e", "5")
+	c.expectError("", "XADD", "s", "3-0", "odd")
+	c.expect(nil, "XADD", "none", "NOMKSTREAM", "*", "a", "1")
+	c.expect(int64(0), "EXISTS", "none")
+	if id, ok := c.do("XADD", "auto", "*", "a", "1").(string); !ok || id == "" {
+		t.Fatalf("XADD *: got %#v", id)
+	}
+	c.expect(int64(3), "XLEN", "s")
+	c.expect(int64(0), "XLEN", "none")
+
+	c.expect([]interface{}{entry("1-1", "a", "1"), entry("1-2", "b", "2"), entry("2-0", "c", "3", "d", "4")}, "XRANGE", "s", "-", "+")
+	c.expect([]interface{}{entry("1-2", "b", "2")}, "XRANGE", "s", "(1-1", "1")
+	c.expect([]interface{}{entry("2-0", "c", "3", "d", "4"), entry("1-2", "b", "2")}, "XREVRANGE", "s", "+", "-", "COUNT", 2)
+	c.expect([]interface{}{}, "XRANGE", "s", "3", "+")
+
+	c.expect([]interface{}{[]interface{}{"s", []interface{}{entry("2-0", "c", "3", "d", "4")}}}, "XREAD", "STREAMS", "s", "1-2")
+	c.expect(nil, "XREAD", "STREAMS", "s", "$")
+	c.expect(nil, "XREAD", "BLOCK", 50, "STREAMS", "s", "$")
+
+	c.expect(int64(1), "XDEL", "s", "1-2", "9-9")
+	c.expect(int64(2), "XLEN", "s")
+	c.expect("3-0", "XADD", "s", "MAXLEN", 2, "3", "e", "5")
+	c.expect([]interface{}{entry("2-0", "c", "3", "d", "4"), entry("3-0", "e", "5")}, "XRANGE", "s", "-", "+")
+	c.expect(int64(1), "XTRIM", "s", "MAXLEN", "~", 1)
+	c.expect([]interface{}{entry("3-0", "e", "5")}, "XRANGE", "s", "-", "+")
+	//the ids never go back, even after the last entries are deleted
+	c.expectError("", "XADD", "s", "2-5", "f", "6")
+	c.expect("OK", "SET", "k", "v")
+	c.expectError("WRONGTYPE", "XADD", "k", "*", "a", "1")
+}
+
+func TestStreamGroups(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	b := s.dial()
+	defer b.Close()
+	c.expectError("", "XGROUP", "CREATE", "s", "g", "$")
+	c.expect("OK", "XGROUP", "CREATE", "s", "g", "$", "MKSTREAM")
+	c.expectError("BUSYGROUP", "XGROUP", "CREATE", "s", "g", "$")
+	c.expect("1-0", "XADD", "s", "1", "a", "1")
+	c.expect("2-0", "XADD", "s", "2", "b", "2")
+
+	c.expect([]interface{}{[]interface{}{"s", []interface{}{entry("1-0", "a", "1")}}}, "XREADGROUP", "GROUP", "g", "alice", "COUNT", 1, "STREAMS", "s", ">")
+	c.expect([]interface{}{[]interface{}{"s", []interface{}{entry("2-0", "b", "2")}}}, "XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")
+	c.expect(nil, "XREADGROUP", "GROUP", "g", "bob", "STREAMS", "s", ">")
+	//the history of a consumer is its pending entries
+	c.expect([]interface{}{[]interface{}{"s", []interface{}{entry("1-0", "a", "1")}}}, "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", "0")
+
+	c.expect([]interface{}{int64(2), "1-0", "2-0", []interface{}{strs("alice", "1"), strs("bob", "1")}}, "XPENDING", "s", "g")
+	pending := c.array("XPENDING", "s", "g", "-", "+", 10, "alice")
+	if len(pending) != 1 {
+		t.Fatalf("XPENDING alice: got %#v", pending)
+	}
+	if p := pending[0].([]interface{}); p[0] != "1-0" || p[1] != "alice" || p[3] != int64(2) {
+		t.Fatalf("XPENDING alice: got %#v", p)
+	}
+	c.expect([]interface{}{}, "XPENDING", "s", "g", "IDLE", 3600000, "-", "+", 10)
+
+	c.expect(int64(1), "XACK", "s", "g", "1-0", "9-0")
+	c.expect(int64(0), "XACK", "s", "g", "1-0")
+	c.expect([]interface{}{int64(1), "2-0", "2-0", []interface{}{strs("bob", "1")}}, "XPENDING", "s", "g")
+	c.expect(int64(1), "XGROUP", "DELCONSUMER", "s", "g", "bob")
+	c.expect([]interface{}{int64(0), nil, nil, nil}, "XPENDING", "s", "g")
+
+	//a consumer blocked on the group is served the next entry
+	b.send("XREADGROUP", "GROUP", "g", "carol", "BLOCK", 0, "STREAMS", "s", ">")
+	waitBlocked()
+	c.expect("3-0", "XADD", "s", "3", "c", "3")
+	if got := b.receive(); !equalReply(got, []interface{}{[]interface{}{"s", []interface{}{entry("3-0", "c", "3")}}}) {
+		t.Fatalf("blocked XREADGROUP: got %#v", got)
+	}
+
+	c.expect("OK", "XGROUP", "SETID", "s", "g", "0")
+	if got := c.array("XREADGROUP", "GROUP", "g", "dave", "NOACK", "STREAMS", "s", ">"); len(got) != 1 {
+		t.Fatalf("XREADGROUP after SETID: got %#v", got)
+	}
+	c.expect(int64(1), "XGROUP", "DESTROY", "s", "g")
+	c.expect(int64(0), "XGROUP", "DESTROY", "s", "g")
+	c.expectError("NOGROUP", "XREADGROUP", "GROUP", "g", "alice", "STREAMS", "s", ">")
+}
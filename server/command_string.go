@@ -1,7 +1,12 @@
 package server
 
 import (
+	"math"
+	"strings"
+	"time"
+
 	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/tidis"
 	"github.com/chuangyou/qkv/utils"
 )
 
@@ -23,6 +28,16 @@ func init() {
 	commandRegister("PEXPIRE", pexpireCommand)
 	commandRegister("EXPIREAT", expireatCommand)
 	commandRegister("PEXPIREAT", pexpireatCommand)
+	commandRegister("SETNX", setNXCommand)
+	commandRegister("GETSET", getSetCommand)
+	commandRegister("GETDEL", getDelCommand)
+	commandRegister("GETEX", getEXCommand)
+	commandRegister("APPEND", appendCommand)
+	commandRegister("GETRANGE", getRangeCommand)
+	commandRegister("SETRANGE", setRangeCommand)
+	commandRegister("INCRBYFLOAT", incrbyFloatCommand)
+	commandRegister("MSETNX", msetNXCommand)
+	commandRegister("PSETEX", psetEXCommand)
 }
 func getCommand(c *Client) (err error) {
 	var (
@@ -39,16 +54,59 @@ func getCommand(c *Client) (err error) {
 	}
 	return c.Resp(value)
 }
+
+//SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|KEEPTTL]
 func setCommand(c *Client) (err error) {
-	if len(c.args) != 2 {
+	var (
+		opts   tidis.SetOptions
+		expire bool
+		old    []byte
+		ok     bool
+	)
+	if len(c.args) < 2 {
 		err = qkverror.ErrorCommandParams
 		return
-	} else {
-		err = c.tdb.Set(c.GetTxn(), c.args[0], c.args[1])
-		if err != nil {
+	}
+	for i := 2; i < len(c.args); i++ {
+		switch option := strings.ToUpper(string(c.args[i])); option {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "GET":
+			opts.Get = true
+		case "KEEPTTL":
+			opts.KeepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expire || i+1 >= len(c.args) {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			opts.ExpireAt, err = parseExpireAt(option, c.args[i+1])
+			if err != nil {
+				return
+			}
+			expire = true
+			i++
+		default:
+			err = qkverror.ErrorCommandParams
 			return
 		}
 	}
+	if (opts.NX && opts.XX) || (opts.KeepTTL && expire) {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	old, ok, err = c.tdb.SetWithOptions(c.GetTxn(), c.args[0], c.args[1], opts)
+	if err != nil {
+		return
+	}
+	if opts.Get {
+		return c.Resp(old)
+	}
+	if !ok {
+		return c.Resp(nil)
+	}
 	return c.Resp("OK")
 }
 func mgetCommand(c *Client) (err error) {
@@ -68,7 +126,7 @@ func mgetCommand(c *Client) (err error) {
 	return
 }
 func msetCommand(c *Client) (err error) {
-	if len(c.args) < 2 && len(c.args)%2 != 0 {
+	if len(c.args) < 2 || len(c.args)%2 != 0 {
 		err = qkverror.ErrorCommandParams
 		return
 	}
@@ -142,6 +200,10 @@ func incrbyCommand(c *Client) (err error) {
 		return
 	}
 	step, err = utils.StrBytesToInt64(c.args[1])
+	if err != nil {
+		err = qkverror.ErrorNotInteger
+		return
+	}
 	ret, err = c.tdb.Incr(c.GetTxn(), c.args[0], step)
 	if err != nil {
 		return
@@ -172,6 +234,10 @@ func decrbyCommand(c *Client) (err error) {
 		return
 	}
 	step, err = utils.StrBytesToInt64(c.args[1])
+	if err != nil {
+		err = qkverror.ErrorNotInteger
+		return
+	}
 	ret, err = c.tdb.Decr(c.GetTxn(), c.args[0], step)
 	if err != nil {
 		return
@@ -300,3 +366,242 @@ func pexpireatCommand(c *Client) (err error) {
 	}
 	return c.Resp(int64(ret))
 }
+func setNXCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.SetNX(c.GetTxn(), c.args[0], c.args[1])
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+func getSetCommand(c *Client) (err error) {
+	var (
+		old []byte
+	)
+	if len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	old, err = c.tdb.GetSet(c.GetTxn(), c.args[0], c.args[1])
+	if err != nil {
+		return
+	}
+	return c.Resp(old)
+}
+func getDelCommand(c *Client) (err error) {
+	var (
+		value []byte
+	)
+	if len(c.args) != 1 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	value, err = c.tdb.GetDel(c.GetTxn(), c.args[0])
+	if err != nil {
+		return
+	}
+	return c.Resp(value)
+}
+
+//GETEX key [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|PERSIST]
+func getEXCommand(c *Client) (err error) {
+	var (
+		expireAt int64
+		persist  bool
+		value    []byte
+	)
+	switch len(c.args) {
+	case 1:
+	case 2:
+		if strings.ToUpper(string(c.args[1])) != "PERSIST" {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		persist = true
+	case 3:
+		switch option := strings.ToUpper(string(c.args[1])); option {
+		case "EX", "PX", "EXAT", "PXAT":
+			expireAt, err = parseExpireAt(option, c.args[2])
+			if err != nil {
+				return
+			}
+		default:
+			err = qkverror.ErrorCommandParams
+			return
+		}
+	default:
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	value, err = c.tdb.GetEX(c.GetTxn(), c.args[0], expireAt, persist)
+	if err != nil {
+		return
+	}
+	return c.Resp(value)
+}
+func appendCommand(c *Client) (err error) {
+	var (
+		length int64
+	)
+	if len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	length, err = c.tdb.Append(c.GetTxn(), c.args[0], c.args[1])
+	if err != nil {
+		return
+	}
+	return c.Resp(length)
+}
+func getRangeCommand(c *Client) (err error) {
+	var (
+		start, end int64
+		value      []byte
+	)
+	if len(c.args) != 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	start, err = utils.StrBytesToInt64(c.args[1])
+	if err != nil {
+		err = qkverror.ErrorNotInteger
+		return
+	}
+	end, err = utils.StrBytesToInt64(c.args[2])
+	if err != nil {
+		err = qkverror.ErrorNotInteger
+		return
+	}
+	value, err = c.tdb.GetRange(c.GetTxn(), c.args[0], start, end)
+	if err != nil {
+		return
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return c.Resp(value)
+}
+func setRangeCommand(c *Client) (err error) {
+	var (
+		offset int64
+		length int64
+	)
+	if len(c.args) != 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	offset, err = utils.StrBytesToInt64(c.args[1])
+	if err != nil {
+		err = qkverror.ErrorNotInteger
+		return
+	}
+	length, err = c.tdb.SetRange(c.GetTxn(), c.args[0], offset, c.args[2])
+	if err != nil {
+		return
+	}
+	return c.Resp(length)
+}
+func incrbyFloatCommand(c *Client) (err error) {
+	var (
+		step  float64
+		value []byte
+	)
+	if len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	step, err = utils.StrBytesToFloat64(c.args[1])
+	if err != nil {
+		err = qkverror.ErrorNotFloat
+		return
+	}
+	value, err = c.tdb.IncrByFloat(c.GetTxn(), c.args[0], step)
+	if err != nil {
+		return
+	}
+	return c.Resp(value)
+}
+func msetNXCommand(c *Client) (err error) {
+	var (
+		ret int64
+	)
+	if len(c.args) < 2 || len(c.args)%2 != 0 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ret, err = c.tdb.MSetNX(c.GetTxn(), c.args)
+	if err != nil {
+		return
+	}
+	return c.Resp(ret)
+}
+func psetEXCommand(c *Client) (err error) {
+	var (
+		ms int64
+	)
+	if len(c.args) != 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	ms, err = utils.StrBytesToInt64(c.args[1])
+	if err != nil {
+		err = qkverror.ErrorNotInteger
+		return
+	}
+	if ms <= 0 {
+		err = qkverror.ErrorInvalidExpire
+		return
+	}
+	err = c.tdb.PSetEX(c.GetTxn(), c.args[0], ms, c.args[2])
+	if err != nil {
+		return
+	}
+	return c.Resp("OK")
+}
+
+//parseExpireAt the unix time in milliseconds of an EX, PX, EXAT or PXAT option
+func parseExpireAt(option string, arg []byte) (expireAt int64, err error) {
+	var (
+		n   int64
+		now int64
+	)
+	n, err = utils.StrBytesToInt64(arg)
+	if err != nil {
+		err = qkverror.ErrorNotInteger
+		return
+	}
+	if n <= 0 {
+		err = qkverror.ErrorInvalidExpire
+		return
+	}
+	now = time.Now().UnixNano() / 1000 / 1000
+	switch option {
+	case "EX":
+		if n > (math.MaxInt64-now)/1000 {
+			err = qkverror.ErrorInvalidExpire
+			return
+		}
+		expireAt = now + n*1000
+	case "PX":
+		if n > math.MaxInt64-now {
+			err = qkverror.ErrorInvalidExpire
+			return
+		}
+		expireAt = now + n
+	case "EXAT":
+		if n > math.MaxInt64/1000 {
+			err = qkverror.ErrorInvalidExpire
+			return
+		}
+		expireAt = n * 1000
+	case "PXAT":
+		expireAt = n
+	}
+	return
+}
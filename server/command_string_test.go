@@ -0,0 +1,184 @@
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestGetSet(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(nil, "GET", "k")
+	c.expect("OK", "SET", "k", "v")
+	c.expect("v", "GET", "k")
+	c.expect("OK", "SET", "k", "v2")
+	c.expect("v2", "GET", "k")
+	c.expect(int64(2), "STRLEN", "k")
+	c.expect(int64(0), "STRLEN", "none")
+	c.expectError("", "SET", "k")
+}
+
+func TestMGetMSet(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("OK", "MSET", "a", "1", "b", "2")
+	c.expect([]interface{}{"1", nil, "2"}, "MGET", "a", "none", "b")
+	c.expectError("", "MSET", "a", "1", "b")
+}
+
+func TestDel(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("OK", "MSET", "a", "1", "b", "2")
+	c.expect(int64(1), "RPUSH", "l", "x")
+	c.expect(int64(3), "DEL", "a", "b", "l", "none")
+	c.expect(nil, "GET", "a")
+	c.expect(int64(0), "LLEN", "l")
+	c.expect(int64(0), "DEL", "a")
+}
+
+func TestIncrDecr(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(1), "INCR", "n")
+	c.expect(int64(11), "INCRBY", "n", 10)
+	c.expect(int64(10), "DECR", "n")
+	c.expect(int64(-5), "DECRBY", "n", 15)
+	c.expect("-5", "GET", "n")
+	c.expect("OK", "SET", "s", "abc")
+	c.expectError("integer", "INCR", "s")
+	c.expectError("", "INCRBY", "n", "x")
+}
+
+func TestExpire(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(-2), "TTL", "k")
+	c.expect("OK", "SET", "k", "v")
+	c.expect(int64(-1), "TTL", "k")
+	c.expect(int64(1), "EXPIRE", "k", 100)
+	if ttl := c.integer("TTL", "k"); ttl < 99 || ttl > 100 {
+		t.Fatalf("TTL after EXPIRE 100: %d", ttl)
+	}
+	c.expect(int64(1), "PEXPIRE", "k", 100000)
+	if pttl := c.integer("PTTL", "k"); pttl < 99000 || pttl > 100000 {
+		t.Fatalf("PTTL after PEXPIRE 100000: %d", pttl)
+	}
+	at := time.Now().Unix() + 200
+	c.expect(int64(1), "EXPIREAT", "k", at)
+	if ttl := c.integer("TTL", "k"); ttl < 199 || ttl > 200 {
+		t.Fatalf("TTL after EXPIREAT: %d", ttl)
+	}
+	c.expect(int64(1), "PEXPIREAT", "k", strconv.FormatInt(time.Now().UnixNano()/1e6+50, 10))
+	time.Sleep(100 * time.Millisecond)
+	c.expect(nil, "GET", "k")
+	c.expect(int64(-2), "TTL", "k")
+	c.expect(int64(0), "EXPIRE", "none", 100)
+}
+
+func TestSetEX(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("OK", "SETEX", "k", 100, "v")
+	c.expect("v", "GET", "k")
+	if ttl := c.integer("TTL", "k"); ttl < 99 || ttl > 100 {
+		t.Fatalf("TTL after SETEX 100: %d", ttl)
+	}
+	c.expectError("", "SETEX", "k", "x", "v")
+}
+
+func TestSetOptions(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(nil, "SET", "k", "v", "XX")
+	c.expect("OK", "SET", "k", "v", "NX")
+	c.expect(nil, "SET", "k", "v2", "NX")
+	c.expect("v", "SET", "k", "v2", "XX", "GET")
+	c.expect(nil, "SET", "new", "v", "GET")
+	c.expectError("", "SET", "k", "v", "NX", "XX")
+	c.expectError("", "SET", "k", "v", "EX", 10, "PX", 100)
+	c.expectError("", "SET", "k", "v", "EX", 0)
+
+	c.expect("OK", "SET", "k", "v", "EX", 100)
+	if ttl := c.integer("PTTL", "k"); ttl <= 99000 || ttl > 100000 {
+		t.Fatalf("PTTL after SET EX: %d", ttl)
+	}
+	c.expect("OK", "SET", "k", "v2", "KEEPTTL")
+	if ttl := c.integer("TTL", "k"); ttl <= 0 {
+		t.Fatalf("TTL after SET KEEPTTL: %d", ttl)
+	}
+	c.expect("OK", "SET", "k", "v3")
+	c.expect(int64(-1), "TTL", "k")
+	c.expect("OK", "SET", "k", "v", "PXAT", time.Now().Add(time.Hour).UnixNano()/int64(time.Millisecond))
+	if ttl := c.integer("TTL", "k"); ttl <= 3590 || ttl > 3600 {
+		t.Fatalf("TTL after SET PXAT: %d", ttl)
+	}
+	c.expect("OK", "SET", "k", "v", "EXAT", time.Now().Add(-time.Hour).Unix())
+	c.expect(nil, "GET", "k")
+	c.expect(int64(1), "RPUSH", "l", "a")
+	c.expectError("WRONGTYPE", "SET", "l", "v", "GET")
+}
+
+func TestStringCommands(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(1), "SETNX", "k", "v")
+	c.expect(int64(0), "SETNX", "k", "v2")
+	c.expect("v", "GETSET", "k", "v2")
+	c.expect(nil, "GETSET", "new", "v")
+	c.expect("v2", "GETDEL", "k")
+	c.expect(nil, "GETDEL", "k")
+
+	c.expect("OK", "PSETEX", "k", 100000, "v")
+	if ttl := c.integer("TTL", "k"); ttl <= 0 || ttl > 100 {
+		t.Fatalf("TTL after PSETEX: %d", ttl)
+	}
+	c.expect("v", "GETEX", "k", "PERSIST")
+	c.expect(int64(-1), "TTL", "k")
+	c.expect("v", "GETEX", "k", "EX", 50)
+	if ttl := c.integer("TTL", "k"); ttl <= 0 || ttl > 50 {
+		t.Fatalf("TTL after GETEX EX: %d", ttl)
+	}
+	c.expect("v", "GETEX", "k")
+	c.expect(nil, "GETEX", "none", "EX", 50)
+	c.expectError("", "GETEX", "k", "EX", 50, "PERSIST")
+
+	c.expect(int64(5), "APPEND", "a", "Hello")
+	c.expect(int64(11), "APPEND", "a", " World")
+	c.expect("Hello", "GETRANGE", "a", 0, 4)
+	c.expect("World", "GETRANGE", "a", -5, -1)
+	c.expect("", "GETRANGE", "a", 20, 30)
+	c.expect("", "GETRANGE", "none", 0, -1)
+	c.expect(int64(11), "SETRANGE", "a", 6, "Redis")
+	c.expect("Hello Redis", "GET", "a")
+	c.expect(int64(7), "SETRANGE", "pad", 2, "abcde")
+	c.expect("\x00\x00abcde", "GET", "pad")
+
+	c.expect("10.5", "INCRBYFLOAT", "f", 10.5)
+	c.expect("10", "INCRBYFLOAT", "f", -0.5)
+	c.expect("OK", "SET", "e", "5.0e3")
+	c.expect("5200", "INCRBYFLOAT", "e", 200)
+	c.expectError("", "INCRBYFLOAT", "a", 1)
+
+	c.expect(int64(1), "MSETNX", "m1", "1", "m2", "2")
+	c.expect(int64(0), "MSETNX", "m2", "x", "m3", "3")
+	c.expect([]interface{}{"1", "2", nil}, "MGET", "m1", "m2", "m3")
+	c.expectError("", "MSETNX", "m1")
+}
@@ -1,7 +1,6 @@
 package server
 
 import (
-	"strconv"
 	"strings"
 
 	"github.com/chuangyou/qkv/qkverror"
@@ -13,33 +12,45 @@ func init() {
 	commandRegister("ZADD", zaddCommand)
 	commandRegister("ZCARD", zcardCommand)
 	commandRegister("ZCOUNT", zcountCommand)
+	commandRegister("ZDIFF", zDiffCommand)
 	commandRegister("ZINCRBY", zincrbyCommand)
+	commandRegister("ZINTERSTORE", zInterStoreCommand)
 	commandRegister("ZLEXCOUNT", zlexcountCommand)
+	commandRegister("ZMSCORE", zMScoreCommand)
+	commandRegister("ZPOPMAX", zPopMaxCommand)
+	commandRegister("ZPOPMIN", zPopMinCommand)
+	commandRegister("ZRANDMEMBER", zRandMemberCommand)
 	commandRegister("ZRANGE", zrangeCommand)
 	commandRegister("ZRANGEBYLEX", zrangeByLexCommand)
 	commandRegister("ZRANGEBYSCORE", zrangeByScoreCommand)
+	commandRegister("ZRANGESTORE", zRangeStoreCommand)
+	commandRegister("ZRANK", zRankCommand)
 	commandRegister("ZREM", zremCommand)
 	commandRegister("ZREMRANGEBYLEX", zRemRangeByLexCommand)
 	commandRegister("ZREMRANGEBYSCORE", zRemRangeByScoreCommand)
 	commandRegister("ZREVRANGE", zRevRangeCommand)
 	commandRegister("ZREVRANGEBYLEX", zRevRangeByLexCommand)
 	commandRegister("ZREVRANGEBYSCORE", zRevRangeByScoreCommand)
+	commandRegister("ZREVRANK", zRevRankCommand)
+	commandRegister("ZSCAN", zscanCommand)
 	commandRegister("ZSCORE", zScoreCommand)
+	commandRegister("ZUNIONSTORE", zUnionStoreCommand)
 }
 func zaddCommand(c *Client) (err error) {
 	var (
 		ret   int64
 		zks   = make([]*tidis.ZSetPair, 0)
 		zk    *tidis.ZSetPair
-		score int64
+		score float64
 	)
-	if len(c.args) < 3 && len(c.args)%2 == 0 {
+	if len(c.args) < 3 || len(c.args)%2 == 0 {
 		err = qkverror.ErrorCommandParams
 		return
 	} else {
 		for i := 1; i < len(c.args); i += 2 {
-			score, err = utils.StrBytesToInt64(c.args[i])
+			score, err = utils.StrBytesToFloat64(c.args[i])
 			if err != nil {
+				err = qkverror.ErrorNotFloat
 				return
 			}
 			zk = new(tidis.ZSetPair)
@@ -72,38 +83,21 @@ func zcardCommand(c *Client) (err error) {
 }
 func zcountCommand(c *Client) (err error) {
 	var (
-		min, max    int64
-		scoreString string
-		ret         int64
+		min, max tidis.ZScoreBound
+		ret      int64
 	)
 	if len(c.args) < 3 {
 		err = qkverror.ErrorCommandParams
 		return
 	} else {
 		// score pre-process
-		scoreString = strings.ToUpper(string(c.args[1]))
-		switch scoreString {
-		case "-INF":
-			min = utils.SCORE_MIN
-		case "+INF":
-			min = utils.SCORE_MAX
-		default:
-			min, err = utils.StrBytesToInt64(c.args[1])
-			if err != nil {
-				return
-			}
+		min, err = parseZScoreBound(c.args[1])
+		if err != nil {
+			return
 		}
-		scoreString = strings.ToUpper(string(c.args[2]))
-		switch scoreString {
-		case "-INF":
-			max = utils.SCORE_MIN
-		case "+INF":
-			max = utils.SCORE_MAX
-		default:
-			max, err = utils.StrBytesToInt64(c.args[2])
-			if err != nil {
-				return
-			}
+		max, err = parseZScoreBound(c.args[2])
+		if err != nil {
+			return
 		}
 		ret, err = c.tdb.ZCount(c.GetTxn(), c.args[0], min, max)
 		if err != nil {
@@ -114,20 +108,24 @@ func zcountCommand(c *Client) (err error) {
 }
 func zincrbyCommand(c *Client) (err error) {
 	var (
-		step  int64
-		value int64
+		step  float64
+		value float64
 	)
 	if len(c.args) < 3 {
 		err = qkverror.ErrorCommandParams
 		return
 	} else {
-		step, err = utils.StrBytesToInt64(c.args[1])
+		step, err = utils.StrBytesToFloat64(c.args[1])
 		if err != nil {
+			err = qkverror.ErrorNotFloat
 			return
 		}
 		value, err = c.tdb.ZIncrby(c.GetTxn(), c.args[0], step, c.args[2])
+		if err != nil {
+			return
+		}
 	}
-	return c.Resp(value)
+	return c.Resp(utils.Float64ToStrBytes(value))
 }
 func zlexcountCommand(c *Client) (err error) {
 	var (
@@ -221,14 +219,13 @@ func zrangeCommand(c *Client) (err error) {
 }
 func zrangeByScoreCommand(c *Client) (err error) {
 	var (
-		value       []interface{}
-		start       int64
-		end         int64
-		withscores  bool  = false
-		offset      int64 = -1
-		count       int64 = -1
-		str         string
-		scoreString string
+		value      []interface{}
+		start      tidis.ZScoreBound
+		end        tidis.ZScoreBound
+		withscores bool  = false
+		offset     int64 = -1
+		count      int64 = -1
+		str        string
 	)
 	if len(c.args) < 3 {
 		err = qkverror.ErrorCommandParams
@@ -254,30 +251,14 @@ func zrangeByScoreCommand(c *Client) (err error) {
 				break
 			}
 		}
-		scoreString = strings.ToUpper(string(c.args[1]))
-		switch scoreString {
-		case "-INF":
-			start = utils.SCORE_MIN
-		case "+INF":
-			start = utils.SCORE_MAX
-		default:
-			start, err = utils.StrBytesToInt64(c.args[1])
-			if err != nil {
-				return
-			}
+		start, err = parseZScoreBound(c.args[1])
+		if err != nil {
+			return
 		}
 
-		scoreString = strings.ToUpper(string(c.args[2]))
-		switch scoreString {
-		case "-INF":
-			end = utils.SCORE_MIN
-		case "+INF":
-			end = utils.SCORE_MAX
-		default:
-			end, err = utils.StrBytesToInt64(c.args[2])
-			if err != nil {
-				return
-			}
+		end, err = parseZScoreBound(c.args[2])
+		if err != nil {
+			return
 		}
 		value, err = c.tdb.ZRangeByScore(c.GetTxn(), c.args[0], start, end, withscores, int(offset), int(count), false)
 		if err != nil {
@@ -319,38 +300,21 @@ func zRemRangeByLexCommand(c *Client) (err error) {
 }
 func zRemRangeByScoreCommand(c *Client) (err error) {
 	var (
-		ret         int64
-		start       int64
-		end         int64
-		scoreString string
+		ret   int64
+		start tidis.ZScoreBound
+		end   tidis.ZScoreBound
 	)
 	if len(c.args) < 3 {
 		err = qkverror.ErrorCommandParams
 		return
 	} else {
-		scoreString = strings.ToUpper(string(c.args[1]))
-		switch scoreString {
-		case "-INF":
-			start = utils.SCORE_MIN
-		case "+INF":
-			start = utils.SCORE_MAX
-		default:
-			start, err = utils.StrBytesToInt64(c.args[1])
-			if err != nil {
-				return
-			}
+		start, err = parseZScoreBound(c.args[1])
+		if err != nil {
+			return
 		}
-		scoreString = strings.ToUpper(string(c.args[2]))
-		switch scoreString {
-		case "-INF":
-			end = utils.SCORE_MIN
-		case "+INF":
-			end = utils.SCORE_MAX
-		default:
-			end, err = utils.StrBytesToInt64(c.args[2])
-			if err != nil {
-				return
-			}
+		end, err = parseZScoreBound(c.args[2])
+		if err != nil {
+			return
 		}
 		ret, err = c.tdb.ZRemRangeByScore(c.GetTxn(), c.args[0], start, end)
 		if err != nil {
@@ -426,7 +390,8 @@ func zRevRangeByLexCommand(c *Client) (err error) {
 				return
 			}
 		}
-		value, err = c.tdb.ZRangeByLex(c.GetTxn(), c.args[0], c.args[1], c.args[2], int(offset), int(count), true)
+		//ZREVRANGEBYLEX key max min
+		value, err = c.tdb.ZRangeByLex(c.GetTxn(), c.args[0], c.args[2], c.args[1], int(offset), int(count), true)
 		if err != nil {
 			return
 		}
@@ -435,14 +400,13 @@ func zRevRangeByLexCommand(c *Client) (err error) {
 }
 func zRevRangeByScoreCommand(c *Client) (err error) {
 	var (
-		value       []interface{}
-		start       int64
-		end         int64
-		withscores  bool  = false
-		offset      int64 = -1
-		count       int64 = -1
-		str         string
-		scoreString string
+		value      []interface{}
+		start      tidis.ZScoreBound
+		end        tidis.ZScoreBound
+		withscores bool  = false
+		offset     int64 = -1
+		count      int64 = -1
+		str        string
 	)
 	if len(c.args) < 3 {
 		err = qkverror.ErrorCommandParams
@@ -468,30 +432,14 @@ func zRevRangeByScoreCommand(c *Client) (err error) {
 				break
 			}
 		}
-		scoreString = strings.ToUpper(string(c.args[1]))
-		switch scoreString {
-		case "-INF":
-			start = utils.SCORE_MIN
-		case "+INF":
-			start = utils.SCORE_MAX
-		default:
-			start, err = utils.StrBytesToInt64(c.args[1])
-			if err != nil {
-				return
-			}
+		start, err = parseZScoreBound(c.args[1])
+		if err != nil {
+			return
 		}
 
-		scoreString = strings.ToUpper(string(c.args[2]))
-		switch scoreString {
-		case "-INF":
-			end = utils.SCORE_MIN
-		case "+INF":
-			end = utils.SCORE_MAX
-		default:
-			end, err = utils.StrBytesToInt64(c.args[2])
-			if err != nil {
-				return
-			}
+		end, err = parseZScoreBound(c.args[2])
+		if err != nil {
+			return
 		}
 		value, err = c.tdb.ZRangeByScore(c.GetTxn(), c.args[0], start, end, withscores, int(offset), int(count), true)
 		if err != nil {
@@ -503,18 +451,398 @@ func zRevRangeByScoreCommand(c *Client) (err error) {
 }
 func zScoreCommand(c *Client) (err error) {
 	var (
-		value int64
-		data  []byte
+		value  float64
+		exists bool
+		data   []byte
 	)
 	if len(c.args) != 2 {
 		err = qkverror.ErrorCommandParams
 		return
 	} else {
-		value, err = c.tdb.ZScore(c.GetTxn(), c.args[0], c.args[1])
+		value, exists, err = c.tdb.ZScore(c.GetTxn(), c.args[0], c.args[1])
 		if err != nil {
 			return
 		}
-		data = strconv.AppendInt([]byte(nil), value, 10)
+		if exists {
+			data = utils.Float64ToStrBytes(value)
+		}
 	}
 	return c.Resp(data)
 }
+func zscanCommand(c *Client) (err error) {
+	var (
+		next  []byte
+		resp  []interface{}
+		match []byte
+		count int64
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	match, count, _, err = parseScanArgs(c.args[2:], false)
+	if err != nil {
+		return
+	}
+	next, resp, err = c.tdb.ZScan(c.GetTxn(), c.args[0], c.args[1], match, count)
+	if err != nil {
+		return
+	}
+	return c.Resp([]interface{}{next, resp})
+}
+
+//parseZScoreBound parses min and max of the score range commands, ( prefix for an exclusive bound
+func parseZScoreBound(arg []byte) (bound tidis.ZScoreBound, err error) {
+	if len(arg) > 0 && arg[0] == '(' {
+		bound.Exclusive = true
+		arg = arg[1:]
+	}
+	bound.Score, err = utils.StrBytesToFloat64(arg)
+	if err != nil {
+		err = qkverror.ErrorMinMaxNotFloat
+	}
+	return
+}
+
+//ZRANK key member [WITHSCORE]
+func zRankCommand(c *Client) (err error) {
+	return zRankResp(c, false)
+}
+
+//ZREVRANK key member [WITHSCORE]
+func zRevRankCommand(c *Client) (err error) {
+	return zRankResp(c, true)
+}
+func zRankResp(c *Client, reverse bool) (err error) {
+	var (
+		rank      int64
+		score     float64
+		exists    bool
+		withScore bool
+	)
+	if len(c.args) != 2 && len(c.args) != 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	if len(c.args) == 3 {
+		if strings.ToUpper(string(c.args[2])) != "WITHSCORE" {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		withScore = true
+	}
+	rank, score, exists, err = c.tdb.ZRank(c.GetTxn(), c.args[0], c.args[1], reverse)
+	if err != nil {
+		return
+	}
+	if !exists {
+		return c.Resp(nil)
+	}
+	if withScore {
+		return c.Resp([]interface{}{rank, utils.Float64ToStrBytes(score)})
+	}
+	return c.Resp(rank)
+}
+
+//ZMSCORE key member [member ...]
+func zMScoreCommand(c *Client) (err error) {
+	var (
+		resp []interface{}
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	resp, err = c.tdb.ZMScore(c.GetTxn(), c.args[0], c.args[1:]...)
+	if err != nil {
+		return
+	}
+	return c.Resp(resp)
+}
+
+//ZPOPMIN key [count]
+func zPopMinCommand(c *Client) (err error) {
+	return zPopResp(c, false)
+}
+
+//ZPOPMAX key [count]
+func zPopMaxCommand(c *Client) (err error) {
+	return zPopResp(c, true)
+}
+func zPopResp(c *Client, max bool) (err error) {
+	var (
+		count int64 = 1
+		resp  []interface{}
+	)
+	if len(c.args) != 1 && len(c.args) != 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	if len(c.args) == 2 {
+		count, err = utils.StrBytesToInt64(c.args[1])
+		if err != nil || count < 0 {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+	}
+	resp, err = c.tdb.ZPop(c.GetTxn(), c.args[0], count, max)
+	if err != nil {
+		return
+	}
+	return c.Resp(resp)
+}
+
+//ZRANDMEMBER key [count [WITHSCORES]]
+func zRandMemberCommand(c *Client) (err error) {
+	var (
+		count      int64 = 1
+		withScores bool
+		resp       []interface{}
+	)
+	if len(c.args) < 1 || len(c.args) > 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	if len(c.args) > 1 {
+		count, err = utils.StrBytesToInt64(c.args[1])
+		if err != nil {
+			return
+		}
+	}
+	if len(c.args) == 3 {
+		if strings.ToUpper(string(c.args[2])) != "WITHSCORES" {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+		withScores = true
+	}
+	resp, err = c.tdb.ZRandMember(c.GetTxn(), c.args[0], count, withScores)
+	if err != nil {
+		return
+	}
+	//a single member without count
+	if len(c.args) == 1 {
+		if len(resp) == 0 {
+			return c.Resp(nil)
+		}
+		return c.Resp(resp[0])
+	}
+	return c.Resp(resp)
+}
+
+//ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func zUnionStoreCommand(c *Client) (err error) {
+	return zStoreResp(c, tidis.Union)
+}
+
+//ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func zInterStoreCommand(c *Client) (err error) {
+	return zStoreResp(c, tidis.Inter)
+}
+func zStoreResp(c *Client, actionType int) (err error) {
+	var (
+		keys      [][]byte
+		weights   []float64
+		aggregate byte
+		stored    int64
+	)
+	if len(c.args) < 3 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	keys, weights, aggregate, _, err = parseZSetKeys(c.args[1:], true)
+	if err != nil {
+		return
+	}
+	stored, err = c.tdb.ZStoreAction(c.GetTxn(), actionType, c.args[0], keys, weights, aggregate)
+	if err != nil {
+		return
+	}
+	return c.Resp(stored)
+}
+
+//ZDIFF numkeys key [key ...] [WITHSCORES]
+func zDiffCommand(c *Client) (err error) {
+	var (
+		keys       [][]byte
+		withScores bool
+		resp       []interface{}
+	)
+	if len(c.args) < 2 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	keys, _, _, withScores, err = parseZSetKeys(c.args, false)
+	if err != nil {
+		return
+	}
+	resp, err = c.tdb.ZDiff(c.GetTxn(), keys, withScores)
+	if err != nil {
+		return
+	}
+	return c.Resp(resp)
+}
+
+//ZRANGESTORE dst src min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+func zRangeStoreCommand(c *Client) (err error) {
+	var (
+		spec   tidis.ZRangeSpec
+		limit  bool
+		offset int64
+		count  int64
+		stored int64
+	)
+	if len(c.args) < 4 {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	for i := 4; i < len(c.args); i++ {
+		switch strings.ToUpper(string(c.args[i])) {
+		case "BYSCORE":
+			spec.By = tidis.ZRangeByScore
+		case "BYLEX":
+			spec.By = tidis.ZRangeByLex
+		case "REV":
+			spec.Reverse = true
+		case "LIMIT":
+			if i+2 >= len(c.args) {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			offset, err = utils.StrBytesToInt64(c.args[i+1])
+			if err != nil {
+				return
+			}
+			count, err = utils.StrBytesToInt64(c.args[i+2])
+			if err != nil {
+				return
+			}
+			limit = true
+			i += 2
+		default:
+			err = qkverror.ErrorCommandParams
+			return
+		}
+	}
+	//LIMIT is only allowed with BYSCORE or BYLEX
+	if limit && spec.By == tidis.ZRangeByIndex {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	//a negative offset selects nothing
+	if offset < 0 {
+		return c.Resp(int64(0))
+	}
+	spec.Offset, spec.Count = int(offset), int(count)
+	if !limit {
+		spec.Count = -1
+	}
+	switch spec.By {
+	case tidis.ZRangeByIndex:
+		spec.Start, err = utils.StrBytesToInt64(c.args[2])
+		if err != nil {
+			return
+		}
+		spec.Stop, err = utils.StrBytesToInt64(c.args[3])
+		if err != nil {
+			return
+		}
+	case tidis.ZRangeByScore:
+		spec.Min, err = parseZScoreBound(c.args[2])
+		if err != nil {
+			return
+		}
+		spec.Max, err = parseZScoreBound(c.args[3])
+		if err != nil {
+			return
+		}
+	case tidis.ZRangeByLex:
+		//with REV the range is given from max to min
+		spec.LexMin, spec.LexMax = c.args[2], c.args[3]
+		if spec.Reverse {
+			spec.LexMin, spec.LexMax = c.args[3], c.args[2]
+		}
+		if !validZLexBound(spec.LexMin) || !validZLexBound(spec.LexMax) {
+			err = qkverror.ErrorCommandParams
+			return
+		}
+	}
+	stored, err = c.tdb.ZRangeStore(c.GetTxn(), c.args[0], c.args[1], spec)
+	if err != nil {
+		return
+	}
+	return c.Resp(stored)
+}
+
+//parseZSetKeys numkeys key [key ...] followed by WEIGHTS and AGGREGATE of the store commands, or WITHSCORES
+func parseZSetKeys(args [][]byte, store bool) (keys [][]byte, weights []float64, aggregate byte, withScores bool, err error) {
+	var (
+		numKeys int64
+		i       int
+	)
+	numKeys, err = utils.StrBytesToInt64(args[0])
+	if err != nil || numKeys <= 0 || numKeys > int64(len(args)-1) {
+		err = qkverror.ErrorCommandParams
+		return
+	}
+	keys = args[1 : numKeys+1]
+	for i = int(numKeys) + 1; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "WEIGHTS":
+			if !store || i+int(numKeys) >= len(args) {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			weights = make([]float64, numKeys)
+			for j := range weights {
+				weights[j], err = utils.StrBytesToFloat64(args[i+1+j])
+				if err != nil {
+					err = qkverror.ErrorNotFloat
+					return
+				}
+			}
+			i += int(numKeys)
+		case "AGGREGATE":
+			if !store || i+1 >= len(args) {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			switch strings.ToUpper(string(args[i+1])) {
+			case "SUM":
+				aggregate = tidis.ZAggregateSum
+			case "MIN":
+				aggregate = tidis.ZAggregateMin
+			case "MAX":
+				aggregate = tidis.ZAggregateMax
+			default:
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			i++
+		case "WITHSCORES":
+			if store {
+				err = qkverror.ErrorCommandParams
+				return
+			}
+			withScores = true
+		default:
+			err = qkverror.ErrorCommandParams
+			return
+		}
+	}
+	return
+}
+
+//validZLexBound a lex range bound starts with ( or [, or is - or +
+func validZLexBound(arg []byte) bool {
+	if len(arg) == 0 {
+		return false
+	}
+	switch arg[0] {
+	case '(', '[':
+		return true
+	case '-', '+':
+		return len(arg) == 1
+	}
+	return false
+}
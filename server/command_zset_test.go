@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestZSetCommands(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(3), "ZADD", "z", 1, "a", 2, "b", 3, "c")
+	c.expect(int64(1), "ZADD", "z", 2.5, "d", 4, "a")
+	c.expect(int64(4), "ZCARD", "z")
+	c.expect("4", "ZSCORE", "z", "a")
+	c.expect(nil, "ZSCORE", "z", "none")
+	c.expect(strs("b", "d", "c", "a"), "ZRANGE", "z", 0, -1)
+	c.expect(strs("b", "2", "d", "2.5"), "ZRANGE", "z", 0, 1, "WITHSCORES")
+	c.expect(strs("a", "c", "d", "b"), "ZREVRANGE", "z", 0, -1)
+	c.expect(int64(3), "ZCOUNT", "z", 2, 3)
+	c.expect(int64(1), "ZCOUNT", "z", "(2", "(3")
+	c.expect(strs("d", "c"), "ZRANGEBYSCORE", "z", "(2", 3)
+	c.expect(strs("c", "d"), "ZREVRANGEBYSCORE", "z", 3, "(2")
+	c.expect(strs("b", "d", "c", "a"), "ZRANGEBYSCORE", "z", "-inf", "+inf")
+	c.expect("5", "ZINCRBY", "z", 1, "a")
+	c.expect("1.5", "ZINCRBY", "z", -1, "d")
+	c.expect(int64(2), "ZREM", "z", "a", "d", "none")
+	c.expect(int64(1), "ZREMRANGEBYSCORE", "z", 0, 2)
+	c.expect(strs("c"), "ZRANGE", "z", 0, -1)
+	c.expectError("", "ZADD", "z", "x", "a")
+	c.expect("OK", "SET", "k", "v")
+	c.expectError("WRONGTYPE", "ZADD", "k", 1, "a")
+}
+
+func TestZSetLex(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(5), "ZADD", "z", 0, "a", 0, "b", 0, "c", 0, "d", 0, "e")
+	c.expect(int64(3), "ZLEXCOUNT", "z", "[b", "[d")
+	c.expect(int64(5), "ZLEXCOUNT", "z", "-", "+")
+	c.expect(strs("b", "c"), "ZRANGEBYLEX", "z", "[b", "(d")
+	c.expect(strs("d", "c"), "ZREVRANGEBYLEX", "z", "[d", "(b")
+	c.expect(strs("a", "b"), "ZRANGEBYLEX", "z", "-", "+", "LIMIT", 0, 2)
+	c.expect(int64(2), "ZREMRANGEBYLEX", "z", "[a", "(c")
+	c.expect(strs("c", "d", "e"), "ZRANGE", "z", 0, -1)
+}
+
+func TestZSetRank(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	for i := 0; i < 20; i++ {
+		c.expect(int64(1), "ZADD", "z", i, fmt.Sprintf("m%02d", i))
+	}
+	for _, i := range []int{0, 1, 9, 10, 18, 19} {
+		c.expect(int64(i), "ZRANK", "z", fmt.Sprintf("m%02d", i))
+		c.expect(int64(19-i), "ZREVRANK", "z", fmt.Sprintf("m%02d", i))
+	}
+	c.expect([]interface{}{int64(3), "3"}, "ZRANK", "z", "m03", "WITHSCORE")
+	c.expect(nil, "ZRANK", "z", "none")
+	c.expect(nil, "ZRANK", "none", "m01")
+	c.expect([]interface{}{"1", nil, "19"}, "ZMSCORE", "z", "m01", "none", "m19")
+}
+
+func TestZSetPopRandom(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(4), "ZADD", "z", 1, "a", 2, "b", 3, "c", 4, "d")
+	c.expect(strs("a", "1"), "ZPOPMIN", "z")
+	c.expect(strs("d", "4", "c", "3"), "ZPOPMAX", "z", 2)
+	c.expect("b", "ZRANDMEMBER", "z")
+	c.expect(strs("b", "2"), "ZRANDMEMBER", "z", 1, "WITHSCORES")
+	c.expect(strs("b", "b", "b"), "ZRANDMEMBER", "z", -3)
+	c.expect(strs("b"), "ZRANDMEMBER", "z", 5)
+	c.expect(strs("b", "2"), "ZPOPMIN", "z", 5)
+	c.expect(int64(0), "EXISTS", "z")
+	c.expect([]interface{}{}, "ZPOPMIN", "z")
+	c.expect(nil, "ZRANDMEMBER", "z")
+}
+
+func TestZSetStore(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect(int64(3), "ZADD", "z1", 1, "a", 2, "b", 3, "c")
+	c.expect(int64(2), "ZADD", "z2", 10, "b", 20, "d")
+	c.expect(int64(1), "SADD", "s", "c")
+	c.expect(int64(4), "ZUNIONSTORE", "u", 2, "z1", "z2")
+	c.expect(strs("a", "1", "c", "3", "b", "12", "d", "20"), "ZRANGE", "u", 0, -1, "WITHSCORES")
+	c.expect(int64(1), "ZINTERSTORE", "i", 2, "z1", "z2", "WEIGHTS", 2, 1, "AGGREGATE", "MAX")
+	c.expect(strs("b", "10"), "ZRANGE", "i", 0, -1, "WITHSCORES")
+	c.expect(int64(1), "ZINTERSTORE", "i", 2, "z1", "s")
+	c.expect(strs("c", "4"), "ZRANGE", "i", 0, -1, "WITHSCORES")
+	c.expect(strs("a", "c"), "ZDIFF", 2, "z1", "z2")
+	c.expect(strs("a", "1"), "ZDIFF", 3, "z1", "z2", "s", "WITHSCORES")
+	c.expect(int64(2), "ZRANGESTORE", "r", "z1", 1, 2)
+	c.expect(strs("b", "c"), "ZRANGE", "r", 0, -1)
+	c.expect(int64(1), "ZRANGESTORE", "r", "u", 12, "+inf", "BYSCORE", "LIMIT", 1, 1)
+	c.expect(strs("d"), "ZRANGE", "r", 0, -1)
+	c.expect(int64(0), "ZUNIONSTORE", "u", 1, "none")
+	c.expect(int64(0), "EXISTS", "u")
+}
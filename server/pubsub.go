@@ -0,0 +1,241 @@
+package server
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/chuangyou/qkv/utils"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	//pushQueueSize the messages queued for a subscriber, it is disconnected when they are not written fast enough
+	pushQueueSize = 1024
+)
+
+//PubSub the clients of this instance subscribed to channels and patterns
+type PubSub struct {
+	sync.RWMutex
+	channels map[string]map[*Client]bool
+	patterns map[string]map[*Client]bool
+}
+
+func NewPubSub() *PubSub {
+	return &PubSub{
+		channels: make(map[string]map[*Client]bool),
+		patterns: make(map[string]map[*Client]bool),
+	}
+}
+
+//Subscribe returns false if c already subscribed to channel
+func (ps *PubSub) Subscribe(c *Client, channel []byte) bool {
+	return ps.add(ps.channels, c, channel)
+}
+func (ps *PubSub) Unsubscribe(c *Client, channel []byte) bool {
+	return ps.remove(ps.channels, c, channel)
+}
+func (ps *PubSub) PSubscribe(c *Client, pattern []byte) bool {
+	return ps.add(ps.patterns, c, pattern)
+}
+func (ps *PubSub) PUnsubscribe(c *Client, pattern []byte) bool {
+	return ps.remove(ps.patterns, c, pattern)
+}
+
+//UnsubscribeAll drop all the subscriptions of a disconnected client
+func (ps *PubSub) UnsubscribeAll(c *Client) {
+	for channel := range c.channels {
+		ps.Unsubscribe(c, []byte(channel))
+	}
+	for pattern := range c.patterns {
+		ps.PUnsubscribe(c, []byte(pattern))
+	}
+}
+func (ps *PubSub) add(subs map[string]map[*Client]bool, c *Client, name []byte) bool {
+	ps.Lock()
+	defer ps.Unlock()
+	if subs[string(name)] == nil {
+		subs[string(name)] = make(map[*Client]bool)
+	}
+	if subs[string(name)][c] {
+		return false
+	}
+	subs[string(name)][c] = true
+	return true
+}
+func (ps *PubSub) remove(subs map[string]map[*Client]bool, c *Client, name []byte) bool {
+	ps.Lock()
+	defer ps.Unlock()
+	if !subs[string(name)][c] {
+		return false
+	}
+	delete(subs[string(name)], c)
+	if len(subs[string(name)]) == 0 {
+		delete(subs, string(name))
+	}
+	return true
+}
+
+//delivery a message for a subscriber
+type delivery struct {
+	c    *Client
+	resp []interface{}
+}
+
+//Publish deliver message to the local subscribers of channel, returns the number of clients that received it.
+//The channels and patterns of the tenants are prefixed by their tenant, they are delivered without it.
+//The messages are queued for the subscribers, none of them is written under the lock.
+func (ps *PubSub) Publish(channel, message []byte) (receivers int64) {
+	var (
+		prefix     []byte
+		name       []byte
+		pprefix    []byte
+		pname      []byte
+		deliveries []delivery
+	)
+	prefix, name = utils.SplitTenant(channel)
+	ps.RLock()
+	for c := range ps.channels[string(channel)] {
+		deliveries = append(deliveries, delivery{c, []interface{}{[]byte("message"), name, message}})
+	}
+	for pattern, clients := range ps.patterns {
+		//a pattern only matches the channels of its tenant
+		pprefix, pname = utils.SplitTenant([]byte(pattern))
+		if !bytes.Equal(pprefix, prefix) || !utils.MatchPattern(pname, name) {
+			continue
+		}
+		for c := range clients {
+			deliveries = append(deliveries, delivery{c, []interface{}{[]byte("pmessage"), pname, name, message}})
+		}
+	}
+	ps.RUnlock()
+	for _, d := range deliveries {
+		if d.c.push(d.resp) {
+			receivers++
+		}
+	}
+	return
+}
+
+//startPushes queue the replies of the client from now on, it is about to subscribe
+func (c *Client) startPushes() {
+	if c.pushes == nil {
+		c.pushes = make(chan interface{}, pushQueueSize)
+		go c.pushLoop()
+	}
+	c.wmu.Lock()
+	c.pushing = true
+	c.wmu.Unlock()
+}
+
+//stopPushes write the replies directly again once the queued ones are written, the client has no subscription left.
+//The messages still queued after that are dropped.
+func (c *Client) stopPushes() {
+	if !c.pushing {
+		return
+	}
+	written := make(chan struct{})
+	c.pushes <- written
+	<-written
+	c.wmu.Lock()
+	c.pushing = false
+	c.wmu.Unlock()
+}
+
+//push queue a message for the subscriber without waiting. A subscriber too slow to read its messages is
+//disconnected, like redis does when the output buffer of a client is full.
+func (c *Client) push(resp []interface{}) bool {
+	select {
+	case <-c.closed:
+		return false
+	default:
+	}
+	select {
+	case c.pushes <- resp:
+		return true
+	default:
+		log.Warnf("pubsub client %s too slow, %d messages queued, disconnected", c.conn.RemoteAddr().String(), pushQueueSize)
+		c.conn.Close()
+		return false
+	}
+}
+
+//pushLoop write the queued replies and messages of the client until its connection is done
+func (c *Client) pushLoop() {
+	var (
+		failed bool
+		err    error
+	)
+	for {
+		select {
+		case resp := <-c.pushes:
+			if written, ok := resp.(chan struct{}); ok {
+				close(written)
+				continue
+			}
+			c.wmu.Lock()
+			if c.pushing && !failed {
+				if err = c.writeResp(resp); err != nil {
+					//keep draining the queue until the client is done
+					log.Debugf("pubsub deliver failed, %s", err.Error())
+					failed = true
+					c.conn.Close()
+				}
+			}
+			c.wmu.Unlock()
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+//HasSubscribers whether any client of this instance subscribed to a channel or pattern
+func (ps *PubSub) HasSubscribers() bool {
+	ps.RLock()
+	defer ps.RUnlock()
+	return len(ps.channels) > 0 || len(ps.patterns) > 0
+}
+
+//Channels active channels of the tenant prefix matching pattern, all of them if pattern is nil, without the prefix
+func (ps *PubSub) Channels(prefix, pattern []byte) (channels []interface{}) {
+	var (
+		cprefix []byte
+		name    []byte
+	)
+	ps.RLock()
+	defer ps.RUnlock()
+	channels = make([]interface{}, 0, len(ps.channels))
+	for channel := range ps.channels {
+		cprefix, name = utils.SplitTenant([]byte(channel))
+		if !bytes.Equal(cprefix, prefix) {
+			continue
+		}
+		if pattern != nil && !utils.MatchPattern(pattern, name) {
+			continue
+		}
+		channels = append(channels, name)
+	}
+	return
+}
+
+//NumSub number of subscribers of channel
+func (ps *PubSub) NumSub(channel []byte) int64 {
+	ps.RLock()
+	defer ps.RUnlock()
+	return int64(len(ps.channels[string(channel)]))
+}
+
+//NumPat number of subscribed patterns of the tenant prefix
+func (ps *PubSub) NumPat(prefix []byte) (n int64) {
+	var (
+		pprefix []byte
+	)
+	ps.RLock()
+	defer ps.RUnlock()
+	for pattern := range ps.patterns {
+		pprefix, _ = utils.SplitTenant([]byte(pattern))
+		if bytes.Equal(pprefix, prefix) {
+			n++
+		}
+	}
+	return
+}
@@ -16,6 +16,10 @@ type Server struct {
 	listener *net.TCPListener
 	tdb      *tidis.Tidis
 	auth     string
+	pubsub   *PubSub
+	//tlsListener the TLS listener next to the plain one, nil without tls_address
+	tlsListener net.Listener
+	tlsConfig   *tlsConfig
 }
 
 func NewServer(conf *config.Config) (server *Server, err error) {
@@ -25,7 +29,21 @@ func NewServer(conf *config.Config) (server *Server, err error) {
 	server = new(Server)
 	server.conf = conf
 	server.tdb, err = tidis.NewTidis(conf)
+	if err != nil {
+		log.Errorf("open tidis error(%v)", err)
+		return
+	}
+	if err = server.tdb.CheckLayout(); err != nil {
+		log.Errorf("check the keys of db 0 error(%v)", err)
+		return
+	}
+	//the keys of the swapped databases are found through the maps
+	if err = server.tdb.ReloadDBMaps(); err != nil {
+		log.Errorf("load the database maps error(%v)", err)
+		return
+	}
 	server.auth = conf.QKV.Auth
+	server.pubsub = NewPubSub()
 	if addr, err = net.ResolveTCPAddr("tcp4", conf.QKV.Address); err != nil {
 		log.Error("net.ResolveTCPAddr(\"tcp4\", \"%s\") error(%v)", conf.QKV.Address, err)
 		return
@@ -34,17 +52,38 @@ func NewServer(conf *config.Config) (server *Server, err error) {
 		log.Error("net.ListenTCP(\"tcp4\", \"%s\") error(%v)", conf.QKV.Address, err)
 		return
 	}
+	if conf.QKV.TLSAddress != "" {
+		err = server.listenTLS()
+	}
 	return
 }
 func (s *Server) Start() {
 	for i := 0; i < s.conf.QKV.Maxproc; i++ {
 		go s.acceptTCP()
+		if s.tlsListener != nil {
+			go s.acceptTLS()
+		}
 	}
 }
 func (s *Server) TTLCheck() {
-	go tidis.TTLCheckerRun(s.tdb, s.conf.QKV.TTLCheckerLoop, s.conf.QKV.TTLCheckerInterval)
+	go tidis.TTLCheckerRun(s.tdb, s.conf.QKV.TTLCheckerLoop, s.conf.QKV.TTLCheckerInterval, s.conf.QKV.LeaseTimeout)
 
 }
+func (s *Server) GC() {
+	go tidis.GCRun(s.tdb, s.conf.QKV.GCBatch, s.conf.QKV.GCInterval, s.conf.QKV.LeaseTimeout)
+}
+func (s *Server) Quota() {
+	go tidis.QuotaRun(s.tdb, s.conf.QKV.QuotaInterval, s.conf.QKV.LeaseTimeout)
+}
+func (s *Server) ACL() {
+	go tidis.ACLRun(s.tdb, s.conf.QKV.ACLReloadInterval)
+}
+func (s *Server) DBMap() {
+	go tidis.DBMapRun(s.tdb, s.conf.QKV.DBMapReloadInterval)
+}
+func (s *Server) PubSub() {
+	go tidis.PubSubRun(s.tdb, s.conf.QKV.PubSubPollInterval, s.conf.QKV.PubSubRetention, s.conf.QKV.LeaseTimeout, s.pubsub.Publish, s.pubsub.HasSubscribers)
+}
 func (s *Server) acceptTCP() {
 	var (
 		conn   *net.TCPConn
@@ -58,12 +97,14 @@ func (s *Server) acceptTCP() {
 			return
 		}
 		defer conn.Close()
-		client = NewClient(conn, s.tdb, s.conf.QKV.Auth)
+		client = NewClient(conn, s.tdb, s.conf.QKV.Auth, s.pubsub)
 		go s.serveTCP(client)
 
 	}
 }
 func (s *Server) serveTCP(client *Client) {
+	defer s.pubsub.UnsubscribeAll(client)
+	defer close(client.closed)
 	for {
 		req, err := client.r.ParseRequest()
 		if err != nil && err != io.EOF {
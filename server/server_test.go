@@ -0,0 +1,389 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/chuangyou/qkv/config"
+	"github.com/siddontang/goredis"
+)
+
+const testAuth = "qkv-test"
+
+//testServer a server over the in-process mocktikv store, listening on a free port
+type testServer struct {
+	*Server
+	t *testing.T
+}
+
+//newTestServer start a server, setup may change the config before the server is created
+func newTestServer(t *testing.T, setup func(conf *config.Config)) *testServer {
+	conf := &config.Config{
+		QKV: config.QKVConfig{
+			Address:   "127.0.0.1:0",
+			Auth:      testAuth,
+			Maxproc:   1,
+			Databases: 16,
+		},
+		Tikv: config.TikvConfig{Pds: "mocktikv://"},
+	}
+	if setup != nil {
+		setup(conf)
+	}
+	s, err := NewServer(conf)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	s.Start()
+	return &testServer{Server: s, t: t}
+}
+
+func (s *testServer) Close() {
+	s.listener.Close()
+	if s.tlsListener != nil {
+		s.tlsListener.Close()
+	}
+}
+
+//dial a client authenticated with the password of the config
+func (s *testServer) dial() *testClient {
+	c := s.dialNoAuth()
+	c.expect("OK", "AUTH", testAuth)
+	return c
+}
+func (s *testServer) dialNoAuth() *testClient {
+	conn, err := net.Dial("tcp", s.listener.Addr().String())
+	if err != nil {
+		s.t.Fatalf("dial: %v", err)
+	}
+	return newTestClient(s.t, conn)
+}
+
+//testClient a RESP client, the replies are normalized by reply
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *goredis.RespReader
+	w    *goredis.RespWriter
+	bw   *bufio.Writer
+}
+
+func newTestClient(t *testing.T, conn net.Conn) *testClient {
+	bw := bufio.NewWriter(conn)
+	return &testClient{
+		t:    t,
+		conn: conn,
+		r:    goredis.NewRespReader(bufio.NewReader(conn)),
+		w:    goredis.NewRespWriter(bw),
+		bw:   bw,
+	}
+}
+
+func (c *testClient) Close() {
+	c.conn.Close()
+}
+
+//send a command without reading its reply
+func (c *testClient) send(cmd string, args ...interface{}) {
+	c.t.Helper()
+	if err := c.w.WriteCommand(cmd, args...); err != nil {
+		c.t.Fatalf("write %s: %v", cmd, err)
+	}
+	if err := c.bw.Flush(); err != nil {
+		c.t.Fatalf("write %s: %v", cmd, err)
+	}
+}
+
+//receive the next reply
+func (c *testClient) receive() interface{} {
+	c.t.Helper()
+	resp, err := c.r.Parse()
+	if err != nil {
+		c.t.Fatalf("read reply: %v", err)
+	}
+	return reply(resp)
+}
+
+//do run a command and return its reply
+func (c *testClient) do(cmd string, args ...interface{}) interface{} {
+	c.t.Helper()
+	c.send(cmd, args...)
+	return c.receive()
+}
+
+//expect run a command and fail if its reply is not want
+func (c *testClient) expect(want interface{}, cmd string, args ...interface{}) {
+	c.t.Helper()
+	got := c.do(cmd, args...)
+	if !equalReply(got, want) {
+		c.t.Fatalf("%s %v: got %#v, want %#v", cmd, args, got, want)
+	}
+}
+
+//expectSorted like expect for the array replies in no particular order
+func (c *testClient) expectSorted(want []interface{}, cmd string, args ...interface{}) {
+	c.t.Helper()
+	got := c.do(cmd, args...)
+	ay, ok := got.([]interface{})
+	if !ok && got != nil || !reflect.DeepEqual(sorted(ay), sorted(want)) {
+		c.t.Fatalf("%s %v: got %#v, want %#v", cmd, args, got, want)
+	}
+}
+
+//expectError run a command and fail if it does not reply an error containing want
+func (c *testClient) expectError(want string, cmd string, args ...interface{}) {
+	c.t.Helper()
+	got := c.do(cmd, args...)
+	err, ok := got.(goredis.Error)
+	if !ok || !strings.Contains(string(err), want) {
+		c.t.Fatalf("%s %v: got %#v, want an error containing %q", cmd, args, got, want)
+	}
+}
+
+//integer run a command replying an integer
+func (c *testClient) integer(cmd string, args ...interface{}) int64 {
+	c.t.Helper()
+	got, ok := c.do(cmd, args...).(int64)
+	if !ok {
+		c.t.Fatalf("%s %v: not an integer reply", cmd, args)
+	}
+	return got
+}
+
+//array run a command replying an array
+func (c *testClient) array(cmd string, args ...interface{}) []interface{} {
+	c.t.Helper()
+	got := c.do(cmd, args...)
+	ay, ok := got.([]interface{})
+	if !ok {
+		c.t.Fatalf("%s %v: got %#v, not an array reply", cmd, args, got)
+	}
+	return ay
+}
+
+//equalReply an empty array may be replied as a null array
+func equalReply(got, want interface{}) bool {
+	if ay, ok := want.([]interface{}); ok && len(ay) == 0 && got == nil {
+		return true
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+//reply the bulk strings of resp as strings, so that replies compare with literals
+func reply(resp interface{}) interface{} {
+	switch v := resp.(type) {
+	case []byte:
+		return string(v)
+	case []interface{}:
+		ay := make([]interface{}, len(v))
+		for i := range v {
+			ay[i] = reply(v[i])
+		}
+		return ay
+	default:
+		return v
+	}
+}
+
+func sorted(ay []interface{}) []string {
+	s := make([]string, len(ay))
+	for i := range ay {
+		s[i] = fmt.Sprint(ay[i])
+	}
+	sort.Strings(s)
+	return s
+}
+
+//strs the array of the string literals ss, as replied
+func strs(ss ...string) []interface{} {
+	ay := make([]interface{}, len(ss))
+	for i := range ss {
+		ay[i] = ss[i]
+	}
+	return ay
+}
+
+func TestAuth(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dialNoAuth()
+	defer c.Close()
+	c.expectError("no authentication", "GET", "k")
+	c.expectError("invalid password", "AUTH", "wrong")
+	c.expectError("no authentication", "GET", "k")
+	c.expect("OK", "AUTH", testAuth)
+	c.expect("OK", "AUTH", "default", testAuth)
+	c.expect(nil, "GET", "k")
+}
+
+func TestPing(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("PONG", "PING")
+}
+
+func TestUnknownCommand(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expectError("", "NOSUCHCOMMAND", "k")
+	c.expectError("", "GET")
+}
+
+func TestMultiExec(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("OK", "MULTI")
+	c.expectError("", "MULTI")
+	c.expect("QUEUED", "SET", "a", "1")
+	c.expect("QUEUED", "INCR", "a")
+	c.expect("QUEUED", "GET", "a")
+	c.expect([]interface{}{"OK", int64(2), "2"}, "EXEC")
+	c.expect("2", "GET", "a")
+
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "SET", "a", "3")
+	c.expect("OK", "DISCARD")
+	c.expect("2", "GET", "a")
+
+	//a failed command aborts the transaction
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "SET", "b", "1")
+	c.expect("QUEUED", "LPUSH", "a", "x")
+	c.expect(nil, "EXEC")
+	c.expect(nil, "GET", "b")
+	c.expect(nil, "EXEC")
+}
+
+func TestWatch(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	other := s.dial()
+	defer other.Close()
+	c.expect("OK", "HMSET", "h", "f", "1", "g", "2")
+	c.expect(int64(2), "RPUSH", "l", "a", "b")
+	c.expect(int64(2), "ZADD", "z", "1", "a", "2", "b")
+	c.expect("OK", "SET", "s", "abc")
+
+	//a write after WATCH aborts the transaction, even one that only touches a member or writes the same bytes
+	for _, write := range [][]interface{}{
+		{"h", "HSET", "h", "f", "1"},
+		{"h", "HINCRBY", "h", "g", "1"},
+		{"l", "LSET", "l", "0", "x"},
+		{"z", "ZADD", "z", "5", "a"},
+		{"s", "SETRANGE", "s", "0", "abc"},
+		{"s", "SET", "s", "abc"},
+		{"s", "PEXPIRE", "s", "3600000"},
+		{"new", "SET", "new", "v"},
+	} {
+		c.expect("OK", "WATCH", write[0])
+		other.do(write[1].(string), write[2:]...)
+		c.expect("OK", "MULTI")
+		c.expect("QUEUED", "SET", "k", "v")
+		if got := c.do("EXEC"); got != nil {
+			t.Fatalf("EXEC after %v: got %#v", write[1:], got)
+		}
+	}
+
+	c.expect("OK", "WATCH", "h", "l")
+	other.expect("OK", "SET", "unrelated", "v")
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "HSET", "h", "f", "2")
+	c.expect([]interface{}{int64(0)}, "EXEC")
+	c.expect("2", "HGET", "h", "f")
+
+	c.expect("OK", "WATCH", "h")
+	c.expect("OK", "UNWATCH")
+	other.expect(int64(0), "HSET", "h", "f", "3")
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "HGET", "h", "f")
+	c.expect(strs("3"), "EXEC")
+
+	//UNWATCH inside MULTI is queued, it does not unwatch the keys of the transaction
+	c.expect("OK", "WATCH", "h")
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "UNWATCH")
+	other.expect(int64(0), "HSET", "h", "f", "4")
+	c.expect("QUEUED", "SET", "k", "v2")
+	c.expect(nil, "EXEC")
+	c.expect(nil, "GET", "k")
+	c.expect("OK", "WATCH", "h")
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "UNWATCH")
+	c.expect("QUEUED", "SET", "k", "v2")
+	c.expect([]interface{}{"OK", "OK"}, "EXEC")
+	//EXEC drops the watched keys
+	other.expect(int64(0), "HSET", "h", "f", "5")
+	c.expect("OK", "MULTI")
+	c.expect("QUEUED", "GET", "k")
+	c.expect(strs("v2"), "EXEC")
+}
+
+func TestTenants(t *testing.T) {
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.Tenants = []config.TenantConfig{
+			{Name: "t1", Password: "pw1", MaxKeys: 3},
+			{Name: "t2", Password: "pw2"},
+		}
+	})
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	t1 := s.dialNoAuth()
+	defer t1.Close()
+	t2 := s.dialNoAuth()
+	defer t2.Close()
+	t1.expect("OK", "AUTH", "t1", "pw1")
+	t2.expect("OK", "AUTH", "pw2")
+	bad := s.dialNoAuth()
+	defer bad.Close()
+	bad.expectError("", "AUTH", "t1", "pw2")
+	bad.expectError("", "AUTH", "pw3")
+
+	//each tenant has its own keys and databases
+	c.expect("OK", "SET", "k", "default")
+	t1.expect("OK", "SET", "k", "t1")
+	t2.expect(nil, "GET", "k")
+	t2.expect("OK", "SET", "k", "t2")
+	c.expect("default", "GET", "k")
+	t1.expect("t1", "GET", "k")
+	if got := t1.scanAll("SCAN"); !equalReply(got, strs("k")) {
+		t.Fatalf("SCAN of t1: got %#v", got)
+	}
+	t1.expect("OK", "SELECT", 1)
+	t1.expect(nil, "GET", "k")
+	t1.expect("OK", "SET", "k1", "v")
+	t1.expect("OK", "FLUSHALL")
+	t1.expect(int64(0), "EXISTS", "k1")
+	t1.expect("OK", "SELECT", 0)
+	t1.expect(int64(0), "EXISTS", "k")
+	t2.expect("t2", "GET", "k")
+	c.expect("default", "GET", "k")
+
+	//the writes are refused in the transaction going over the quota, the deletes are not. The counters were corrected
+	//after FLUSHALL, the keys it deleted are not counted
+	t1.expect("OK", "MSET", "a", "1", "b", "2", "c", "3")
+	t1.expectError("OOM", "SET", "d", "4")
+	t1.expect(int64(0), "EXISTS", "d")
+	t1.expect("OK", "SET", "a", "10")
+	t1.expect("OK", "MULTI")
+	t1.expect("QUEUED", "SET", "e", "5")
+	t1.expect("QUEUED", "DEL", "b")
+	t1.expect("QUEUED", "SET", "f", "6")
+	t1.expectError("OOM", "EXEC")
+	t1.expect(int64(1), "EXISTS", "b")
+	t1.expect(int64(1), "DEL", "a")
+	t2.expect("OK", "MSET", "a", "1", "b", "2", "c", "3", "d", "4")
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/chuangyou/qkv/config"
+	"github.com/chuangyou/qkv/qkverror"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	//tlsHandshakeTimeout a client has this long to complete the handshake
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+//tlsConfig the certificate and the client CAs of the TLS listener, replaced by ReloadTLS
+type tlsConfig struct {
+	sync.RWMutex
+	config *tls.Config
+}
+
+func (t *tlsConfig) get() *tls.Config {
+	t.RLock()
+	defer t.RUnlock()
+	return t.config
+}
+func (t *tlsConfig) set(config *tls.Config) {
+	t.Lock()
+	defer t.Unlock()
+	t.config = config
+}
+
+//loadTLSConfig read the certificate, the key and the client CAs of conf
+func loadTLSConfig(conf *config.QKVConfig) (tlsConf *tls.Config, err error) {
+	var (
+		cert tls.Certificate
+		pem  []byte
+	)
+	cert, err = tls.LoadX509KeyPair(conf.TLSCertFile, conf.TLSKeyFile)
+	if err != nil {
+		return
+	}
+	tlsConf = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if conf.TLSCAFile != "" {
+		pem, err = ioutil.ReadFile(conf.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.ClientCAs = x509.NewCertPool()
+		if !tlsConf.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, qkverror.ErrorTLSCAFile
+		}
+	}
+	switch conf.TLSAuthClients {
+	case "", "no":
+		tlsConf.ClientAuth = tls.NoClientCert
+	case "optional":
+		tlsConf.ClientAuth = tls.VerifyClientCertIfGiven
+	case "yes":
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, qkverror.ErrorTLSAuthClients
+	}
+	if tlsConf.ClientAuth != tls.NoClientCert && tlsConf.ClientCAs == nil {
+		return nil, qkverror.ErrorTLSAuthClients
+	}
+	return
+}
+
+//listenTLS listen on the TLS address, each handshake uses the config loaded last
+func (s *Server) listenTLS() (err error) {
+	var (
+		addr     *net.TCPAddr
+		listener *net.TCPListener
+		tlsConf  *tls.Config
+	)
+	tlsConf, err = loadTLSConfig(&s.conf.QKV)
+	if err != nil {
+		log.Errorf("load tls certificates error(%v)", err)
+		return
+	}
+	s.tlsConfig = &tlsConfig{config: tlsConf}
+	if addr, err = net.ResolveTCPAddr("tcp4", s.conf.QKV.TLSAddress); err != nil {
+		log.Errorf("net.ResolveTCPAddr(\"tcp4\", \"%s\") error(%v)", s.conf.QKV.TLSAddress, err)
+		return
+	}
+	if listener, err = net.ListenTCP("tcp4", addr); err != nil {
+		log.Errorf("net.ListenTCP(\"tcp4\", \"%s\") error(%v)", s.conf.QKV.TLSAddress, err)
+		return
+	}
+	s.tlsListener = tls.NewListener(listener, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.tlsConfig.get(), nil
+		},
+	})
+	return
+}
+
+//ReloadTLS load the certificate, the key and the client CAs again, for the next handshakes.
+//The current config is kept if they can't be loaded.
+func (s *Server) ReloadTLS() {
+	var (
+		tlsConf *tls.Config
+		err     error
+	)
+	if s.tlsListener == nil {
+		return
+	}
+	tlsConf, err = loadTLSConfig(&s.conf.QKV)
+	if err != nil {
+		log.Errorf("reload tls certificates error(%v), keep the current ones", err)
+		return
+	}
+	s.tlsConfig.set(tlsConf)
+	log.Infof("tls certificates reloaded")
+}
+func (s *Server) acceptTLS() {
+	var (
+		conn   net.Conn
+		err    error
+		client *Client
+	)
+	for {
+		if conn, err = s.tlsListener.Accept(); err != nil {
+			// if listener close then return
+			log.Errorf("listener.Accept(\"%s\") error(%v)", s.tlsListener.Addr().String(), err)
+			return
+		}
+		client = NewClient(conn, s.tdb, s.conf.QKV.Auth, s.pubsub)
+		go s.serveTLS(client, conn.(*tls.Conn))
+	}
+}
+func (s *Server) serveTLS(client *Client, conn *tls.Conn) {
+	defer conn.Close()
+	if err := s.authTLSClient(client, conn); err != nil {
+		log.Debugf("tls client %s error(%v)", conn.RemoteAddr().String(), err)
+		return
+	}
+	s.serveTCP(client)
+}
+
+//authTLSClient complete the handshake, a client with a verified certificate whose CN is the name
+//of an ACL user is logged in as that user
+func (s *Server) authTLSClient(client *Client, conn *tls.Conn) (err error) {
+	var (
+		state tls.ConnectionState
+		cn    string
+		ok    bool
+	)
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err = conn.Handshake(); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	state = conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return
+	}
+	cn = state.PeerCertificates[0].Subject.CommonName
+	if cn == "" {
+		return
+	}
+	ok, err = s.tdb.ACLLogin(cn)
+	if err != nil {
+		log.Warnf("tls client acl user %s error(%v)", cn, err)
+	}
+	if err != nil || !ok {
+		//the client can still AUTH
+		return nil
+	}
+	client.isAuth = true
+	client.user = cn
+	return
+}
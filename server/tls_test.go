@@ -0,0 +1,180 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chuangyou/qkv/config"
+)
+
+//testCA a certificate authority issuing the certificates of the tests
+type testCA struct {
+	t    *testing.T
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	ca := &testCA{t: t}
+	ca.cert, ca.key, ca.pem = ca.issue(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: "qkv test ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	return ca
+}
+
+//issue sign template with the ca, self signed for the ca itself
+func (ca *testCA) issue(template *x509.Certificate) (cert *x509.Certificate, key *ecdsa.PrivateKey, certPEM []byte) {
+	ca.t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		ca.t.Fatalf("generate key: %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	parent, signer := template, key
+	if ca.cert != nil {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		ca.t.Fatalf("create certificate: %v", err)
+	}
+	if cert, err = x509.ParseCertificate(der); err != nil {
+		ca.t.Fatalf("parse certificate: %v", err)
+	}
+	return cert, key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+//keyPair a certificate of cn signed by the ca and its key, in PEM
+func (ca *testCA) keyPair(cn string, server bool) (certPEM, keyPEM []byte) {
+	ca.t.Helper()
+	template := &x509.Certificate{Subject: pkix.Name{CommonName: cn}, KeyUsage: x509.KeyUsageDigitalSignature}
+	if server {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	_, key, certPEM := ca.issue(template)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		ca.t.Fatalf("marshal key: %v", err)
+	}
+	return certPEM, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+//writeFile write data to name in dir, returns its path
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return path
+}
+
+//dialTLS a client of the TLS listener trusting ca, with the client certificate of cn if cn is not empty
+func (s *testServer) dialTLS(ca *testCA, cn string) (*testClient, *tls.Conn) {
+	s.t.Helper()
+	conf := &tls.Config{RootCAs: x509.NewCertPool()}
+	conf.RootCAs.AddCert(ca.cert)
+	if cn != "" {
+		certPEM, keyPEM := ca.keyPair(cn, false)
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			s.t.Fatalf("client key pair: %v", err)
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	conn, err := tls.Dial("tcp", s.tlsListener.Addr().String(), conf)
+	if err != nil {
+		s.t.Fatalf("dial tls: %v", err)
+	}
+	return newTestClient(s.t, conn), conn
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.keyPair("qkv", true)
+	s := newTestServer(t, func(conf *config.Config) {
+		conf.QKV.TLSAddress = "127.0.0.1:0"
+		conf.QKV.TLSCertFile = writeFile(t, dir, "server.crt", certPEM)
+		conf.QKV.TLSKeyFile = writeFile(t, dir, "server.key", keyPEM)
+		conf.QKV.TLSCAFile = writeFile(t, dir, "ca.crt", ca.pem)
+		conf.QKV.TLSAuthClients = "optional"
+	})
+	defer s.Close()
+	c := s.dial()
+	defer c.Close()
+	c.expect("OK", "ACL", "SETUSER", "alice", "on", "~*", "+@all")
+
+	//without a client certificate the client still needs AUTH
+	a, _ := s.dialTLS(ca, "")
+	defer a.Close()
+	a.expectError("no authentication", "GET", "k")
+	a.expect("OK", "AUTH", testAuth)
+	a.expect("OK", "SET", "k", "v")
+
+	//the CN of a verified certificate logs the client in as the ACL user
+	b, _ := s.dialTLS(ca, "alice")
+	defer b.Close()
+	b.expect("alice", "ACL", "WHOAMI")
+	b.expect("v", "GET", "k")
+	u, _ := s.dialTLS(ca, "nobody")
+	defer u.Close()
+	u.expectError("no authentication", "GET", "k")
+
+	//a certificate of another ca is refused
+	other := newTestCA(t)
+	certPEM, keyPEM = other.keyPair("alice", false)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("key pair: %v", err)
+	}
+	conf := &tls.Config{RootCAs: x509.NewCertPool(), Certificates: []tls.Certificate{cert}}
+	conf.RootCAs.AddCert(ca.cert)
+	if conn, err := tls.Dial("tcp", s.tlsListener.Addr().String(), conf); err == nil {
+		//with TLS 1.3 the client learns it was refused at its first read
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err = conn.Read(make([]byte, 1)); err == nil {
+			t.Fatalf("certificate of another ca accepted")
+		}
+		conn.Close()
+	}
+
+	//a reload with files that can't be loaded keeps the current certificate
+	writeFile(t, dir, "server.key", []byte("broken"))
+	s.ReloadTLS()
+	a2, _ := s.dialTLS(ca, "")
+	a2.expect("OK", "AUTH", testAuth)
+	a2.Close()
+
+	//the reloaded certificate is used by the next handshakes
+	certPEM, keyPEM = ca.keyPair("qkv reloaded", true)
+	writeFile(t, dir, "server.crt", certPEM)
+	writeFile(t, dir, "server.key", keyPEM)
+	s.ReloadTLS()
+	a3, conn := s.dialTLS(ca, "")
+	defer a3.Close()
+	if cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != "qkv reloaded" {
+		t.Fatalf("certificate after the reload: %q", cn)
+	}
+	a.expect("v", "GET", "k")
+}
@@ -5,11 +5,12 @@ import (
 	"os/signal"
 	"syscall"
 
+	"github.com/chuangyou/qkv/server"
 	log "github.com/sirupsen/logrus"
 )
 
-// InitSignal register signals handler.
-func InitSignal() {
+// InitSignal register signals handler, SIGHUP reloads the TLS certificates of qkvServer.
+func InitSignal(qkvServer *server.Server) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	for {
@@ -19,13 +20,13 @@ func InitSignal() {
 		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
 			return
 		case syscall.SIGHUP:
-			reload()
+			reload(qkvServer)
 		default:
 			return
 		}
 	}
 }
 
-func reload() {
-
+func reload(qkvServer *server.Server) {
+	qkvServer.ReloadTLS()
 }
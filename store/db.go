@@ -9,7 +9,9 @@ type DB interface {
 	SetEX(interface{}, []byte, int64, []byte) error
 	PExipre(interface{}, []byte, int64) (int, error)
 	DeleteRangeWithTxn(interface{}, []byte, []byte, uint64) (uint64, error)
+	DeleteRange([]byte, []byte) error
 	GetRangeKeys(interface{}, []byte, bool, []byte, bool, uint64, uint64, bool) ([][]byte, uint64, error)
 	GetRangeKeysValues(interface{}, []byte, []byte, uint64, bool) ([][]byte, error)
 	NewTxn() (interface{}, error)
+	CurrentVersion() (uint64, error)
 }
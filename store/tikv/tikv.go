@@ -2,31 +2,197 @@ package tikv
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"math"
+	"net"
+	"strings"
 	"time"
 
 	"github.com/chuangyou/qkv/config"
 	"github.com/chuangyou/qkv/qkverror"
 	"github.com/chuangyou/qkv/utils"
+	tidbconfig "github.com/pingcap/tidb/config"
 	"github.com/pingcap/tidb/kv"
+	"github.com/pingcap/tidb/store/mockstore"
 	ti "github.com/pingcap/tidb/store/tikv"
 )
 
+//MockPrefix pds with this prefix open an embedded mocktikv store instead of a real cluster
+const MockPrefix = "mocktikv://"
+
+const (
+	defaultDialTimeout    = 3000
+	defaultConnectTimeout = 20000
+)
+
 type Tikv struct {
 	store kv.Storage
 }
 
 //OpenTikv open the tikv connection by pds
 func Open(conf *config.Config) (*Tikv, error) {
-	driver := ti.Driver{}
-	store, err := driver.Open(fmt.Sprintf("tikv://%s?cluster=1&disableGC=false", conf.Tikv.Pds))
+	var (
+		store     kv.Storage
+		err       error
+		endpoints []string
+	)
+	if strings.HasPrefix(conf.Tikv.Pds, MockPrefix) {
+		//in-process store, nothing is dialed
+		driver := mockstore.MockDriver{}
+		store, err = driver.Open(conf.Tikv.Pds)
+		if err != nil {
+			return nil, err
+		}
+		return &Tikv{store: store}, nil
+	}
+	endpoints = pdEndpoints(&conf.Tikv)
+	if len(endpoints) == 0 {
+		return nil, qkverror.ErrorTikvNoPd
+	}
+	if err = setSecurity(&conf.Tikv); err != nil {
+		return nil, err
+	}
+	if err = dialPds(endpoints, conf.Tikv.DialTimeout); err != nil {
+		return nil, err
+	}
+	store, err = openTikv(endpoints, conf.Tikv.ConnectTimeout)
 	if err != nil {
 		return nil, err
 	}
 	return &Tikv{store: store}, nil
 }
 
+//pdEndpoints the pd endpoints of the config, pd_endpoints or else the comma separated pds
+func pdEndpoints(conf *config.TikvConfig) (endpoints []string) {
+	var (
+		list = conf.PdEndpoints
+	)
+	if len(list) == 0 {
+		list = strings.Split(conf.Pds, ",")
+	}
+	for _, endpoint := range list {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return
+}
+
+//setSecurity the driver connects to PD and TiKV with the cluster security of the global TiDB config.
+//The files are loaded here so a wrong path fails at startup with its name.
+//The global config is shared by the whole process: each Open replaces it with the paths of its config, empty without
+//TLS, and the stores opened after it use them. A store opened before keeps its connections, and the driver returns
+//that same store to a later Open of the same cluster, so the security of a cluster can't change within a process.
+func setSecurity(conf *config.TikvConfig) (err error) {
+	var (
+		pem []byte
+	)
+	if conf.CAPath == "" && conf.CertPath == "" && conf.KeyPath == "" {
+		applySecurity(conf)
+		return
+	}
+	if conf.CAPath == "" || conf.CertPath == "" || conf.KeyPath == "" {
+		return qkverror.ErrorTikvSecurity
+	}
+	pem, err = ioutil.ReadFile(conf.CAPath)
+	if err != nil {
+		return fmt.Errorf("read tikv ca_path %s: %v", conf.CAPath, err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificate found in tikv ca_path %s", conf.CAPath)
+	}
+	if _, err = tls.LoadX509KeyPair(conf.CertPath, conf.KeyPath); err != nil {
+		return fmt.Errorf("load tikv cert_path %s and key_path %s: %v", conf.CertPath, conf.KeyPath, err)
+	}
+	applySecurity(conf)
+	return
+}
+
+//applySecurity set the cluster security of the global TiDB config to the paths of conf
+func applySecurity(conf *config.TikvConfig) {
+	security := &tidbconfig.GetGlobalConfig().Security
+	security.ClusterSSLCA = conf.CAPath
+	security.ClusterSSLCert = conf.CertPath
+	security.ClusterSSLKey = conf.KeyPath
+}
+
+//dialPds fail with the address and the error of each endpoint if none of them can be reached
+func dialPds(endpoints []string, timeout int) error {
+	var (
+		conn     net.Conn
+		err      error
+		failures []string
+	)
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
+	}
+	for _, endpoint := range endpoints {
+		conn, err = net.DialTimeout("tcp", pdHost(endpoint), time.Duration(timeout)*time.Millisecond)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", endpoint, err))
+	}
+	return fmt.Errorf("can't reach any pd endpoint (%s)", strings.Join(failures, "; "))
+}
+
+//pdHost the host:port of an endpoint that may be an url
+func pdHost(endpoint string) string {
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		endpoint = endpoint[i+3:]
+	}
+	return strings.TrimSuffix(endpoint, "/")
+}
+
+//openTikv open the store through the TiDB driver, which keeps retrying pd, fail after timeout ms.
+//The driver can't be cancelled, a store it opens after the timeout is closed.
+func openTikv(endpoints []string, timeout int) (store kv.Storage, err error) {
+	type result struct {
+		store kv.Storage
+		err   error
+	}
+	var (
+		done      = make(chan result)
+		abandoned = make(chan struct{})
+		path      string
+		hosts     []string
+	)
+	if timeout <= 0 {
+		timeout = defaultConnectTimeout
+	}
+	for _, endpoint := range endpoints {
+		hosts = append(hosts, pdHost(endpoint))
+	}
+	path = fmt.Sprintf("tikv://%s?cluster=1&disableGC=false", strings.Join(hosts, ","))
+	go func() {
+		var r result
+		driver := ti.Driver{}
+		r.store, r.err = driver.Open(path)
+		select {
+		case done <- r:
+		case <-abandoned:
+			if r.store != nil {
+				r.store.Close()
+			}
+		}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			return nil, fmt.Errorf("connect to tikv cluster by pd %s: %v", strings.Join(hosts, ","), r.err)
+		}
+		return r.store, nil
+	case <-time.After(time.Duration(timeout) * time.Millisecond):
+		close(abandoned)
+		return nil, fmt.Errorf("connect to tikv cluster by pd %s: timed out after %dms", strings.Join(hosts, ","), timeout)
+	}
+}
+
 //Get get the value of key and  can use tikv transaction get the value
 func (tikv *Tikv) Get(txn interface{}, key []byte) (data []byte, err error) {
 	var (
@@ -446,12 +612,42 @@ func (tikv *Tikv) DeleteRangeWithTxn(txn interface{}, start []byte, end []byte,
 	return
 }
 
+//DeleteRange delete all the keys in [start, end) with the delete range of TiKV. It is not a transaction: the keys are
+//removed region by region, the transactions running meanwhile may still read them or write keys in the range.
+func (tikv *Tikv) DeleteRange(start []byte, end []byte) (err error) {
+	var (
+		store ti.Storage
+		ok    bool
+	)
+	if !tikv.store.SupportDeleteRange() {
+		return qkverror.ErrorDeleteRangeUnsupported
+	}
+	store, ok = tikv.store.(ti.Storage)
+	if !ok {
+		return qkverror.ErrorDeleteRangeUnsupported
+	}
+	return ti.NewDeleteRangeTask(context.Background(), store, start, end).Execute()
+}
+
 //NewTxn new a tikv transaction,return a interface.
 func (tikv *Tikv) NewTxn() (txn interface{}, err error) {
 	txn, err = tikv.store.Begin()
 	return
 }
 
+//CurrentVersion a new timestamp of the oracle, later than the start of the transactions begun before.
+func (tikv *Tikv) CurrentVersion() (ts uint64, err error) {
+	var (
+		ver kv.Version
+	)
+	ver, err = tikv.store.CurrentVersion()
+	if err != nil {
+		return
+	}
+	ts = ver.Ver
+	return
+}
+
 //Close close the tikv connection.
 func (tikv *Tikv) Close() error {
 	return tikv.store.Close()
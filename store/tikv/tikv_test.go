@@ -0,0 +1,166 @@
+package tikv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chuangyou/qkv/config"
+	"github.com/chuangyou/qkv/qkverror"
+	tidbconfig "github.com/pingcap/tidb/config"
+)
+
+func TestPdEndpoints(t *testing.T) {
+	for _, c := range []struct {
+		pds       string
+		endpoints []string
+		want      []string
+	}{
+		{"127.0.0.1:2379", nil, []string{"127.0.0.1:2379"}},
+		{"pd1:2379,pd2:2379, pd3:2379", nil, []string{"pd1:2379", "pd2:2379", "pd3:2379"}},
+		//empty entries and spaces are dropped
+		{" pd1:2379 ,, ,pd2:2379,", nil, []string{"pd1:2379", "pd2:2379"}},
+		{"", nil, nil},
+		{" , ", nil, nil},
+		//pd_endpoints wins over pds
+		{"pd1:2379", []string{"http://pd2:2379", " ", "pd3:2379 "}, []string{"http://pd2:2379", "pd3:2379"}},
+		{"pd1:2379", []string{}, []string{"pd1:2379"}},
+	} {
+		got := pdEndpoints(&config.TikvConfig{Pds: c.pds, PdEndpoints: c.endpoints})
+		if !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("endpoints of pds %q and pd_endpoints %q: %q, want %q", c.pds, c.endpoints, got, c.want)
+		}
+	}
+}
+
+func TestPdHost(t *testing.T) {
+	for _, c := range []struct {
+		endpoint string
+		want     string
+	}{
+		{"127.0.0.1:2379", "127.0.0.1:2379"},
+		{"http://pd1:2379", "pd1:2379"},
+		{"https://pd1:2379/", "pd1:2379"},
+		{"tikv://pd1:2379", "pd1:2379"},
+		{"pd1:2379/", "pd1:2379"},
+	} {
+		if got := pdHost(c.endpoint); got != c.want {
+			t.Fatalf("host of %q: %q, want %q", c.endpoint, got, c.want)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	for _, c := range []struct {
+		conf config.TikvConfig
+		err  error
+	}{
+		{config.TikvConfig{Pds: MockPrefix}, nil},
+		//the pd endpoints are not parsed for the in-process store
+		{config.TikvConfig{Pds: MockPrefix + "anything", PdEndpoints: []string{"pd1:2379"}}, nil},
+		{config.TikvConfig{Pds: " , "}, qkverror.ErrorTikvNoPd},
+		{config.TikvConfig{Pds: "pd1:2379", CAPath: "ca.pem"}, qkverror.ErrorTikvSecurity},
+	} {
+		store, err := Open(&config.Config{Tikv: c.conf})
+		if err != c.err {
+			t.Fatalf("open %+v: %v, want %v", c.conf, err, c.err)
+		}
+		if err == nil && store.store == nil {
+			t.Fatalf("open %+v: no store", c.conf)
+		}
+	}
+}
+
+//writeTestCerts write a self signed certificate and its key to dir, and a file that is not a certificate
+func writeTestCerts(t *testing.T, dir string) (cert, key, garbage string) {
+	pk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "qkv test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &pk.PublicKey, pk)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(pk)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	cert, key, garbage = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "garbage.pem")
+	for path, data := range map[string][]byte{
+		cert:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:     pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		garbage: []byte("not a certificate"),
+	} {
+		if err = ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	return
+}
+
+func TestSetSecurity(t *testing.T) {
+	var err error
+	dir := t.TempDir()
+	cert, key, garbage := writeTestCerts(t, dir)
+	missing := filepath.Join(dir, "missing.pem")
+	security := &tidbconfig.GetGlobalConfig().Security
+	for _, c := range []struct {
+		conf config.TikvConfig
+		//err the error, or the text it contains
+		err     error
+		errText string
+	}{
+		{conf: config.TikvConfig{CAPath: cert, CertPath: cert, KeyPath: key}},
+		{conf: config.TikvConfig{CAPath: cert}, err: qkverror.ErrorTikvSecurity},
+		{conf: config.TikvConfig{CertPath: cert, KeyPath: key}, err: qkverror.ErrorTikvSecurity},
+		{conf: config.TikvConfig{CAPath: missing, CertPath: cert, KeyPath: key}, errText: missing},
+		{conf: config.TikvConfig{CAPath: garbage, CertPath: cert, KeyPath: key}, errText: "no certificate found in tikv ca_path " + garbage},
+		{conf: config.TikvConfig{CAPath: cert, CertPath: cert, KeyPath: garbage}, errText: "load tikv cert_path " + cert},
+		{conf: config.TikvConfig{CAPath: cert, CertPath: missing, KeyPath: key}, errText: missing},
+		//no TLS clears the paths of a previous Open
+		{conf: config.TikvConfig{}},
+	} {
+		//a failed check leaves the global config as it was
+		security.ClusterSSLCA, security.ClusterSSLCert, security.ClusterSSLKey = "previous", "previous", "previous"
+		err = setSecurity(&c.conf)
+		switch {
+		case c.err != nil:
+			if err != c.err {
+				t.Fatalf("security of %+v: %v, want %v", c.conf, err, c.err)
+			}
+		case c.errText != "":
+			if err == nil || !strings.Contains(err.Error(), c.errText) {
+				t.Fatalf("security of %+v: %v, want an error containing %q", c.conf, err, c.errText)
+			}
+		default:
+			if err != nil {
+				t.Fatalf("security of %+v: %v", c.conf, err)
+			}
+		}
+		want := [3]string{"previous", "previous", "previous"}
+		if err == nil {
+			want = [3]string{c.conf.CAPath, c.conf.CertPath, c.conf.KeyPath}
+		}
+		if got := [3]string{security.ClusterSSLCA, security.ClusterSSLCert, security.ClusterSSLKey}; got != want {
+			t.Fatalf("global security after %+v: %q, want %q", c.conf, got, want)
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package tidis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultACLReloadInterval = 1000
+	//maxACLUsers users loaded at a time
+	maxACLUsers = 100000
+)
+
+var (
+	aclPrefix = []byte("acl:")
+)
+
+//ACLUser a user of the ACL, stored in a sys key so all the instances share it.
+//Commands are the rules +command, -command, +@category and -@category, applied in order.
+type ACLUser struct {
+	Name      string   `json:"name"`
+	Enabled   bool     `json:"enabled"`
+	NoPass    bool     `json:"nopass"`
+	Passwords []string `json:"passwords"`
+	Keys      []string `json:"keys"`
+	Commands  []string `json:"commands"`
+}
+
+//aclUsers the users of this instance, reloaded from TiKV in the background
+type aclUsers struct {
+	sync.RWMutex
+	users map[string]*ACLUser
+}
+
+func newACLUsers() *aclUsers {
+	return &aclUsers{users: make(map[string]*ACLUser)}
+}
+
+//NewACLUser a user as created by ACL SETUSER, off and without passwords, keys and commands
+func NewACLUser(name string) *ACLUser {
+	return &ACLUser{Name: name, Commands: []string{"-@all"}}
+}
+
+//HashPassword the sha256 of password in hex, the form passwords are stored and listed in
+func HashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+//Apply a rule of ACL SETUSER. The command rules are not checked against the commands, the caller does.
+func (user *ACLUser) Apply(rule string) error {
+	var (
+		lower = strings.ToLower(rule)
+	)
+	switch {
+	case lower == "on":
+		user.Enabled = true
+	case lower == "off":
+		user.Enabled = false
+	case lower == "nopass":
+		user.NoPass = true
+		user.Passwords = nil
+	case lower == "resetpass":
+		user.NoPass = false
+		user.Passwords = nil
+	case lower == "allkeys":
+		user.Keys = []string{"*"}
+	case lower == "resetkeys":
+		user.Keys = nil
+	case lower == "allcommands":
+		user.Commands = []string{"+@all"}
+	case lower == "nocommands":
+		user.Commands = []string{"-@all"}
+	case lower == "reset":
+		*user = *NewACLUser(user.Name)
+	case strings.HasPrefix(rule, ">"):
+		user.NoPass = false
+		user.addPassword(HashPassword(rule[1:]))
+	case strings.HasPrefix(rule, "#"):
+		if _, err := hex.DecodeString(rule[1:]); err != nil || len(rule) != 65 {
+			return qkverror.ErrorACLRule
+		}
+		user.NoPass = false
+		user.addPassword(strings.ToLower(rule[1:]))
+	case strings.HasPrefix(rule, "<"):
+		user.removePassword(HashPassword(rule[1:]))
+	case strings.HasPrefix(rule, "!"):
+		user.removePassword(strings.ToLower(rule[1:]))
+	case strings.HasPrefix(rule, "~"):
+		if len(rule) == 1 {
+			return qkverror.ErrorACLRule
+		}
+		user.Keys = append(user.Keys, rule[1:])
+	case len(rule) > 1 && (rule[0] == '+' || rule[0] == '-'):
+		user.Commands = append(user.Commands, lower)
+	default:
+		return qkverror.ErrorACLRule
+	}
+	return nil
+}
+func (user *ACLUser) addPassword(hash string) {
+	for _, p := range user.Passwords {
+		if p == hash {
+			return
+		}
+	}
+	user.Passwords = append(user.Passwords, hash)
+}
+func (user *ACLUser) removePassword(hash string) {
+	for i, p := range user.Passwords {
+		if p == hash {
+			user.Passwords = append(user.Passwords[:i], user.Passwords[i+1:]...)
+			return
+		}
+	}
+}
+
+//CheckPassword whether the user is on and password is one of its passwords
+func (user *ACLUser) CheckPassword(password string) bool {
+	var (
+		hash = HashPassword(password)
+	)
+	if !user.Enabled {
+		return false
+	}
+	if user.NoPass {
+		return true
+	}
+	for _, p := range user.Passwords {
+		if p == hash {
+			return true
+		}
+	}
+	return false
+}
+
+//CanRun whether the user may run cmd, a command of categories. The last matching rule wins.
+func (user *ACLUser) CanRun(cmd string, categories []string) (ok bool) {
+	cmd = strings.ToLower(cmd)
+	for _, rule := range user.Commands {
+		if rule[1] != '@' {
+			if rule[1:] == cmd {
+				ok = rule[0] == '+'
+			}
+			continue
+		}
+		if rule[2:] == "all" {
+			ok = rule[0] == '+'
+			continue
+		}
+		for _, category := range categories {
+			if rule[2:] == category {
+				ok = rule[0] == '+'
+			}
+		}
+	}
+	return
+}
+
+//CanAccess whether key matches one of the key patterns of the user
+func (user *ACLUser) CanAccess(key []byte) bool {
+	for _, pattern := range user.Keys {
+		if utils.MatchPattern([]byte(pattern), key) {
+			return true
+		}
+	}
+	return false
+}
+
+//ACLUser the user name of this instance, the users are reloaded from TiKV every acl_reload_interval ms
+func (tidis *Tidis) ACLUser(name string) (user *ACLUser, ok bool) {
+	tidis.aclUsers.RLock()
+	defer tidis.aclUsers.RUnlock()
+	user, ok = tidis.aclUsers.users[name]
+	return
+}
+
+//ACLAuthenticate the user name if password is one of its passwords. The user is read from TiKV,
+//it may have been set on another instance since the last reload.
+func (tidis *Tidis) ACLAuthenticate(name, password string) (ok bool, err error) {
+	var (
+		user *ACLUser
+	)
+	user, err = tidis.GetACLUser(nil, name)
+	if err != nil {
+		return
+	}
+	tidis.cacheACLUser(name, user)
+	return user != nil && user.CheckPassword(password), nil
+}
+
+//ACLLogin whether the user name exists and is on, for the clients authenticated otherwise than by a password.
+//The user is read from TiKV like in ACLAuthenticate.
+func (tidis *Tidis) ACLLogin(name string) (ok bool, err error) {
+	var (
+		user *ACLUser
+	)
+	user, err = tidis.GetACLUser(nil, name)
+	if err != nil {
+		return
+	}
+	tidis.cacheACLUser(name, user)
+	return user != nil && user.Enabled, nil
+}
+
+//GetACLUser the user name stored in TiKV, nil if there is none
+func (tidis *Tidis) GetACLUser(txn interface{}, name string) (user *ACLUser, err error) {
+	var (
+		raw []byte
+	)
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			user, err = tidis.GetACLUser(txn, name)
+			return
+		})
+		return
+	}
+	raw, err = tidis.db.Get(txn, aclKey(name))
+	if err != nil || raw == nil {
+		return
+	}
+	return decodeACLUser(raw)
+}
+
+//SetACLUser apply rules to the user name, created if it does not exist
+func (tidis *Tidis) SetACLUser(txn interface{}, name string, rules []string) (err error) {
+	var (
+		user *ACLUser
+		raw  []byte
+	)
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) error {
+			return tidis.SetACLUser(txn, name, rules)
+		})
+		if err == nil {
+			user, err = tidis.GetACLUser(nil, name)
+			if err == nil {
+				tidis.cacheACLUser(name, user)
+			}
+		}
+		return
+	}
+	user, err = tidis.GetACLUser(txn, name)
+	if err != nil {
+		return
+	}
+	if user == nil {
+		user = NewACLUser(name)
+	}
+	for _, rule := range rules {
+		if err = user.Apply(rule); err != nil {
+			return
+		}
+	}
+	raw, err = encodeACLUser(user)
+	if err != nil {
+		return
+	}
+	return tidis.db.Set(txn, aclKey(name), raw)
+}
+
+//DelACLUsers delete the users names, returns the number of users deleted
+func (tidis *Tidis) DelACLUsers(txn interface{}, names []string) (deleted int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		raw      []byte
+	)
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			deleted, err = tidis.DelACLUsers(txn, names)
+			return
+		})
+		if err == nil {
+			for _, name := range names {
+				tidis.cacheACLUser(name, nil)
+			}
+		}
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	deleted = 0
+	for _, name := range names {
+		raw, err = tidis.db.Get(txn, aclKey(name))
+		if err != nil {
+			return
+		}
+		if raw == nil {
+			continue
+		}
+		if err = tikv_txn.Delete(aclKey(name)); err != nil {
+			return
+		}
+		deleted++
+	}
+	return
+}
+
+//ACLUsers all the users stored in TiKV, by name
+func (tidis *Tidis) ACLUsers(txn interface{}) (users []*ACLUser, err error) {
+	var (
+		kvs   [][]byte
+		start = utils.EncodeSysKey(aclPrefix)
+		user  *ACLUser
+	)
+	kvs, err = tidis.db.GetRangeKeysValues(txn, start, utils.PrefixEnd(start), maxACLUsers, true)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(kvs)-1; i = i + 2 {
+		user, err = decodeACLUser(kvs[i+1])
+		if err != nil {
+			return
+		}
+		users = append(users, user)
+	}
+	return
+}
+
+//ACLRun reload the users of this instance from TiKV every interval ms, for the changes made on the other instances
+func ACLRun(tdb *Tidis, interval int) {
+	var (
+		c   <-chan time.Time
+		err error
+	)
+	if interval <= 0 {
+		interval = defaultACLReloadInterval
+	}
+	if err = tdb.reloadACLUsers(); err != nil {
+		log.Warnf("acl load users failed, %s", err.Error())
+	}
+	c = time.Tick(time.Duration(interval) * time.Millisecond)
+	for _ = range c {
+		if err = tdb.reloadACLUsers(); err != nil {
+			log.Warnf("acl reload users failed, %s", err.Error())
+		}
+	}
+}
+
+//reloadACLUsers replace the users of this instance by the users stored in TiKV
+func (tidis *Tidis) reloadACLUsers() (err error) {
+	var (
+		users []*ACLUser
+		cache map[string]*ACLUser
+	)
+	users, err = tidis.ACLUsers(nil)
+	if err != nil {
+		return
+	}
+	cache = make(map[string]*ACLUser, len(users))
+	for _, user := range users {
+		cache[user.Name] = user
+	}
+	tidis.aclUsers.Lock()
+	tidis.aclUsers.users = cache
+	tidis.aclUsers.Unlock()
+	return
+}
+
+//cacheACLUser update the user name of this instance, user is nil once it is deleted
+func (tidis *Tidis) cacheACLUser(name string, user *ACLUser) {
+	tidis.aclUsers.Lock()
+	defer tidis.aclUsers.Unlock()
+	if user == nil {
+		delete(tidis.aclUsers.users, name)
+		return
+	}
+	tidis.aclUsers.users[name] = user
+}
+
+//aclKey the sys key of the user name
+func aclKey(name string) []byte {
+	return utils.EncodeSysKey(append(append([]byte{}, aclPrefix...), name...))
+}
+
+//encodeACLUser type(sys)|json
+func encodeACLUser(user *ACLUser) (raw []byte, err error) {
+	var (
+		data []byte
+	)
+	data, err = json.Marshal(user)
+	if err != nil {
+		return
+	}
+	return utils.EncodeData(utils.SYS_TYPE, data), nil
+}
+func decodeACLUser(raw []byte) (user *ACLUser, err error) {
+	if len(raw) < 1 || raw[0] != utils.SYS_TYPE {
+		err = qkverror.ErrorInvalidRawData
+		return
+	}
+	user = new(ACLUser)
+	err = json.Unmarshal(raw[1:], user)
+	return
+}
@@ -0,0 +1,179 @@
+package tidis
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pingcap/tidb/kv"
+)
+
+//Waiter a client blocked on list keys, it is woken up when one of the keys may have items
+type Waiter struct {
+	keys   [][]byte
+	notify chan struct{}
+}
+
+//Notify receives a value when one of the keys is pushed to
+func (w *Waiter) Notify() <-chan struct{} {
+	return w.notify
+}
+func (w *Waiter) wake() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+//keyWaiters clients blocked on each key of this instance, in the order they blocked
+type keyWaiters struct {
+	sync.Mutex
+	queues map[string][]*Waiter
+}
+
+func newKeyWaiters() *keyWaiters {
+	return &keyWaiters{queues: make(map[string][]*Waiter)}
+}
+
+//BlockOn register a client blocked on keys, the clients blocked on a key are served in the order they blocked.
+func (tidis *Tidis) BlockOn(keys ...[]byte) (w *Waiter) {
+	w = &Waiter{keys: keys, notify: make(chan struct{}, 1)}
+	tidis.waiters.Lock()
+	defer tidis.waiters.Unlock()
+	for _, key := range keys {
+		tidis.waiters.queues[string(key)] = append(tidis.waiters.queues[string(key)], w)
+	}
+	return
+}
+
+//Unblock unregister the client, the next client blocked on its keys checks them again
+func (tidis *Tidis) Unblock(w *Waiter) {
+	var (
+		queue []*Waiter
+	)
+	tidis.waiters.Lock()
+	defer tidis.waiters.Unlock()
+	for _, key := range w.keys {
+		queue = tidis.waiters.queues[string(key)]
+		for i := range queue {
+			if queue[i] == w {
+				queue = append(queue[:i], queue[i+1:]...)
+				break
+			}
+		}
+		if len(queue) == 0 {
+			delete(tidis.waiters.queues, string(key))
+			continue
+		}
+		tidis.waiters.queues[string(key)] = queue
+		queue[0].wake()
+	}
+}
+
+//IsFirstWaiter whether w is the longest blocked client of key on this instance
+func (tidis *Tidis) IsFirstWaiter(w *Waiter, key []byte) bool {
+	var (
+		queue []*Waiter
+	)
+	tidis.waiters.Lock()
+	defer tidis.waiters.Unlock()
+	queue = tidis.waiters.queues[string(key)]
+	return len(queue) > 0 && queue[0] == w
+}
+
+//notifyKey wake up the longest blocked client of key
+func (tidis *Tidis) notifyKey(key []byte) {
+	var (
+		queue []*Waiter
+	)
+	tidis.waiters.Lock()
+	defer tidis.waiters.Unlock()
+	queue = tidis.waiters.queues[string(key)]
+	if len(queue) > 0 {
+		queue[0].wake()
+	}
+}
+
+//wakeKey wake up all the clients blocked on key, the readers of a stream do not consume the entries
+func (tidis *Tidis) wakeKey(key []byte) {
+	tidis.waiters.Lock()
+	defer tidis.waiters.Unlock()
+	for _, w := range tidis.waiters.queues[string(key)] {
+		w.wake()
+	}
+}
+
+//blockingTxn a transaction waking up the clients blocked on the keys it pushed to once it commits,
+//whether it is run by RetryTxn or by EXEC, so the woken clients see the pushed items.
+//It also holds the messages published in the transaction until the commit, and the usage it adds to the tenants.
+type blockingTxn struct {
+	kv.Transaction
+	tidis    *Tidis
+	notify   [][]byte
+	wake     [][]byte
+	messages []pubsubMessage
+	usage    map[string]*tenantUsage
+}
+
+//Set count the usage of key for its tenant and set it
+func (txn *blockingTxn) Set(k kv.Key, v []byte) (err error) {
+	if err = txn.countUsage(k, v); err != nil {
+		return
+	}
+	return txn.Transaction.Set(k, v)
+}
+
+//Delete count the usage of key for its tenant and delete it
+func (txn *blockingTxn) Delete(k kv.Key) (err error) {
+	if err = txn.countUsage(k, nil); err != nil {
+		return
+	}
+	return txn.Transaction.Delete(k)
+}
+
+//Commit update the usage of the tenants, write the published messages and commit the transaction, then wake up the
+//clients blocked on its keys. It fails with ErrorQuotaExceeded when the usage goes over a quota.
+func (txn *blockingTxn) Commit(ctx context.Context) (err error) {
+	var (
+		ts uint64
+	)
+	if len(txn.usage) > 0 {
+		if err = txn.tidis.updateUsage(txn.Transaction, txn.usage); err != nil {
+			txn.Transaction.Rollback()
+			return
+		}
+	}
+	if len(txn.messages) > 0 {
+		ts, err = txn.tidis.writePubSub(txn.Transaction, txn.messages)
+		if err != nil {
+			txn.Transaction.Rollback()
+			return
+		}
+		//the messages are in flight until the commit is over, the polls of the log wait for them
+		defer txn.tidis.pubsub.end(ts)
+	}
+	err = txn.Transaction.Commit(ctx)
+	if err != nil {
+		return
+	}
+	for _, key := range txn.notify {
+		txn.tidis.notifyKey(key)
+	}
+	for _, key := range txn.wake {
+		txn.tidis.wakeKey(key)
+	}
+	return
+}
+
+//notifyAfterCommit wake up the longest blocked client of key when txn commits
+func (tidis *Tidis) notifyAfterCommit(txn interface{}, key []byte) {
+	if btxn, ok := txn.(*blockingTxn); ok {
+		btxn.notify = append(btxn.notify, key)
+	}
+}
+
+//wakeAfterCommit wake up all the clients blocked on key when txn commits
+func (tidis *Tidis) wakeAfterCommit(txn interface{}, key []byte) {
+	if btxn, ok := txn.(*blockingTxn); ok {
+		btxn.wake = append(btxn.wake, key)
+	}
+}
@@ -0,0 +1,349 @@
+package tidis
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultDatabases = 16
+	//defaultDBMapReloadInterval ms between the reloads of the database maps
+	defaultDBMapReloadInterval = 1000
+	//maxDBMaps database maps loaded at a time, one per tenant
+	maxDBMaps = 100000
+	//maxDatabases the db index is stored in 2 bytes, the last one ends the range of the previous db
+	maxDatabases = 1<<16 - 1
+	//moveBatch keys moved in a transaction by the migrations
+	moveBatch = 1024
+)
+
+var (
+	//layoutChecked set once db 0 is known to have no key whose first byte is reserved
+	layoutChecked = []byte("layout_checked")
+	//dbMapPrefix the sys keys of the database maps of the tenants, followed by the name of the tenant
+	dbMapPrefix = []byte("db_map:")
+)
+
+//dbMaps the logical to physical database maps of the tenants of this instance by tenant name, "" for the databases
+//outside of the tenants. SELECT picks a logical database, its keys are in the namespace of its physical database.
+type dbMaps struct {
+	sync.RWMutex
+	maps map[string]map[int]int
+}
+
+func newDBMaps() *dbMaps {
+	return &dbMaps{maps: make(map[string]map[int]int)}
+}
+
+//Databases the number of databases, SELECT accepts 0 to Databases()-1
+func (tidis *Tidis) Databases() int {
+	return tidis.databases
+}
+
+//FlushDB deletes all the keys of the database namespace ns with the delete range of the store. It is not a transaction,
+//the keys written to the database during the flush may survive it. The gc keys and the expire index entries of the
+//deleted keys are left to the gc and the ttl checker, they find the keys gone.
+func (tidis *Tidis) FlushDB(ns []byte) (err error) {
+	var (
+		prefix []byte
+	)
+	for _, r := range dbRanges(ns) {
+		err = tidis.db.DeleteRange(r[0], r[1])
+		if err != nil {
+			return
+		}
+	}
+	//the keys are deleted outside of a transaction, the usage counters of the tenant are corrected by a measure
+	prefix, _ = utils.SplitTenant(ns)
+	for _, tenant := range tidis.tenants {
+		if bytes.Equal(tenant.prefix, prefix) {
+			return tidis.repairUsage(tenant)
+		}
+	}
+	return
+}
+
+//FlushAll deletes the keys of all the databases of tenant, or of all the databases outside of the tenants if tenant is nil.
+func (tidis *Tidis) FlushAll(tenant *Tenant) (err error) {
+	if tenant != nil {
+		err = tidis.db.DeleteRange(tenant.prefix, utils.PrefixEnd(tenant.prefix))
+		if err != nil {
+			return
+		}
+		return tidis.repairUsage(tenant)
+	}
+	err = tidis.FlushDB(nil)
+	if err != nil {
+		return
+	}
+	return tidis.db.DeleteRange([]byte{utils.DB_PREFIX}, []byte{utils.DB_PREFIX + 1})
+}
+
+//CheckLayout refuse to run over the keys written by a release before the databases and the tenants when db 0 has keys
+//starting with the reserved bytes 0xfc to 0xfe, they would be read as internal keys or as the keys of the other
+//databases and of the tenants. The check runs until it passes once.
+func (tidis *Tidis) CheckLayout() (err error) {
+	var (
+		raw []byte
+		kvs [][]byte
+	)
+	raw, err = tidis.db.Get(nil, utils.EncodeSysKey(layoutChecked))
+	if err != nil || raw != nil {
+		return
+	}
+	kvs, err = tidis.db.GetRangeKeysValues(nil, []byte{utils.SYSTEM_PREFIX}, nil, 1, true)
+	if err != nil {
+		return
+	}
+	if len(kvs) > 0 && utils.IsReservedKey(kvs[0]) {
+		log.Errorf("db 0 has the key %q starting with a reserved byte", kvs[0])
+		return qkverror.ErrorReservedKeysInUse
+	}
+	return tidis.RetryTxn(func(txn interface{}) error {
+		return txn.(kv.Transaction).Set(utils.EncodeSysKey(layoutChecked), []byte{1})
+	})
+}
+
+//SwapDB swaps the databases db1 and db2 of tenant, tenant is nil for the databases outside of the tenants. The keys
+//are not moved, the two entries of the logical to physical map of the databases of tenant are swapped in one
+//transaction, so it is atomic and O(1). The other instances use the new map from their next reload.
+func (tidis *Tidis) SwapDB(tenant *Tenant, db1, db2 int) (err error) {
+	var (
+		name  = dbMapName(tenant)
+		dbMap map[int]int
+	)
+	if db1 == db2 {
+		return
+	}
+	err = tidis.RetryTxn(func(txn interface{}) (err error) {
+		dbMap, err = tidis.getDBMap(txn, name)
+		if err != nil {
+			return
+		}
+		dbMap[db1], dbMap[db2] = physicalDB(dbMap, db2), physicalDB(dbMap, db1)
+		return txn.(kv.Transaction).Set(dbMapKey(name), encodeDBMap(dbMap))
+	})
+	if err != nil {
+		return
+	}
+	tidis.cacheDBMap(name, dbMap)
+	return
+}
+
+//DBMapKey the sys key of the database map of tenant, SWAPDB writes it so the transactions watching keys of tenant
+//can tell their databases were swapped
+func (tidis *Tidis) DBMapKey(tenant *Tenant) []byte {
+	return dbMapKey(dbMapName(tenant))
+}
+
+//DBMapRun reload the database maps of this instance from TiKV every interval ms, for the SWAPDB run on the other instances
+func DBMapRun(tdb *Tidis, interval int) {
+	var (
+		c   <-chan time.Time
+		err error
+	)
+	if interval <= 0 {
+		interval = defaultDBMapReloadInterval
+	}
+	c = time.Tick(time.Duration(interval) * time.Millisecond)
+	for _ = range c {
+		if err = tdb.ReloadDBMaps(); err != nil {
+			log.Warnf("reload database maps failed, %s", err.Error())
+		}
+	}
+}
+
+//ReloadDBMaps replace the database maps of this instance by the maps stored in TiKV, the server loads them before
+//accepting clients
+func (tidis *Tidis) ReloadDBMaps() (err error) {
+	var (
+		kvs   [][]byte
+		start = utils.EncodeSysKey(dbMapPrefix)
+		maps  map[string]map[int]int
+	)
+	kvs, err = tidis.db.GetRangeKeysValues(nil, start, utils.PrefixEnd(start), maxDBMaps, true)
+	if err != nil {
+		return
+	}
+	maps = make(map[string]map[int]int, len(kvs)/2)
+	for i := 0; i < len(kvs)-1; i = i + 2 {
+		maps[string(kvs[i][len(start):])], err = decodeDBMap(kvs[i+1])
+		if err != nil {
+			return
+		}
+	}
+	tidis.dbMaps.Lock()
+	tidis.dbMaps.maps = maps
+	tidis.dbMaps.Unlock()
+	return
+}
+
+//physical the physical database of the database db of tenant
+func (tidis *Tidis) physical(tenant *Tenant, db int) int {
+	tidis.dbMaps.RLock()
+	defer tidis.dbMaps.RUnlock()
+	return physicalDB(tidis.dbMaps.maps[dbMapName(tenant)], db)
+}
+
+//logical the database stored in the physical database db of the tenant of prefix, empty for no tenant
+func (tidis *Tidis) logical(prefix []byte, db int) int {
+	var (
+		name string
+	)
+	if len(prefix) > 2 {
+		name = string(prefix[2:])
+	}
+	tidis.dbMaps.RLock()
+	defer tidis.dbMaps.RUnlock()
+	for logical, physical := range tidis.dbMaps.maps[name] {
+		if physical == db {
+			return logical
+		}
+	}
+	return db
+}
+
+//getDBMap the database map of the tenant named name stored in TiKV, empty if no database was swapped
+func (tidis *Tidis) getDBMap(txn interface{}, name string) (dbMap map[int]int, err error) {
+	var (
+		raw []byte
+	)
+	raw, err = tidis.db.Get(txn, dbMapKey(name))
+	if err != nil {
+		return
+	}
+	if raw == nil {
+		return make(map[int]int), nil
+	}
+	return decodeDBMap(raw)
+}
+
+//cacheDBMap update the database map of the tenant named name of this instance
+func (tidis *Tidis) cacheDBMap(name string, dbMap map[int]int) {
+	tidis.dbMaps.Lock()
+	defer tidis.dbMaps.Unlock()
+	tidis.dbMaps.maps[name] = dbMap
+}
+
+//physicalDB the physical database of db in dbMap, a database missing from it is stored in itself
+func physicalDB(dbMap map[int]int, db int) int {
+	if physical, ok := dbMap[db]; ok {
+		return physical
+	}
+	return db
+}
+
+//dbMapName the name of tenant in the sys keys of the database maps, empty for no tenant
+func dbMapName(tenant *Tenant) string {
+	if tenant == nil {
+		return ""
+	}
+	return tenant.Name
+}
+
+//dbMapKey the sys key of the database map of the tenant named name
+func dbMapKey(name string) []byte {
+	return utils.EncodeSysKey(append(append([]byte{}, dbMapPrefix...), name...))
+}
+
+//encodeDBMap type(sys)|(logical(2)|physical(2))..., the databases stored in themselves are left out
+func encodeDBMap(dbMap map[int]int) []byte {
+	buf := []byte{utils.SYS_TYPE}
+	for logical, physical := range dbMap {
+		if logical == physical {
+			continue
+		}
+		entry := make([]byte, 4)
+		utils.Uint16ToBytesExt(entry, uint16(logical))
+		utils.Uint16ToBytesExt(entry[2:], uint16(physical))
+		buf = append(buf, entry...)
+	}
+	return buf
+}
+func decodeDBMap(raw []byte) (dbMap map[int]int, err error) {
+	if len(raw) < 1 || raw[0] != utils.SYS_TYPE || (len(raw)-1)%4 != 0 {
+		err = qkverror.ErrorInvalidRawData
+		return
+	}
+	dbMap = make(map[int]int, (len(raw)-1)/4)
+	for i := 1; i < len(raw); i = i + 4 {
+		dbMap[int(uint16(raw[i])<<8|uint16(raw[i+1]))] = int(uint16(raw[i+2])<<8 | uint16(raw[i+3]))
+	}
+	return
+}
+
+//dbRanges the key ranges [start, end) of the database namespace ns. The keys of db 0 are stored bare, so its ranges
+//are all the keys but the internal ones and the ones of the tenants and the other databases.
+func dbRanges(ns []byte) (ranges [][2][]byte) {
+	if len(ns) == 0 {
+		return [][2][]byte{
+			{[]byte{}, []byte{utils.SYSTEM_PREFIX}},
+			{[]byte{utils.DB_PREFIX + 1}, []byte{utils.NONE_TYPE, utils.NONE_TYPE}},
+		}
+	}
+	return [][2][]byte{{ns, utils.PrefixEnd(ns)}}
+}
+
+//moveRange rewrite the keys in [start, end) in transactions of moveBatch keys, move returns the new key of a key
+//or nil to keep it
+func (tidis *Tidis) moveRange(start, end []byte, move func(txn kv.Transaction, key, value []byte) ([]byte, error)) (err error) {
+	var (
+		done bool
+		next []byte
+	)
+	for !done {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			var (
+				tikv_txn kv.Transaction
+				ok       bool
+				it       kv.Iterator
+				moved    []byte
+				examined int
+			)
+			tikv_txn, ok = txn.(kv.Transaction)
+			if !ok {
+				return qkverror.ErrorServerInternal
+			}
+			it, err = tikv_txn.GetSnapshot().Seek(start)
+			if err != nil {
+				return
+			}
+			defer it.Close()
+			done, next = true, nil
+			for examined = 0; it.Valid() && bytes.Compare(it.Key(), end) < 0; examined++ {
+				if examined == moveBatch {
+					done = false
+					break
+				}
+				next = it.Key().Next()
+				moved, err = move(tikv_txn, it.Key(), it.Value())
+				if err != nil {
+					return
+				}
+				if moved != nil {
+					if err = tikv_txn.Delete(it.Key()); err != nil {
+						return
+					}
+					if err = tikv_txn.Set(moved, it.Value()); err != nil {
+						return
+					}
+				}
+				if err = it.Next(); err != nil {
+					return
+				}
+			}
+			return
+		})
+		if err != nil {
+			return
+		}
+		start = next
+	}
+	return
+}
@@ -0,0 +1,127 @@
+package tidis
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+)
+
+func TestFlushDB(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	keys := [][]byte{[]byte("a"), utils.EncodeDBKey(1, []byte("b")), utils.EncodeDBKey(2, []byte("c"))}
+	for _, key := range keys {
+		if err := tdb.Set(nil, key, []byte("v")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if err := tdb.FlushDB(utils.EncodeDBKey(1, nil)); err != nil {
+		t.Fatalf("flushdb: %v", err)
+	}
+	for i, key := range keys {
+		if v := mustGet(t, tdb, key); (v == nil) != (i == 1) {
+			t.Fatalf("%q after flushdb: %q", key, v)
+		}
+	}
+	if err := tdb.FlushAll(nil); err != nil {
+		t.Fatalf("flushall: %v", err)
+	}
+	for _, key := range keys {
+		if v := mustGet(t, tdb, key); v != nil {
+			t.Fatalf("%q after flushall: %q", key, v)
+		}
+	}
+}
+
+func TestSwapDB(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	for i := 0; i < 100; i++ {
+		if err := tdb.Set(nil, tdb.Key(nil, 0, []byte(fmt.Sprintf("k%d", i))), []byte("v1")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	if err := tdb.Set(nil, tdb.Key(nil, 3, []byte("k0")), []byte("v2")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if _, err := tdb.PExpire(nil, tdb.Key(nil, 0, []byte("k1")), 3600*1000); err != nil {
+		t.Fatalf("pexpire: %v", err)
+	}
+	if err := tdb.SwapDB(nil, 0, 3); err != nil {
+		t.Fatalf("swapdb: %v", err)
+	}
+	//the keys stay where they are
+	if v := mustGet(t, tdb, []byte("k1")); v == nil {
+		t.Fatalf("k1 moved by swapdb")
+	}
+	check := func(tdb *Tidis) {
+		if v, _ := tdb.Get(nil, tdb.Key(nil, 0, []byte("k0"))); string(v) != "v2" {
+			t.Fatalf("db 0 k0 after swapdb: %q", v)
+		}
+		if v, _ := tdb.Get(nil, tdb.Key(nil, 0, []byte("k1"))); v != nil {
+			t.Fatalf("db 0 k1 after swapdb: %q", v)
+		}
+		for i := 0; i < 100; i++ {
+			if v, _ := tdb.Get(nil, tdb.Key(nil, 3, []byte(fmt.Sprintf("k%d", i)))); string(v) != "v1" {
+				t.Fatalf("db 3 k%d after swapdb: %q", i, v)
+			}
+		}
+		if ttl, err := tdb.PTTL(nil, tdb.Key(nil, 3, []byte("k1"))); err != nil || ttl <= 0 {
+			t.Fatalf("pttl after swapdb: %d, %v", ttl, err)
+		}
+	}
+	check(tdb)
+	//another instance finds the map in TiKV
+	tdb.dbMaps = newDBMaps()
+	if err := tdb.ReloadDBMaps(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	check(tdb)
+	if db := tdb.logical(nil, 0); db != 3 {
+		t.Fatalf("database stored in db 0: %d", db)
+	}
+
+	//swapping back leaves the databases in themselves, the other tenants are not swapped
+	tenant := &Tenant{Name: "t", prefix: utils.EncodeTenantPrefix([]byte("t"))}
+	if err := tdb.SwapDB(nil, 3, 0); err != nil {
+		t.Fatalf("swapdb: %v", err)
+	}
+	if err := tdb.SwapDB(tenant, 1, 2); err != nil {
+		t.Fatalf("swapdb: %v", err)
+	}
+	if v, _ := tdb.Get(nil, tdb.Key(nil, 0, []byte("k1"))); string(v) != "v1" {
+		t.Fatalf("db 0 k1 after swapping back: %q", v)
+	}
+	if ns := tdb.Namespace(nil, 1); string(ns) != string(utils.EncodeDBKey(1, nil)) {
+		t.Fatalf("namespace of db 1: %q", ns)
+	}
+	if ns := tdb.Namespace(tenant, 1); string(ns) != string(utils.EncodeTenantKey([]byte("t"), 2, nil)) {
+		t.Fatalf("namespace of db 1 of the tenant: %q", ns)
+	}
+}
+
+func TestCheckLayout(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	if err := tdb.CheckLayout(); err != nil {
+		t.Fatalf("check an empty store: %v", err)
+	}
+	//the keys of the other databases are written once checked
+	if err := tdb.Set(nil, utils.EncodeDBKey(1, []byte("k")), []byte("v")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err := tdb.CheckLayout(); err != nil {
+		t.Fatalf("check again: %v", err)
+	}
+
+	//a key of db 0 written by a previous release
+	tdb = newTestTidis(t, nil)
+	err := tdb.RetryTxn(func(txn interface{}) error {
+		return txn.(kv.Transaction).Set([]byte{utils.DB_PREFIX, 'k'}, utils.EncodeData(utils.STRING_TYPE, []byte("v")))
+	})
+	if err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err = tdb.CheckLayout(); err == nil {
+		t.Fatalf("reserved key not detected")
+	}
+}
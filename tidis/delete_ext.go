@@ -30,20 +30,8 @@ func (tidis *Tidis) DeleteWithTxn(txn interface{}, keys [][]byte) (deleted int64
 			if err != nil {
 				return
 			}
-			switch dataType {
-			//delete set member
-			case utils.SET_TYPE:
-				_, err = tidis.ClearSetMembers(txn, k)
-			//delete zset member
-			case utils.ZSET_TYPE:
-				_, err = tidis.ZRemRangeByScore(txn, k, utils.SCORE_MIN, utils.SCORE_MAX)
-			//delete hash field
-			case utils.HASH_TYPE:
-				_, err = tidis.ClearHash(txn, k)
-			//delete list member
-			case utils.LIST_TYPE:
-				_, err = tidis.ClearListMembers(txn, k)
-			}
+			//members of a collection are purged by the background gc
+			err = tidis.clearMembers(tikv_txn, k, dataType)
 			if err != nil {
 				return
 			}
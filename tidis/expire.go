@@ -1,7 +1,6 @@
 package tidis
 
 import (
-	"context"
 	"time"
 
 	"github.com/chuangyou/qkv/qkverror"
@@ -11,25 +10,14 @@ import (
 
 func (tidis *Tidis) DeleteIfExpired(txn interface{}, key []byte, delValue bool) (err error) {
 	var (
-		ttl            int64
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		keys           [][]byte
+		ttl     int64
+		keys    [][]byte
+		deleted int64
 	)
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
+		return tidis.RetryTxn(func(txn interface{}) error {
+			return tidis.DeleteIfExpired(txn, key, delValue)
+		})
 	}
 	ttl, err = tidis.TTL(txn, key)
 	if err != nil {
@@ -47,14 +35,11 @@ func (tidis *Tidis) DeleteIfExpired(txn interface{}, key []byte, delValue bool)
 	//delete data
 	if delValue {
 		keys = append(keys, key)
-		_, err = tidis.DeleteWithTxn(txn, keys)
-
-	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
+		deleted, err = tidis.DeleteWithTxn(txn, keys)
+		if err != nil || deleted == 0 {
 			return
 		}
+		err = tidis.notify(txn, notifyExpired, "expired", key)
 	}
 	return
 }
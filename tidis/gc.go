@@ -0,0 +1,254 @@
+package tidis
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/chuangyou/qkv/metrics"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultGCBatch    = 256
+	defaultGCInterval = 1000
+)
+
+var (
+	gcLease = []byte("gc")
+)
+
+//GCRun purge the member keys of the deleted collections in the background, on the instance holding the gc lease
+func GCRun(tdb *Tidis, batch, interval, lease int) {
+	var (
+		c         <-chan time.Time
+		err       error
+		purged    int
+		timeout   int64
+		leader    bool
+		startTime time.Time
+	)
+	if batch <= 0 {
+		batch = defaultGCBatch
+	}
+	if interval <= 0 {
+		interval = defaultGCInterval
+	}
+	timeout = leaseTimeout(lease, interval)
+	c = time.Tick(time.Duration(interval) * time.Millisecond)
+	for _ = range c {
+		leader, err = tdb.AcquireLease(gcLease, timeout)
+		if err != nil {
+			log.Warnf("gc acquire lease failed, %s", err.Error())
+		}
+		if !leader {
+			metrics.WorkerLeaderGauge.WithLabelValues("gc").Set(0)
+			continue
+		}
+		metrics.WorkerLeaderGauge.WithLabelValues("gc").Set(1)
+		startTime = time.Now()
+		//leave half of the lease to renew it
+		for time.Since(startTime) < time.Duration(timeout/2)*time.Millisecond {
+			purged, err = tdb.gcPurge(batch)
+			if err != nil {
+				log.Warnf("gc purge failed, %s", err.Error())
+				break
+			}
+			if purged == 0 {
+				break
+			}
+			log.Debugf("gc purge %d keys", purged)
+			metrics.GCPurgedCounter.Add(float64(purged))
+		}
+	}
+}
+
+//gcPurge delete at most batch member keys of the deleted collections in one transaction
+func (tidis *Tidis) gcPurge(batch int) (purged int, err error) {
+	var (
+		tikv_txn kv.Transaction
+		snapshot kv.Snapshot
+		it       kv.Iterator
+		gcKeys   [][]byte
+		gcKey    []byte
+		prefix   = utils.EncodeSystemPrefix(utils.GC_TYPE)
+		dataType byte
+		version  uint64
+		key      []byte
+		deleted  int
+		done     bool
+	)
+	tikv_txn, err = tidis.NewTxn()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tikv_txn.Rollback()
+		}
+	}()
+	snapshot = tikv_txn.GetSnapshot()
+	it, err = snapshot.Seek(prefix)
+	if err != nil {
+		return
+	}
+	for it.Valid() && len(gcKeys) < batch {
+		if !it.Key().HasPrefix(prefix) {
+			break
+		}
+		gcKeys = append(gcKeys, []byte(it.Key()))
+		if err = it.Next(); err != nil {
+			it.Close()
+			return
+		}
+	}
+	it.Close()
+	for _, gcKey = range gcKeys {
+		if purged >= batch {
+			break
+		}
+		dataType, version, key, err = utils.DecodeGCKey(gcKey)
+		if err != nil {
+			return
+		}
+		done = true
+		for _, prefix := range gcMemberPrefixes(dataType, key, version) {
+			deleted, err = tidis.deletePrefix(tikv_txn, prefix, batch-purged)
+			if err != nil {
+				return
+			}
+			purged += deleted
+			if purged >= batch {
+				done = false
+				break
+			}
+		}
+		if done {
+			if err = tikv_txn.Delete(gcKey); err != nil {
+				return
+			}
+		}
+	}
+	if len(gcKeys) == 0 {
+		tikv_txn.Rollback()
+		return
+	}
+	err = tikv_txn.Commit(context.Background())
+	return
+}
+
+//newVersion version of a collection created in txn, the start ts of the transaction
+func (tidis *Tidis) newVersion(txn interface{}) uint64 {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+	)
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		return 0
+	}
+	return tikv_txn.StartTS()
+}
+
+//clearMembers drop the members of the collection at key, the caller deletes the meta key.
+//Members are handed over to the background gc unless the collection is created in the same transaction.
+func (tidis *Tidis) clearMembers(txn kv.Transaction, key []byte, dataType byte) (err error) {
+	var (
+		size    uint64
+		version uint64
+		prefix  []byte
+	)
+	switch dataType {
+	case utils.SET_TYPE:
+		_, size, _, _, version, _, err = tidis.getSetMetaWithRank(txn, key)
+	case utils.ZSET_TYPE:
+		_, size, _, _, version, _, err = tidis.getZSetMetaWithEncoding(txn, key)
+	case utils.HASH_TYPE:
+		size, _, _, version, err = tidis.getHashMetaWithType(txn, key)
+	case utils.LIST_TYPE:
+		_, _, size, _, _, version, err = tidis.getListMetaWithType(txn, key)
+	case utils.BITMAP_TYPE:
+		_, size, _, _, version, err = tidis.getHashMeta(txn, key)
+	case utils.STREAM_TYPE:
+		_, size, version, _, err = tidis.getStreamMeta(txn, key)
+		//an empty stream may still have consumer groups
+		size++
+	default:
+		return
+	}
+	if err != nil || size == 0 {
+		return
+	}
+	if version != txn.StartTS() {
+		err = txn.Set(utils.EncodeGCKey(dataType, version, key), []byte{utils.GC_TYPE})
+		return
+	}
+	//members written in this transaction are still in the membuffer
+	for _, prefix = range gcMemberPrefixes(dataType, key, version) {
+		_, err = tidis.deletePrefix(txn, prefix, 0)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+//deletePrefix delete at most limit keys with the prefix, 0 means no limit
+func (tidis *Tidis) deletePrefix(txn kv.Transaction, prefix []byte, limit int) (deleted int, err error) {
+	var (
+		it kv.Iterator
+	)
+	it, err = txn.Seek(prefix)
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for it.Valid() && (limit == 0 || deleted < limit) {
+		if !bytes.HasPrefix(it.Key(), prefix) {
+			break
+		}
+		if err = txn.Delete(it.Key()); err != nil {
+			return
+		}
+		deleted++
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//gcMemberPrefixes prefixes of the member keys of a collection
+func gcMemberPrefixes(dataType byte, key []byte, version uint64) [][]byte {
+	switch dataType {
+	case utils.SET_TYPE:
+		return [][]byte{
+			utils.EncodeMemberPrefix(utils.SET_DATA, key, version),
+			utils.EncodeMemberPrefix(utils.MEMBER_RANK, key, version),
+		}
+	case utils.ZSET_TYPE:
+		return [][]byte{
+			utils.EncodeMemberPrefix(utils.ZSET_DATA, key, version),
+			utils.EncodeMemberPrefix(utils.ZSET_SCORE, key, version),
+			utils.EncodeMemberPrefix(utils.MEMBER_RANK, key, version),
+		}
+	case utils.HASH_TYPE:
+		return [][]byte{
+			utils.EncodeMemberPrefix(utils.HASH_DATA, key, version),
+			utils.EncodeMemberPrefix(utils.HASH_FIELD_TTL, key, version),
+		}
+	case utils.LIST_TYPE:
+		return [][]byte{utils.EncodeMemberPrefix(utils.LIST_DATA, key, version)}
+	case utils.BITMAP_TYPE:
+		return [][]byte{utils.EncodeMemberPrefix(utils.BITMAP_DATA, key, version)}
+	case utils.STREAM_TYPE:
+		return [][]byte{
+			utils.EncodeMemberPrefix(utils.STREAM_DATA, key, version),
+			utils.EncodeMemberPrefix(utils.STREAM_GROUP, key, version),
+			utils.EncodeMemberPrefix(utils.STREAM_PEL, key, version),
+		}
+	}
+	return nil
+}
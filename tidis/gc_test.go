@@ -0,0 +1,51 @@
+package tidis
+
+import (
+	"testing"
+
+	"github.com/chuangyou/qkv/utils"
+)
+
+func TestGCPurge(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	//user keys of db 0 sharing the first byte of the gc type
+	for _, key := range []string{"o", "orders:12345", "orders:1234567890"} {
+		if err := tdb.Set(nil, []byte(key), []byte("v")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := tdb.HSet(nil, []byte("h"), []byte{byte('a' + i)}, []byte("v")); err != nil {
+			t.Fatalf("hset: %v", err)
+		}
+	}
+	if n := countPrefix(t, tdb, []byte{utils.HASH_DATA}); n != 10 {
+		t.Fatalf("hash members: %d", n)
+	}
+	if _, err := tdb.Delete(nil, [][]byte{[]byte("h")}); err != nil {
+		t.Fatalf("del: %v", err)
+	}
+	if n := countPrefix(t, tdb, utils.EncodeSystemPrefix(utils.GC_TYPE)); n != 1 {
+		t.Fatalf("gc keys after del: %d", n)
+	}
+	for {
+		purged, err := tdb.gcPurge(4)
+		if err != nil {
+			t.Fatalf("gc purge: %v", err)
+		}
+		if purged == 0 {
+			break
+		}
+	}
+	if n := countPrefix(t, tdb, []byte{utils.HASH_DATA}); n != 0 {
+		t.Fatalf("hash members after gc: %d", n)
+	}
+	if n := countPrefix(t, tdb, utils.EncodeSystemPrefix(utils.GC_TYPE)); n != 0 {
+		t.Fatalf("gc keys after gc: %d", n)
+	}
+	for _, key := range []string{"o", "orders:12345", "orders:1234567890"} {
+		if v, err := tdb.Get(nil, []byte(key)); err != nil || string(v) != "v" {
+			t.Fatalf("get %s after gc: %q, %v", key, v, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package tidis
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+)
+
+const (
+	defaultLeaseTimeout = 5000
+	//physicalShiftBits the low bits of a timestamp are the logical part
+	physicalShiftBits = 18
+)
+
+//AcquireLease take or renew the lease name for this instance, the lease expires after timeout ms.
+//ok is false while another instance holds an unexpired lease.
+func (tidis *Tidis) AcquireLease(name []byte, timeout int64) (ok bool, err error) {
+	err = tidis.RetryTxn(func(txn interface{}) (err error) {
+		ok, err = tidis.acquireLease(txn, name, timeout)
+		return
+	})
+	if err != nil {
+		ok = false
+	}
+	return
+}
+func (tidis *Tidis) acquireLease(txn interface{}, name []byte, timeout int64) (ok bool, err error) {
+	var (
+		tikv_txn kv.Transaction
+		now      int64
+		raw      []byte
+		owner    []byte
+		expireAt int64
+	)
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	ok = false
+	//the clock of the timestamp oracle, shared by all the instances
+	now = int64(tikv_txn.StartTS() >> physicalShiftBits)
+	raw, err = tidis.db.Get(txn, utils.EncodeSysKey(name))
+	if err != nil {
+		return
+	}
+	if raw != nil {
+		owner, expireAt, err = decodeLease(raw)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(owner, tidis.instanceID) && expireAt > now {
+			return
+		}
+	}
+	err = tikv_txn.Set(utils.EncodeSysKey(name), encodeLease(tidis.instanceID, now+timeout))
+	if err != nil {
+		return
+	}
+	ok = true
+	return
+}
+
+//encodeLease type(sys)|expire at(8)|owner
+func encodeLease(owner []byte, expireAt int64) []byte {
+	buf := make([]byte, len(owner)+9)
+	buf[0] = utils.SYS_TYPE
+	utils.Uint64ToBytesExt(buf[1:], uint64(expireAt))
+	copy(buf[9:], owner)
+	return buf
+}
+func decodeLease(raw []byte) (owner []byte, expireAt int64, err error) {
+	var (
+		ts uint64
+	)
+	if len(raw) < 9 || raw[0] != utils.SYS_TYPE {
+		err = qkverror.ErrorInvalidRawData
+		return
+	}
+	ts, err = utils.BytesToUint64(raw[1:])
+	if err != nil {
+		return
+	}
+	expireAt = int64(ts)
+	owner = raw[9:]
+	return
+}
+
+//leaseTimeout lease of a worker running every interval ms, at least three intervals
+func leaseTimeout(timeout, interval int) int64 {
+	if timeout <= 0 {
+		timeout = defaultLeaseTimeout
+	}
+	if timeout < 3*interval {
+		timeout = 3 * interval
+	}
+	return int64(timeout)
+}
+
+//newInstanceID identify this instance as the owner of the leases
+func newInstanceID(address string) []byte {
+	var (
+		host string
+		err  error
+	)
+	host, err = os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return []byte(fmt.Sprintf("%s/%s/%d", host, address, os.Getpid()))
+}
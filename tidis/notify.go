@@ -0,0 +1,103 @@
+package tidis
+
+import (
+	"strconv"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+)
+
+//keyspace event classes, the flags of notify_keyspace_events
+const (
+	notifyKeyspace = 1 << iota //K
+	notifyKeyevent             //E
+	notifyGeneric              //g
+	notifyString               //$
+	notifyList                 //l
+	notifySet                  //s
+	notifyHash                 //h
+	notifyZSet                 //z
+	notifyExpired              //x
+	notifyEvicted              //e
+	notifyStream               //t
+	notifyAll      = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet | notifyExpired | notifyEvicted | notifyStream
+)
+
+//parseNotifyFlags parse the notify_keyspace_events config, "" disables the notifications.
+//Like redis, nothing is published unless K or E is set together with at least one class.
+func parseNotifyFlags(config string) (flags int, err error) {
+	for _, c := range config {
+		switch c {
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 's':
+			flags |= notifySet
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZSet
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 't':
+			flags |= notifyStream
+		case 'A':
+			flags |= notifyAll
+		default:
+			err = qkverror.ErrorNotifyKeyspaceEvents
+			return
+		}
+	}
+	return
+}
+
+//notify publish the keyspace event of key in txn, so it is only delivered if the change commits.
+//The channels carry the database of key and are prefixed by its tenant, the key is published without its namespace.
+//Every write notifies the keys it changes, so the meta of key is also locked in txn: its commit conflicts with the
+//transactions watching key even when the write leaves the meta unchanged, like an HSET of an existing field.
+func (tidis *Tidis) notify(txn interface{}, class int, event string, key []byte) (err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		db       int
+		ns       []byte
+		prefix   []byte
+	)
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	err = tikv_txn.LockKeys(key)
+	if err != nil {
+		return
+	}
+	if tidis.notifyFlags&class == 0 {
+		return
+	}
+	ns, _ = utils.SplitDBKey(key)
+	prefix, _ = utils.SplitTenant(ns)
+	db, key = utils.DecodeDBKey(key)
+	//the channels name the database selected by the clients, not the one the keys are stored in
+	db = tidis.logical(prefix, db)
+	if tidis.notifyFlags&notifyKeyspace != 0 {
+		err = tidis.Publish(txn, append(append(append([]byte{}, prefix...), "__keyspace@"+strconv.Itoa(db)+"__:"...), key...), []byte(event))
+		if err != nil {
+			return
+		}
+	}
+	if tidis.notifyFlags&notifyKeyevent != 0 {
+		err = tidis.Publish(txn, append(append([]byte{}, prefix...), "__keyevent@"+strconv.Itoa(db)+"__:"+event...), key)
+	}
+	return
+}
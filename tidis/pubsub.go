@@ -0,0 +1,441 @@
+package tidis
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chuangyou/qkv/metrics"
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPubSubPollInterval = 50
+	defaultPubSubRetention    = 10000
+	//pubsubHeartbeat ms between the watermarks written by an instance, the polls read the log from the oldest one
+	pubsubHeartbeat = 1000
+	//pubsubBatch messages purged in one transaction
+	pubsubBatch = 1000
+)
+
+var (
+	pubsubLease = []byte("pubsub")
+	//pubsubWatermarkPrefix the watermark of each instance, its messages with an older timestamp are all committed
+	pubsubWatermarkPrefix = []byte("pubsub_watermark:")
+)
+
+//pubsubMessage a message published in a transaction, written to the log when it commits
+type pubsubMessage struct {
+	channel   []byte
+	message   []byte
+	delivered bool
+}
+
+//pubsubState the messages of the pub/sub log already delivered on this instance, and the timestamps of the messages
+//it is committing
+type pubsubState struct {
+	sync.Mutex
+	seq      uint64
+	ids      map[string]uint64
+	sealed   uint64
+	inflight map[uint64]int
+}
+
+func newPubSubState() *pubsubState {
+	return &pubsubState{ids: make(map[string]uint64), inflight: make(map[uint64]int)}
+}
+
+//mark returns false if the message id of timestamp ts was already delivered
+func (s *pubsubState) mark(id []byte, ts uint64) bool {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.ids[string(id)]; ok {
+		return false
+	}
+	s.ids[string(id)] = ts
+	return true
+}
+
+//prune forget the messages older than ts, the polls do not read them again
+func (s *pubsubState) prune(ts uint64) {
+	s.Lock()
+	defer s.Unlock()
+	for id, t := range s.ids {
+		if t < ts {
+			delete(s.ids, id)
+		}
+	}
+}
+
+//begin take the timestamp of messages about to be committed, it is in flight until end. A timestamp older than the
+//last watermark is taken again, the watermark promises the messages below it are committed.
+func (s *pubsubState) begin(tso func() (uint64, error)) (ts uint64, err error) {
+	for {
+		ts, err = tso()
+		if err != nil {
+			return
+		}
+		s.Lock()
+		if ts >= s.sealed {
+			s.inflight[ts]++
+			s.Unlock()
+			return
+		}
+		s.Unlock()
+	}
+}
+func (s *pubsubState) end(ts uint64) {
+	s.Lock()
+	defer s.Unlock()
+	s.inflight[ts]--
+	if s.inflight[ts] <= 0 {
+		delete(s.inflight, ts)
+	}
+}
+
+//seal the watermark at the timestamp now: the oldest message in flight, or now if there is none
+func (s *pubsubState) seal(now uint64) (watermark uint64) {
+	s.Lock()
+	defer s.Unlock()
+	s.sealed = now
+	watermark = now
+	for ts := range s.inflight {
+		if ts < watermark {
+			watermark = ts
+		}
+	}
+	return
+}
+
+//Publish append message to the pub/sub log read by all the instances when txn commits.
+//Without txn the message counts as delivered on this instance, the caller delivers it to the local subscribers.
+func (tidis *Tidis) Publish(txn interface{}, channel, message []byte) (err error) {
+	if txn == nil {
+		return tidis.RetryTxn(func(txn interface{}) error {
+			return tidis.publish(txn, channel, message, true)
+		})
+	}
+	return tidis.publish(txn, channel, message, false)
+}
+func (tidis *Tidis) publish(txn interface{}, channel, message []byte, delivered bool) (err error) {
+	var (
+		btxn *blockingTxn
+		ok   bool
+	)
+	btxn, ok = txn.(*blockingTxn)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	btxn.messages = append(btxn.messages, pubsubMessage{channel: channel, message: message, delivered: delivered})
+	return
+}
+
+//writePubSub write the messages of txn to the log right before it commits, the caller ends their timestamp once the
+//commit is over. Their keys take a timestamp of the oracle instead of the start of txn, a transaction open for long
+//(MULTI, WATCH) would otherwise publish into the past.
+func (tidis *Tidis) writePubSub(txn kv.Transaction, messages []pubsubMessage) (ts uint64, err error) {
+	var (
+		id []byte
+	)
+	ts, err = tidis.pubsub.begin(tidis.db.CurrentVersion)
+	if err != nil {
+		return
+	}
+	for _, m := range messages {
+		id = utils.EncodePubSubKey(ts, atomic.AddUint64(&tidis.pubsub.seq, 1))
+		err = txn.Set(id, utils.EncodePubSubValue(m.channel, m.message))
+		if err != nil {
+			tidis.pubsub.end(ts)
+			return
+		}
+		if m.delivered {
+			//marked before commit so the poller never delivers it twice
+			tidis.pubsub.mark(id, ts)
+		}
+	}
+	return
+}
+
+//PubSubRun deliver the messages published through the other instances, polling the pub/sub log every interval ms
+//while there are subscribers. Every instance writes its watermark every second, a poll reads the log from the oldest
+//watermark it read before, so a message committing slowly is read once its commit is over. The watermark of an
+//instance stopped for lease ms is ignored. The instance holding the pubsub lease removes the messages older than
+//retention ms and the oldest watermark.
+func PubSubRun(tdb *Tidis, interval, retention, lease int, deliver func(channel, message []byte) int64, active func() bool) {
+	var (
+		c         <-chan time.Time
+		now       time.Time
+		lastBeat  time.Time
+		lastPurge time.Time
+		cursor    uint64
+		err       error
+		leader    bool
+		timeout   int64
+	)
+	if interval <= 0 {
+		interval = defaultPubSubPollInterval
+	}
+	if retention <= pubsubHeartbeat {
+		retention = defaultPubSubRetention
+	}
+	timeout = leaseTimeout(lease, pubsubHeartbeat)
+	err = tdb.pubsubHeartbeat(timeout)
+	if err != nil {
+		log.Warnf("pubsub heartbeat failed, %s", err.Error())
+	}
+	lastBeat = time.Now()
+	c = time.Tick(time.Duration(interval) * time.Millisecond)
+	for now = range c {
+		if now.Sub(lastBeat) >= pubsubHeartbeat*time.Millisecond {
+			lastBeat = now
+			err = tdb.pubsubHeartbeat(timeout)
+			if err != nil {
+				log.Warnf("pubsub heartbeat failed, %s", err.Error())
+			}
+		}
+		if !active() {
+			cursor = 0
+			tdb.pubsub.prune(pubsubTS(now.Add(-time.Duration(retention) * time.Millisecond)))
+		} else {
+			if cursor == 0 {
+				//the messages published before the first subscriber are not delivered
+				cursor = pubsubTS(now.Add(-time.Duration(interval) * time.Millisecond))
+			}
+			cursor, err = tdb.pollPubSub(cursor, deliver)
+			if err != nil {
+				log.Warnf("pubsub poll failed, %s", err.Error())
+			}
+			tdb.pubsub.prune(cursor)
+		}
+		if now.Sub(lastPurge) < time.Duration(retention/2)*time.Millisecond {
+			continue
+		}
+		lastPurge = now
+		leader, err = tdb.AcquireLease(pubsubLease, leaseTimeout(lease, retention/2))
+		if err != nil {
+			log.Warnf("pubsub acquire lease failed, %s", err.Error())
+		}
+		if !leader {
+			metrics.WorkerLeaderGauge.WithLabelValues("pubsub").Set(0)
+			continue
+		}
+		metrics.WorkerLeaderGauge.WithLabelValues("pubsub").Set(1)
+		err = tdb.purgePubSub(pubsubTS(now.Add(-time.Duration(retention) * time.Millisecond)))
+		if err != nil {
+			log.Warnf("pubsub purge failed, %s", err.Error())
+		}
+	}
+}
+
+//pubsubHeartbeat write the watermark of this instance, it expires after timeout ms
+func (tidis *Tidis) pubsubHeartbeat(timeout int64) (err error) {
+	var (
+		now       uint64
+		watermark uint64
+	)
+	now, err = tidis.db.CurrentVersion()
+	if err != nil {
+		return
+	}
+	watermark = tidis.pubsub.seal(now)
+	return tidis.RetryTxn(func(txn interface{}) error {
+		var (
+			tikv_txn kv.Transaction
+			ok       bool
+		)
+		tikv_txn, ok = txn.(kv.Transaction)
+		if !ok {
+			return qkverror.ErrorServerInternal
+		}
+		//expires on the clock of the timestamp oracle, like the leases
+		return tikv_txn.Set(pubsubWatermarkKey(tidis.instanceID), encodeWatermark(watermark, int64(tikv_txn.StartTS()>>physicalShiftBits)+timeout))
+	})
+}
+
+//pubsubWatermark the oldest unexpired watermark of the instances seen by txn, its start if there is none, and the
+//keys of the expired ones
+func pubsubWatermark(txn kv.Transaction) (watermark uint64, expired [][]byte, err error) {
+	var (
+		it       kv.Iterator
+		ts       uint64
+		expireAt int64
+		now      = int64(txn.StartTS() >> physicalShiftBits)
+		prefix   = utils.EncodeSysKey(pubsubWatermarkPrefix)
+	)
+	watermark = txn.StartTS()
+	it, err = txn.GetSnapshot().Seek(prefix)
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for it.Valid() && it.Key().HasPrefix(prefix) {
+		ts, expireAt, err = decodeWatermark(it.Value())
+		if err != nil {
+			return
+		}
+		if expireAt < now {
+			expired = append(expired, append([]byte{}, it.Key()...))
+		} else if ts < watermark {
+			watermark = ts
+		}
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//pollPubSub deliver the messages published since the timestamp cursor, skipping the ones delivered already.
+//next is the cursor of the following poll, all the messages before it are committed.
+func (tidis *Tidis) pollPubSub(cursor uint64, deliver func(channel, message []byte) int64) (next uint64, err error) {
+	var (
+		tikv_txn  kv.Transaction
+		it        kv.Iterator
+		key       kv.Key
+		ts        uint64
+		watermark uint64
+		channel   []byte
+		message   []byte
+		prefix    = utils.EncodeSystemPrefix(utils.PUBSUB_TYPE)
+	)
+	next = cursor
+	tikv_txn, err = tidis.NewTxn()
+	if err != nil {
+		return
+	}
+	defer tikv_txn.Rollback()
+	//read in the snapshot of the messages, the ones below it are visible
+	watermark, _, err = pubsubWatermark(tikv_txn)
+	if err != nil {
+		return
+	}
+	it, err = tikv_txn.GetSnapshot().Seek(utils.EncodePubSubKey(cursor, 0))
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for it.Valid() {
+		key = it.Key()
+		if !key.HasPrefix(prefix) {
+			break
+		}
+		ts, err = utils.DecodePubSubKey(key)
+		if err == nil {
+			channel, message, err = utils.DecodePubSubValue(it.Value())
+		}
+		if err == nil && tidis.pubsub.mark(key, ts) {
+			deliver(channel, message)
+		}
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	if watermark > cursor {
+		next = watermark
+	}
+	return
+}
+
+//purgePubSub remove the messages published before the timestamp before and the oldest watermark, and the expired
+//watermarks
+func (tidis *Tidis) purgePubSub(before uint64) (err error) {
+	var (
+		tikv_txn  kv.Transaction
+		it        kv.Iterator
+		endKey    kv.Key
+		purged    int
+		watermark uint64
+		expired   [][]byte
+	)
+	for {
+		tikv_txn, err = tidis.NewTxn()
+		if err != nil {
+			return
+		}
+		watermark, expired, err = pubsubWatermark(tikv_txn)
+		if err != nil {
+			tikv_txn.Rollback()
+			return
+		}
+		for _, key := range expired {
+			if err = tikv_txn.Delete(key); err != nil {
+				tikv_txn.Rollback()
+				return
+			}
+		}
+		if watermark < before {
+			before = watermark
+		}
+		it, err = tikv_txn.GetSnapshot().Seek(utils.EncodePubSubKey(0, 0))
+		if err != nil {
+			tikv_txn.Rollback()
+			return
+		}
+		endKey = utils.EncodePubSubKey(before, 0)
+		purged = 0
+		for it.Valid() && it.Key().Cmp(endKey) < 0 && purged < pubsubBatch {
+			if err = tikv_txn.Delete(it.Key()); err != nil {
+				break
+			}
+			purged++
+			if err = it.Next(); err != nil {
+				break
+			}
+		}
+		it.Close()
+		if err != nil || purged+len(expired) == 0 {
+			tikv_txn.Rollback()
+			return
+		}
+		if err = tikv_txn.Commit(context.Background()); err != nil {
+			return
+		}
+		log.Debugf("pubsub purge %d messages", purged)
+		if purged < pubsubBatch {
+			return
+		}
+	}
+}
+
+//pubsubWatermarkKey the watermark of the instance id
+func pubsubWatermarkKey(id []byte) []byte {
+	return utils.EncodeSysKey(append(append([]byte{}, pubsubWatermarkPrefix...), id...))
+}
+
+//encodeWatermark type(sys)|watermark(8)|expire at(8)
+func encodeWatermark(watermark uint64, expireAt int64) []byte {
+	buf := make([]byte, 17)
+	buf[0] = utils.SYS_TYPE
+	utils.Uint64ToBytesExt(buf[1:], watermark)
+	utils.Uint64ToBytesExt(buf[9:], uint64(expireAt))
+	return buf
+}
+func decodeWatermark(raw []byte) (watermark uint64, expireAt int64, err error) {
+	var (
+		ts uint64
+	)
+	if len(raw) != 17 || raw[0] != utils.SYS_TYPE {
+		err = qkverror.ErrorInvalidRawData
+		return
+	}
+	watermark, err = utils.BytesToUint64(raw[1:9])
+	if err != nil {
+		return
+	}
+	ts, err = utils.BytesToUint64(raw[9:])
+	if err != nil {
+		return
+	}
+	expireAt = int64(ts)
+	return
+}
+
+//pubsubTS timestamp of the oracle at t
+func pubsubTS(t time.Time) uint64 {
+	return uint64(t.UnixNano()/int64(time.Millisecond)) << physicalShiftBits
+}
@@ -0,0 +1,71 @@
+package tidis
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//receive the next message delivered by the poller within timeout, nil if there is none
+func receive(messages chan string, timeout time.Duration) []byte {
+	select {
+	case m := <-messages:
+		return []byte(m)
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func TestPubSubLateCommit(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	messages := make(chan string, 16)
+	go PubSubRun(tdb, 10, 10000, 1000, func(channel, message []byte) int64 {
+		messages <- string(channel) + ":" + string(message)
+		return 1
+	}, func() bool { return true })
+	time.Sleep(50 * time.Millisecond)
+
+	//a transaction open for long publishes at its commit
+	txn, err := tdb.NewTxn()
+	if err != nil {
+		t.Fatalf("new txn: %v", err)
+	}
+	if err = tdb.Publish(txn, []byte("news"), []byte("open")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	if err = txn.Commit(context.Background()); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if m := receive(messages, 500*time.Millisecond); string(m) != "news:open" {
+		t.Fatalf("message of the long transaction: %q", m)
+	}
+
+	//a message whose commit is slow is read once the commit is over
+	txn, err = tdb.NewTxn()
+	if err != nil {
+		t.Fatalf("new txn: %v", err)
+	}
+	btxn := txn.(*blockingTxn)
+	ts, err := tdb.writePubSub(btxn.Transaction, []pubsubMessage{{channel: []byte("news"), message: []byte("slow")}})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	time.Sleep(2500 * time.Millisecond)
+	err = btxn.Transaction.Commit(context.Background())
+	tdb.pubsub.end(ts)
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if m := receive(messages, time.Second); string(m) != "news:slow" {
+		t.Fatalf("message of the slow commit: %q", m)
+	}
+
+	//the messages delivered by the publisher and the ones delivered already are not delivered again
+	if err = tdb.Publish(nil, []byte("news"), []byte("local")); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if m := receive(messages, 1500*time.Millisecond); m != nil {
+		t.Fatalf("message delivered again: %q", m)
+	}
+}
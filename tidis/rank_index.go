@@ -0,0 +1,383 @@
+package tidis
+
+import (
+	"bytes"
+	"hash/fnv"
+
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+)
+
+const (
+	//rankLevels levels of the rank index above the member keys
+	rankLevels = 4
+	//rankFanoutBits a member is on the next level with a chance of 1 in 2^rankFanoutBits
+	rankFanoutBits = 5
+)
+
+//rankIndex a skip list of counts over the ordered member keys of a collection, so the rank of a member and the member
+//at a rank are found in O(log n) reads. The member keys are level 0. A member is on the levels up to its height, drawn
+//from the hash of its tail. The node of a member at a level stores its span, the number of members from it to the next
+//node of the level. The head of a level stores the number of members before its first node.
+//
+//The keys are type(rank)|len(key)|key|level, the head, and type(rank)|len(key)|key|level|0|tail, the nodes, where the
+//tail is what follows base in the member key.
+type rankIndex struct {
+	txn    kv.Transaction
+	base   []byte
+	prefix []byte
+}
+
+//newRankIndex the rank index of the collection at key of version, over the member keys starting with base
+func newRankIndex(txn kv.Transaction, key []byte, version uint64, base []byte) *rankIndex {
+	return &rankIndex{txn: txn, base: base, prefix: utils.EncodeMemberPrefix(utils.MEMBER_RANK, key, version)}
+}
+
+//rankHeight the number of levels of the member at tail
+func rankHeight(tail []byte) (height int) {
+	h := fnv.New32a()
+	h.Write(tail)
+	sum := h.Sum32()
+	for height < rankLevels && sum&(1<<rankFanoutBits-1) == 0 {
+		height++
+		sum >>= rankFanoutBits
+	}
+	return
+}
+
+//levelKey the node of tail at level, the head if tail is nil
+func (r *rankIndex) levelKey(level int, tail []byte) []byte {
+	buf := make([]byte, 0, len(r.prefix)+2+len(tail))
+	buf = append(append(buf, r.prefix...), byte(level))
+	if tail == nil {
+		return buf
+	}
+	return append(append(buf, 0), tail...)
+}
+
+//nodeTail the tail of a key of level, nil for the head or a key out of the level
+func (r *rankIndex) nodeTail(level int, key []byte) []byte {
+	head := r.levelKey(level, nil)
+	if len(key) <= len(head) || !bytes.HasPrefix(key, head) {
+		return nil
+	}
+	return key[len(head)+1:]
+}
+
+//span of the node of tail at level, or of its head
+func (r *rankIndex) span(level int, tail []byte) (span uint64, err error) {
+	var (
+		raw []byte
+	)
+	raw, err = r.txn.Get(r.levelKey(level, tail))
+	if kv.IsErrNotFound(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return
+	}
+	return utils.BytesToUint64(raw)
+}
+func (r *rankIndex) setSpan(level int, tail []byte, span uint64) error {
+	buf := make([]byte, 8)
+	utils.Uint64ToBytesExt(buf, span)
+	return r.txn.Set(r.levelKey(level, tail), buf)
+}
+
+//prev the last node of level before tail and its span, a nil node is the head
+func (r *rankIndex) prev(level int, tail []byte) (node []byte, span uint64, err error) {
+	var (
+		it kv.Iterator
+	)
+	it, err = r.txn.SeekReverse(r.levelKey(level, tail))
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	if it.Valid() {
+		if node = r.nodeTail(level, it.Key()); node != nil {
+			node = append([]byte{}, node...)
+			span, err = utils.BytesToUint64(it.Value())
+			return
+		}
+	}
+	span, err = r.span(level, nil)
+	return
+}
+
+//walk the nodes of level after from, the head if nil, while next returns true for the tail and span of the node
+func (r *rankIndex) walk(level int, from []byte, next func(tail []byte, span uint64) bool) (err error) {
+	var (
+		it   kv.Iterator
+		tail []byte
+		span uint64
+	)
+	it, err = r.txn.Seek(kv.Key(r.levelKey(level, from)).Next())
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for it.Valid() {
+		if tail = r.nodeTail(level, it.Key()); tail == nil {
+			return
+		}
+		span, err = utils.BytesToUint64(it.Value())
+		if err != nil {
+			return
+		}
+		if !next(append([]byte{}, tail...), span) {
+			return
+		}
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//count the members from the node of from at level, the head if nil, to tail excluded
+func (r *rankIndex) count(level int, from, tail []byte) (count uint64, err error) {
+	var (
+		it kv.Iterator
+		to []byte
+	)
+	if level == 0 {
+		to = append(append([]byte{}, r.base...), tail...)
+		it, err = r.txn.Seek(append(append([]byte{}, r.base...), from...))
+		if err != nil {
+			return
+		}
+		defer it.Close()
+		for it.Valid() && it.Key().HasPrefix(r.base) && bytes.Compare(it.Key(), to) < 0 {
+			count++
+			if err = it.Next(); err != nil {
+				return
+			}
+		}
+		return
+	}
+	count, err = r.span(level, from)
+	if err != nil {
+		return
+	}
+	err = r.walk(level, from, func(node []byte, span uint64) bool {
+		if bytes.Compare(node, tail) >= 0 {
+			return false
+		}
+		count += span
+		return true
+	})
+	return
+}
+
+//insert the member key, once it is written
+func (r *rankIndex) insert(key []byte) (err error) {
+	var (
+		height int
+		node   []byte
+		span   uint64
+		before uint64
+		tail   = key[len(r.base):]
+	)
+	height = rankHeight(tail)
+	//the levels below are up to date when the members before tail are counted
+	for level := 1; level <= rankLevels; level++ {
+		node, span, err = r.prev(level, tail)
+		if err != nil {
+			return
+		}
+		if level > height {
+			if err = r.setSpan(level, node, span+1); err != nil {
+				return
+			}
+			continue
+		}
+		before, err = r.count(level-1, node, tail)
+		if err != nil {
+			return
+		}
+		if err = r.setSpan(level, node, before); err != nil {
+			return
+		}
+		if err = r.setSpan(level, tail, span+1-before); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//remove the member key
+func (r *rankIndex) remove(key []byte) (err error) {
+	var (
+		height int
+		node   []byte
+		span   uint64
+		own    uint64
+		tail   = key[len(r.base):]
+	)
+	height = rankHeight(tail)
+	for level := 1; level <= rankLevels; level++ {
+		node, span, err = r.prev(level, tail)
+		if err != nil {
+			return
+		}
+		if level > height {
+			if err = r.setSpan(level, node, span-1); err != nil {
+				return
+			}
+			continue
+		}
+		own, err = r.span(level, tail)
+		if err != nil {
+			return
+		}
+		if err = r.setSpan(level, node, span+own-1); err != nil {
+			return
+		}
+		if err = r.txn.Delete(r.levelKey(level, tail)); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//rank the number of members before the member key
+func (r *rankIndex) rank(key []byte) (rank uint64, err error) {
+	var (
+		cur  []byte
+		span uint64
+		tail = key[len(r.base):]
+	)
+	for level := rankLevels; level >= 1; level-- {
+		span, err = r.span(level, cur)
+		if err != nil {
+			return
+		}
+		err = r.walk(level, cur, func(node []byte, nodeSpan uint64) bool {
+			if bytes.Compare(node, tail) >= 0 {
+				return false
+			}
+			rank += span
+			cur, span = node, nodeSpan
+			return true
+		})
+		if err != nil {
+			return
+		}
+	}
+	span, err = r.count(0, cur, tail)
+	rank += span
+	return
+}
+
+//seek the member key at rank, nil if there is none
+func (r *rankIndex) seek(rank uint64) (key []byte, err error) {
+	var (
+		cur  []byte
+		pos  uint64
+		span uint64
+		it   kv.Iterator
+	)
+	for level := rankLevels; level >= 1; level-- {
+		span, err = r.span(level, cur)
+		if err != nil {
+			return
+		}
+		err = r.walk(level, cur, func(node []byte, nodeSpan uint64) bool {
+			if pos+span > rank {
+				return false
+			}
+			pos += span
+			cur, span = node, nodeSpan
+			return true
+		})
+		if err != nil {
+			return
+		}
+	}
+	it, err = r.txn.Seek(append(append([]byte{}, r.base...), cur...))
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for ; it.Valid() && it.Key().HasPrefix(r.base); pos++ {
+		if pos == rank {
+			key = append([]byte{}, it.Key()...)
+			return
+		}
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//build the index of the members from their keys, replacing any index left
+func (r *rankIndex) build() (err error) {
+	var (
+		it     kv.Iterator
+		tail   []byte
+		height int
+		nodes  [rankLevels + 1][]byte
+		spans  [rankLevels + 1]uint64
+	)
+	if err = r.clear(); err != nil {
+		return
+	}
+	it, err = r.txn.Seek(r.base)
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for it.Valid() && it.Key().HasPrefix(r.base) {
+		tail = append([]byte{}, it.Key()[len(r.base):]...)
+		height = rankHeight(tail)
+		for level := 1; level <= rankLevels; level++ {
+			if level > height {
+				spans[level]++
+				continue
+			}
+			if err = r.setSpan(level, nodes[level], spans[level]); err != nil {
+				return
+			}
+			nodes[level], spans[level] = tail, 1
+		}
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	for level := 1; level <= rankLevels; level++ {
+		if err = r.setSpan(level, nodes[level], spans[level]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//clear delete the index, once the collection is empty
+func (r *rankIndex) clear() (err error) {
+	var (
+		it   kv.Iterator
+		keys []kv.Key
+	)
+	it, err = r.txn.Seek(r.prefix)
+	if err != nil {
+		return
+	}
+	for it.Valid() && it.Key().HasPrefix(r.prefix) {
+		keys = append(keys, append(kv.Key{}, it.Key()...))
+		if err = it.Next(); err != nil {
+			break
+		}
+	}
+	it.Close()
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		if err = r.txn.Delete(key); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,266 @@
+package tidis
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+)
+
+//checkZSetRanks compare the ranks of the sorted set at key and the members at each rank with scores
+func checkZSetRanks(t *testing.T, tdb *Tidis, key []byte, scores map[string]float64) {
+	members := make([]string, 0, len(scores))
+	for member := range scores {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := utils.ZScoreOffset(scores[members[i]]), utils.ZScoreOffset(scores[members[j]])
+		return a < b || a == b && members[i] < members[j]
+	})
+	for i, member := range members {
+		rank, score, exists, err := tdb.ZRank(nil, key, []byte(member), false)
+		if err != nil || !exists || rank != int64(i) || score != scores[member] {
+			t.Fatalf("rank of %s: %d %v %v %v, want %d", member, rank, score, exists, err, i)
+		}
+		rank, _, _, err = tdb.ZRank(nil, key, []byte(member), true)
+		if err != nil || rank != int64(len(members)-i-1) {
+			t.Fatalf("reverse rank of %s: %d %v, want %d", member, rank, err, len(members)-i-1)
+		}
+	}
+	err := tdb.RetryTxn(func(txn interface{}) error {
+		_, _, _, version, err := tdb.getZSetMeta(txn, key)
+		if err != nil {
+			return err
+		}
+		index := zsetRank(txn.(kv.Transaction), key, version)
+		for i, member := range members {
+			found, err := index.seek(uint64(i))
+			if err != nil {
+				return err
+			}
+			if want := utils.EncodeZSetScore(key, version, []byte(member), scores[member]); !bytes.Equal(found, want) {
+				return fmt.Errorf("member at rank %d: %q, want %q", i, found, want)
+			}
+		}
+		if found, err := index.seek(uint64(len(members))); err != nil || found != nil {
+			return fmt.Errorf("member past the last rank: %q %v", found, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+}
+
+func TestZSetRankIndex(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	key := []byte("z")
+	scores := make(map[string]float64)
+	r := rand.New(rand.NewSource(1))
+	//enough members for the upper levels of the index
+	for batch := 0; batch < 4; batch++ {
+		pairs := make([]*ZSetPair, 0, 500)
+		for i := 0; i < 500; i++ {
+			member := fmt.Sprintf("m%d", r.Intn(3000))
+			//few distinct scores, the members of a score are ordered by name
+			score := float64(r.Intn(50) - 25)
+			scores[member] = score
+			pairs = append(pairs, &ZSetPair{Score: score, Key: []byte(member)})
+		}
+		if _, err := tdb.ZAdd(nil, key, pairs...); err != nil {
+			t.Fatalf("zadd: %v", err)
+		}
+	}
+	checkZSetRanks(t, tdb, key, scores)
+
+	for member := range scores {
+		switch r.Intn(4) {
+		case 0:
+			if _, err := tdb.ZRem(nil, key, []byte(member)); err != nil {
+				t.Fatalf("zrem: %v", err)
+			}
+			delete(scores, member)
+		case 1:
+			score, err := tdb.ZIncrby(nil, key, 0.5, []byte(member))
+			if err != nil {
+				t.Fatalf("zincrby: %v", err)
+			}
+			scores[member] = score
+		}
+	}
+	if _, err := tdb.ZRemRangeByScore(nil, key, ZScoreBound{Score: -3}, ZScoreBound{Score: 3}); err != nil {
+		t.Fatalf("zremrangebyscore: %v", err)
+	}
+	for member, score := range scores {
+		if score >= -3 && score <= 3 {
+			delete(scores, member)
+		}
+	}
+	checkZSetRanks(t, tdb, key, scores)
+
+	//the index is dropped with the last member
+	members := make([][]byte, 0, len(scores))
+	for member := range scores {
+		members = append(members, []byte(member))
+	}
+	if _, err := tdb.ZRem(nil, key, members...); err != nil {
+		t.Fatalf("zrem: %v", err)
+	}
+	if n := countPrefix(t, tdb, []byte{utils.MEMBER_RANK}); n != 0 {
+		t.Fatalf("rank index keys of the deleted sorted set: %d", n)
+	}
+}
+
+//a sorted set written before the rank index gets it on first access
+func TestZSetRankIndexBuild(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	key := []byte("z")
+	scores := make(map[string]float64)
+	pairs := make([]*ZSetPair, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		scores[fmt.Sprintf("m%d", i)] = float64(i % 7)
+		pairs = append(pairs, &ZSetPair{Score: float64(i % 7), Key: []byte(fmt.Sprintf("m%d", i))})
+	}
+	if _, err := tdb.ZAdd(nil, key, pairs...); err != nil {
+		t.Fatalf("zadd: %v", err)
+	}
+	err := tdb.RetryTxn(func(txn interface{}) error {
+		zsize, ttl, _, version, err := tdb.getZSetMeta(txn, key)
+		if err != nil {
+			return err
+		}
+		if err = zsetRank(txn.(kv.Transaction), key, version).clear(); err != nil {
+			return err
+		}
+		meta := append(tdb.createHashMeta(zsize, ttl, utils.FLAG_NORMAL, version), utils.ZSCORE_FLOAT64)
+		return txn.(kv.Transaction).Set(key, utils.EncodeData(utils.ZSET_TYPE, meta))
+	})
+	if err != nil {
+		t.Fatalf("rewrite as an old sorted set: %v", err)
+	}
+	checkZSetRanks(t, tdb, key, scores)
+	_, _, _, _, _, encoding, err := tdb.getZSetMetaWithEncoding(nil, key)
+	if err != nil || encoding != utils.ZSCORE_FLOAT64_RANK {
+		t.Fatalf("encoding after the first access: %d %v", encoding, err)
+	}
+}
+
+//the members packed after a long member or in a cluster are picked as often as the others
+func TestSetRandomMembers(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	key := []byte("s")
+	members := [][]byte{[]byte("a"), []byte("b"), []byte("\xfe\xfe\xfe\xfe\xfe\xfe\xfe\xfe"), []byte("zzzzzzzzzzzzzzzzzzzz")}
+	for i := 0; i < 26; i++ {
+		members = append(members, []byte(fmt.Sprintf("aaaa%d", i)))
+	}
+	if _, err := tdb.SAdd(nil, key, members...); err != nil {
+		t.Fatalf("sadd: %v", err)
+	}
+	const draws = 400
+	picked := make(map[string]int)
+	for i := 0; i < draws*len(members)/1000; i++ {
+		got, err := tdb.SRandMember(nil, key, -1000)
+		if err != nil || len(got) != 1000 {
+			t.Fatalf("srandmember: %d %v", len(got), err)
+		}
+		for _, member := range got {
+			picked[string(member.([]byte))]++
+		}
+	}
+	for _, member := range members {
+		//the standard deviation is about 20
+		if n := picked[string(member)]; n < draws*7/10 || n > draws*13/10 {
+			t.Fatalf("%q picked %d times, want %d", member, n, draws)
+		}
+	}
+
+	//distinct members, removed from the set and from its rank index
+	popped, err := tdb.SPop(nil, key, 10)
+	if err != nil || len(popped) != 10 {
+		t.Fatalf("spop: %d %v", len(popped), err)
+	}
+	left := make(map[string]bool)
+	for _, member := range members {
+		left[string(member)] = true
+	}
+	for _, member := range popped {
+		if !left[string(member.([]byte))] {
+			t.Fatalf("popped %q twice or not a member", member)
+		}
+		delete(left, string(member.([]byte)))
+	}
+	got, err := tdb.SRandMember(nil, key, 15)
+	if err != nil || len(got) != 15 {
+		t.Fatalf("srandmember: %d %v", len(got), err)
+	}
+	seen := make(map[string]bool)
+	for _, member := range got {
+		if !left[string(member.([]byte))] || seen[string(member.([]byte))] {
+			t.Fatalf("srandmember returned %q, popped or twice", member)
+		}
+		seen[string(member.([]byte))] = true
+	}
+	if _, err = tdb.SPop(nil, key, int64(len(left))); err != nil {
+		t.Fatalf("spop: %v", err)
+	}
+	if n := countPrefix(t, tdb, []byte{utils.MEMBER_RANK}); n != 0 {
+		t.Fatalf("rank index keys of the deleted set: %d", n)
+	}
+}
+
+//a set written before the rank index gets it on first access
+func TestSetRankIndexBuild(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	key := []byte("s")
+	for i := 0; i < 300; i++ {
+		if _, err := tdb.SAdd(nil, key, []byte(fmt.Sprintf("m%d", i))); err != nil {
+			t.Fatalf("sadd: %v", err)
+		}
+	}
+	err := tdb.RetryTxn(func(txn interface{}) error {
+		ssize, ttl, _, version, err := tdb.getSetMeta(txn, key)
+		if err != nil {
+			return err
+		}
+		if err = setRank(txn.(kv.Transaction), key, version).clear(); err != nil {
+			return err
+		}
+		return txn.(kv.Transaction).Set(key, utils.EncodeData(utils.SET_TYPE, tdb.createHashMeta(ssize, ttl, utils.FLAG_NORMAL, version)))
+	})
+	if err != nil {
+		t.Fatalf("rewrite as an old set: %v", err)
+	}
+	got, err := tdb.SRandMember(nil, key, 100)
+	if err != nil || len(got) != 100 {
+		t.Fatalf("srandmember: %d %v", len(got), err)
+	}
+	_, _, _, _, version, ranked, err := tdb.getSetMetaWithRank(nil, key)
+	if err != nil || !ranked {
+		t.Fatalf("rank index after the first access: %v %v", ranked, err)
+	}
+	members := make([]string, 0, 300)
+	for i := 0; i < 300; i++ {
+		members = append(members, fmt.Sprintf("m%d", i))
+	}
+	sort.Strings(members)
+	err = tdb.RetryTxn(func(txn interface{}) error {
+		rank := setRank(txn.(kv.Transaction), key, version)
+		for i, member := range members {
+			found, err := rank.seek(uint64(i))
+			if err != nil {
+				return err
+			}
+			if want := utils.EncodeSetData(key, version, []byte(member)); !bytes.Equal(found, want) {
+				return fmt.Errorf("member at rank %d: %q, want %q", i, found, want)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+}
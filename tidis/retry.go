@@ -0,0 +1,78 @@
+package tidis
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/chuangyou/qkv/metrics"
+	"github.com/pingcap/tidb/kv"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultTxnRetryLimit      = 5
+	defaultTxnRetryBackoff    = 2
+	defaultTxnRetryMaxBackoff = 200
+)
+
+//RetryTxn run f in a new transaction and commit it, f is run again in a fresh transaction
+//when the transaction fails with a retryable error such as a write conflict.
+func (tidis *Tidis) RetryTxn(f func(txn interface{}) error) (err error) {
+	var (
+		tikv_txn kv.Transaction
+		attempt  int
+	)
+	for attempt = 1; ; attempt++ {
+		tikv_txn, err = tidis.NewTxn()
+		if err != nil {
+			return
+		}
+		err = f(tikv_txn)
+		if err == nil {
+			err = tikv_txn.Commit(context.Background())
+		} else {
+			tikv_txn.Rollback()
+		}
+		if err == nil {
+			if attempt > 1 {
+				metrics.TxnRetryCounter.WithLabelValues("ok").Inc()
+			}
+			return
+		}
+		if !isRetryableError(err) {
+			if attempt > 1 {
+				metrics.TxnRetryCounter.WithLabelValues("fatal").Inc()
+			}
+			return
+		}
+		if attempt >= tidis.retryLimit {
+			metrics.TxnRetryCounter.WithLabelValues("exhausted").Inc()
+			log.Warnf("transaction retry %d times failed, %s", attempt, err.Error())
+			return
+		}
+		metrics.TxnRetryCounter.WithLabelValues("retry").Inc()
+		time.Sleep(tidis.retryBackoff(attempt))
+	}
+}
+
+//isRetryableError write conflicts and stale region errors are worth retrying, the others are fatal.
+func isRetryableError(err error) bool {
+	return kv.IsRetryableError(err)
+}
+
+//retryBackoff exponential backoff with jitter for the given attempt
+func (tidis *Tidis) retryBackoff(attempt int) time.Duration {
+	var (
+		backoff int
+	)
+	backoff = tidis.retryBaseBackoff
+	for i := 1; i < attempt && backoff < tidis.retryMaxBackoff; i++ {
+		backoff = backoff * 2
+	}
+	if backoff > tidis.retryMaxBackoff {
+		backoff = tidis.retryMaxBackoff
+	}
+	backoff = backoff/2 + rand.Intn(backoff/2+1)
+	return time.Duration(backoff) * time.Millisecond
+}
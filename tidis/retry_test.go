@@ -0,0 +1,123 @@
+package tidis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chuangyou/qkv/config"
+	"github.com/pingcap/tidb/kv"
+)
+
+//conflict commit a write to key in another transaction, the transactions started before it conflict on key
+func conflict(t *testing.T, tdb *Tidis, key []byte) {
+	txn, err := tdb.NewTxn()
+	if err != nil {
+		t.Fatalf("new txn: %v", err)
+	}
+	if err = txn.Set(key, []byte("other")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err = txn.Commit(context.Background()); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+}
+
+func TestRetryTxnWriteConflict(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	key := []byte("k")
+	attempts := 0
+	err := tdb.RetryTxn(func(txn interface{}) error {
+		attempts++
+		if attempts == 1 {
+			//written after the first attempt started, its commit fails with a write conflict
+			conflict(t, tdb, key)
+		}
+		return txn.(kv.Transaction).Set(key, []byte("mine"))
+	})
+	if err != nil {
+		t.Fatalf("retry txn: %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts: %d", attempts)
+	}
+	if v := mustGet(t, tdb, key); string(v) != "mine" {
+		t.Fatalf("value after retry: %q", v)
+	}
+}
+
+func TestRetryTxnLimit(t *testing.T) {
+	tdb := newTestTidis(t, func(conf *config.Config) {
+		conf.QKV.TxnRetryLimit = 3
+		conf.QKV.TxnRetryBackoff = 1
+		conf.QKV.TxnRetryMaxBackoff = 1
+	})
+	key := []byte("k")
+	attempts := 0
+	err := tdb.RetryTxn(func(txn interface{}) error {
+		attempts++
+		conflict(t, tdb, key)
+		return txn.(kv.Transaction).Set(key, []byte("mine"))
+	})
+	if !kv.IsRetryableError(err) {
+		t.Fatalf("retry txn: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("attempts: %d", attempts)
+	}
+	if v := mustGet(t, tdb, key); string(v) != "other" {
+		t.Fatalf("value after the failed retries: %q", v)
+	}
+}
+
+func TestRetryTxnFatal(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	fatal := errors.New("fatal")
+	attempts := 0
+	err := tdb.RetryTxn(func(txn interface{}) error {
+		attempts++
+		if err := txn.(kv.Transaction).Set([]byte("k"), []byte("v")); err != nil {
+			return err
+		}
+		return fatal
+	})
+	if err != fatal {
+		t.Fatalf("retry txn: %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("attempts: %d", attempts)
+	}
+	if v := mustGet(t, tdb, []byte("k")); v != nil {
+		t.Fatalf("value of the rolled back txn: %q", v)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	for _, c := range []struct {
+		base, max int
+		attempt   int
+		want      int
+	}{
+		{2, 200, 1, 2},
+		{2, 200, 3, 8},
+		{2, 200, 7, 128},
+		{2, 200, 8, 200},
+		{2, 200, 30, 200},
+		//a max below the base is raised to the base
+		{50, 10, 1, 50},
+		{50, 10, 5, 50},
+	} {
+		tdb := newTestTidis(t, func(conf *config.Config) {
+			conf.QKV.TxnRetryBackoff = c.base
+			conf.QKV.TxnRetryMaxBackoff = c.max
+		})
+		for i := 0; i < 20; i++ {
+			//the jitter keeps the backoff between half of it and all of it
+			d := tdb.retryBackoff(c.attempt)
+			if d < time.Duration(c.want/2)*time.Millisecond || d > time.Duration(c.want)*time.Millisecond {
+				t.Fatalf("backoff of attempt %d with base %d and max %d: %v, want %dms", c.attempt, c.base, c.max, d, c.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package tidis
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+)
+
+const (
+	DefaultScanCount = 10
+)
+
+//Scan iterates the user keys of the database namespace ns from cursor, returns the next cursor and the matched keys.
+//At most count keys are examined, internal member and expire keys are skipped.
+func (tidis *Tidis) Scan(txn interface{}, ns []byte, cursor, match []byte, count int64, typeName string) (next []byte, keys []interface{}, err error) {
+	var (
+		start    []byte
+		end      []byte
+		kvs      [][]byte
+		key      []byte
+		keyNS    []byte
+		bare     []byte
+		value    []byte
+		examined int64
+		limit    int64
+		jumped   bool
+		internal bool
+		expired  bool
+	)
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+	start, err = utils.DecodeCursor(cursor)
+	if err != nil {
+		return
+	}
+	//the cursor is the position in the database, without its namespace
+	start = append(append([]byte{}, ns...), start...)
+	if len(ns) > 0 {
+		end = utils.PrefixEnd(ns)
+	}
+	keys = make([]interface{}, 0)
+	for examined < count {
+		limit = count - examined
+		kvs, err = tidis.db.GetRangeKeysValues(txn, start, end, uint64(limit), true)
+		if err != nil {
+			return
+		}
+		jumped = false
+		for i := 0; i < len(kvs)-1; i = i + 2 {
+			key, value = kvs[i], kvs[i+1]
+			keyNS, bare = utils.SplitDBKey(key)
+			examined++
+			start = kv.Key(key).Next()
+			if len(bare) == 0 || bytes.Equal(key, end) {
+				continue
+			}
+			if len(ns) == 0 && (len(keyNS) > 0 || utils.IsReservedKey(key)) {
+				//the internal keys, the other databases and the tenants, continue after them
+				start = []byte{utils.NONE_TYPE}
+				jumped = true
+				break
+			}
+			if isMemberPrefix(bare[0]) {
+				//the whole member range of a data type, continue after it
+				start = append(append([]byte{}, ns...), bare[0]+1)
+				jumped = true
+				break
+			}
+			internal, err = tidis.isExpireMetaKey(txn, key, value)
+			if err != nil {
+				return
+			}
+			if internal || len(value) == 0 {
+				continue
+			}
+			if match != nil && !utils.MatchPattern(match, bare) {
+				continue
+			}
+			if typeName != "" && utils.TypeName(value[0]) != typeName {
+				continue
+			}
+			expired, err = tidis.isExpired(txn, key)
+			if err != nil {
+				return
+			}
+			if !expired {
+				keys = append(keys, bare)
+			}
+		}
+		if !jumped && int64(len(kvs)/2) < limit {
+			//no more keys
+			start = nil
+			break
+		}
+	}
+	_, start = utils.SplitDBKey(start)
+	next = utils.EncodeCursor(start)
+	return
+}
+
+//HScan iterates the fields and values of the hash stored at key.
+func (tidis *Tidis) HScan(txn interface{}, key, cursor, match []byte, count int64) (next []byte, resp []interface{}, err error) {
+	var (
+		hsize   uint64
+		version uint64
+		kvs     [][]byte
+		field   []byte
+		expired map[string]bool
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	//delete hash if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	hsize, _, _, version, err = tidis.getHashMetaWithType(txn, key)
+	if err != nil {
+		return
+	}
+	resp = make([]interface{}, 0)
+	if hsize == 0 {
+		next = utils.EncodeCursor(nil)
+		return
+	}
+	expired, err = tidis.expiredHashFields(txn, key, version, hsize)
+	if err != nil {
+		return
+	}
+	next, kvs, err = tidis.scanMembers(txn, utils.EncodeHashData(key, version, nil), cursor, count)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(kvs)-1; i = i + 2 {
+		_, field, err = utils.DecodeHashData(kvs[i])
+		if err != nil {
+			return
+		}
+		if expired[string(field)] {
+			continue
+		}
+		if match != nil && !utils.MatchPattern(match, field) {
+			continue
+		}
+		resp = append(resp, field, kvs[i+1])
+	}
+	return
+}
+
+//SScan iterates the members of the set stored at key.
+func (tidis *Tidis) SScan(txn interface{}, key, cursor, match []byte, count int64) (next []byte, resp []interface{}, err error) {
+	var (
+		ssize   uint64
+		version uint64
+		kvs     [][]byte
+		member  []byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	ssize, _, _, version, err = tidis.getSetMeta(txn, key)
+	if err != nil {
+		return
+	}
+	resp = make([]interface{}, 0)
+	if ssize == 0 {
+		next = utils.EncodeCursor(nil)
+		return
+	}
+	next, kvs, err = tidis.scanMembers(txn, utils.EncodeSetData(key, version, nil), cursor, count)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(kvs)-1; i = i + 2 {
+		_, member, err = utils.DecodeSetData(kvs[i])
+		if err != nil {
+			return
+		}
+		if match != nil && !utils.MatchPattern(match, member) {
+			continue
+		}
+		resp = append(resp, member)
+	}
+	return
+}
+
+//ZScan iterates the members and scores of the sorted set stored at key.
+func (tidis *Tidis) ZScan(txn interface{}, key, cursor, match []byte, count int64) (next []byte, resp []interface{}, err error) {
+	var (
+		zsize   uint64
+		version uint64
+		kvs     [][]byte
+		member  []byte
+		score   float64
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	zsize, _, _, version, err = tidis.getZSetMeta(txn, key)
+	if err != nil {
+		return
+	}
+	resp = make([]interface{}, 0)
+	if zsize == 0 {
+		next = utils.EncodeCursor(nil)
+		return
+	}
+	next, kvs, err = tidis.scanMembers(txn, utils.EncodeZSetDataPrefix(key, version), cursor, count)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(kvs)-1; i = i + 2 {
+		_, member, err = utils.DecodeZSetData(kvs[i])
+		if err != nil {
+			return
+		}
+		if match != nil && !utils.MatchPattern(match, member) {
+			continue
+		}
+		score, err = utils.BytesToFloat64(kvs[i+1])
+		if err != nil {
+			return
+		}
+		resp = append(resp, member, utils.Float64ToStrBytes(score))
+	}
+	return
+}
+
+//scanMembers returns up to count key/value pairs under prefix from cursor
+func (tidis *Tidis) scanMembers(txn interface{}, prefix, cursor []byte, count int64) (next []byte, kvs [][]byte, err error) {
+	var (
+		start   []byte
+		fetched [][]byte
+	)
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+	start, err = utils.DecodeCursor(cursor)
+	if err != nil {
+		return
+	}
+	if start == nil {
+		start = prefix
+	} else if !bytes.HasPrefix(start, prefix) {
+		err = qkverror.ErrorInvalidCursor
+		return
+	}
+	fetched, err = tidis.db.GetRangeKeysValues(txn, start, nil, uint64(count), true)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(fetched)-1; i = i + 2 {
+		if !bytes.HasPrefix(fetched[i], prefix) {
+			break
+		}
+		kvs = append(kvs, fetched[i], fetched[i+1])
+	}
+	if len(kvs) == len(fetched) && int64(len(kvs)/2) == count {
+		next = utils.EncodeCursor(kv.Key(kvs[len(kvs)-2]).Next())
+	} else {
+		next = utils.EncodeCursor(nil)
+	}
+	return
+}
+
+//isExpireMetaKey whether key is a ttl key, or an expire key of db 0 not migrated yet, rather than a user key sharing
+//its first byte, key may be prefixed by the namespace of its database
+func (tidis *Tidis) isExpireMetaKey(txn interface{}, key, value []byte) (ok bool, err error) {
+	var (
+		ts    uint64
+		raw   []byte
+		ns    []byte
+		bare  []byte
+		entry []byte
+	)
+	ns, bare = utils.SplitDBKey(key)
+	if len(bare) == 0 {
+		return
+	}
+	switch bare[0] {
+	case utils.TTL_TYPE:
+		if len(value) != 8 {
+			return
+		}
+		ts, _ = utils.BytesToUint64(value)
+		raw, err = tidis.db.Get(txn, utils.EncodeExpireKey(append(append([]byte{}, ns...), bare[1:]...), int64(ts)))
+		ok = raw != nil
+	case utils.EXPTIME_TYPE:
+		entry, err = tidis.legacyExpireEntry(txn, key, value)
+		ok = entry != nil
+	}
+	return
+}
+
+//isExpired whether the timeout of key has passed
+func (tidis *Tidis) isExpired(txn interface{}, key []byte) (expired bool, err error) {
+	var (
+		raw []byte
+		ts  uint64
+	)
+	raw, err = tidis.db.Get(txn, utils.EncodeTTLKey(key))
+	if err != nil || raw == nil {
+		return
+	}
+	ts, err = utils.BytesToUint64(raw)
+	if err != nil {
+		return
+	}
+	expired = ts <= uint64(time.Now().UnixNano()/1000/1000)
+	return
+}
+
+//isMemberPrefix member keys of set, zset, hash, list, stream and bitmap
+func isMemberPrefix(prefix byte) bool {
+	switch prefix {
+	case utils.SET_DATA, utils.ZSET_DATA, utils.ZSET_SCORE, utils.MEMBER_RANK, utils.HASH_DATA, utils.HASH_FIELD_TTL, utils.LIST_DATA,
+		utils.STREAM_DATA, utils.STREAM_GROUP, utils.STREAM_PEL, utils.BITMAP_DATA:
+		return true
+	}
+	return false
+}
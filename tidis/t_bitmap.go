@@ -0,0 +1,878 @@
+package tidis
+
+import (
+	"bytes"
+	"math/bits"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+)
+
+const (
+	//bitmapChunkSize bytes of a bitmap chunk, a bitmap is stored in chunks so it is not limited by the size of a TiKV value
+	bitmapChunkSize = 4096
+	//BitmapMaxOffset greatest bit offset, a bitmap is at most 512MB like a redis string
+	BitmapMaxOffset = 1<<32 - 1
+)
+
+//operations of BITOP
+const (
+	BitopAnd byte = iota
+	BitopOr
+	BitopXor
+	BitopNot
+)
+
+//subcommands and overflow behaviors of BITFIELD
+const (
+	BitfieldGet byte = iota
+	BitfieldSet
+	BitfieldIncrby
+)
+const (
+	BitfieldWrap byte = iota
+	BitfieldSat
+	BitfieldFail
+)
+
+var (
+	bitmapZeros = make([]byte, bitmapChunkSize)
+)
+
+//BitfieldOp a GET, SET or INCRBY of BITFIELD on the integer of Bits bits at the bit Offset
+type BitfieldOp struct {
+	Kind     byte
+	Signed   bool
+	Bits     uint
+	Offset   uint64
+	Value    int64
+	Overflow byte
+}
+
+//bitmap the value of a string key read as a bitmap, a plain string value is chunked when it is written.
+//chunks holds the chunks written by the command until saveBitmap.
+type bitmap struct {
+	key     []byte
+	exists  bool
+	chunked bool
+	size    uint64
+	version uint64
+	str     []byte
+	chunks  map[uint64][]byte
+}
+
+//SetBit sets or clears the bit at offset in the string value stored at key, returns the original bit value.
+func (tidis *Tidis) SetBit(txn interface{}, key []byte, offset uint64, on bool) (old int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		bm       *bitmap
+		bit      byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if offset > BitmapMaxOffset {
+		err = qkverror.ErrorBitOffset
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			old, err = tidis.SetBit(txn, key, offset, on)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	bm, err = tidis.loadBitmap(txn, key)
+	if err != nil {
+		return
+	}
+	bit, err = tidis.setBit(txn, bm, offset, on)
+	if err != nil {
+		return
+	}
+	err = tidis.saveBitmap(tikv_txn, bm)
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyString, "setbit", key)
+	if err != nil {
+		return
+	}
+	old = int64(bit)
+	return
+}
+
+//GetBit returns the bit value at offset in the string value stored at key.
+func (tidis *Tidis) GetBit(txn interface{}, key []byte, offset uint64) (bit int64, err error) {
+	var (
+		bm *bitmap
+		b  byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if offset > BitmapMaxOffset {
+		err = qkverror.ErrorBitOffset
+		return
+	}
+	bm, err = tidis.loadBitmap(txn, key)
+	if err != nil || !bm.exists {
+		return
+	}
+	b, err = tidis.getBit(txn, bm, offset)
+	bit = int64(b)
+	return
+}
+
+//BitCount count the set bits of the string value stored at key between start and end (inclusive),
+//bytes unless bitMode is set. Negative indexes count from the end.
+func (tidis *Tidis) BitCount(txn interface{}, key []byte, start, end int64, bitMode bool) (count int64, err error) {
+	var (
+		tikv_txn            kv.Transaction
+		ok                  bool
+		bm                  *bitmap
+		firstByte, lastByte uint64
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			count, err = tidis.BitCount(txn, key, start, end, bitMode)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	bm, err = tidis.loadBitmap(txn, key)
+	if err != nil || !bm.exists {
+		return
+	}
+	start, end, firstByte, lastByte, ok = bitmapRange(bm.size, start, end, bitMode)
+	if !ok {
+		return
+	}
+	err = tidis.scanBitmap(tikv_txn, bm, firstByte, lastByte+1, func(offset uint64, data []byte) bool {
+		for i, b := range data {
+			if bitMode {
+				b &= bitmapMask(offset+uint64(i), start, end, firstByte, lastByte)
+			}
+			count += int64(bits.OnesCount8(b))
+		}
+		return true
+	})
+	return
+}
+
+//BitPos returns the position of the first bit set to bit in the string value stored at key between start and end (inclusive),
+//bytes unless bitMode is set. A missing clear bit is looked for after the end of the string when endGiven is not set.
+func (tidis *Tidis) BitPos(txn interface{}, key []byte, bit byte, start, end int64, endGiven, bitMode bool) (pos int64, err error) {
+	var (
+		tikv_txn            kv.Transaction
+		ok                  bool
+		bm                  *bitmap
+		firstByte, lastByte uint64
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			pos, err = tidis.BitPos(txn, key, bit, start, end, endGiven, bitMode)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	bm, err = tidis.loadBitmap(txn, key)
+	if err != nil {
+		return
+	}
+	if !bm.exists {
+		//an empty string has clear bits only
+		if bit == 1 {
+			pos = -1
+		}
+		return
+	}
+	pos = -1
+	start, end, firstByte, lastByte, ok = bitmapRange(bm.size, start, end, bitMode)
+	if !ok {
+		return
+	}
+	err = tidis.scanBitmap(tikv_txn, bm, firstByte, lastByte+1, func(offset uint64, data []byte) bool {
+		var (
+			mask byte = 0xff
+		)
+		for i, b := range data {
+			if bitMode {
+				mask = bitmapMask(offset+uint64(i), start, end, firstByte, lastByte)
+			}
+			if bit == 1 {
+				b &= mask
+			} else {
+				b = ^b & mask
+			}
+			if b != 0 {
+				pos = int64(offset+uint64(i))*8 + int64(bits.LeadingZeros8(b))
+				return false
+			}
+		}
+		return true
+	})
+	if err == nil && pos == -1 && bit == 0 && !endGiven {
+		pos = int64(bm.size) * 8
+	}
+	return
+}
+
+//BitOp performs a bitwise operation between the strings stored at keys and stores the result in dest,
+//returns the size of the string stored in dest.
+func (tidis *Tidis) BitOp(txn interface{}, op byte, dest []byte, keys ...[]byte) (size int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		sources  []*bitmap
+		bm       *bitmap
+		maxSize  uint64
+		destType byte
+		dest_bm  *bitmap
+		version  uint64
+		chunks   uint64
+		chunk    []byte
+		result   []byte
+	)
+	if len(dest) == 0 || len(keys) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if op == BitopNot && len(keys) != 1 {
+		err = qkverror.ErrorBitopNot
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			size, err = tidis.BitOp(txn, op, dest, keys...)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	sources = make([]*bitmap, len(keys))
+	for i, key := range keys {
+		bm, err = tidis.loadBitmap(txn, key)
+		if err != nil {
+			return
+		}
+		if bm.size > maxSize {
+			maxSize = bm.size
+		}
+		sources[i] = bm
+	}
+	//delete dest if expired
+	err = tidis.DeleteIfExpired(txn, dest, true)
+	if err != nil {
+		return
+	}
+	destType, err = tidis.getType(txn, dest)
+	if err != nil {
+		return
+	}
+	if maxSize == 0 {
+		//the result is an empty string
+		if destType != utils.NONE_TYPE {
+			_, err = tidis.Delete(txn, [][]byte{dest})
+		}
+		return
+	}
+	version = tidis.newVersion(txn)
+	if destType != utils.NONE_TYPE {
+		if destType == utils.BITMAP_TYPE {
+			dest_bm, err = tidis.loadBitmap(txn, dest)
+			if err != nil {
+				return
+			}
+		}
+		//the chunks of a bitmap created in this transaction are overwritten below
+		if dest_bm == nil || dest_bm.version != version {
+			err = tidis.clearMembers(tikv_txn, dest, destType)
+			if err != nil {
+				return
+			}
+		}
+		err = tidis.removeMetaKey(txn, dest)
+		if err != nil {
+			return
+		}
+	}
+	chunks = (maxSize + bitmapChunkSize - 1) / bitmapChunkSize
+	for i := uint64(0); i < chunks; i++ {
+		result = make([]byte, bitmapChunkSize)
+		if i == chunks-1 {
+			result = result[:maxSize-i*bitmapChunkSize]
+		}
+		for j, src := range sources {
+			chunk, err = tidis.bitmapChunk(txn, src, i)
+			if err != nil {
+				return
+			}
+			if j == 0 {
+				copy(result, chunk)
+				if op == BitopNot {
+					for k := range result {
+						result[k] = ^result[k]
+					}
+				}
+				continue
+			}
+			for k := range result {
+				var b byte
+				if k < len(chunk) {
+					b = chunk[k]
+				}
+				switch op {
+				case BitopAnd:
+					result[k] &= b
+				case BitopOr:
+					result[k] |= b
+				case BitopXor:
+					result[k] ^= b
+				}
+			}
+		}
+		err = tikv_txn.Set(utils.EncodeBitmapData(dest, version, i), result)
+		if err != nil {
+			return
+		}
+	}
+	if dest_bm != nil && dest_bm.version == version {
+		//chunks after the end of the result
+		err = tidis.deleteBitmapChunks(tikv_txn, dest_bm, chunks)
+		if err != nil {
+			return
+		}
+	}
+	err = tikv_txn.Set(dest, utils.EncodeData(utils.BITMAP_TYPE, tidis.createHashMeta(maxSize, 0, utils.FLAG_NORMAL, version)))
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyString, "set", dest)
+	if err != nil {
+		return
+	}
+	size = int64(maxSize)
+	return
+}
+
+//BitField runs the GET, SET and INCRBY ops on the string value stored at key in order, returns the result of each op.
+//The result of an op failing with the FAIL overflow behavior is nil.
+func (tidis *Tidis) BitField(txn interface{}, key []byte, ops []BitfieldOp) (resp []interface{}, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		bm       *bitmap
+		old      int64
+		value    int64
+		written  bool
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			resp, err = tidis.BitField(txn, key, ops)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	bm, err = tidis.loadBitmap(txn, key)
+	if err != nil {
+		return
+	}
+	resp = make([]interface{}, 0, len(ops))
+	for _, op := range ops {
+		old, err = tidis.getBits(txn, bm, op.Offset, op.Bits, op.Signed)
+		if err != nil {
+			return
+		}
+		if op.Kind == BitfieldGet {
+			resp = append(resp, old)
+			continue
+		}
+		if op.Kind == BitfieldSet {
+			value, ok = bitfieldOverflow(op.Value, 0, op.Bits, op.Signed, op.Overflow)
+		} else {
+			value, ok = bitfieldOverflow(old, op.Value, op.Bits, op.Signed, op.Overflow)
+		}
+		if !ok {
+			resp = append(resp, nil)
+			continue
+		}
+		err = tidis.setBits(txn, bm, op.Offset, op.Bits, uint64(value))
+		if err != nil {
+			return
+		}
+		written = true
+		if op.Kind == BitfieldSet {
+			resp = append(resp, old)
+		} else {
+			resp = append(resp, value)
+		}
+	}
+	if !written {
+		return
+	}
+	err = tidis.saveBitmap(tikv_txn, bm)
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyString, "setbit", key)
+	return
+}
+
+//getBitmapValue the string stored in the chunks of the bitmap at key
+func (tidis *Tidis) getBitmapValue(txn interface{}, key []byte) (value []byte, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		bm       *bitmap
+	)
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			value, err = tidis.getBitmapValue(txn, key)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	bm, err = tidis.loadBitmap(txn, key)
+	if err != nil || !bm.exists {
+		return
+	}
+	value = make([]byte, 0, bm.size)
+	err = tidis.scanBitmap(tikv_txn, bm, 0, bm.size, func(offset uint64, data []byte) bool {
+		value = append(value, data...)
+		return true
+	})
+	return
+}
+
+//overwriteString check that a string value can be written over the value of dataType at key,
+//the chunks of a bitmap are purged by the gc
+func (tidis *Tidis) overwriteString(txn interface{}, key []byte, dataType byte) (err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+	)
+	switch dataType {
+	case utils.STRING_TYPE, utils.HLL_TYPE:
+		return
+	case utils.BITMAP_TYPE:
+		tikv_txn, ok = txn.(kv.Transaction)
+		if !ok {
+			err = qkverror.ErrorServerInternal
+			return
+		}
+		err = tidis.clearMembers(tikv_txn, key, dataType)
+	default:
+		err = qkverror.ErrorWrongType
+	}
+	return
+}
+
+//loadBitmap the string value stored at key, WRONGTYPE for the other types
+func (tidis *Tidis) loadBitmap(txn interface{}, key []byte) (bm *bitmap, err error) {
+	var (
+		rawData  []byte
+		dataType byte
+		value    []byte
+	)
+	bm = &bitmap{key: key, chunks: make(map[uint64][]byte)}
+	//delete string if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	rawData, err = tidis.db.Get(txn, key)
+	if err != nil || rawData == nil {
+		return
+	}
+	dataType, value, err = utils.DecodeData(rawData)
+	if err != nil {
+		return
+	}
+	switch dataType {
+	case utils.STRING_TYPE, utils.HLL_TYPE:
+		bm.str = value
+		bm.size = uint64(len(value))
+	case utils.BITMAP_TYPE:
+		bm.size, _, _, bm.version, err = decodeHashMeta(value)
+		bm.chunked = true
+	default:
+		err = qkverror.ErrorWrongType
+	}
+	bm.exists = err == nil
+	return
+}
+
+//bitmapChunk the bytes of chunk index, shorter than bitmapChunkSize or nil when the end of the chunk is not written
+func (tidis *Tidis) bitmapChunk(txn interface{}, bm *bitmap, index uint64) (chunk []byte, err error) {
+	var (
+		ok   bool
+		from uint64
+	)
+	if chunk, ok = bm.chunks[index]; ok {
+		return
+	}
+	if !bm.chunked {
+		from = index * bitmapChunkSize
+		if from >= uint64(len(bm.str)) {
+			return
+		}
+		if from+bitmapChunkSize >= uint64(len(bm.str)) {
+			return bm.str[from:], nil
+		}
+		return bm.str[from : from+bitmapChunkSize], nil
+	}
+	return tidis.db.Get(txn, utils.EncodeBitmapData(bm.key, bm.version, index))
+}
+
+//scanBitmap call f with the bytes of bm in [from, to) chunk by chunk until it returns false, unwritten bytes are zeros
+func (tidis *Tidis) scanBitmap(txn kv.Transaction, bm *bitmap, from, to uint64, f func(offset uint64, data []byte) bool) (err error) {
+	var (
+		it          kv.Iterator
+		endKey      kv.Key
+		index       uint64
+		chunkIndex  uint64
+		chunk       []byte
+		start, stop uint64
+		data        []byte
+	)
+	if to > bm.size {
+		to = bm.size
+	}
+	if from >= to {
+		return
+	}
+	if !bm.chunked {
+		f(from, bm.str[from:to])
+		return
+	}
+	endKey = utils.EncodeBitmapData(bm.key, bm.version, (to-1)/bitmapChunkSize)
+	it, err = txn.Seek(utils.EncodeBitmapData(bm.key, bm.version, from/bitmapChunkSize))
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for from < to {
+		index = from / bitmapChunkSize
+		chunk = nil
+		//chunks are only written where bits are set
+		for it.Valid() && it.Key().Cmp(endKey) <= 0 {
+			_, chunkIndex, err = utils.DecodeBitmapData(it.Key())
+			if err != nil {
+				return
+			}
+			if chunkIndex == index {
+				chunk = it.Value()
+			}
+			if chunkIndex >= index {
+				break
+			}
+			if err = it.Next(); err != nil {
+				return
+			}
+		}
+		start = from - index*bitmapChunkSize
+		stop = bitmapChunkSize
+		if (index+1)*bitmapChunkSize > to {
+			stop = to - index*bitmapChunkSize
+		}
+		switch {
+		case uint64(len(chunk)) >= stop:
+			data = chunk[start:stop]
+		case uint64(len(chunk)) <= start:
+			data = bitmapZeros[start:stop]
+		default:
+			data = make([]byte, stop-start)
+			copy(data, chunk[start:])
+		}
+		if !f(from, data) {
+			return
+		}
+		from = from - start + stop
+	}
+	return
+}
+
+//getBit the bit at offset, chunks written by the command included
+func (tidis *Tidis) getBit(txn interface{}, bm *bitmap, offset uint64) (bit byte, err error) {
+	var (
+		chunk []byte
+		pos   uint64
+	)
+	chunk, err = tidis.bitmapChunk(txn, bm, offset/8/bitmapChunkSize)
+	if err != nil {
+		return
+	}
+	pos = offset / 8 % bitmapChunkSize
+	if pos < uint64(len(chunk)) {
+		bit = chunk[pos] >> (7 - offset%8) & 1
+	}
+	return
+}
+
+//setBit set the bit at offset in the chunks of bm, returns the original bit
+func (tidis *Tidis) setBit(txn interface{}, bm *bitmap, offset uint64, on bool) (old byte, err error) {
+	var (
+		index uint64
+		pos   uint64
+		chunk []byte
+		ok    bool
+		mask  byte
+	)
+	if !bm.chunked {
+		tidis.chunkBitmap(txn, bm)
+	}
+	index = offset / 8 / bitmapChunkSize
+	pos = offset / 8 % bitmapChunkSize
+	if chunk, ok = bm.chunks[index]; !ok {
+		chunk, err = tidis.bitmapChunk(txn, bm, index)
+		if err != nil {
+			return
+		}
+		chunk = append([]byte(nil), chunk...)
+	}
+	if uint64(len(chunk)) <= pos {
+		chunk = append(chunk, make([]byte, pos+1-uint64(len(chunk)))...)
+	}
+	mask = 1 << (7 - offset%8)
+	if chunk[pos]&mask != 0 {
+		old = 1
+	}
+	if on {
+		chunk[pos] |= mask
+	} else {
+		chunk[pos] &^= mask
+	}
+	bm.chunks[index] = chunk
+	if offset/8+1 > bm.size {
+		bm.size = offset/8 + 1
+	}
+	return
+}
+
+//getBits the integer of n bits at offset, most significant bit first
+func (tidis *Tidis) getBits(txn interface{}, bm *bitmap, offset uint64, n uint, signed bool) (value int64, err error) {
+	var (
+		raw uint64
+		bit byte
+	)
+	for i := uint(0); i < n; i++ {
+		bit, err = tidis.getBit(txn, bm, offset+uint64(i))
+		if err != nil {
+			return
+		}
+		raw = raw<<1 | uint64(bit)
+	}
+	if signed && n < 64 && raw&(1<<(n-1)) != 0 {
+		//sign extension
+		raw |= ^uint64(0) << n
+	}
+	value = int64(raw)
+	return
+}
+
+//setBits write the low n bits of value at offset
+func (tidis *Tidis) setBits(txn interface{}, bm *bitmap, offset uint64, n uint, value uint64) (err error) {
+	for i := uint(0); i < n; i++ {
+		_, err = tidis.setBit(txn, bm, offset+uint64(i), value>>(n-1-i)&1 == 1)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+//chunkBitmap turn a plain string value or a new key into a chunked bitmap, the chunks are written by saveBitmap
+func (tidis *Tidis) chunkBitmap(txn interface{}, bm *bitmap) {
+	var (
+		chunk []byte
+	)
+	for i := uint64(0); i*bitmapChunkSize < uint64(len(bm.str)); i++ {
+		chunk, _ = tidis.bitmapChunk(txn, bm, i)
+		bm.chunks[i] = append([]byte(nil), chunk...)
+	}
+	bm.str = nil
+	bm.version = tidis.newVersion(txn)
+	bm.chunked = true
+}
+
+//saveBitmap write the chunks changed by the command and the meta of bm
+func (tidis *Tidis) saveBitmap(txn kv.Transaction, bm *bitmap) (err error) {
+	for index, chunk := range bm.chunks {
+		err = txn.Set(utils.EncodeBitmapData(bm.key, bm.version, index), chunk)
+		if err != nil {
+			return
+		}
+	}
+	err = txn.Set(bm.key, utils.EncodeData(utils.BITMAP_TYPE, tidis.createHashMeta(bm.size, 0, utils.FLAG_NORMAL, bm.version)))
+	return
+}
+
+//deleteBitmapChunks delete the chunks of bm from the chunk index from
+func (tidis *Tidis) deleteBitmapChunks(txn kv.Transaction, bm *bitmap, from uint64) (err error) {
+	var (
+		it     kv.Iterator
+		prefix []byte
+	)
+	prefix = utils.EncodeMemberPrefix(utils.BITMAP_DATA, bm.key, bm.version)
+	it, err = txn.Seek(utils.EncodeBitmapData(bm.key, bm.version, from))
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for it.Valid() && bytes.HasPrefix(it.Key(), prefix) {
+		if err = txn.Delete(it.Key()); err != nil {
+			return
+		}
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//bitmapRange normalize the start and end indexes of BITCOUNT and BITPOS for a string of size bytes,
+//returns the bytes holding them. ok is false when the range is empty.
+func bitmapRange(size uint64, start, end int64, bitMode bool) (rstart, rend int64, firstByte, lastByte uint64, ok bool) {
+	var (
+		total int64
+	)
+	total = int64(size)
+	if bitMode {
+		total = total * 8
+	}
+	if start < 0 {
+		start = start + total
+	}
+	if end < 0 {
+		end = end + total
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= total {
+		end = total - 1
+	}
+	if total == 0 || start > end {
+		return
+	}
+	rstart, rend, ok = start, end, true
+	firstByte, lastByte = uint64(start), uint64(end)
+	if bitMode {
+		firstByte, lastByte = uint64(start/8), uint64(end/8)
+	}
+	return
+}
+
+//bitmapMask bits of the byte at pos inside the bit range [start, end]
+func bitmapMask(pos uint64, start, end int64, firstByte, lastByte uint64) (mask byte) {
+	mask = 0xff
+	if pos == firstByte {
+		mask &= 0xff >> uint(start%8)
+	}
+	if pos == lastByte {
+		mask &= 0xff << uint(7-end%8)
+	}
+	return
+}
+
+//bitfieldOverflow value+incr as an integer of n bits, ok is false if it overflows with BitfieldFail
+func bitfieldOverflow(value, incr int64, n uint, signed bool, overflow byte) (result int64, ok bool) {
+	var (
+		max, min  int64
+		umax      uint64
+		uvalue    uint64
+		up, down  bool
+		wrapped   uint64
+		signedBit uint64
+	)
+	if signed {
+		max = int64(uint64(1)<<(n-1) - 1)
+		min = -max - 1
+		//the same checks as redis, max-value only overflows when value is negative and incr can not overflow
+		up = value > max || (n != 64 && incr > max-value) || (value >= 0 && incr > 0 && incr > max-value)
+		down = value < min || (n != 64 && incr < min-value) || (value < 0 && incr < 0 && incr < min-value)
+		wrapped = uint64(value) + uint64(incr)
+		if n < 64 {
+			signedBit = uint64(1) << (n - 1)
+			if wrapped&signedBit != 0 {
+				wrapped |= ^uint64(0) << n
+			} else {
+				wrapped &^= ^uint64(0) << n
+			}
+		}
+	} else {
+		umax = uint64(1)<<n - 1
+		uvalue = uint64(value)
+		up = uvalue > umax || (incr > 0 && uint64(incr) > umax-uvalue)
+		down = !up && incr < 0 && uint64(-incr) > uvalue
+		wrapped = (uvalue + uint64(incr)) & umax
+		max = int64(umax)
+	}
+	ok = true
+	if !up && !down {
+		result = int64(wrapped)
+		return
+	}
+	switch overflow {
+	case BitfieldWrap:
+		result = int64(wrapped)
+	case BitfieldSat:
+		if up {
+			result = max
+		} else {
+			result = min
+		}
+	default:
+		ok = false
+	}
+	return
+}
@@ -0,0 +1,266 @@
+package tidis
+
+import (
+	"sort"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+)
+
+//order of the results of GeoSearch
+const (
+	GeoSortNone byte = iota
+	GeoSortAsc
+	GeoSortDesc
+)
+
+//GeoPoint a member of a geo set and its position, stored in a zset with the geohash as score
+type GeoPoint struct {
+	Member    []byte
+	Longitude float64
+	Latitude  float64
+}
+
+//GeoShape the area of GEOSEARCH in meters, centered at the position of Member if it is set.
+//A box is Width by Height, a circle has Radius.
+type GeoShape struct {
+	Member    []byte
+	Longitude float64
+	Latitude  float64
+	Box       bool
+	Radius    float64
+	Width     float64
+	Height    float64
+}
+
+//GeoResult a member found by GeoSearch, Dist is its distance to the center in meters
+type GeoResult struct {
+	Member    []byte
+	Dist      float64
+	Hash      uint64
+	Longitude float64
+	Latitude  float64
+}
+
+//GeoAdd adds the members with their positions to the geo set stored at key, returns the number of members added,
+//with changed the members whose position changed are counted too.
+func (tidis *Tidis) GeoAdd(txn interface{}, key []byte, nx, xx, changed bool, points ...GeoPoint) (ret int64, err error) {
+	var (
+		pairs   []*ZSetPair
+		indexes = make(map[string]int, len(points))
+		exists  []bool
+		scores  []float64
+		score   float64
+		found   bool
+		i       int
+		ok      bool
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.GeoAdd(txn, key, nx, xx, changed, points...)
+			return
+		})
+		return
+	}
+	for _, point := range points {
+		if !utils.GeoValid(point.Longitude, point.Latitude) {
+			err = qkverror.ErrorGeoPosition
+			return
+		}
+		//the last position of a member given twice wins
+		if i, ok = indexes[string(point.Member)]; ok {
+			pairs[i].Score = float64(utils.GeoEncode(point.Longitude, point.Latitude))
+			continue
+		}
+		score, found, err = tidis.ZScore(txn, key, point.Member)
+		if err != nil {
+			return
+		}
+		if (nx && found) || (xx && !found) {
+			continue
+		}
+		indexes[string(point.Member)] = len(pairs)
+		pairs = append(pairs, &ZSetPair{Score: float64(utils.GeoEncode(point.Longitude, point.Latitude)), Key: point.Member})
+		exists = append(exists, found)
+		scores = append(scores, score)
+	}
+	if len(pairs) == 0 {
+		return
+	}
+	for i = range pairs {
+		if !exists[i] || (changed && scores[i] != pairs[i].Score) {
+			ret++
+		}
+	}
+	_, err = tidis.ZAdd(txn, key, pairs...)
+	return
+}
+
+//GeoPos returns the positions of the members of the geo set stored at key, nil for a missing member.
+func (tidis *Tidis) GeoPos(txn interface{}, key []byte, members ...[]byte) (points []*GeoPoint, err error) {
+	var (
+		score  float64
+		exists bool
+		point  *GeoPoint
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	points = make([]*GeoPoint, len(members))
+	for i, member := range members {
+		score, exists, err = tidis.ZScore(txn, key, member)
+		if err != nil {
+			return
+		}
+		if !exists {
+			continue
+		}
+		point = &GeoPoint{Member: member}
+		point.Longitude, point.Latitude = utils.GeoDecode(uint64(score))
+		points[i] = point
+	}
+	return
+}
+
+//GeoDist returns the distance in meters between two members of the geo set stored at key, ok is false if one is missing.
+func (tidis *Tidis) GeoDist(txn interface{}, key, member1, member2 []byte) (dist float64, ok bool, err error) {
+	var (
+		points []*GeoPoint
+	)
+	points, err = tidis.GeoPos(txn, key, member1, member2)
+	if err != nil || points[0] == nil || points[1] == nil {
+		return
+	}
+	dist = utils.GeoDistance(points[0].Longitude, points[0].Latitude, points[1].Longitude, points[1].Latitude)
+	ok = true
+	return
+}
+
+//GeoSearch returns the members of the geo set stored at key inside shape, at most count of them if count is positive.
+//With any the search stops as soon as count members are found.
+func (tidis *Tidis) GeoSearch(txn interface{}, key []byte, shape GeoShape, order byte, count int, any bool) (results []GeoResult, err error) {
+	var (
+		zsize            uint64
+		version          uint64
+		center           []*GeoPoint
+		width, height    float64
+		startKey, endKey []byte
+		members          [][]byte
+		result           GeoResult
+		score            float64
+		ok               bool
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if shape.Member != nil {
+		center, err = tidis.GeoPos(txn, key, shape.Member)
+		if err != nil {
+			return
+		}
+		if center[0] == nil {
+			err = qkverror.ErrorGeoMember
+			return
+		}
+		shape.Longitude, shape.Latitude = center[0].Longitude, center[0].Latitude
+	}
+	zsize, _, _, version, err = tidis.getZSetMeta(txn, key)
+	if err != nil || zsize == 0 {
+		return
+	}
+	width, height = shape.Width, shape.Height
+	if !shape.Box {
+		width, height = 2*shape.Radius, 2*shape.Radius
+	}
+	//only the cells around the center are scanned
+	for _, r := range utils.GeoRanges(shape.Longitude, shape.Latitude, width, height) {
+		startKey, endKey = zScoreRangeKeys(key, version, ZScoreBound{Score: float64(r[0])}, ZScoreBound{Score: float64(r[1]), Exclusive: true})
+		members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, endKey, false, 0, zsize, false)
+		if err != nil {
+			return
+		}
+		for _, m := range members {
+			_, result.Member, score, err = utils.DecodeZSetScore(m)
+			if err != nil {
+				return
+			}
+			result.Hash = uint64(score)
+			result.Longitude, result.Latitude = utils.GeoDecode(result.Hash)
+			if shape.Box {
+				result.Dist, ok = utils.GeoInBox(shape.Width, shape.Height, shape.Longitude, shape.Latitude, result.Longitude, result.Latitude)
+			} else {
+				result.Dist = utils.GeoDistance(shape.Longitude, shape.Latitude, result.Longitude, result.Latitude)
+				ok = result.Dist <= shape.Radius
+			}
+			if !ok {
+				continue
+			}
+			results = append(results, result)
+			if any && count > 0 && len(results) >= count {
+				break
+			}
+		}
+		if any && count > 0 && len(results) >= count {
+			break
+		}
+	}
+	switch order {
+	case GeoSortAsc:
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Dist < results[j].Dist })
+	case GeoSortDesc:
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Dist > results[j].Dist })
+	}
+	if count > 0 && len(results) > count {
+		results = results[:count]
+	}
+	return
+}
+
+//GeoSearchStore stores the members found by GeoSearch in the zset dest, scored by their distance in unit meters
+//if storeDist is set, or by their geohash. Returns the number of members stored.
+func (tidis *Tidis) GeoSearchStore(txn interface{}, dest, key []byte, shape GeoShape, order byte, count int, any bool, storeDist bool, unit float64) (stored int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		results  []GeoResult
+		pairs    []*ZSetPair
+		score    float64
+	)
+	if len(dest) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			stored, err = tidis.GeoSearchStore(txn, dest, key, shape, order, count, any, storeDist, unit)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	results, err = tidis.GeoSearch(txn, key, shape, order, count, any)
+	if err != nil {
+		return
+	}
+	pairs = make([]*ZSetPair, len(results))
+	for i, result := range results {
+		score = float64(result.Hash)
+		if storeDist {
+			score = result.Dist / unit
+		}
+		pairs[i] = &ZSetPair{Score: score, Key: result.Member}
+	}
+	stored, err = tidis.zStore(tikv_txn, dest, pairs, "georadiusstore")
+	return
+}
@@ -1,9 +1,10 @@
 package tidis
 
 import (
-	"context"
-
+	"bytes"
+	"math"
 	"strconv"
+	"time"
 
 	"github.com/chuangyou/qkv/qkverror"
 	"github.com/chuangyou/qkv/utils"
@@ -13,52 +14,48 @@ import (
 //HDel removes the specified fields from the hash stored at key.
 func (tidis *Tidis) HDel(txn interface{}, key []byte, fields ...[]byte) (deleted int64, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		ttl            uint64
-		hsize          uint64
-		field          []byte
-		hashDataKey    []byte
-		value          []byte
-		hashMetaValue  []byte
+		tikv_txn      kv.Transaction
+		ok            bool
+		ttl           uint64
+		hsize         uint64
+		field         []byte
+		hashDataKey   []byte
+		value         []byte
+		hashMetaValue []byte
+		version       uint64
+		reaped        bool
 	)
 	if len(key) == 0 || len(fields) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			deleted, err = tidis.HDel(txn, key, fields...)
 			return
-		}
+		})
+		return
 	}
-	hsize, ttl, _, err = tidis.getHashMetaWithType(txn, key)
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	hsize, ttl, _, version, err = tidis.getHashMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
 	for _, field = range fields {
 		//encode hash field key
-		hashDataKey = utils.EncodeHashData(key, field)
-		//get field value
-		value, err = tidis.db.Get(txn, hashDataKey)
+		hashDataKey = utils.EncodeHashData(key, version, field)
+		//get field value, its ttl is removed with it
+		value, reaped, err = tidis.prepareHashField(tikv_txn, key, version, field, false)
 		if err != nil {
 			return
 		}
+		if reaped {
+			hsize--
+		}
 		if value != nil {
 			deleted++
 			err = tikv_txn.Delete(hashDataKey)
@@ -70,7 +67,7 @@ func (tidis *Tidis) HDel(txn interface{}, key []byte, fields ...[]byte) (deleted
 	hsize = hsize - uint64(deleted)
 	if hsize > 0 {
 		//update meta
-		hashMetaValue = tidis.createHashMeta(hsize, ttl, utils.FLAG_NORMAL)
+		hashMetaValue = tidis.createHashMeta(hsize, ttl, utils.FLAG_NORMAL, version)
 		//encode hash type
 		hashMetaValue = utils.EncodeData(utils.HASH_TYPE, hashMetaValue)
 		err = tikv_txn.Set(key, hashMetaValue)
@@ -83,12 +80,14 @@ func (tidis *Tidis) HDel(txn interface{}, key []byte, fields ...[]byte) (deleted
 			return
 		}
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
+	if deleted == 0 {
+		return
 	}
+	err = tidis.notify(txn, notifyHash, "hdel", key)
+	if err != nil || hsize > 0 {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "del", key)
 	return
 }
 
@@ -112,6 +111,8 @@ func (tidis *Tidis) HExists(txn interface{}, key, field []byte) (ret int64, err
 func (tidis *Tidis) HGet(txn interface{}, key, field []byte) (value []byte, err error) {
 	var (
 		hashDataKey []byte
+		version     uint64
+		expireAt    int64
 	)
 	if len(key) == 0 || len(field) == 0 {
 		err = qkverror.ErrorKeyEmpty
@@ -122,7 +123,15 @@ func (tidis *Tidis) HGet(txn interface{}, key, field []byte) (value []byte, err
 	if err != nil {
 		return
 	}
-	hashDataKey = utils.EncodeHashData(key, field)
+	_, _, _, version, err = tidis.getHashMetaWithType(txn, key)
+	if err != nil {
+		return
+	}
+	expireAt, err = tidis.hashFieldTTL(txn, key, version, field)
+	if err != nil || hashFieldExpired(expireAt) {
+		return
+	}
+	hashDataKey = utils.EncodeHashData(key, version, field)
 	value, err = tidis.db.Get(txn, hashDataKey)
 	return
 }
@@ -133,6 +142,9 @@ func (tidis *Tidis) HGetAll(txn interface{}, key []byte) (kvs []interface{}, err
 		hsize       uint64
 		hashDataKey []byte
 		members     [][]byte
+		version     uint64
+		field       []byte
+		expired     map[string]bool
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
@@ -143,7 +155,7 @@ func (tidis *Tidis) HGetAll(txn interface{}, key []byte) (kvs []interface{}, err
 	if err != nil {
 		return
 	}
-	hsize, _, _, err = tidis.getHashMetaWithType(txn, key)
+	hsize, _, _, version, err = tidis.getHashMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
@@ -151,18 +163,22 @@ func (tidis *Tidis) HGetAll(txn interface{}, key []byte) (kvs []interface{}, err
 		kvs = utils.EmptyListInterfaces
 		return
 	}
-	hashDataKey = utils.EncodeHashData(key, nil)
+	expired, err = tidis.expiredHashFields(txn, key, version, hsize)
+	if err != nil {
+		return
+	}
+	hashDataKey = utils.EncodeHashData(key, version, nil)
 	members, err = tidis.db.GetRangeKeysValues(txn, hashDataKey, nil, hsize, true)
 	if err != nil {
 		return
 	}
-	kvs = make([]interface{}, len(members))
-	for i := 0; i < len(members); i = i + 1 {
-		if i%2 == 0 {
-			_, kvs[i], _ = utils.DecodeHashData(members[i])
-		} else {
-			kvs[i] = members[i]
+	kvs = make([]interface{}, 0, len(members))
+	for i := 0; i < len(members)-1; i = i + 2 {
+		_, field, _ = utils.DecodeHashData(members[i])
+		if expired[string(field)] {
+			continue
 		}
+		kvs = append(kvs, field, members[i+1])
 	}
 	return
 }
@@ -170,41 +186,34 @@ func (tidis *Tidis) HGetAll(txn interface{}, key []byte) (kvs []interface{}, err
 //HIncrby increments the number stored at field in the hash stored at key by increment.
 func (tidis *Tidis) HIncrby(txn interface{}, key []byte, field []byte, step int64) (resp int64, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		hsize          uint64
-		ttl            uint64
-		newValue       int64
-		newValueRaw    []byte
-		hashDataKey    []byte
-		oldRaw         []byte
-		oldValue       int64
-		hashMetaValue  []byte
+		tikv_txn      kv.Transaction
+		ok            bool
+		hsize         uint64
+		ttl           uint64
+		newValue      int64
+		newValueRaw   []byte
+		hashDataKey   []byte
+		oldRaw        []byte
+		oldValue      int64
+		hashMetaValue []byte
+		version       uint64
+		reaped        bool
 	)
 	if len(key) == 0 || len(field) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			resp, err = tidis.HIncrby(txn, key, field, step)
 			return
-		}
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
 	//delete hash if expired
 	err = tidis.DeleteIfExpired(txn, key, true)
@@ -212,16 +221,22 @@ func (tidis *Tidis) HIncrby(txn interface{}, key []byte, field []byte, step int6
 		return
 	}
 	//get hash meta
-	hsize, _, _, err = tidis.getHashMetaWithType(txn, key)
+	hsize, _, _, version, err = tidis.getHashMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
-	hashDataKey = utils.EncodeHashData(key, field)
-	//hash field value
-	oldRaw, err = tidis.db.Get(txn, hashDataKey)
+	if hsize == 0 {
+		version = tidis.newVersion(txn)
+	}
+	hashDataKey = utils.EncodeHashData(key, version, field)
+	//hash field value, the ttl of field is kept
+	oldRaw, reaped, err = tidis.prepareHashField(tikv_txn, key, version, field, true)
 	if err != nil {
 		return
 	}
+	if reaped {
+		hsize--
+	}
 	if oldRaw == nil {
 		hsize++
 		newValue = step
@@ -232,7 +247,7 @@ func (tidis *Tidis) HIncrby(txn interface{}, key []byte, field []byte, step int6
 			return
 		}
 		//update meta
-		hashMetaValue = tidis.createHashMeta(hsize, ttl, utils.FLAG_NORMAL)
+		hashMetaValue = tidis.createHashMeta(hsize, ttl, utils.FLAG_NORMAL, version)
 		//encode hash type
 		hashMetaValue = utils.EncodeData(utils.HASH_TYPE, hashMetaValue)
 		err = tikv_txn.Set(key, hashMetaValue)
@@ -251,11 +266,9 @@ func (tidis *Tidis) HIncrby(txn interface{}, key []byte, field []byte, step int6
 		}
 
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
+	err = tidis.notify(txn, notifyHash, "hincrby", key)
+	if err != nil {
+		return
 	}
 	resp = newValue
 	return
@@ -267,8 +280,10 @@ func (tidis *Tidis) HKeys(txn interface{}, key []byte) (keys []interface{}, err
 		hsize    uint64
 		startKey []byte
 		members  [][]byte
-		i        int
 		hashKey  []byte
+		version  uint64
+		field    []byte
+		expired  map[string]bool
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
@@ -279,7 +294,7 @@ func (tidis *Tidis) HKeys(txn interface{}, key []byte) (keys []interface{}, err
 	if err != nil {
 		return
 	}
-	hsize, _, _, err = tidis.getHashMetaWithType(txn, key)
+	hsize, _, _, version, err = tidis.getHashMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
@@ -287,14 +302,21 @@ func (tidis *Tidis) HKeys(txn interface{}, key []byte) (keys []interface{}, err
 		keys = utils.EmptyListInterfaces
 		return
 	}
-	startKey = utils.EncodeHashData(key, nil)
+	expired, err = tidis.expiredHashFields(txn, key, version, hsize)
+	if err != nil {
+		return
+	}
+	startKey = utils.EncodeHashData(key, version, nil)
 	members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, nil, true, 0, hsize, false)
 	if err != nil {
 		return
 	}
-	keys = make([]interface{}, len(members))
-	for i, hashKey = range members {
-		_, keys[i], _ = utils.DecodeHashData(hashKey)
+	keys = make([]interface{}, 0, len(members))
+	for _, hashKey = range members {
+		_, field, _ = utils.DecodeHashData(hashKey)
+		if !expired[string(field)] {
+			keys = append(keys, field)
+		}
 	}
 	return
 }
@@ -302,7 +324,9 @@ func (tidis *Tidis) HKeys(txn interface{}, key []byte) (keys []interface{}, err
 //HLen returns the number of fields contained in the hash stored at key.
 func (tidis *Tidis) HLen(txn interface{}, key []byte) (size int64, err error) {
 	var (
-		hsize uint64
+		hsize   uint64
+		version uint64
+		expired map[string]bool
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
@@ -313,11 +337,16 @@ func (tidis *Tidis) HLen(txn interface{}, key []byte) (size int64, err error) {
 	if err != nil {
 		return
 	}
-	hsize, _, _, err = tidis.getHashMetaWithType(txn, key)
+	hsize, _, _, version, err = tidis.getHashMetaWithType(txn, key)
+	if err != nil || hsize == 0 {
+		return
+	}
+	//fields expired but not removed by the ttl checker yet are still counted in the meta
+	expired, err = tidis.expiredHashFields(txn, key, version, hsize)
 	if err != nil {
 		return
 	}
-	size = int64(hsize)
+	size = int64(hsize) - int64(len(expired))
 	return
 }
 
@@ -332,6 +361,10 @@ func (tidis *Tidis) HMGet(txn interface{}, key []byte, fields ...[]byte) (resp [
 		item         []byte
 		value        []byte
 		ok           bool
+		version      uint64
+		ttlKeys      [][]byte
+		ttls         map[string][]byte
+		expireAt     int64
 	)
 	if len(key) == 0 || len(fields) == 0 {
 		err = qkverror.ErrorKeyEmpty
@@ -342,17 +375,31 @@ func (tidis *Tidis) HMGet(txn interface{}, key []byte, fields ...[]byte) (resp [
 	if err != nil {
 		return
 	}
+	_, _, _, version, err = tidis.getHashMetaWithType(txn, key)
+	if err != nil {
+		return
+	}
 	hashDataKeys = make([][]byte, len(fields))
+	ttlKeys = make([][]byte, len(fields))
 	for i, field = range fields {
-		hashDataKeys[i] = utils.EncodeHashData(key, field)
+		hashDataKeys[i] = utils.EncodeHashData(key, version, field)
+		ttlKeys[i] = utils.EncodeHashFieldTTL(key, version, field)
 	}
 	dataM, err = tidis.db.MGet(txn, hashDataKeys)
 	if err != nil {
 		return
 	}
+	ttls, err = tidis.db.MGet(txn, ttlKeys)
+	if err != nil {
+		return
+	}
 	resp = make([]interface{}, len(fields))
 	for x, item = range hashDataKeys {
 		value, ok = dataM[string(item)]
+		if ok && ttls[string(ttlKeys[x])] != nil {
+			expireAt, _ = utils.BytesToInt64(ttls[string(ttlKeys[x])])
+			ok = !hashFieldExpired(expireAt)
+		}
 		if ok {
 			resp[x] = value
 		} else {
@@ -365,40 +412,31 @@ func (tidis *Tidis) HMGet(txn interface{}, key []byte, fields ...[]byte) (resp [
 //HMSet sets the specified fields to their respective values in the hash stored at key.
 func (tidis *Tidis) HMSet(txn interface{}, key []byte, fieldsAndValues ...[]byte) (err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		ttl            uint64
-		hsize          uint64
-		field          []byte
-		value          []byte
-		hashDataKey    []byte
-		oldValue       []byte
-		hashMetaValue  []byte
+		tikv_txn      kv.Transaction
+		ok            bool
+		ttl           uint64
+		hsize         uint64
+		field         []byte
+		value         []byte
+		hashDataKey   []byte
+		oldValue      []byte
+		hashMetaValue []byte
+		version       uint64
+		reaped        bool
 	)
 	if len(key) == 0 || len(fieldsAndValues)%2 != 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
+		return tidis.RetryTxn(func(txn interface{}) error {
+			return tidis.HMSet(txn, key, fieldsAndValues...)
+		})
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
 	//delete hash if expired
 	err = tidis.DeleteIfExpired(txn, key, true)
@@ -406,17 +444,24 @@ func (tidis *Tidis) HMSet(txn interface{}, key []byte, fieldsAndValues ...[]byte
 		return
 	}
 	//get meta
-	hsize, ttl, _, err = tidis.getHashMetaWithType(txn, key)
+	hsize, ttl, _, version, err = tidis.getHashMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
+	if hsize == 0 {
+		version = tidis.newVersion(txn)
+	}
 	for i := 0; i < len(fieldsAndValues)-1; i = i + 2 {
 		field, value = fieldsAndValues[i], fieldsAndValues[i+1]
-		hashDataKey = utils.EncodeHashData(key, field)
-		oldValue, err = tidis.db.Get(txn, hashDataKey)
+		hashDataKey = utils.EncodeHashData(key, version, field)
+		//the ttl of an overwritten field is removed
+		oldValue, reaped, err = tidis.prepareHashField(tikv_txn, key, version, field, false)
 		if err != nil {
 			return
 		}
+		if reaped {
+			hsize--
+		}
 		if oldValue == nil {
 			hsize++
 		}
@@ -427,80 +472,74 @@ func (tidis *Tidis) HMSet(txn interface{}, key []byte, fieldsAndValues ...[]byte
 		}
 	}
 	//update meta
-	hashMetaValue = tidis.createHashMeta(hsize, ttl, utils.FLAG_NORMAL)
+	hashMetaValue = tidis.createHashMeta(hsize, ttl, utils.FLAG_NORMAL, version)
 	//encode hash type
 	hashMetaValue = utils.EncodeData(utils.HASH_TYPE, hashMetaValue)
 	err = tikv_txn.Set(key, hashMetaValue)
 	if err != nil {
 		return
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
-	}
+	err = tidis.notify(txn, notifyHash, "hset", key)
 	return
 }
 
 //HSet sets field in the hash stored at key to value.
 func (tidis *Tidis) HSet(txn interface{}, key, field, value []byte) (ret int64, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		ttl            uint64
-		hsize          uint64
-		hashDataKey    []byte
-		oldValue       []byte
-		hashMetaValue  []byte
+		tikv_txn      kv.Transaction
+		ok            bool
+		ttl           uint64
+		hsize         uint64
+		hashDataKey   []byte
+		oldValue      []byte
+		hashMetaValue []byte
+		version       uint64
+		reaped        bool
 	)
 	if len(key) == 0 || len(field) == 0 || len(value) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.HSet(txn, key, field, value)
 			return
-		}
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
 	//delete hash if expired
 	err = tidis.DeleteIfExpired(txn, key, true)
 	if err != nil {
 		return
 	}
-	hsize, ttl, _, err = tidis.getHashMetaWithType(txn, key)
+	hsize, ttl, _, version, err = tidis.getHashMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
-	hashDataKey = utils.EncodeHashData(key, field)
-	//hash field value
-	oldValue, err = tidis.db.Get(txn, hashDataKey)
+	if hsize == 0 {
+		version = tidis.newVersion(txn)
+	}
+	hashDataKey = utils.EncodeHashData(key, version, field)
+	//hash field value, the ttl of an overwritten field is removed
+	oldValue, reaped, err = tidis.prepareHashField(tikv_txn, key, version, field, false)
 	if err != nil {
 		return
 	}
+	if reaped {
+		hsize--
+	}
 	if oldValue != nil {
 		ret = 0
 	} else {
 		ret = 1
 		hsize++
 		//update meta
-		hashMetaValue = tidis.createHashMeta(hsize, ttl, utils.FLAG_NORMAL)
+		hashMetaValue = tidis.createHashMeta(hsize, ttl, utils.FLAG_NORMAL, version)
 		//encode hash type
 		hashMetaValue = utils.EncodeData(utils.HASH_TYPE, hashMetaValue)
 		err = tikv_txn.Set(key, hashMetaValue)
@@ -513,12 +552,7 @@ func (tidis *Tidis) HSet(txn interface{}, key, field, value []byte) (ret int64,
 	if err != nil {
 		return
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
-	}
+	err = tidis.notify(txn, notifyHash, "hset", key)
 	return
 
 }
@@ -526,53 +560,52 @@ func (tidis *Tidis) HSet(txn interface{}, key, field, value []byte) (ret int64,
 //HSetNX sets field in the hash stored at key to value, only if field does not yet exist. If key does not exist, a new key holding a hash is created.
 func (tidis *Tidis) HSetNX(txn interface{}, key, field, value []byte) (isSeted int64, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		ttl            uint64
-		hsize          uint64
-		hashDataKey    []byte
-		hashMetaValue  []byte
-		oldValue       []byte
+		tikv_txn      kv.Transaction
+		ok            bool
+		ttl           uint64
+		hsize         uint64
+		hashDataKey   []byte
+		hashMetaValue []byte
+		oldValue      []byte
+		version       uint64
+		reaped        bool
 	)
 	if len(key) == 0 || len(field) == 0 || len(value) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			isSeted, err = tidis.HSetNX(txn, key, field, value)
 			return
-		}
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
 	//delete hash if expired
 	err = tidis.DeleteIfExpired(txn, key, true)
 	if err != nil {
 		return
 	}
-	hsize, ttl, _, err = tidis.getHashMetaWithType(txn, key)
+	hsize, ttl, _, version, err = tidis.getHashMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
-	hashDataKey = utils.EncodeHashData(key, field)
-	oldValue, err = tidis.db.Get(txn, hashDataKey)
+	if hsize == 0 {
+		version = tidis.newVersion(txn)
+	}
+	hashDataKey = utils.EncodeHashData(key, version, field)
+	oldValue, reaped, err = tidis.prepareHashField(tikv_txn, key, version, field, true)
 	if err != nil {
 		return
 	}
+	if reaped {
+		hsize--
+	}
 	if oldValue != nil {
 		return
 	}
@@ -583,18 +616,16 @@ func (tidis *Tidis) HSetNX(txn interface{}, key, field, value []byte) (isSeted i
 		return
 	}
 	//update meta
-	hashMetaValue = tidis.createHashMeta(hsize, ttl, utils.FLAG_NORMAL)
+	hashMetaValue = tidis.createHashMeta(hsize, ttl, utils.FLAG_NORMAL, version)
 	//encode hash type
 	hashMetaValue = utils.EncodeData(utils.HASH_TYPE, hashMetaValue)
 	err = tikv_txn.Set(key, hashMetaValue)
 	if err != nil {
 		return
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
+	err = tidis.notify(txn, notifyHash, "hset", key)
+	if err != nil {
+		return
 	}
 	isSeted = 1
 	return
@@ -620,8 +651,9 @@ func (tidis *Tidis) HVals(txn interface{}, key []byte) (values []interface{}, er
 		hsize       uint64
 		members     [][]byte
 		hashDataKey []byte
-		value       []byte
-		i           int
+		version     uint64
+		field       []byte
+		expired     map[string]bool
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
@@ -632,7 +664,7 @@ func (tidis *Tidis) HVals(txn interface{}, key []byte) (values []interface{}, er
 	if err != nil {
 		return
 	}
-	hsize, _, _, err = tidis.getHashMetaWithType(txn, key)
+	hsize, _, _, version, err = tidis.getHashMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
@@ -640,14 +672,21 @@ func (tidis *Tidis) HVals(txn interface{}, key []byte) (values []interface{}, er
 		values = utils.EmptyListInterfaces
 		return
 	}
-	hashDataKey = utils.EncodeHashData(key, nil)
-	members, err = tidis.db.GetRangeKeysValues(txn, hashDataKey, nil, hsize, false)
+	expired, err = tidis.expiredHashFields(txn, key, version, hsize)
 	if err != nil {
 		return
 	}
-	values = make([]interface{}, len(members))
-	for i, value = range members {
-		values[i] = value
+	hashDataKey = utils.EncodeHashData(key, version, nil)
+	members, err = tidis.db.GetRangeKeysValues(txn, hashDataKey, nil, hsize, true)
+	if err != nil {
+		return
+	}
+	values = make([]interface{}, 0, len(members)/2)
+	for i := 0; i < len(members)-1; i = i + 2 {
+		_, field, _ = utils.DecodeHashData(members[i])
+		if !expired[string(field)] {
+			values = append(values, members[i+1])
+		}
 	}
 	return
 }
@@ -655,36 +694,28 @@ func (tidis *Tidis) HVals(txn interface{}, key []byte) (values []interface{}, er
 //ClearHash clear hash
 func (tidis *Tidis) ClearHash(txn interface{}, key []byte) (deleted int64, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		hsize          uint64
-		startKey       []byte
-		members        [][]byte
-		hashKey        []byte
+		tikv_txn kv.Transaction
+		ok       bool
+		hsize    uint64
+		startKey []byte
+		members  [][]byte
+		hashKey  []byte
+		version  uint64
 	)
 
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			deleted, err = tidis.ClearHash(txn, key)
 			return
-		}
+		})
+		return
 	}
-	hsize, _, _, err = tidis.getHashMetaWithType(txn, key)
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	hsize, _, _, version, err = tidis.getHashMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
@@ -695,7 +726,7 @@ func (tidis *Tidis) ClearHash(txn interface{}, key []byte) (deleted int64, err e
 	if err != nil {
 		return
 	}
-	startKey = utils.EncodeHashData(key, nil)
+	startKey = utils.EncodeHashData(key, version, nil)
 	members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, nil, true, 0, hsize, false)
 	if err != nil {
 		return
@@ -706,19 +737,13 @@ func (tidis *Tidis) ClearHash(txn interface{}, key []byte) (deleted int64, err e
 			return
 		}
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
-	}
 	return
 }
-func (tidis *Tidis) getHashMetaWithType(txn interface{}, key []byte) (ssize uint64, ttl uint64, flag byte, err error) {
+func (tidis *Tidis) getHashMetaWithType(txn interface{}, key []byte) (ssize uint64, ttl uint64, flag byte, version uint64, err error) {
 	var (
 		dataType byte
 	)
-	dataType, ssize, ttl, flag, err = tidis.getHashMeta(txn, key)
+	dataType, ssize, ttl, flag, version, err = tidis.getHashMeta(txn, key)
 	if ssize > 0 {
 		if dataType != utils.HASH_TYPE {
 			err = qkverror.ErrorWrongType
@@ -727,7 +752,7 @@ func (tidis *Tidis) getHashMetaWithType(txn interface{}, key []byte) (ssize uint
 	}
 	return
 }
-func (tidis *Tidis) getHashMeta(txn interface{}, key []byte) (dataType byte, ssize uint64, ttl uint64, flag byte, err error) {
+func (tidis *Tidis) getHashMeta(txn interface{}, key []byte) (dataType byte, ssize uint64, ttl uint64, flag byte, version uint64, err error) {
 	var (
 		rawData []byte
 		value   []byte
@@ -752,6 +777,25 @@ func (tidis *Tidis) getHashMeta(txn interface{}, key []byte) (dataType byte, ssi
 		flag = utils.FLAG_NORMAL
 		return
 	}
+	//the meta of the other types may not decode as a hash meta
+	switch dataType {
+	case utils.SET_TYPE, utils.ZSET_TYPE, utils.HASH_TYPE, utils.BITMAP_TYPE:
+	default:
+		err = qkverror.ErrorWrongType
+		return
+	}
+	ssize, ttl, flag, version, err = decodeHashMeta(value)
+	return
+}
+
+//decodeHashMeta size(8)|ttl(8)|flag(1)|version(8), zset meta has the score encoding appended, set meta SET_MEMBER_RANK
+//once the set has the rank index.
+//Meta written before versioned meta has no version, its members are stored with version 0.
+func decodeHashMeta(value []byte) (ssize uint64, ttl uint64, flag byte, version uint64, err error) {
+	flag = utils.FLAG_NORMAL
+	if value == nil {
+		return
+	}
 	if len(value) < 16 {
 		err = qkverror.ErrorInvalidMeta
 		return
@@ -764,15 +808,558 @@ func (tidis *Tidis) getHashMeta(txn interface{}, key []byte) (dataType byte, ssi
 		err = qkverror.ErrorInvalidMeta
 		return
 	}
-	if len(value) == 17 {
+	if len(value) >= 17 {
 		flag = value[16]
 	}
+	if len(value) >= 25 {
+		version, _ = utils.BytesToUint64(value[17:])
+	}
 	return
 }
-func (tidis *Tidis) createHashMeta(size, ttl uint64, flag byte) (buf []byte) {
-	buf = make([]byte, 17)
+func (tidis *Tidis) createHashMeta(size, ttl uint64, flag byte, version uint64) (buf []byte) {
+	buf = make([]byte, 25)
 	utils.Uint64ToBytesExt(buf[0:], size)
 	utils.Uint64ToBytesExt(buf[8:], ttl)
 	buf[16] = flag
+	utils.Uint64ToBytesExt(buf[17:], version)
+	return
+}
+
+//condition of HExpire
+const (
+	HExpireAlways byte = iota
+	HExpireNX
+	HExpireXX
+	HExpireGT
+	HExpireLT
+)
+
+//results of HExpire, HExpireTime and HPersist for each field, like redis
+const (
+	HFieldMissing   int64 = -2
+	HFieldNoTTL     int64 = -1
+	HFieldSkipped   int64 = 0
+	HFieldUpdated   int64 = 1
+	HFieldDeleted   int64 = 2
+	hashFieldsLimit       = 1 << 20
+)
+
+//HIncrByFloat increments the number stored at field in the hash stored at key by the float increment.
+func (tidis *Tidis) HIncrByFloat(txn interface{}, key, field []byte, step float64) (ret []byte, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		hsize    uint64
+		ttl      uint64
+		version  uint64
+		oldRaw   []byte
+		old      float64
+		reaped   bool
+	)
+	if len(key) == 0 || len(field) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.HIncrByFloat(txn, key, field, step)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//delete hash if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	hsize, ttl, _, version, err = tidis.getHashMetaWithType(txn, key)
+	if err != nil {
+		return
+	}
+	if hsize == 0 {
+		version = tidis.newVersion(txn)
+	}
+	//the ttl of field is kept
+	oldRaw, reaped, err = tidis.prepareHashField(tikv_txn, key, version, field, true)
+	if err != nil {
+		return
+	}
+	if reaped {
+		hsize--
+	}
+	if oldRaw != nil {
+		old, err = utils.StrBytesToFloat64(oldRaw)
+		if err != nil {
+			err = qkverror.ErrorHashNotFloat
+			return
+		}
+	}
+	old = old + step
+	if math.IsNaN(old) || math.IsInf(old, 0) {
+		err = qkverror.ErrorIncrNaN
+		return
+	}
+	//no exponent like redis
+	ret = strconv.AppendFloat(nil, old, 'f', -1, 64)
+	err = tikv_txn.Set(utils.EncodeHashData(key, version, field), ret)
+	if err != nil {
+		return
+	}
+	if oldRaw == nil {
+		hsize++
+		err = tikv_txn.Set(key, utils.EncodeData(utils.HASH_TYPE, tidis.createHashMeta(hsize, ttl, utils.FLAG_NORMAL, version)))
+		if err != nil {
+			return
+		}
+	}
+	err = tidis.notify(txn, notifyHash, "hincrbyfloat", key)
 	return
 }
+
+//HRandField returns count random fields of the hash stored at key, each followed by its value with withValues.
+//A positive count returns distinct fields, a negative count may return the same field several times.
+//The fields are read by a single scan up to the greatest rank picked, unless some fields have expired.
+func (tidis *Tidis) HRandField(txn interface{}, key []byte, count int64, withValues bool) (resp []interface{}, err error) {
+	var (
+		hsize       uint64
+		version     uint64
+		expired     map[string]bool
+		ranks       []int64
+		first, last int64
+		members     [][]byte
+		live        [][]byte
+		field       []byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	//delete hash if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	hsize, _, _, version, err = tidis.getHashMetaWithType(txn, key)
+	if err != nil {
+		return
+	}
+	resp = utils.EmptyListInterfaces
+	if hsize == 0 || count == 0 {
+		return
+	}
+	expired, err = tidis.expiredHashFields(txn, key, version, hsize)
+	if err != nil {
+		return
+	}
+	if len(expired) == 0 && (count < 0 || uint64(count) < hsize) {
+		ranks, first, last = randomRanks(hsize, count)
+		members, err = tidis.db.GetRangeKeysValues(txn, utils.EncodeHashData(key, version, nil), nil, uint64(last+1), true)
+		if err != nil {
+			return
+		}
+		if int64(len(members)/2) != last+1 {
+			err = qkverror.ErrorInvalidMeta
+			return
+		}
+		members = members[2*first:]
+	} else {
+		//the expired fields are skipped, so the live fields are picked from all of them
+		members, err = tidis.db.GetRangeKeysValues(txn, utils.EncodeHashData(key, version, nil), nil, hsize, true)
+		if err != nil {
+			return
+		}
+		for i := 0; i < len(members)-1; i = i + 2 {
+			_, field, _ = utils.DecodeHashData(members[i])
+			if !expired[string(field)] {
+				live = append(live, members[i], members[i+1])
+			}
+		}
+		members = live
+		if len(members) == 0 {
+			return
+		}
+		first = 0
+		if count > 0 && count >= int64(len(members)/2) {
+			ranks = make([]int64, len(members)/2)
+			for i := range ranks {
+				ranks[i] = int64(i)
+			}
+		} else {
+			ranks, _, _ = randomRanks(uint64(len(members)/2), count)
+		}
+	}
+	resp = make([]interface{}, 0, len(ranks)*2)
+	for _, rank := range ranks {
+		_, field, err = utils.DecodeHashData(members[2*(rank-first)])
+		if err != nil {
+			return
+		}
+		resp = append(resp, field)
+		if withValues {
+			resp = append(resp, members[2*(rank-first)+1])
+		}
+	}
+	return
+}
+
+//HExpire sets the expire time of the fields of the hash stored at key to the unix time expireAt in ms
+//if cond holds, a field is deleted if expireAt has passed. Returns the result of each field.
+func (tidis *Tidis) HExpire(txn interface{}, key []byte, expireAt int64, cond byte, fields ...[]byte) (resp []int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		hsize    uint64
+		ttl      uint64
+		version  uint64
+		value    []byte
+		old      int64
+		reaped   bool
+		updated  bool
+		deleted  int64
+	)
+	if len(key) == 0 || len(fields) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			resp, err = tidis.HExpire(txn, key, expireAt, cond, fields...)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//delete hash if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	hsize, ttl, _, version, err = tidis.getHashMetaWithType(txn, key)
+	if err != nil {
+		return
+	}
+	resp = make([]int64, len(fields))
+	for i, field := range fields {
+		resp[i] = HFieldMissing
+		if hsize == 0 {
+			continue
+		}
+		value, reaped, err = tidis.prepareHashField(tikv_txn, key, version, field, true)
+		if err != nil {
+			return
+		}
+		if reaped {
+			hsize--
+			deleted++
+		}
+		if value == nil {
+			continue
+		}
+		old, err = tidis.hashFieldTTL(txn, key, version, field)
+		if err != nil {
+			return
+		}
+		resp[i] = HFieldSkipped
+		switch cond {
+		case HExpireNX:
+			ok = old == 0
+		case HExpireXX:
+			ok = old != 0
+		case HExpireGT:
+			//no ttl is an infinite ttl
+			ok = old != 0 && expireAt > old
+		case HExpireLT:
+			ok = old == 0 || expireAt < old
+		default:
+			ok = true
+		}
+		if !ok {
+			continue
+		}
+		if hashFieldExpired(expireAt) {
+			err = tidis.setHashFieldTTL(tikv_txn, key, version, field, old, 0)
+			if err != nil {
+				return
+			}
+			err = tikv_txn.Delete(utils.EncodeHashData(key, version, field))
+			if err != nil {
+				return
+			}
+			hsize--
+			deleted++
+			resp[i] = HFieldDeleted
+			continue
+		}
+		err = tidis.setHashFieldTTL(tikv_txn, key, version, field, old, expireAt)
+		if err != nil {
+			return
+		}
+		updated = true
+		resp[i] = HFieldUpdated
+	}
+	if deleted > 0 {
+		err = tidis.updateHashSize(tikv_txn, key, hsize, ttl, version)
+		if err != nil {
+			return
+		}
+		err = tidis.notify(txn, notifyHash, "hdel", key)
+		if err != nil {
+			return
+		}
+	}
+	if updated {
+		err = tidis.notify(txn, notifyHash, "hexpire", key)
+		if err != nil {
+			return
+		}
+	}
+	if deleted > 0 && hsize == 0 {
+		err = tidis.notify(txn, notifyGeneric, "del", key)
+	}
+	return
+}
+
+//HExpireTime returns the unix time in ms each field of the hash stored at key expires at,
+//HFieldNoTTL if it has no ttl or HFieldMissing if it does not exist.
+func (tidis *Tidis) HExpireTime(txn interface{}, key []byte, fields ...[]byte) (resp []int64, err error) {
+	var (
+		hsize    uint64
+		version  uint64
+		value    []byte
+		expireAt int64
+	)
+	if len(key) == 0 || len(fields) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	//delete hash if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	hsize, _, _, version, err = tidis.getHashMetaWithType(txn, key)
+	if err != nil {
+		return
+	}
+	resp = make([]int64, len(fields))
+	for i, field := range fields {
+		resp[i] = HFieldMissing
+		if hsize == 0 {
+			continue
+		}
+		value, err = tidis.db.Get(txn, utils.EncodeHashData(key, version, field))
+		if err != nil {
+			return
+		}
+		if value == nil {
+			continue
+		}
+		expireAt, err = tidis.hashFieldTTL(txn, key, version, field)
+		if err != nil {
+			return
+		}
+		switch {
+		case expireAt == 0:
+			resp[i] = HFieldNoTTL
+		case !hashFieldExpired(expireAt):
+			resp[i] = expireAt
+		}
+	}
+	return
+}
+
+//HPersist removes the expire time of the fields of the hash stored at key, returns the result of each field.
+func (tidis *Tidis) HPersist(txn interface{}, key []byte, fields ...[]byte) (resp []int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		hsize    uint64
+		ttl      uint64
+		version  uint64
+		value    []byte
+		expireAt int64
+		reaped   bool
+		deleted  bool
+		updated  bool
+	)
+	if len(key) == 0 || len(fields) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			resp, err = tidis.HPersist(txn, key, fields...)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//delete hash if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	hsize, ttl, _, version, err = tidis.getHashMetaWithType(txn, key)
+	if err != nil {
+		return
+	}
+	resp = make([]int64, len(fields))
+	for i, field := range fields {
+		resp[i] = HFieldMissing
+		if hsize == 0 {
+			continue
+		}
+		expireAt, err = tidis.hashFieldTTL(txn, key, version, field)
+		if err != nil {
+			return
+		}
+		value, reaped, err = tidis.prepareHashField(tikv_txn, key, version, field, false)
+		if err != nil {
+			return
+		}
+		if reaped {
+			hsize--
+			deleted = true
+		}
+		if value == nil {
+			continue
+		}
+		resp[i] = HFieldNoTTL
+		if expireAt != 0 {
+			resp[i] = HFieldUpdated
+			updated = true
+		}
+	}
+	if deleted {
+		err = tidis.updateHashSize(tikv_txn, key, hsize, ttl, version)
+		if err != nil {
+			return
+		}
+	}
+	if updated {
+		err = tidis.notify(txn, notifyHash, "hpersist", key)
+	}
+	return
+}
+
+//hashFieldTTL the unix time in ms field expires at, 0 if it has no ttl
+func (tidis *Tidis) hashFieldTTL(txn interface{}, key []byte, version uint64, field []byte) (expireAt int64, err error) {
+	var (
+		raw []byte
+	)
+	raw, err = tidis.db.Get(txn, utils.EncodeHashFieldTTL(key, version, field))
+	if err != nil || raw == nil {
+		return
+	}
+	expireAt, err = utils.BytesToInt64(raw)
+	return
+}
+
+//hashFieldExpired whether the expire time of a field has passed, 0 is no expire time
+func hashFieldExpired(expireAt int64) bool {
+	return expireAt > 0 && expireAt <= time.Now().UnixNano()/1000/1000
+}
+
+//setHashFieldTTL replace the expire time old of field by expireAt, 0 removes it.
+//The ttl checker finds the fields to remove by their entries in the hash field expire index.
+func (tidis *Tidis) setHashFieldTTL(txn kv.Transaction, key []byte, version uint64, field []byte, old, expireAt int64) (err error) {
+	var (
+		raw []byte
+	)
+	if old > 0 {
+		err = txn.Delete(utils.EncodeHashFieldExpire(key, version, field, old))
+		if err != nil {
+			return
+		}
+	}
+	if expireAt == 0 {
+		return txn.Delete(utils.EncodeHashFieldTTL(key, version, field))
+	}
+	raw, _ = utils.Int64ToBytes(expireAt)
+	err = txn.Set(utils.EncodeHashFieldTTL(key, version, field), raw)
+	if err != nil {
+		return
+	}
+	return txn.Set(utils.EncodeHashFieldExpire(key, version, field, expireAt), []byte{0})
+}
+
+//prepareHashField the value of field before it is written or deleted. An expired field is removed and reaped is set,
+//the caller decrements the size of the hash. The ttl of field is removed too unless keepTTL is set.
+func (tidis *Tidis) prepareHashField(txn kv.Transaction, key []byte, version uint64, field []byte, keepTTL bool) (value []byte, reaped bool, err error) {
+	var (
+		expireAt int64
+	)
+	value, err = tidis.db.Get(txn, utils.EncodeHashData(key, version, field))
+	if err != nil || value == nil {
+		return
+	}
+	expireAt, err = tidis.hashFieldTTL(txn, key, version, field)
+	if err != nil || expireAt == 0 {
+		return
+	}
+	if hashFieldExpired(expireAt) {
+		err = txn.Delete(utils.EncodeHashData(key, version, field))
+		if err != nil {
+			return
+		}
+		value, reaped = nil, true
+	}
+	if reaped || !keepTTL {
+		err = tidis.setHashFieldTTL(txn, key, version, field, expireAt, 0)
+	}
+	return
+}
+
+//expiredHashFields the fields of the hash stored at key whose expire time has passed, but are not removed yet
+func (tidis *Tidis) expiredHashFields(txn interface{}, key []byte, version, hsize uint64) (expired map[string]bool, err error) {
+	var (
+		prefix   []byte
+		kvs      [][]byte
+		field    []byte
+		expireAt int64
+	)
+	prefix = utils.EncodeMemberPrefix(utils.HASH_FIELD_TTL, key, version)
+	kvs, err = tidis.db.GetRangeKeysValues(txn, prefix, nil, hsize, true)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(kvs)-1; i = i + 2 {
+		if !bytes.HasPrefix(kvs[i], prefix) {
+			break
+		}
+		expireAt, _ = utils.BytesToInt64(kvs[i+1])
+		if !hashFieldExpired(expireAt) {
+			continue
+		}
+		_, field, err = utils.DecodeHashFieldTTL(kvs[i])
+		if err != nil {
+			return
+		}
+		if expired == nil {
+			expired = make(map[string]bool)
+		}
+		expired[string(field)] = true
+	}
+	return
+}
+
+//updateHashSize write the hash meta with the new size, an empty hash is deleted
+func (tidis *Tidis) updateHashSize(txn kv.Transaction, key []byte, hsize, ttl, version uint64) (err error) {
+	if hsize == 0 {
+		return txn.Delete(key)
+	}
+	return txn.Set(key, utils.EncodeData(utils.HASH_TYPE, tidis.createHashMeta(hsize, ttl, utils.FLAG_NORMAL, version)))
+}
@@ -0,0 +1,220 @@
+package tidis
+
+import (
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+)
+
+//PFAdd adds the elements to the HyperLogLog stored at key, returns 1 if the key was created or a register changed.
+func (tidis *Tidis) PFAdd(txn interface{}, key []byte, elements ...[]byte) (ret int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		value    []byte
+		exists   bool
+		regs     utils.HLLRegisters
+		encoding byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.PFAdd(txn, key, elements...)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	value, exists, err = tidis.getHLL(txn, key)
+	if err != nil {
+		return
+	}
+	if !exists {
+		value = utils.NewHLL()
+		ret = 1
+	}
+	encoding, err = utils.DecodeHLL(value, &regs)
+	if err != nil {
+		return
+	}
+	for _, element := range elements {
+		if utils.HLLAdd(&regs, element) {
+			ret = 1
+		}
+	}
+	if ret == 0 {
+		return
+	}
+	value, _ = utils.EncodeHLL(&regs, encoding, 0, true)
+	err = tikv_txn.Set(key, utils.EncodeData(utils.HLL_TYPE, value))
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyString, "pfadd", key)
+	return
+}
+
+//PFCount returns the approximated cardinality of the union of the HyperLogLogs stored at keys.
+//The cardinality of a single key is cached in its header like redis.
+func (tidis *Tidis) PFCount(txn interface{}, keys ...[]byte) (count int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		value    []byte
+		exists   bool
+		regs     utils.HLLRegisters
+		card     uint64
+	)
+	if len(keys) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			count, err = tidis.PFCount(txn, keys...)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	if len(keys) == 1 {
+		value, exists, err = tidis.getHLL(txn, keys[0])
+		if err != nil || !exists {
+			return
+		}
+		if card, ok = utils.HLLCachedCard(value); ok {
+			count = int64(card)
+			return
+		}
+		_, err = utils.DecodeHLL(value, &regs)
+		if err != nil {
+			return
+		}
+		card = utils.HLLCount(&regs)
+		value = append([]byte(nil), value...)
+		utils.SetHLLCachedCard(value, card)
+		err = tikv_txn.Set(keys[0], utils.EncodeData(utils.HLL_TYPE, value))
+		if err != nil {
+			return
+		}
+		count = int64(card)
+		return
+	}
+	for _, key := range keys {
+		value, exists, err = tidis.getHLL(txn, key)
+		if err != nil {
+			return
+		}
+		if !exists {
+			continue
+		}
+		_, err = utils.DecodeHLL(value, &regs)
+		if err != nil {
+			return
+		}
+	}
+	count = int64(utils.HLLCount(&regs))
+	return
+}
+
+//PFMerge merges the HyperLogLogs stored at keys and dest into dest.
+//The result is dense if one of them is dense like redis.
+func (tidis *Tidis) PFMerge(txn interface{}, dest []byte, keys ...[]byte) (err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		value    []byte
+		exists   bool
+		regs     utils.HLLRegisters
+		encoding byte
+		dense    bool
+	)
+	if len(dest) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		return tidis.RetryTxn(func(txn interface{}) error {
+			return tidis.PFMerge(txn, dest, keys...)
+		})
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	for _, key := range append([][]byte{dest}, keys...) {
+		value, exists, err = tidis.getHLL(txn, key)
+		if err != nil {
+			return
+		}
+		if !exists {
+			continue
+		}
+		encoding, err = utils.DecodeHLL(value, &regs)
+		if err != nil {
+			return
+		}
+		if encoding == utils.HLL_DENSE {
+			dense = true
+		}
+	}
+	encoding = utils.HLL_SPARSE
+	if dense {
+		encoding = utils.HLL_DENSE
+	}
+	value, _ = utils.EncodeHLL(&regs, encoding, 0, true)
+	err = tikv_txn.Set(dest, utils.EncodeData(utils.HLL_TYPE, value))
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyString, "pfadd", dest)
+	return
+}
+
+//getHLL the HyperLogLog stored at key, a string value is accepted if it is a valid HyperLogLog
+func (tidis *Tidis) getHLL(txn interface{}, key []byte) (value []byte, exists bool, err error) {
+	var (
+		rawData  []byte
+		dataType byte
+	)
+	//delete string if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	rawData, err = tidis.db.Get(txn, key)
+	if err != nil || rawData == nil {
+		return
+	}
+	dataType, value, err = utils.DecodeData(rawData)
+	if err != nil {
+		return
+	}
+	switch dataType {
+	case utils.HLL_TYPE, utils.STRING_TYPE:
+		if !utils.IsHLL(value) {
+			err = qkverror.ErrorInvalidHLL
+			return
+		}
+	case utils.BITMAP_TYPE:
+		err = qkverror.ErrorInvalidHLL
+		return
+	default:
+		err = qkverror.ErrorWrongType
+		return
+	}
+	exists = true
+	return
+}
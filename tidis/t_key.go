@@ -0,0 +1,635 @@
+package tidis
+
+import (
+	"bytes"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+)
+
+//Exists returns the number of keys existing among the ones specified as arguments.
+func (tidis *Tidis) Exists(txn interface{}, keys ...[]byte) (count int64, err error) {
+	var (
+		key      []byte
+		dataType byte
+	)
+	if len(keys) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	for _, key = range keys {
+		dataType, err = tidis.getType(txn, key)
+		if err != nil {
+			return
+		}
+		if dataType != utils.NONE_TYPE {
+			count++
+		}
+	}
+	return
+}
+
+//Type returns the string representation of the type of the value stored at key.
+func (tidis *Tidis) Type(txn interface{}, key []byte) (typeName string, err error) {
+	var (
+		dataType byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	dataType, err = tidis.getType(txn, key)
+	if err != nil {
+		return
+	}
+	typeName = utils.TypeName(dataType)
+	return
+}
+
+//Persist remove the existing timeout on key.
+func (tidis *Tidis) Persist(txn interface{}, key []byte) (ret int64, err error) {
+	var (
+		ttl int64
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.Persist(txn, key)
+			return
+		})
+		return
+	}
+	//delete key if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	ttl, err = tidis.PTTL(txn, key)
+	if err != nil {
+		return
+	}
+	if ttl < 0 {
+		//not key or no timeout
+		return
+	}
+	err = tidis.removeMetaKey(txn, key)
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "persist", key)
+	if err != nil {
+		return
+	}
+	ret = 1
+	return
+}
+
+//Rename renames key to newkey, newkey is overwritten if it already exists.
+func (tidis *Tidis) Rename(txn interface{}, key, newKey []byte) (err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		copied   int64
+	)
+	if len(key) == 0 || len(newKey) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		return tidis.RetryTxn(func(txn interface{}) error {
+			return tidis.Rename(txn, key, newKey)
+		})
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	copied, err = tidis.copyKey(tikv_txn, key, newKey, true)
+	if err != nil {
+		return
+	}
+	if copied == 0 {
+		err = qkverror.ErrorNoSuchKey
+		return
+	}
+	if string(key) == string(newKey) {
+		return
+	}
+	//delete old key with its members and expire meta
+	err = tidis.removeMetaKey(txn, key)
+	if err != nil {
+		return
+	}
+	_, err = tidis.DeleteWithTxn(tikv_txn, [][]byte{key})
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "rename_from", key)
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "rename_to", newKey)
+	return
+}
+
+//RenameNX renames key to newkey if newkey does not yet exist.
+func (tidis *Tidis) RenameNX(txn interface{}, key, newKey []byte) (ret int64, err error) {
+	var (
+		dataType byte
+	)
+	if len(key) == 0 || len(newKey) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.RenameNX(txn, key, newKey)
+			return
+		})
+		return
+	}
+	//delete newkey if expired
+	err = tidis.DeleteIfExpired(txn, newKey, true)
+	if err != nil {
+		return
+	}
+	dataType, err = tidis.getType(txn, newKey)
+	if err != nil {
+		return
+	}
+	if dataType != utils.NONE_TYPE {
+		//check source
+		dataType, err = tidis.getType(txn, key)
+		if err == nil && dataType == utils.NONE_TYPE {
+			err = qkverror.ErrorNoSuchKey
+		}
+		return
+	}
+	err = tidis.Rename(txn, key, newKey)
+	if err != nil {
+		return
+	}
+	ret = 1
+	return
+}
+
+//Move moves key to dest, the same key in another database, returns 0 if key does not exist or dest already exists.
+func (tidis *Tidis) Move(txn interface{}, key, dest []byte) (ret int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+	)
+	if len(key) == 0 || len(dest) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.Move(txn, key, dest)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	ret, err = tidis.copyKey(tikv_txn, key, dest, false)
+	if err != nil || ret == 0 {
+		return
+	}
+	//delete old key with its members and expire meta
+	err = tidis.removeMetaKey(txn, key)
+	if err != nil {
+		return
+	}
+	_, err = tidis.DeleteWithTxn(tikv_txn, [][]byte{key})
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "move_from", key)
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "move_to", dest)
+	return
+}
+
+//Copy copies the value stored at the source key to the destination key, the destination
+//is only overwritten when replace is set.
+func (tidis *Tidis) Copy(txn interface{}, src, dest []byte, replace bool) (ret int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+	)
+	if len(src) == 0 || len(dest) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.Copy(txn, src, dest, replace)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	ret, err = tidis.copyKey(tikv_txn, src, dest, replace)
+	if err != nil || ret == 0 || string(src) == string(dest) {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "copy_to", dest)
+	return
+}
+
+//copyKey copy src to dest with its members and expire meta, shared by COPY and RENAME
+func (tidis *Tidis) copyKey(tikv_txn kv.Transaction, src, dest []byte, replace bool) (ret int64, err error) {
+	var (
+		txn      interface{}
+		srcType  byte
+		destType byte
+		rawData  []byte
+		ttlValue []byte
+		ts       uint64
+	)
+	txn = tikv_txn
+	//delete keys if expired
+	err = tidis.DeleteIfExpired(txn, src, true)
+	if err != nil {
+		return
+	}
+	err = tidis.DeleteIfExpired(txn, dest, true)
+	if err != nil {
+		return
+	}
+	srcType, err = tidis.getType(txn, src)
+	if err != nil || srcType == utils.NONE_TYPE {
+		return
+	}
+	if string(src) == string(dest) {
+		ret = 1
+		return
+	}
+	destType, err = tidis.getType(txn, dest)
+	if err != nil {
+		return
+	}
+	if destType != utils.NONE_TYPE {
+		if !replace {
+			return
+		}
+		err = tidis.removeMetaKey(txn, dest)
+		if err != nil {
+			return
+		}
+		_, err = tidis.DeleteWithTxn(tikv_txn, [][]byte{dest})
+		if err != nil {
+			return
+		}
+	}
+	switch srcType {
+	case utils.SET_TYPE:
+		err = tidis.copySetMembers(tikv_txn, src, dest)
+	case utils.ZSET_TYPE:
+		err = tidis.copyZSetMembers(tikv_txn, src, dest)
+	case utils.HASH_TYPE:
+		err = tidis.copyHashFields(tikv_txn, src, dest)
+	case utils.LIST_TYPE:
+		err = tidis.copyListMembers(tikv_txn, src, dest)
+	case utils.STREAM_TYPE:
+		err = tidis.copyStream(tikv_txn, src, dest)
+	case utils.BITMAP_TYPE:
+		err = tidis.copyBitmap(tikv_txn, src, dest)
+	case utils.STRING_TYPE, utils.HLL_TYPE:
+		//string value is copied as it is
+		rawData, err = tidis.db.Get(txn, src)
+		if err != nil {
+			return
+		}
+		err = tikv_txn.Set(dest, rawData)
+	}
+	if err != nil {
+		return
+	}
+	//carry the expire meta over
+	ttlValue, err = tidis.db.Get(txn, utils.EncodeTTLKey(src))
+	if err != nil {
+		return
+	}
+	if ttlValue != nil {
+		ts, err = utils.BytesToUint64(ttlValue)
+		if err != nil {
+			return
+		}
+		_, err = tidis.db.PExipre(txn, dest, int64(ts))
+		if err != nil {
+			return
+		}
+	}
+	ret = 1
+	return
+}
+
+//Touch alters the last access time of the keys, returns the number of keys that were touched.
+func (tidis *Tidis) Touch(txn interface{}, keys ...[]byte) (count int64, err error) {
+	return tidis.Exists(txn, keys...)
+}
+
+//Unlink removes the specified keys.
+func (tidis *Tidis) Unlink(txn interface{}, keys ...[]byte) (count int64, err error) {
+	return tidis.Delete(txn, keys)
+}
+
+//getType type of the value stored at key, NONE_TYPE if key does not exist or is expired
+func (tidis *Tidis) getType(txn interface{}, key []byte) (dataType byte, err error) {
+	var (
+		rawData []byte
+		ttl     int64
+	)
+	dataType = utils.NONE_TYPE
+	rawData, err = tidis.db.Get(txn, key)
+	if err != nil || rawData == nil {
+		return
+	}
+	ttl, err = tidis.PTTL(txn, key)
+	if err != nil || ttl == 0 {
+		return
+	}
+	dataType, _, err = utils.DecodeData(rawData)
+	return
+}
+func (tidis *Tidis) copySetMembers(txn kv.Transaction, src, dest []byte) (err error) {
+	var (
+		ssize, ttl      uint64
+		flag            byte
+		version, newVer uint64
+		members         [][]byte
+		member          []byte
+		field           []byte
+	)
+	ssize, ttl, flag, version, err = tidis.getSetMeta(txn, src)
+	if err != nil || ssize == 0 {
+		return
+	}
+	members, _, err = tidis.db.GetRangeKeys(txn, utils.EncodeSetData(src, version, nil), true, nil, true, 0, ssize, false)
+	if err != nil {
+		return
+	}
+	newVer = tidis.newVersion(txn)
+	for _, member = range members {
+		_, field, err = utils.DecodeSetData(member)
+		if err != nil {
+			return
+		}
+		err = txn.Set(utils.EncodeSetData(dest, newVer, field), []byte{0})
+		if err != nil {
+			return
+		}
+	}
+	err = setRank(txn, dest, newVer).build()
+	if err != nil {
+		return
+	}
+	err = txn.Set(dest, utils.EncodeData(utils.SET_TYPE, tidis.createSetMeta(ssize, ttl, flag, newVer)))
+	return
+}
+func (tidis *Tidis) copyZSetMembers(txn kv.Transaction, src, dest []byte) (err error) {
+	var (
+		zsize, ttl      uint64
+		flag            byte
+		version, newVer uint64
+		members         [][]byte
+		member          []byte
+		score           float64
+	)
+	zsize, ttl, flag, version, err = tidis.getZSetMeta(txn, src)
+	if err != nil || zsize == 0 {
+		return
+	}
+	//member -> score pairs
+	members, err = tidis.db.GetRangeKeysValues(txn, utils.EncodeZSetData(src, version, nil), nil, zsize, true)
+	if err != nil {
+		return
+	}
+	newVer = tidis.newVersion(txn)
+	for i := 0; i < len(members)-1; i = i + 2 {
+		_, member, err = utils.DecodeZSetData(members[i])
+		if err != nil {
+			return
+		}
+		score, err = utils.BytesToFloat64(members[i+1])
+		if err != nil {
+			return
+		}
+		err = txn.Set(utils.EncodeZSetData(dest, newVer, member), members[i+1])
+		if err != nil {
+			return
+		}
+		err = txn.Set(utils.EncodeZSetScore(dest, newVer, member, score), []byte{0})
+		if err != nil {
+			return
+		}
+	}
+	err = zsetRank(txn, dest, newVer).build()
+	if err != nil {
+		return
+	}
+	err = txn.Set(dest, utils.EncodeData(utils.ZSET_TYPE, tidis.createZSetMeta(zsize, ttl, flag, newVer)))
+	return
+}
+func (tidis *Tidis) copyHashFields(txn kv.Transaction, src, dest []byte) (err error) {
+	var (
+		hsize, ttl      uint64
+		flag            byte
+		version, newVer uint64
+		members         [][]byte
+		field           []byte
+		expireAt        int64
+		copied          uint64
+	)
+	hsize, ttl, flag, version, err = tidis.getHashMetaWithType(txn, src)
+	if err != nil || hsize == 0 {
+		return
+	}
+	//field -> value pairs
+	members, err = tidis.db.GetRangeKeysValues(txn, utils.EncodeHashData(src, version, nil), nil, hsize, true)
+	if err != nil {
+		return
+	}
+	newVer = tidis.newVersion(txn)
+	for i := 0; i < len(members)-1; i = i + 2 {
+		_, field, err = utils.DecodeHashData(members[i])
+		if err != nil {
+			return
+		}
+		//the ttl of a field is carried over, an expired field is not copied
+		expireAt, err = tidis.hashFieldTTL(txn, src, version, field)
+		if err != nil {
+			return
+		}
+		if hashFieldExpired(expireAt) {
+			continue
+		}
+		err = txn.Set(utils.EncodeHashData(dest, newVer, field), members[i+1])
+		if err != nil {
+			return
+		}
+		if expireAt > 0 {
+			err = tidis.setHashFieldTTL(txn, dest, newVer, field, 0, expireAt)
+			if err != nil {
+				return
+			}
+		}
+		copied++
+	}
+	if copied == 0 {
+		return
+	}
+	err = txn.Set(dest, utils.EncodeData(utils.HASH_TYPE, tidis.createHashMeta(copied, ttl, flag, newVer)))
+	return
+}
+func (tidis *Tidis) copyListMembers(txn kv.Transaction, src, dest []byte) (err error) {
+	var (
+		head, tail, size, ttl uint64
+		flag                  byte
+		version, newVer       uint64
+		item                  []byte
+		meta                  []byte
+	)
+	head, tail, size, ttl, flag, version, err = tidis.getListMetaWithType(txn, src)
+	if err != nil || size == 0 {
+		return
+	}
+	//same index space under the new version
+	newVer = tidis.newVersion(txn)
+	for i := head; i < tail; i++ {
+		item, err = tidis.db.Get(txn, utils.EncodeListData(src, version, i))
+		if err != nil {
+			return
+		}
+		if item == nil {
+			continue
+		}
+		err = txn.Set(utils.EncodeListData(dest, newVer, i), item)
+		if err != nil {
+			return
+		}
+	}
+	meta, err = tidis.createListMeta(head, tail, size, ttl, flag, newVer)
+	if err != nil {
+		return
+	}
+	err = txn.Set(dest, utils.EncodeData(utils.LIST_TYPE, meta))
+	return
+}
+
+//copyStream copy the entries, the consumer groups and their pending entries
+func (tidis *Tidis) copyStream(txn kv.Transaction, src, dest []byte) (err error) {
+	var (
+		size            uint64
+		version, newVer uint64
+		last            StreamID
+		it              kv.Iterator
+		prefix          []byte
+		keys            [][]byte
+		values          [][]byte
+		key             []byte
+		group           []byte
+		ms, seq         uint64
+	)
+	_, size, version, last, err = tidis.getStreamMeta(txn, src)
+	if err != nil {
+		return
+	}
+	for _, prefix = range gcMemberPrefixes(utils.STREAM_TYPE, src, version) {
+		it, err = txn.Seek(prefix)
+		if err != nil {
+			return
+		}
+		for it.Valid() && bytes.HasPrefix(it.Key(), prefix) {
+			keys = append(keys, []byte(it.Key()))
+			values = append(values, it.Value())
+			if err = it.Next(); err != nil {
+				it.Close()
+				return
+			}
+		}
+		it.Close()
+	}
+	newVer = tidis.newVersion(txn)
+	for i := range keys {
+		switch keys[i][0] {
+		case utils.STREAM_DATA:
+			_, ms, seq, err = utils.DecodeStreamData(keys[i])
+			key = utils.EncodeStreamData(dest, newVer, ms, seq)
+		case utils.STREAM_GROUP:
+			_, group, err = utils.DecodeStreamGroup(keys[i])
+			key = utils.EncodeStreamGroup(dest, newVer, group)
+		case utils.STREAM_PEL:
+			_, group, ms, seq, err = utils.DecodeStreamPEL(keys[i])
+			key = utils.EncodeStreamPEL(dest, newVer, group, ms, seq)
+		}
+		if err != nil {
+			return
+		}
+		err = txn.Set(key, values[i])
+		if err != nil {
+			return
+		}
+	}
+	err = txn.Set(dest, utils.EncodeData(utils.STREAM_TYPE, tidis.createStreamMeta(size, newVer, last)))
+	return
+}
+
+//copyBitmap copy the chunks of a bitmap
+func (tidis *Tidis) copyBitmap(txn kv.Transaction, src, dest []byte) (err error) {
+	var (
+		size            uint64
+		version, newVer uint64
+		it              kv.Iterator
+		prefix          []byte
+		chunks          []uint64
+		values          [][]byte
+		chunk           uint64
+	)
+	_, size, _, _, version, err = tidis.getHashMeta(txn, src)
+	if err != nil {
+		return
+	}
+	prefix = utils.EncodeMemberPrefix(utils.BITMAP_DATA, src, version)
+	it, err = txn.Seek(prefix)
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for it.Valid() && bytes.HasPrefix(it.Key(), prefix) {
+		_, chunk, err = utils.DecodeBitmapData(it.Key())
+		if err != nil {
+			return
+		}
+		chunks = append(chunks, chunk)
+		values = append(values, it.Value())
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	newVer = tidis.newVersion(txn)
+	for i := range chunks {
+		err = txn.Set(utils.EncodeBitmapData(dest, newVer, chunks[i]), values[i])
+		if err != nil {
+			return
+		}
+	}
+	err = txn.Set(dest, utils.EncodeData(utils.BITMAP_TYPE, tidis.createHashMeta(size, 0, utils.FLAG_NORMAL, newVer)))
+	return
+}
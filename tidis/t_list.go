@@ -1,7 +1,7 @@
 package tidis
 
 import (
-	"context"
+	"bytes"
 
 	"github.com/chuangyou/qkv/qkverror"
 	"github.com/chuangyou/qkv/utils"
@@ -12,6 +12,7 @@ import (
 func (tidis *Tidis) LIndex(txn interface{}, key []byte, index int64) (resp []byte, err error) {
 	var (
 		head, size  uint64
+		version     uint64
 		listDataKey []byte
 	)
 	if len(key) == 0 {
@@ -23,7 +24,7 @@ func (tidis *Tidis) LIndex(txn interface{}, key []byte, index int64) (resp []byt
 	if err != nil {
 		return
 	}
-	head, _, size, _, _, err = tidis.getListMetaWithType(txn, key)
+	head, _, size, _, _, version, err = tidis.getListMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
@@ -37,10 +38,98 @@ func (tidis *Tidis) LIndex(txn interface{}, key []byte, index int64) (resp []byt
 		}
 		index = index + int64(size)
 	}
-	listDataKey = utils.EncodeListData(key, uint64(index)+head)
+	listDataKey = utils.EncodeListData(key, version, uint64(index)+head)
 	return tidis.db.Get(txn, listDataKey)
 }
 
+//LInsert inserts value in the list stored at key either before or after the first element equal to pivot.
+//It returns the length of the list after the insert, 0 if key does not exist and -1 if pivot was not found.
+func (tidis *Tidis) LInsert(txn interface{}, key []byte, before bool, pivot, value []byte) (ret int64, err error) {
+	var (
+		tikv_txn              kv.Transaction
+		ok                    bool
+		head, tail, size, ttl uint64
+		version               uint64
+		items                 [][]byte
+		pos                   int
+		listMetaValue         []byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.LInsert(txn, key, before, pivot, value)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//delete list if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	head, tail, size, ttl, _, version, err = tidis.getListMetaWithType(txn, key)
+	if err != nil || size == 0 {
+		return
+	}
+	items, err = tidis.listItems(tikv_txn, key, version, head, tail)
+	if err != nil {
+		return
+	}
+	pos = -1
+	for i, item := range items {
+		if bytes.Equal(item, pivot) {
+			pos = i
+			break
+		}
+	}
+	if pos == -1 {
+		ret = -1
+		return
+	}
+	if !before {
+		pos++
+	}
+	//move the shorter side of the list one index away to make room for value
+	if pos <= len(items)/2 {
+		head--
+		err = tidis.setListItems(tikv_txn, key, version, head, items[:pos])
+	} else {
+		tail++
+		err = tidis.setListItems(tikv_txn, key, version, head+uint64(pos)+1, items[pos:])
+	}
+	if err != nil {
+		return
+	}
+	err = tikv_txn.Set(utils.EncodeListData(key, version, head+uint64(pos)), value)
+	if err != nil {
+		return
+	}
+	size++
+	listMetaValue, err = tidis.createListMeta(head, tail, size, ttl, utils.FLAG_NORMAL, version)
+	if err != nil {
+		return
+	}
+	err = tikv_txn.Set(key, utils.EncodeData(utils.LIST_TYPE, listMetaValue))
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyList, "linsert", key)
+	if err != nil {
+		return
+	}
+	tidis.notifyAfterCommit(txn, key)
+	ret = int64(size)
+	return
+}
+
 //Llen returns the specified elements of the list stored at key. T
 func (tidis *Tidis) LLen(txn interface{}, key []byte) (ret int64, err error) {
 	var (
@@ -55,7 +144,7 @@ func (tidis *Tidis) LLen(txn interface{}, key []byte) (ret int64, err error) {
 	if err != nil {
 		return
 	}
-	_, _, size, _, _, err = tidis.getListMetaWithType(txn, key)
+	_, _, size, _, _, _, err = tidis.getListMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
@@ -68,8 +157,8 @@ func (tidis *Tidis) LPop(txn interface{}, key []byte, direc uint8) (item []byte,
 	var (
 		tikv_txn              kv.Transaction
 		ok                    bool
-		notTransaction        bool
 		head, tail, size, ttl uint64
+		version               uint64
 		listMetaValue         []byte
 		listDataKey           []byte
 	)
@@ -78,40 +167,35 @@ func (tidis *Tidis) LPop(txn interface{}, key []byte, direc uint8) (item []byte,
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			item, err = tidis.LPop(txn, key, direc)
 			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
 	//delete list if expired
 	err = tidis.DeleteIfExpired(txn, key, true)
 	if err != nil {
 		return
 	}
-	head, tail, size, ttl, _, err = tidis.getListMetaWithType(txn, key)
+	head, tail, size, ttl, _, version, err = tidis.getListMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
+	if size == 0 {
+		return
+	}
 	if direc == utils.LHeadDirection {
-		listDataKey = utils.EncodeListData(key, head)
+		listDataKey = utils.EncodeListData(key, version, head)
 		head++
 	} else {
 		tail--
-		listDataKey = utils.EncodeListData(key, tail)
+		listDataKey = utils.EncodeListData(key, version, tail)
 	}
 	size--
 	if size == 0 {
@@ -122,7 +206,7 @@ func (tidis *Tidis) LPop(txn interface{}, key []byte, direc uint8) (item []byte,
 		}
 	} else {
 		// update meta key
-		listMetaValue, err = tidis.createListMeta(head, tail, size, ttl, utils.FLAG_NORMAL)
+		listMetaValue, err = tidis.createListMeta(head, tail, size, ttl, utils.FLAG_NORMAL, version)
 		if err != nil {
 			return
 		}
@@ -132,26 +216,99 @@ func (tidis *Tidis) LPop(txn interface{}, key []byte, direc uint8) (item []byte,
 			return
 		}
 	}
-	item, err = tikv_txn.GetSnapshot().Get(listDataKey)
-	if err != nil {
-		if !kv.IsErrNotFound(err) {
-			return
-		} else {
-			item = nil
-			err = nil
-			return
-		}
+	//items pushed earlier in the same transaction are only in the membuffer
+	item, err = tidis.db.Get(txn, listDataKey)
+	if err != nil || item == nil {
+		return
 	}
 	// delete item
 	err = tikv_txn.Delete(listDataKey)
 	if err != nil {
 		return
 	}
+	if direc == utils.LHeadDirection {
+		err = tidis.notify(txn, notifyList, "lpop", key)
+	} else {
+		err = tidis.notify(txn, notifyList, "rpop", key)
+	}
+	if err != nil || size > 0 {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "del", key)
+	return
+}
 
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
+//LPos returns the indexes of the elements equal to element in the list stored at key.
+//A negative rank searches from the tail, the |rank|-th match is the first one returned.
+//count 0 returns all the matches and maxlen 0 compares all the elements.
+func (tidis *Tidis) LPos(txn interface{}, key, element []byte, rank, count, maxlen int64) (positions []int64, err error) {
+	var (
+		tikv_txn   kv.Transaction
+		ok         bool
+		head, tail uint64
+		size       uint64
+		version    uint64
+		from, to   uint64
+		items      [][]byte
+		skip       int64
+		idx        int64
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			positions, err = tidis.LPos(txn, key, element, rank, count, maxlen)
 			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//delete list if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	head, tail, size, _, _, version, err = tidis.getListMetaWithType(txn, key)
+	if err != nil || size == 0 {
+		return
+	}
+	from, to = head, tail
+	if maxlen > 0 && uint64(maxlen) < size {
+		if rank > 0 {
+			to = head + uint64(maxlen)
+		} else {
+			from = tail - uint64(maxlen)
+		}
+	}
+	items, err = tidis.listItems(tikv_txn, key, version, from, to)
+	if err != nil {
+		return
+	}
+	skip = rank - 1
+	if rank < 0 {
+		skip = -rank - 1
+	}
+	for i := range items {
+		idx = int64(i)
+		if rank < 0 {
+			idx = int64(len(items) - 1 - i)
+		}
+		if !bytes.Equal(items[idx], element) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		positions = append(positions, int64(from-head)+idx)
+		if count > 0 && int64(len(positions)) == count {
+			break
 		}
 	}
 	return
@@ -162,8 +319,8 @@ func (tidis *Tidis) LPush(txn interface{}, key []byte, direc uint8, items ...[]b
 	var (
 		tikv_txn                     kv.Transaction
 		ok                           bool
-		notTransaction               bool
 		head, tail, size, ttl, index uint64
+		version                      uint64
 		itemCount                    uint64
 		listMetaValue                []byte
 		listDataKey                  []byte
@@ -174,34 +331,29 @@ func (tidis *Tidis) LPush(txn interface{}, key []byte, direc uint8, items ...[]b
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			count, err = tidis.LPush(txn, key, direc, items...)
 			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
 	//delete list if expired
 	err = tidis.DeleteIfExpired(txn, key, true)
 	if err != nil {
 		return
 	}
-	head, tail, size, ttl, _, err = tidis.getListMetaWithType(txn, key)
+	head, tail, size, ttl, _, version, err = tidis.getListMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
+	if size == 0 {
+		version = tidis.newVersion(txn)
+	}
 	itemCount = uint64(len(items))
 
 	if direc == utils.LHeadDirection {
@@ -212,7 +364,7 @@ func (tidis *Tidis) LPush(txn interface{}, key []byte, direc uint8, items ...[]b
 		tail = tail + itemCount
 	}
 	size = size + itemCount
-	listMetaValue, err = tidis.createListMeta(head, tail, size, ttl, utils.FLAG_NORMAL)
+	listMetaValue, err = tidis.createListMeta(head, tail, size, ttl, utils.FLAG_NORMAL, version)
 	if err != nil {
 		return
 	}
@@ -225,9 +377,9 @@ func (tidis *Tidis) LPush(txn interface{}, key []byte, direc uint8, items ...[]b
 	for _, item = range items {
 		if direc == utils.LHeadDirection {
 			index--
-			listDataKey = utils.EncodeListData(key, index)
+			listDataKey = utils.EncodeListData(key, version, index)
 		} else {
-			listDataKey = utils.EncodeListData(key, index)
+			listDataKey = utils.EncodeListData(key, version, index)
 			index++
 		}
 		err = tikv_txn.Set(listDataKey, item)
@@ -235,20 +387,83 @@ func (tidis *Tidis) LPush(txn interface{}, key []byte, direc uint8, items ...[]b
 			return
 		}
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
+	if direc == utils.LHeadDirection {
+		err = tidis.notify(txn, notifyList, "lpush", key)
+	} else {
+		err = tidis.notify(txn, notifyList, "rpush", key)
+	}
+	if err != nil {
+		return
 	}
+	//wake up the clients blocked on key, LPushX and the dest of LMove push through here
+	tidis.notifyAfterCommit(txn, key)
 	count = int64(size)
 	return
 }
 
+//LPushX insert the values at the head or tail of the list stored at key, only if key already holds a list.
+func (tidis *Tidis) LPushX(txn interface{}, key []byte, direc uint8, items ...[]byte) (count int64, err error) {
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			count, err = tidis.LPushX(txn, key, direc, items...)
+			return
+		})
+		return
+	}
+	//delete list if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	count, err = tidis.LLen(txn, key)
+	if err != nil || count == 0 {
+		return
+	}
+	return tidis.LPush(txn, key, direc, items...)
+}
+
+//LMove atomically removes the element at srcDirec of the list stored at src and pushes it at destDirec of the list stored at dest.
+func (tidis *Tidis) LMove(txn interface{}, src, dest []byte, srcDirec, destDirec uint8) (item []byte, err error) {
+	var (
+		destType byte
+	)
+	if len(src) == 0 || len(dest) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			item, err = tidis.LMove(txn, src, dest, srcDirec, destDirec)
+			return
+		})
+		return
+	}
+	//a dest of the wrong type leaves src untouched
+	destType, err = tidis.getType(txn, dest)
+	if err != nil {
+		return
+	}
+	if destType != utils.NONE_TYPE && destType != utils.LIST_TYPE {
+		err = qkverror.ErrorWrongType
+		return
+	}
+	item, err = tidis.LPop(txn, src, srcDirec)
+	if err != nil || item == nil {
+		return
+	}
+	_, err = tidis.LPush(txn, dest, destDirec, item)
+	return
+}
+
 //LRange returns the element at index index in the list stored at key.
 func (tidis *Tidis) LRange(txn interface{}, key []byte, start, stop int64) (resp []interface{}, err error) {
 	var (
 		head, size uint64
+		version    uint64
 		getKeys    [][]byte
 		membersM   map[string][]byte
 	)
@@ -264,7 +479,7 @@ func (tidis *Tidis) LRange(txn interface{}, key []byte, start, stop int64) (resp
 	if err != nil {
 		return
 	}
-	head, _, size, _, _, err = tidis.getListMetaWithType(txn, key)
+	head, _, size, _, _, version, err = tidis.getListMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
@@ -300,7 +515,7 @@ func (tidis *Tidis) LRange(txn interface{}, key []byte, start, stop int64) (resp
 	}
 	getKeys = make([][]byte, stop-start+1)
 	for i, _ := range getKeys {
-		getKeys[i] = utils.EncodeListData(key, head+uint64(start)+uint64(i))
+		getKeys[i] = utils.EncodeListData(key, version, head+uint64(start)+uint64(i))
 	}
 	membersM, err = tidis.db.MGet(txn, getKeys)
 	if err != nil {
@@ -318,45 +533,142 @@ func (tidis *Tidis) LRange(txn interface{}, key []byte, start, stop int64) (resp
 	return
 }
 
-//LSet sets the list element at index to value.
-func (tidis *Tidis) LSet(txn interface{}, key []byte, index int64, value []byte) (err error) {
+//LRem removes the first count occurrences of elements equal to value from the list stored at key.
+//count > 0 removes from head to tail, count < 0 from tail to head and count 0 removes them all.
+func (tidis *Tidis) LRem(txn interface{}, key []byte, count int64, value []byte) (removed int64, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		head, size     uint64
-		listDataKey    []byte
+		tikv_txn              kv.Transaction
+		ok                    bool
+		head, tail, size, ttl uint64
+		version               uint64
+		items                 [][]byte
+		remove                []bool
+		kept                  [][]byte
+		first                 int
+		idx                   int
+		limit                 int64
+		listMetaValue         []byte
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			removed, err = tidis.LRem(txn, key, count, value)
 			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//delete list if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	head, tail, size, ttl, _, version, err = tidis.getListMetaWithType(txn, key)
+	if err != nil || size == 0 {
+		return
+	}
+	items, err = tidis.listItems(tikv_txn, key, version, head, tail)
+	if err != nil {
+		return
+	}
+	limit = count
+	if count < 0 {
+		limit = -count
+	}
+	remove = make([]bool, len(items))
+	first = len(items)
+	for i := range items {
+		idx = i
+		if count < 0 {
+			idx = len(items) - 1 - i
 		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		if limit > 0 && removed == limit {
+			break
+		}
+		if bytes.Equal(items[idx], value) {
+			remove[idx] = true
+			removed++
+			if idx < first {
+				first = idx
+			}
+		}
+	}
+	if removed == 0 {
+		return
+	}
+	//the elements after the first removed one move towards the head
+	for i := first; i < len(items); i++ {
+		if !remove[i] {
+			kept = append(kept, items[i])
+		}
+	}
+	err = tidis.setListItems(tikv_txn, key, version, head+uint64(first), kept)
+	if err != nil {
+		return
+	}
+	size = size - uint64(removed)
+	for i := head + size; i < tail; i++ {
+		err = tikv_txn.Delete(utils.EncodeListData(key, version, i))
+		if err != nil {
 			return
 		}
-		defer tikv_txn.Rollback()
+	}
+	if size == 0 {
+		err = tikv_txn.Delete(key)
 	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		listMetaValue, err = tidis.createListMeta(head, head+size, size, ttl, utils.FLAG_NORMAL, version)
+		if err != nil {
 			return
 		}
+		err = tikv_txn.Set(key, utils.EncodeData(utils.LIST_TYPE, listMetaValue))
+	}
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyList, "lrem", key)
+	if err != nil || size > 0 {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "del", key)
+	return
+}
+
+//LSet sets the list element at index to value.
+func (tidis *Tidis) LSet(txn interface{}, key []byte, index int64, value []byte) (err error) {
+	var (
+		tikv_txn    kv.Transaction
+		ok          bool
+		head, size  uint64
+		version     uint64
+		listDataKey []byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		return tidis.RetryTxn(func(txn interface{}) error {
+			return tidis.LSet(txn, key, index, value)
+		})
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
 	//delete list if expired
 	err = tidis.DeleteIfExpired(txn, key, true)
 	if err != nil {
 		return
 	}
-	head, _, size, _, _, err = tidis.getListMetaWithType(txn, key)
+	head, _, size, _, _, version, err = tidis.getListMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
@@ -377,17 +689,12 @@ func (tidis *Tidis) LSet(txn interface{}, key []byte, index int64, value []byte)
 		err = qkverror.ErrorOutOfRange
 		return
 	}
-	listDataKey = utils.EncodeListData(key, uint64(index)+head)
+	listDataKey = utils.EncodeListData(key, version, uint64(index)+head)
 	err = tikv_txn.Set(listDataKey, value)
 	if err != nil {
 		return
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
-	}
+	err = tidis.notify(txn, notifyList, "lset", key)
 	return
 }
 
@@ -396,39 +703,29 @@ func (tidis *Tidis) LTrim(txn interface{}, key []byte, start, stop int64) (err e
 	var (
 		tikv_txn                               kv.Transaction
 		ok                                     bool
-		notTransaction                         bool
 		head, size, ttl, nhead, ntail, newSize uint64
+		version                                uint64
 		listDataKey                            []byte
 		listMetaValue                          []byte
 		needDel                                bool = false
 		x                                      int64
 	)
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
+		return tidis.RetryTxn(func(txn interface{}) error {
+			return tidis.LTrim(txn, key, start, stop)
+		})
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
 	//delete list if expired
 	err = tidis.DeleteIfExpired(txn, key, true)
 	if err != nil {
 		return
 	}
-	head, _, size, ttl, _, err = tidis.getListMetaWithType(txn, key)
+	head, _, size, ttl, _, version, err = tidis.getListMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
@@ -459,25 +756,22 @@ func (tidis *Tidis) LTrim(txn interface{}, key []byte, start, stop int64) (err e
 		needDel = true
 	}
 	if needDel {
+		//delete data
+		err = tidis.clearMembers(tikv_txn, key, utils.LIST_TYPE)
+		if err != nil {
+			return
+		}
 		//delete meta key
 		err = tikv_txn.Delete(key)
 		if err != nil {
 			return
 		}
-		//delete data
-		for i := start; i < stop; i++ {
-			listDataKey = utils.EncodeListData(key, head+uint64(i))
-			err = tikv_txn.Delete(listDataKey)
-			if err != nil {
-				return
-			}
-		}
 	} else {
 		nhead = head + uint64(start)
 		ntail = head + uint64(stop) + 1
 		newSize = ntail - nhead
 		// update meta key
-		listMetaValue, err = tidis.createListMeta(nhead, ntail, newSize, ttl, utils.FLAG_NORMAL)
+		listMetaValue, err = tidis.createListMeta(nhead, ntail, newSize, ttl, utils.FLAG_NORMAL, version)
 		if err != nil {
 			return
 		}
@@ -487,7 +781,7 @@ func (tidis *Tidis) LTrim(txn interface{}, key []byte, start, stop int64) (err e
 			return
 		}
 		for x = 0; x < start; x++ {
-			listDataKey = utils.EncodeListData(key, head+uint64(x))
+			listDataKey = utils.EncodeListData(key, version, head+uint64(x))
 			err = tikv_txn.Delete(listDataKey)
 			if err != nil {
 				return
@@ -495,19 +789,21 @@ func (tidis *Tidis) LTrim(txn interface{}, key []byte, start, stop int64) (err e
 		}
 
 		for x = stop; x < int64(newSize)-1; x++ {
-			listDataKey = utils.EncodeListData(key, head+uint64(x))
+			listDataKey = utils.EncodeListData(key, version, head+uint64(x))
 			err = tikv_txn.Delete(listDataKey)
 			if err != nil {
 				return
 			}
 		}
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
+	if size == 0 {
+		return
 	}
+	err = tidis.notify(txn, notifyList, "ltrim", key)
+	if err != nil || !needDel {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "del", key)
 	return
 }
 
@@ -516,31 +812,23 @@ func (tidis *Tidis) ClearListMembers(txn interface{}, key []byte) (deleted int64
 	var (
 		tikv_txn         kv.Transaction
 		ok               bool
-		notTransaction   bool
 		head, tail, size uint64
+		version          uint64
 		listDataKey      []byte
 	)
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			deleted, err = tidis.ClearListMembers(txn, key)
 			return
-		}
+		})
+		return
 	}
-	head, tail, size, _, _, err = tidis.getListMetaWithType(txn, key)
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	head, tail, size, _, _, version, err = tidis.getListMetaWithType(txn, key)
 	if err != nil {
 		return
 	}
@@ -552,25 +840,55 @@ func (tidis *Tidis) ClearListMembers(txn interface{}, key []byte) (deleted int64
 		return
 	}
 	for i := head; i < tail; i++ {
-		listDataKey = utils.EncodeListData(key, i)
+		listDataKey = utils.EncodeListData(key, version, i)
 		err = tikv_txn.Delete(listDataKey)
 		if err != nil {
 			return
 		}
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
+	return
+}
+
+//listItems elements of the list from index from to index to (exclusive), including the ones written earlier in txn
+func (tidis *Tidis) listItems(txn kv.Transaction, key []byte, version, from, to uint64) (items [][]byte, err error) {
+	var (
+		it     kv.Iterator
+		endKey kv.Key
+	)
+	if from >= to {
+		return
+	}
+	endKey = utils.EncodeListData(key, version, to)
+	it, err = txn.Seek(utils.EncodeListData(key, version, from))
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	items = make([][]byte, 0, to-from)
+	for it.Valid() && it.Key().Cmp(endKey) < 0 {
+		items = append(items, it.Value())
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//setListItems write items at the indexes starting from index from
+func (tidis *Tidis) setListItems(txn kv.Transaction, key []byte, version, from uint64, items [][]byte) (err error) {
+	for i, item := range items {
+		err = txn.Set(utils.EncodeListData(key, version, from+uint64(i)), item)
 		if err != nil {
 			return
 		}
 	}
 	return
 }
-func (tidis *Tidis) getListMetaWithType(txn interface{}, key []byte) (head uint64, tail uint64, size uint64, ttl uint64, flag byte, err error) {
+func (tidis *Tidis) getListMetaWithType(txn interface{}, key []byte) (head uint64, tail uint64, size uint64, ttl uint64, flag byte, version uint64, err error) {
 	var (
 		dataType byte
 	)
-	dataType, head, tail, size, ttl, flag, err = tidis.getListMeta(txn, key)
+	dataType, head, tail, size, ttl, flag, version, err = tidis.getListMeta(txn, key)
 	if size > 0 {
 		if dataType != utils.LIST_TYPE {
 			err = qkverror.ErrorWrongType
@@ -579,7 +897,9 @@ func (tidis *Tidis) getListMetaWithType(txn interface{}, key []byte) (head uint6
 	}
 	return
 }
-func (tidis *Tidis) getListMeta(txn interface{}, key []byte) (dataType byte, head uint64, tail uint64, size uint64, ttl uint64, flag byte, err error) {
+
+//getListMeta head(8)|tail(8)|size(8)|ttl(8)|flag(1)|version(8), meta written before versioned meta has no version.
+func (tidis *Tidis) getListMeta(txn interface{}, key []byte) (dataType byte, head uint64, tail uint64, size uint64, ttl uint64, flag byte, version uint64, err error) {
 	var (
 		rawData []byte
 		value   []byte
@@ -600,6 +920,11 @@ func (tidis *Tidis) getListMeta(txn interface{}, key []byte) (dataType byte, hea
 	if err != nil {
 		return
 	}
+	//the meta of the other types may not decode as a list meta
+	if dataType != utils.LIST_TYPE {
+		err = qkverror.ErrorWrongType
+		return
+	}
 	if value == nil {
 		head = utils.LItemDefaultIndex
 		tail = utils.LItemDefaultIndex
@@ -627,10 +952,13 @@ func (tidis *Tidis) getListMeta(txn interface{}, key []byte) (dataType byte, hea
 	if len(value) > 32 {
 		flag = value[32]
 	}
+	if len(value) >= 41 {
+		version, _ = utils.BytesToUint64(value[33:])
+	}
 	return
 }
-func (tidis *Tidis) createListMeta(head, tail, size, ttl uint64, flag byte) (buf []byte, err error) {
-	buf = make([]byte, 32+1)
+func (tidis *Tidis) createListMeta(head, tail, size, ttl uint64, flag byte, version uint64) (buf []byte, err error) {
+	buf = make([]byte, 32+1+8)
 	err = utils.Uint64ToBytesExt(buf[0:], head)
 	if err != nil {
 		return
@@ -650,5 +978,6 @@ func (tidis *Tidis) createListMeta(head, tail, size, ttl uint64, flag byte) (buf
 		return
 	}
 	buf[32] = flag
+	err = utils.Uint64ToBytesExt(buf[33:], version)
 	return
 }
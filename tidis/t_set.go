@@ -1,7 +1,7 @@
 package tidis
 
 import (
-	"context"
+	"math/rand"
 
 	"github.com/chuangyou/qkv/qkverror"
 	"github.com/chuangyou/qkv/utils"
@@ -18,43 +18,46 @@ const (
 //SAdd add the specified members to the set stored at key.
 func (tidis *Tidis) SAdd(txn interface{}, key []byte, members ...[]byte) (ret int, err error) {
 	var (
-		notTransaction bool
-		tikv_txn       kv.Transaction
-		ok             bool
-		ssize          uint64
-		ttl            uint64
-		setMemberKey   []byte
-		member         []byte
-		value          []byte
-		setValue       []byte
-		addedCount     int
+		tikv_txn     kv.Transaction
+		ok           bool
+		ssize        uint64
+		ttl          uint64
+		setMemberKey []byte
+		member       []byte
+		value        []byte
+		setValue     []byte
+		addedCount   int
+		version      uint64
+		rank         *rankIndex
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.SAdd(txn, key, members...)
 			return
-		}
-		defer tikv_txn.Rollback()
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
 	//get set
-	ssize, ttl, _, err = tidis.getSetMeta(txn, key)
+	ssize, ttl, _, version, err = tidis.getSetMeta(txn, key)
 	if err != nil {
 		return
 	}
+	if ssize == 0 {
+		version = tidis.newVersion(txn)
+	}
+	rank = setRank(tikv_txn, key, version)
 	//add members
 	for _, member = range members {
-		setMemberKey = utils.EncodeSetData(key, member)
+		setMemberKey = utils.EncodeSetData(key, version, member)
 		value, err = tidis.db.Get(txn, setMemberKey)
 		if err != nil {
 			return
@@ -64,24 +67,23 @@ func (tidis *Tidis) SAdd(txn interface{}, key []byte, members ...[]byte) (ret in
 			if err != nil {
 				return
 			}
+			err = rank.insert(setMemberKey)
+			if err != nil {
+				return
+			}
 			addedCount++
 		}
 	}
 	// update meta
-	setValue = tidis.createSetMeta(ssize+uint64(addedCount), ttl, utils.FLAG_NORMAL)
+	setValue = tidis.createSetMeta(ssize+uint64(addedCount), ttl, utils.FLAG_NORMAL, version)
 	//encode value
 	setValue = utils.EncodeData(utils.SET_TYPE, setValue)
 	err = tidis.db.Set(txn, key, setValue)
 	ret = addedCount
-	if err != nil {
+	if err != nil || addedCount == 0 {
 		return
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
-	}
+	err = tidis.notify(txn, notifySet, "sadd", key)
 	return
 }
 
@@ -95,7 +97,7 @@ func (tidis *Tidis) SCard(txn interface{}, key []byte) (ret int64, err error) {
 		return
 	}
 	//get set
-	ssize, _, _, err = tidis.getSetMeta(txn, key)
+	ssize, _, _, _, err = tidis.getSetMeta(txn, key)
 	if err != nil {
 		return
 	}
@@ -128,16 +130,17 @@ func (tidis *Tidis) Sismember(txn interface{}, key, member []byte) (ret int64, e
 	var (
 		setValue []byte
 		value    []byte
+		version  uint64
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
-	_, _, _, err = tidis.getSetMeta(txn, key)
+	_, _, _, version, err = tidis.getSetMeta(txn, key)
 	if err != nil {
 		return
 	}
-	setValue = utils.EncodeSetData(key, member)
+	setValue = utils.EncodeSetData(key, version, member)
 	value, err = tidis.db.Get(txn, setValue)
 	if err != nil {
 		return
@@ -156,17 +159,18 @@ func (tidis *Tidis) SMembers(txn interface{}, key []byte) (iMembers []interface{
 		members  [][]byte
 		member   []byte
 		i        int
+		version  uint64
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	//get set
-	ssize, _, _, err = tidis.getSetMeta(txn, key)
+	ssize, _, _, version, err = tidis.getSetMeta(txn, key)
 	if err != nil {
 		return
 	}
-	startKey = utils.EncodeSetData(key, []byte(nil))
+	startKey = utils.EncodeSetData(key, version, []byte(nil))
 	members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, nil, true, 0, ssize, false)
 	if err != nil {
 		return
@@ -185,38 +189,214 @@ func (tidis *Tidis) SMembers(txn interface{}, key []byte) (iMembers []interface{
 //SRem remove one or more members from a set
 func (tidis *Tidis) SRem(txn interface{}, key []byte, members ...[]byte) (removed int64, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		setMemberKey   []byte
-		value          []byte
-		ssize          uint64
-		ttl            uint64
-		flag           byte
-		setValue       []byte
-		member         []byte
+		ssize uint64
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			removed, err = tidis.SRem(txn, key, members...)
+			return
+		})
+		return
+	}
+	removed, ssize, err = tidis.sRem(txn, key, members...)
+	if err != nil || removed == 0 {
+		return
+	}
+	err = tidis.notify(txn, notifySet, "srem", key)
+	if err != nil || ssize > 0 {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "del", key)
+	return
+}
+
+//SPop removes and returns up to count random members from the set stored at key.
+func (tidis *Tidis) SPop(txn interface{}, key []byte, count int64) (popped []interface{}, err error) {
+	var (
+		members [][]byte
+		removed int64
+		ssize   uint64
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			popped, err = tidis.SPop(txn, key, count)
+			return
+		})
+		return
+	}
+	if count <= 0 {
+		popped = utils.EmptyListInterfaces
+		return
+	}
+	popped, err = tidis.setRandomMembers(txn, key, count)
+	if err != nil || len(popped) == 0 {
+		return
+	}
+	members = make([][]byte, len(popped))
+	for i, member := range popped {
+		members[i] = member.([]byte)
+	}
+	removed, ssize, err = tidis.sRem(txn, key, members...)
+	if err != nil || removed == 0 {
+		return
+	}
+	err = tidis.notify(txn, notifySet, "spop", key)
+	if err != nil || ssize > 0 {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "del", key)
+	return
+}
+
+//SRandMember returns count random members of the set stored at key. A positive count returns distinct members,
+//a negative count may return the same member several times.
+func (tidis *Tidis) SRandMember(txn interface{}, key []byte, count int64) (members []interface{}, err error) {
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	return tidis.setRandomMembers(txn, key, count)
+}
+
+//SMove moves member from the set stored at src to the set stored at dest, returns 0 if member is not in src.
+func (tidis *Tidis) SMove(txn interface{}, src, dest, member []byte) (ret int64, err error) {
+	if len(src) == 0 || len(dest) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.SMove(txn, src, dest, member)
+			return
+		})
+		return
+	}
+	//dest must be a set too, even if member is not moved
+	_, _, _, _, err = tidis.getSetMeta(txn, dest)
+	if err != nil {
+		return
+	}
+	ret, err = tidis.Sismember(txn, src, member)
+	if err != nil || ret == 0 || string(src) == string(dest) {
+		return
+	}
+	_, err = tidis.SRem(txn, src, member)
+	if err != nil {
+		return
+	}
+	_, err = tidis.SAdd(txn, dest, member)
+	return
+}
+
+//SMIsMember returns whether each member is a member of the set stored at key.
+func (tidis *Tidis) SMIsMember(txn interface{}, key []byte, members ...[]byte) (resp []interface{}, err error) {
+	var (
+		ret int64
+	)
+	resp = make([]interface{}, len(members))
+	for i, member := range members {
+		ret, err = tidis.Sismember(txn, key, member)
 		if err != nil {
 			return
 		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		resp[i] = ret
+	}
+	return
+}
+
+//SInterCard returns the cardinality of the intersection of the sets stored at keys, counting stops at limit if it is positive.
+//Only the members of the smallest set are read, the other sets are probed member by member.
+func (tidis *Tidis) SInterCard(txn interface{}, limit int64, keys ...[]byte) (count int64, err error) {
+	var (
+		ssize    uint64
+		smallest int
+		minSize  uint64
+		members  []interface{}
+		ret      int64
+		found    bool
+	)
+	if len(keys) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	for i, key := range keys {
+		ssize, _, _, _, err = tidis.getSetMeta(txn, key)
+		if err != nil {
 			return
 		}
-		defer tikv_txn.Rollback()
+		if ssize == 0 {
+			return
+		}
+		if i == 0 || ssize < minSize {
+			smallest, minSize = i, ssize
+		}
+	}
+	members, err = tidis.SMembers(txn, keys[smallest])
+	if err != nil {
+		return
+	}
+	for _, member := range members {
+		found = true
+		for i, key := range keys {
+			if i == smallest {
+				continue
+			}
+			ret, err = tidis.Sismember(txn, key, member.([]byte))
+			if err != nil {
+				return
+			}
+			if ret == 0 {
+				found = false
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+		count++
+		if limit > 0 && count >= limit {
+			return
+		}
+	}
+	return
+}
+
+//sRem SRem without the keyspace notification, returns the number of members left
+func (tidis *Tidis) sRem(txn interface{}, key []byte, members ...[]byte) (removed int64, ssize uint64, err error) {
+	var (
+		tikv_txn     kv.Transaction
+		ok           bool
+		setMemberKey []byte
+		value        []byte
+		ttl          uint64
+		flag         byte
+		setValue     []byte
+		member       []byte
+		version      uint64
+		rank         *rankIndex
+	)
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//get meta
+	ssize, ttl, flag, version, err = tidis.getSetMeta(txn, key)
+	if err != nil {
+		return
 	}
+	rank = setRank(tikv_txn, key, version)
 	for _, member = range members {
 		//encode member
-		setMemberKey = utils.EncodeSetData(key, member)
+		setMemberKey = utils.EncodeSetData(key, version, member)
 		//get member
 		value, err = tidis.db.Get(txn, setMemberKey)
 		if err != nil {
@@ -228,46 +408,36 @@ func (tidis *Tidis) SRem(txn interface{}, key []byte, members ...[]byte) (remove
 			if err != nil {
 				return
 			}
-			removed++
-		}
-	}
-	if removed > 0 {
-		//get meta
-		ssize, ttl, flag, err = tidis.getSetMeta(txn, key)
-		if err != nil {
-			return
-		}
-		if ssize < uint64(removed) {
-			err = qkverror.ErrorInvalidMeta
-			return
-		}
-		ssize = ssize - uint64(removed)
-		if ssize > 0 {
-			//update meta
-			setValue = tidis.createSetMeta(ssize, ttl, flag)
-			//encode value type
-			setValue = utils.EncodeData(utils.SET_TYPE, setValue)
-			err = tikv_txn.Set(key, setValue)
-			if err != nil {
-				return
-			}
-		} else {
-			//if no member,then delete set key
-			err = tikv_txn.Delete(key)
+			err = rank.remove(setMemberKey)
 			if err != nil {
 				return
 			}
+			removed++
 		}
-
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
+	if removed == 0 {
+		return
+	}
+	if ssize < uint64(removed) {
+		err = qkverror.ErrorInvalidMeta
+		return
+	}
+	ssize = ssize - uint64(removed)
+	if ssize > 0 {
+		//update meta
+		setValue = tidis.createSetMeta(ssize, ttl, flag, version)
+		//encode value type
+		setValue = utils.EncodeData(utils.SET_TYPE, setValue)
+		err = tikv_txn.Set(key, setValue)
+	} else {
+		//if no member,then delete set key and the rank index
+		err = tikv_txn.Delete(key)
 		if err != nil {
 			return
 		}
+		err = rank.clear()
 	}
 	return
-
 }
 
 //SUnion returns the members of the set resulting from the union of all the given sets.
@@ -275,24 +445,33 @@ func (tidis *Tidis) SUnion(txn interface{}, keys ...[]byte) ([]interface{}, erro
 	return tidis.SAction(txn, Union, keys...)
 }
 
+//SUnionStore this command is equal to SUNION, but instead of returning the resulting set, it is stored in destination.
+func (tidis *Tidis) SUnionStore(txn interface{}, dest []byte, keys ...[]byte) (ret int64, err error) {
+	return tidis.SStoreAction(txn, Union, dest, keys...)
+}
+
 //SDel clear set keys
 func (tidis *Tidis) ClearSetMembers(txn interface{}, key []byte) (deleted int64, err error) {
 	var (
 		ssize    uint64
 		startKey []byte
+		version  uint64
 	)
-	ssize, _, _, err = tidis.getSetMeta(txn, key)
+	ssize, _, _, version, err = tidis.getSetMeta(txn, key)
 	if err != nil {
 		return
 	}
 	if ssize == 0 {
 		return
 	}
-	startKey = utils.EncodeSetData(key, []byte(nil))
+	startKey = utils.EncodeSetData(key, version, []byte(nil))
 	_, err = tidis.db.DeleteRangeWithTxn(txn, startKey, nil, ssize)
 	if err != nil {
 		return
 	}
+	if tikv_txn, ok := txn.(kv.Transaction); ok {
+		err = setRank(tikv_txn, key, version).clear()
+	}
 	return
 }
 func (tidis *Tidis) SAction(txn interface{}, actionType int, keys ...[]byte) (setSlice []interface{}, err error) {
@@ -334,37 +513,34 @@ func (tidis *Tidis) SAction(txn interface{}, actionType int, keys ...[]byte) (se
 }
 func (tidis *Tidis) SStoreAction(txn interface{}, actionType int, dest []byte, keys ...[]byte) (ret int64, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		ssize          uint64
-		mapSets        []mapset.Set
-		actionSet      mapset.Set
-		startKey       []byte
-		member         interface{}
-		setMemberKey   []byte
-		destSetData    []byte
+		tikv_txn     kv.Transaction
+		ok           bool
+		ssize        uint64
+		mapSets      []mapset.Set
+		actionSet    mapset.Set
+		member       interface{}
+		setMemberKey []byte
+		destSetData  []byte
+		version      uint64
 	)
 	if len(keys) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.SStoreAction(txn, actionType, dest, keys...)
 			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
 	//get size
-	ssize, _, _, err = tidis.getSetMeta(txn, dest)
+	ssize, _, _, _, err = tidis.getSetMeta(txn, dest)
 	if err != nil {
 		return
 	}
@@ -394,34 +570,44 @@ func (tidis *Tidis) SStoreAction(txn interface{}, actionType int, dest []byte, k
 		}
 	}
 	if ssize != 0 {
-		startKey = utils.EncodeSetData(dest, []byte(nil))
-		_, err = tidis.db.DeleteRangeWithTxn(txn, startKey, nil, ssize)
+		err = tidis.clearMembers(tikv_txn, dest, utils.SET_TYPE)
 		if err != nil {
 			return
 		}
 	}
 	// save new
+	version = tidis.newVersion(txn)
 	for _, member = range actionSet.ToSlice() {
 		//encode set member
-		setMemberKey = utils.EncodeSetData(dest, []byte(member.(string)))
+		setMemberKey = utils.EncodeSetData(dest, version, []byte(member.(string)))
 		err = tikv_txn.Set(setMemberKey, []byte{0})
 		if err != nil {
 			return
 		}
 	}
+	if actionSet.Cardinality() > 0 {
+		err = setRank(tikv_txn, dest, version).build()
+		if err != nil {
+			return
+		}
+	}
 	//update meta
-	destSetData = tidis.createSetMeta(uint64(actionSet.Cardinality()), 0, utils.FLAG_NORMAL)
+	destSetData = tidis.createSetMeta(uint64(actionSet.Cardinality()), 0, utils.FLAG_NORMAL, version)
 	destSetData = utils.EncodeData(utils.SET_TYPE, destSetData)
 	err = tikv_txn.Set(dest, destSetData)
 	if err != nil {
 		return
 	}
-
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
+	switch actionType {
+	case Diff:
+		err = tidis.notify(txn, notifySet, "sdiffstore", dest)
+	case Inter:
+		err = tidis.notify(txn, notifySet, "sinterstore", dest)
+	case Union:
+		err = tidis.notify(txn, notifySet, "sunionstore", dest)
+	}
+	if err != nil {
+		return
 	}
 	ret = int64(actionSet.Cardinality())
 	return
@@ -439,10 +625,11 @@ func (tidis *Tidis) newSetsFromKeys(txn interface{}, keys ...[]byte) (mapSets []
 		membersStr []interface{}
 		member     []byte
 		field      []byte
+		version    uint64
 	)
 	mapSets = make([]mapset.Set, len(keys))
 	for i, key = range keys {
-		ssize, _, _, err = tidis.getSetMeta(txn, key)
+		ssize, _, _, version, err = tidis.getSetMeta(txn, key)
 		if err != nil {
 			return
 		}
@@ -450,7 +637,7 @@ func (tidis *Tidis) newSetsFromKeys(txn interface{}, keys ...[]byte) (mapSets []
 			mapSets[i] = nil
 			continue
 		}
-		startKey = utils.EncodeSetData(key, []byte(nil))
+		startKey = utils.EncodeSetData(key, version, []byte(nil))
 		members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, nil, true, 0, ssize, false)
 		if err != nil {
 			return
@@ -465,19 +652,183 @@ func (tidis *Tidis) newSetsFromKeys(txn interface{}, keys ...[]byte) (mapSets []
 	return
 }
 
-func (tidis *Tidis) getSetMeta(txn interface{}, key []byte) (ssize uint64, ttl uint64, flag byte, err error) {
+func (tidis *Tidis) getSetMeta(txn interface{}, key []byte) (ssize uint64, ttl uint64, flag byte, version uint64, err error) {
 	var (
 		dataType byte
+		ranked   bool
 	)
-	dataType, ssize, ttl, flag, err = tidis.getHashMeta(txn, key)
-	if ssize > 0 {
-		if dataType != utils.SET_TYPE {
-			err = qkverror.ErrorWrongType
+	dataType, ssize, ttl, flag, version, ranked, err = tidis.getSetMetaWithRank(txn, key)
+	if err != nil || ssize == 0 {
+		return
+	}
+	if dataType != utils.SET_TYPE {
+		err = qkverror.ErrorWrongType
+		return
+	}
+	if !ranked {
+		err = tidis.buildSetRank(txn, key)
+	}
+	return
+}
+
+//getSetMetaWithRank the set meta without building the rank index, ranked tells whether the set has it
+func (tidis *Tidis) getSetMetaWithRank(txn interface{}, key []byte) (dataType byte, ssize uint64, ttl uint64, flag byte, version uint64, ranked bool, err error) {
+	var (
+		rawData []byte
+		value   []byte
+	)
+	flag = utils.FLAG_NORMAL
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	rawData, err = tidis.db.Get(txn, key)
+	if err != nil || rawData == nil {
+		return
+	}
+	dataType, value, err = utils.DecodeData(rawData)
+	if err != nil || value == nil {
+		return
+	}
+	//the meta of the other types may not decode as a set meta
+	switch dataType {
+	case utils.SET_TYPE, utils.ZSET_TYPE, utils.HASH_TYPE, utils.BITMAP_TYPE:
+	default:
+		err = qkverror.ErrorWrongType
+		return
+	}
+	ssize, ttl, flag, version, err = decodeHashMeta(value)
+	ranked = len(value) == 26 && value[25] == utils.SET_MEMBER_RANK
+	return
+}
+func (tidis *Tidis) createSetMeta(size, ttl uint64, flag byte, version uint64) []byte {
+	return append(tidis.createHashMeta(size, ttl, flag, version), utils.SET_MEMBER_RANK)
+}
+
+//setRank the rank index of the member keys of the set at key
+func setRank(txn kv.Transaction, key []byte, version uint64) *rankIndex {
+	return newRankIndex(txn, key, version, utils.EncodeMemberPrefix(utils.SET_DATA, key, version))
+}
+
+//buildSetRank builds the rank index of a set written before it, and marks it in the meta.
+func (tidis *Tidis) buildSetRank(txn interface{}, key []byte) (err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		dataType byte
+		ssize    uint64
+		ttl      uint64
+		flag     byte
+		version  uint64
+		ranked   bool
+	)
+	if txn == nil {
+		return tidis.RetryTxn(func(txn interface{}) error {
+			return tidis.buildSetRank(txn, key)
+		})
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//read meta again, another client may have built it
+	dataType, ssize, ttl, flag, version, ranked, err = tidis.getSetMetaWithRank(txn, key)
+	if err != nil || dataType != utils.SET_TYPE || ssize == 0 || ranked {
+		return
+	}
+	err = setRank(tikv_txn, key, version).build()
+	if err != nil {
+		return
+	}
+	return tikv_txn.Set(key, utils.EncodeData(utils.SET_TYPE, tidis.createSetMeta(ssize, ttl, flag, version)))
+}
+
+//setRandomMembers count random members of the set stored at key, distinct ones if count is positive, the whole set if
+//count is not less than its size. Each member is drawn by its rank below the size in the meta and found with the rank
+//index of the set, so all the members have the same chance and a member costs O(log n) reads.
+func (tidis *Tidis) setRandomMembers(txn interface{}, key []byte, count int64) (members []interface{}, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		ssize    uint64
+		version  uint64
+		ranks    []int64
+		rank     *rankIndex
+		dataKey  []byte
+	)
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			members, err = tidis.setRandomMembers(txn, key, count)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	ssize, _, _, version, err = tidis.getSetMeta(txn, key)
+	if err != nil {
+		return
+	}
+	if ssize == 0 || count == 0 {
+		members = utils.EmptyListInterfaces
+		return
+	}
+	if count >= int64(ssize) {
+		return tidis.SMembers(txn, key)
+	}
+	ranks, _, _ = randomRanks(ssize, count)
+	rank = setRank(tikv_txn, key, version)
+	members = make([]interface{}, len(ranks))
+	for i := range ranks {
+		dataKey, err = rank.seek(uint64(ranks[i]))
+		if err != nil {
+			return
+		}
+		if dataKey == nil {
+			err = qkverror.ErrorInvalidMeta
+			return
+		}
+		_, members[i], err = utils.DecodeSetData(dataKey)
+		if err != nil {
 			return
 		}
 	}
 	return
 }
-func (tidis *Tidis) createSetMeta(size, ttl uint64, flag byte) []byte {
-	return tidis.createHashMeta(size, ttl, flag)
+
+//randomRanks picks |count| random ranks out of size, distinct ones if count is positive and less than size.
+//first and last are the lowest and the greatest rank picked.
+func randomRanks(size uint64, count int64) (ranks []int64, first, last int64) {
+	var (
+		picked map[int64]bool
+		rank   int64
+	)
+	if count > 0 {
+		picked = make(map[int64]bool, count)
+		for int64(len(ranks)) < count {
+			rank = rand.Int63n(int64(size))
+			if !picked[rank] {
+				picked[rank] = true
+				ranks = append(ranks, rank)
+			}
+		}
+	} else {
+		for n := int64(0); n < -count; n++ {
+			ranks = append(ranks, rand.Int63n(int64(size)))
+		}
+	}
+	first, last = ranks[0], ranks[0]
+	for _, rank = range ranks {
+		if rank < first {
+			first = rank
+		}
+		if rank > last {
+			last = rank
+		}
+	}
+	return
 }
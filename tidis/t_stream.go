@@ -0,0 +1,956 @@
+package tidis
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+)
+
+//StreamID id of a stream entry, the ms time and a sequence number within the ms
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	StreamIDMin = StreamID{0, 0}
+	StreamIDMax = StreamID{math.MaxUint64, math.MaxUint64}
+)
+
+//Bytes the id as ms-seq
+func (id StreamID) Bytes() []byte {
+	return []byte(strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10))
+}
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+//Next the smallest id greater than id, ok is false if id is the greatest one
+func (id StreamID) Next() (next StreamID, ok bool) {
+	if id.Seq < math.MaxUint64 {
+		return StreamID{id.Ms, id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return id, false
+}
+
+//Prev the greatest id smaller than id, ok is false if id is the smallest one
+func (id StreamID) Prev() (prev StreamID, ok bool) {
+	if id.Seq > 0 {
+		return StreamID{id.Ms, id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+//StreamEntry an entry of a stream, Fields is nil for a pending entry deleted from the stream
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+//StreamPending an entry delivered to a consumer of a group and not acknowledged yet, Idle is in ms
+type StreamPending struct {
+	ID         StreamID
+	Consumer   []byte
+	Idle       int64
+	Deliveries int64
+}
+
+//XAdd appends an entry to the stream stored at key. The id is generated with autoMs, only its sequence with autoSeq.
+//maxlen >= 0 trims the stream to its newest maxlen entries. ok is false if key does not exist and nomkstream is set.
+func (tidis *Tidis) XAdd(txn interface{}, key []byte, id StreamID, autoMs, autoSeq bool, fields [][]byte, maxlen int64, nomkstream bool) (added StreamID, ok bool, err error) {
+	var (
+		tikv_txn      kv.Transaction
+		exists        bool
+		size, version uint64
+		last          StreamID
+		now           uint64
+		trimmed       int64
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		err = qkverror.ErrorStreamFields
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			added, ok, err = tidis.XAdd(txn, key, id, autoMs, autoSeq, fields, maxlen, nomkstream)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	ok = false
+	//delete stream if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	exists, size, version, last, err = tidis.getStreamMeta(txn, key)
+	if err != nil {
+		return
+	}
+	if !exists {
+		if nomkstream {
+			return
+		}
+		version = tidis.newVersion(txn)
+	}
+	switch {
+	case autoMs:
+		now = streamNow(tikv_txn)
+		if now > last.Ms {
+			added = StreamID{now, 0}
+		} else if added, ok = last.Next(); !ok {
+			err = qkverror.ErrorStreamIDSmall
+			return
+		}
+	case autoSeq:
+		if id.Ms < last.Ms || (id.Ms == last.Ms && last.Seq == math.MaxUint64) {
+			err = qkverror.ErrorStreamIDSmall
+			return
+		}
+		added = StreamID{id.Ms, 0}
+		if id.Ms == last.Ms {
+			added.Seq = last.Seq + 1
+		}
+	default:
+		if id == StreamIDMin {
+			err = qkverror.ErrorStreamIDZero
+			return
+		}
+		if !last.Less(id) {
+			err = qkverror.ErrorStreamIDSmall
+			return
+		}
+		added = id
+	}
+	err = tikv_txn.Set(utils.EncodeStreamData(key, version, added.Ms, added.Seq), utils.EncodeStreamFields(fields))
+	if err != nil {
+		return
+	}
+	size++
+	if maxlen >= 0 && size > uint64(maxlen) {
+		trimmed, err = tidis.trimStream(tikv_txn, key, version, size-uint64(maxlen))
+		if err != nil {
+			return
+		}
+		size = size - uint64(trimmed)
+	}
+	err = tikv_txn.Set(key, utils.EncodeData(utils.STREAM_TYPE, tidis.createStreamMeta(size, version, added)))
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyStream, "xadd", key)
+	if err != nil {
+		return
+	}
+	if trimmed > 0 {
+		err = tidis.notify(txn, notifyStream, "xtrim", key)
+		if err != nil {
+			return
+		}
+	}
+	//wake up the clients reading key
+	tidis.wakeAfterCommit(txn, key)
+	ok = true
+	return
+}
+
+//XLen returns the number of entries of the stream stored at key.
+func (tidis *Tidis) XLen(txn interface{}, key []byte) (size int64, err error) {
+	var (
+		ssize uint64
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	//delete stream if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	_, ssize, _, _, err = tidis.getStreamMeta(txn, key)
+	if err != nil {
+		return
+	}
+	size = int64(ssize)
+	return
+}
+
+//XLastID returns the id of the last entry added to the stream stored at key, 0-0 if key does not exist.
+func (tidis *Tidis) XLastID(txn interface{}, key []byte) (last StreamID, err error) {
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	_, _, _, last, err = tidis.getStreamMeta(txn, key)
+	return
+}
+
+//XRange returns the entries of the stream stored at key with an id between start and end (inclusive),
+//from end to start if reverse is set. count <= 0 returns all of them.
+func (tidis *Tidis) XRange(txn interface{}, key []byte, start, end StreamID, count int64, reverse bool) (entries []StreamEntry, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		exists   bool
+		version  uint64
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			entries, err = tidis.XRange(txn, key, start, end, count, reverse)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//delete stream if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	exists, _, version, _, err = tidis.getStreamMeta(txn, key)
+	if err != nil || !exists || end.Less(start) {
+		return
+	}
+	entries, err = tidis.streamEntries(tikv_txn, key, version, start, end, count, reverse)
+	return
+}
+
+//XDel removes the entries with the ids from the stream stored at key, returns the number of entries deleted.
+//The entries stay pending in the consumer groups until they are acknowledged.
+func (tidis *Tidis) XDel(txn interface{}, key []byte, ids ...StreamID) (deleted int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		exists   bool
+		size     uint64
+		version  uint64
+		last     StreamID
+		dataKey  []byte
+		value    []byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			deleted, err = tidis.XDel(txn, key, ids...)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//delete stream if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	exists, size, version, last, err = tidis.getStreamMeta(txn, key)
+	if err != nil || !exists {
+		return
+	}
+	for _, id := range ids {
+		dataKey = utils.EncodeStreamData(key, version, id.Ms, id.Seq)
+		value, err = tidis.db.Get(txn, dataKey)
+		if err != nil {
+			return
+		}
+		if value == nil {
+			continue
+		}
+		err = tikv_txn.Delete(dataKey)
+		if err != nil {
+			return
+		}
+		deleted++
+	}
+	if deleted == 0 {
+		return
+	}
+	if size < uint64(deleted) {
+		err = qkverror.ErrorInvalidMeta
+		return
+	}
+	//the stream is kept without entries, the next id still has to be greater than last
+	err = tikv_txn.Set(key, utils.EncodeData(utils.STREAM_TYPE, tidis.createStreamMeta(size-uint64(deleted), version, last)))
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyStream, "xdel", key)
+	return
+}
+
+//XTrim removes the oldest entries of the stream stored at key to keep at most maxlen of them, returns the number of entries removed.
+func (tidis *Tidis) XTrim(txn interface{}, key []byte, maxlen int64) (trimmed int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		exists   bool
+		size     uint64
+		version  uint64
+		last     StreamID
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			trimmed, err = tidis.XTrim(txn, key, maxlen)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//delete stream if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	exists, size, version, last, err = tidis.getStreamMeta(txn, key)
+	if err != nil || !exists || size <= uint64(maxlen) {
+		return
+	}
+	trimmed, err = tidis.trimStream(tikv_txn, key, version, size-uint64(maxlen))
+	if err != nil || trimmed == 0 {
+		return
+	}
+	err = tikv_txn.Set(key, utils.EncodeData(utils.STREAM_TYPE, tidis.createStreamMeta(size-uint64(trimmed), version, last)))
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyStream, "xtrim", key)
+	return
+}
+
+//XGroupCreate creates the consumer group of the stream stored at key, delivering the entries after id,
+//or only the entries added from now on with fromLast. mkstream creates an empty stream if key does not exist.
+func (tidis *Tidis) XGroupCreate(txn interface{}, key, group []byte, id StreamID, fromLast, mkstream bool) (err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		exists   bool
+		size     uint64
+		version  uint64
+		last     StreamID
+		groupKey []byte
+		value    []byte
+	)
+	if len(key) == 0 || len(group) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		return tidis.RetryTxn(func(txn interface{}) error {
+			return tidis.XGroupCreate(txn, key, group, id, fromLast, mkstream)
+		})
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//delete stream if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	exists, size, version, last, err = tidis.getStreamMeta(txn, key)
+	if err != nil {
+		return
+	}
+	if !exists {
+		if !mkstream {
+			err = qkverror.ErrorStreamNoKey
+			return
+		}
+		version = tidis.newVersion(txn)
+		err = tikv_txn.Set(key, utils.EncodeData(utils.STREAM_TYPE, tidis.createStreamMeta(size, version, last)))
+		if err != nil {
+			return
+		}
+	}
+	groupKey = utils.EncodeStreamGroup(key, version, group)
+	value, err = tidis.db.Get(txn, groupKey)
+	if err != nil {
+		return
+	}
+	if value != nil {
+		err = qkverror.ErrorBusyGroup
+		return
+	}
+	if fromLast {
+		id = last
+	}
+	err = tikv_txn.Set(groupKey, encodeStreamID(id))
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyStream, "xgroup-create", key)
+	return
+}
+
+//XGroupSetID sets the last delivered id of the consumer group, to the last entry of the stream with fromLast.
+func (tidis *Tidis) XGroupSetID(txn interface{}, key, group []byte, id StreamID, fromLast bool) (err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		version  uint64
+		last     StreamID
+	)
+	if len(key) == 0 || len(group) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		return tidis.RetryTxn(func(txn interface{}) error {
+			return tidis.XGroupSetID(txn, key, group, id, fromLast)
+		})
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	version, last, _, err = tidis.getStreamGroup(txn, key, group)
+	if err != nil {
+		return
+	}
+	if fromLast {
+		id = last
+	}
+	err = tikv_txn.Set(utils.EncodeStreamGroup(key, version, group), encodeStreamID(id))
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyStream, "xgroup-setid", key)
+	return
+}
+
+//XGroupDestroy removes the consumer group with its pending entries, returns the number of groups removed.
+func (tidis *Tidis) XGroupDestroy(txn interface{}, key, group []byte) (destroyed int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		exists   bool
+		version  uint64
+		groupKey []byte
+		value    []byte
+	)
+	if len(key) == 0 || len(group) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			destroyed, err = tidis.XGroupDestroy(txn, key, group)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//delete stream if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	exists, _, version, _, err = tidis.getStreamMeta(txn, key)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = qkverror.ErrorStreamNoKey
+		return
+	}
+	groupKey = utils.EncodeStreamGroup(key, version, group)
+	value, err = tidis.db.Get(txn, groupKey)
+	if err != nil || value == nil {
+		return
+	}
+	err = tikv_txn.Delete(groupKey)
+	if err != nil {
+		return
+	}
+	_, err = tidis.deletePrefix(tikv_txn, utils.EncodeStreamPELPrefix(key, version, group), 0)
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyStream, "xgroup-destroy", key)
+	if err != nil {
+		return
+	}
+	destroyed = 1
+	return
+}
+
+//XGroupDelConsumer removes the pending entries of consumer from the group, returns the number of entries removed.
+func (tidis *Tidis) XGroupDelConsumer(txn interface{}, key, group, consumer []byte) (deleted int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		version  uint64
+		pending  []StreamPending
+	)
+	if len(key) == 0 || len(group) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			deleted, err = tidis.XGroupDelConsumer(txn, key, group, consumer)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	version, _, _, err = tidis.getStreamGroup(txn, key, group)
+	if err != nil {
+		return
+	}
+	pending, err = tidis.streamPending(tikv_txn, key, version, group, StreamIDMin, StreamIDMax, 0, consumer, 0)
+	if err != nil {
+		return
+	}
+	for _, p := range pending {
+		err = tikv_txn.Delete(utils.EncodeStreamPEL(key, version, group, p.ID.Ms, p.ID.Seq))
+		if err != nil {
+			return
+		}
+		deleted++
+	}
+	return
+}
+
+//XReadGroup reads the stream stored at key as consumer of group. With newOnly the entries never delivered to the group
+//are returned and added to the pending entries of consumer unless noack is set, otherwise the pending entries of consumer
+//after id are returned again and their delivery count is incremented, like redis. count <= 0 returns all of them.
+func (tidis *Tidis) XReadGroup(txn interface{}, key, group, consumer []byte, id StreamID, newOnly bool, count int64, noack bool) (entries []StreamEntry, err error) {
+	var (
+		tikv_txn  kv.Transaction
+		ok        bool
+		version   uint64
+		delivered StreamID
+		pending   []StreamPending
+		value     []byte
+		fields    [][]byte
+		now       uint64
+	)
+	if len(key) == 0 || len(group) == 0 || len(consumer) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			entries, err = tidis.XReadGroup(txn, key, group, consumer, id, newOnly, count, noack)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	version, _, delivered, err = tidis.getStreamGroup(txn, key, group)
+	if err != nil {
+		return
+	}
+	if !newOnly {
+		//history of consumer
+		if id, ok = id.Next(); !ok {
+			return
+		}
+		pending, err = tidis.streamPending(tikv_txn, key, version, group, id, StreamIDMax, count, consumer, 0)
+		if err != nil {
+			return
+		}
+		entries = make([]StreamEntry, 0, len(pending))
+		now = streamNow(tikv_txn)
+		for _, p := range pending {
+			err = tikv_txn.Set(utils.EncodeStreamPEL(key, version, group, p.ID.Ms, p.ID.Seq), encodeStreamPending(consumer, now, p.Deliveries+1))
+			if err != nil {
+				return
+			}
+			value, err = tidis.db.Get(txn, utils.EncodeStreamData(key, version, p.ID.Ms, p.ID.Seq))
+			if err != nil {
+				return
+			}
+			fields = nil
+			if value != nil {
+				fields, err = utils.DecodeStreamFields(value)
+				if err != nil {
+					return
+				}
+			}
+			entries = append(entries, StreamEntry{ID: p.ID, Fields: fields})
+		}
+		return
+	}
+	if delivered, ok = delivered.Next(); !ok {
+		return
+	}
+	entries, err = tidis.streamEntries(tikv_txn, key, version, delivered, StreamIDMax, count, false)
+	if err != nil || len(entries) == 0 {
+		return
+	}
+	err = tikv_txn.Set(utils.EncodeStreamGroup(key, version, group), encodeStreamID(entries[len(entries)-1].ID))
+	if err != nil || noack {
+		return
+	}
+	now = streamNow(tikv_txn)
+	for _, entry := range entries {
+		err = tikv_txn.Set(utils.EncodeStreamPEL(key, version, group, entry.ID.Ms, entry.ID.Seq), encodeStreamPending(consumer, now, 1))
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+//XAck removes the entries with the ids from the pending entries of the consumer group, returns the number of entries acknowledged.
+func (tidis *Tidis) XAck(txn interface{}, key, group []byte, ids ...StreamID) (acked int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		version  uint64
+		pelKey   []byte
+		value    []byte
+	)
+	if len(key) == 0 || len(group) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			acked, err = tidis.XAck(txn, key, group, ids...)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	version, _, _, err = tidis.getStreamGroup(txn, key, group)
+	if err == qkverror.ErrorNoGroup {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		pelKey = utils.EncodeStreamPEL(key, version, group, id.Ms, id.Seq)
+		value, err = tidis.db.Get(txn, pelKey)
+		if err != nil {
+			return
+		}
+		if value == nil {
+			continue
+		}
+		err = tikv_txn.Delete(pelKey)
+		if err != nil {
+			return
+		}
+		acked++
+	}
+	return
+}
+
+//XPending returns the pending entries of the consumer group with an id between start and end, only the ones of consumer
+//if it is not nil and only the ones idle for at least minIdle ms. count <= 0 returns all of them.
+func (tidis *Tidis) XPending(txn interface{}, key, group []byte, start, end StreamID, count int64, consumer []byte, minIdle int64) (pending []StreamPending, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		version  uint64
+	)
+	if len(key) == 0 || len(group) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			pending, err = tidis.XPending(txn, key, group, start, end, count, consumer, minIdle)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	version, _, _, err = tidis.getStreamGroup(txn, key, group)
+	if err != nil || end.Less(start) {
+		return
+	}
+	pending, err = tidis.streamPending(tikv_txn, key, version, group, start, end, count, consumer, minIdle)
+	return
+}
+
+//streamEntries entries between start and end (inclusive), at most count of them if count > 0
+func (tidis *Tidis) streamEntries(txn kv.Transaction, key []byte, version uint64, start, end StreamID, count int64, reverse bool) (entries []StreamEntry, err error) {
+	var (
+		it       kv.Iterator
+		startKey kv.Key
+		endKey   kv.Key
+		entry    StreamEntry
+	)
+	startKey = utils.EncodeStreamData(key, version, start.Ms, start.Seq)
+	endKey = utils.EncodeStreamData(key, version, end.Ms, end.Seq)
+	if reverse {
+		it, err = txn.SeekReverse(endKey.Next())
+	} else {
+		it, err = txn.Seek(startKey)
+	}
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	entries = make([]StreamEntry, 0)
+	for it.Valid() && (count <= 0 || int64(len(entries)) < count) {
+		if it.Key().Cmp(startKey) < 0 || it.Key().Cmp(endKey) > 0 {
+			break
+		}
+		_, entry.ID.Ms, entry.ID.Seq, err = utils.DecodeStreamData(it.Key())
+		if err != nil {
+			return
+		}
+		entry.Fields, err = utils.DecodeStreamFields(it.Value())
+		if err != nil {
+			return
+		}
+		entries = append(entries, entry)
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//trimStream delete the n oldest entries
+func (tidis *Tidis) trimStream(txn kv.Transaction, key []byte, version uint64, n uint64) (trimmed int64, err error) {
+	var (
+		deleted int
+	)
+	deleted, err = tidis.deletePrefix(txn, utils.EncodeMemberPrefix(utils.STREAM_DATA, key, version), int(n))
+	trimmed = int64(deleted)
+	return
+}
+
+//streamPending pending entries of group between start and end (inclusive), see XPending
+func (tidis *Tidis) streamPending(txn kv.Transaction, key []byte, version uint64, group []byte, start, end StreamID, count int64, consumer []byte, minIdle int64) (pending []StreamPending, err error) {
+	var (
+		it       kv.Iterator
+		startKey kv.Key
+		endKey   kv.Key
+		p        StreamPending
+		now      int64
+		at       int64
+	)
+	startKey = utils.EncodeStreamPEL(key, version, group, start.Ms, start.Seq)
+	endKey = utils.EncodeStreamPEL(key, version, group, end.Ms, end.Seq)
+	it, err = txn.Seek(startKey)
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	now = int64(streamNow(txn))
+	pending = make([]StreamPending, 0)
+	for it.Valid() && (count <= 0 || int64(len(pending)) < count) {
+		if it.Key().Cmp(endKey) > 0 {
+			break
+		}
+		_, _, p.ID.Ms, p.ID.Seq, err = utils.DecodeStreamPEL(it.Key())
+		if err != nil {
+			return
+		}
+		p.Consumer, at, p.Deliveries, err = decodeStreamPending(it.Value())
+		if err != nil {
+			return
+		}
+		p.Idle = now - at
+		if p.Idle < 0 {
+			p.Idle = 0
+		}
+		if (consumer == nil || bytes.Equal(consumer, p.Consumer)) && p.Idle >= minIdle {
+			pending = append(pending, p)
+		}
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//getStreamGroup version of the stream and last delivered id of group, ErrorNoGroup if the stream or the group does not exist
+func (tidis *Tidis) getStreamGroup(txn interface{}, key, group []byte) (version uint64, last StreamID, delivered StreamID, err error) {
+	var (
+		exists bool
+		value  []byte
+	)
+	//delete stream if expired
+	err = tidis.DeleteIfExpired(txn, key, true)
+	if err != nil {
+		return
+	}
+	exists, _, version, last, err = tidis.getStreamMeta(txn, key)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = qkverror.ErrorNoGroup
+		return
+	}
+	value, err = tidis.db.Get(txn, utils.EncodeStreamGroup(key, version, group))
+	if err != nil {
+		return
+	}
+	if value == nil {
+		err = qkverror.ErrorNoGroup
+		return
+	}
+	delivered, err = decodeStreamID(value)
+	return
+}
+
+//getStreamMeta size(8)|ttl(8)|flag(1)|version(8)|last id(16), a stream exists as long as its meta even without entries
+func (tidis *Tidis) getStreamMeta(txn interface{}, key []byte) (exists bool, size uint64, version uint64, last StreamID, err error) {
+	var (
+		rawData  []byte
+		dataType byte
+		value    []byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	rawData, err = tidis.db.Get(txn, key)
+	if err != nil || rawData == nil {
+		return
+	}
+	dataType, value, err = utils.DecodeData(rawData)
+	if err != nil {
+		return
+	}
+	if dataType != utils.STREAM_TYPE {
+		err = qkverror.ErrorWrongType
+		return
+	}
+	if len(value) != 41 {
+		err = qkverror.ErrorInvalidMeta
+		return
+	}
+	size, _, _, version, err = decodeHashMeta(value[:25])
+	if err != nil {
+		return
+	}
+	last, err = decodeStreamID(value[25:])
+	if err != nil {
+		return
+	}
+	exists = true
+	return
+}
+func (tidis *Tidis) createStreamMeta(size, version uint64, last StreamID) (buf []byte) {
+	buf = make([]byte, 41)
+	copy(buf, tidis.createHashMeta(size, 0, utils.FLAG_NORMAL, version))
+	copy(buf[25:], encodeStreamID(last))
+	return
+}
+
+//encodeStreamID ms(8)|seq(8)
+func encodeStreamID(id StreamID) []byte {
+	buf := make([]byte, 16)
+	utils.Uint64ToBytesExt(buf, id.Ms)
+	utils.Uint64ToBytesExt(buf[8:], id.Seq)
+	return buf
+}
+func decodeStreamID(value []byte) (id StreamID, err error) {
+	if len(value) != 16 {
+		err = qkverror.ErrorInvalidMeta
+		return
+	}
+	id.Ms, _ = utils.BytesToUint64(value)
+	id.Seq, _ = utils.BytesToUint64(value[8:])
+	return
+}
+
+//encodeStreamPending delivery time(8)|deliveries(8)|consumer
+func encodeStreamPending(consumer []byte, at uint64, deliveries int64) []byte {
+	buf := make([]byte, 16+len(consumer))
+	utils.Uint64ToBytesExt(buf, at)
+	utils.Uint64ToBytesExt(buf[8:], uint64(deliveries))
+	copy(buf[16:], consumer)
+	return buf
+}
+func decodeStreamPending(value []byte) (consumer []byte, at int64, deliveries int64, err error) {
+	var (
+		raw uint64
+	)
+	if len(value) < 16 {
+		err = qkverror.ErrorInvalidRawData
+		return
+	}
+	raw, _ = utils.BytesToUint64(value)
+	at = int64(raw)
+	raw, _ = utils.BytesToUint64(value[8:])
+	deliveries = int64(raw)
+	consumer = value[16:]
+	return
+}
+
+//streamNow ms time of the timestamp oracle, shared by all the instances
+func streamNow(txn kv.Transaction) uint64 {
+	return txn.StartTS() >> physicalShiftBits
+}
@@ -1,7 +1,8 @@
 package tidis
 
 import (
-	"context"
+	"math"
+	"strconv"
 	"time"
 
 	"github.com/chuangyou/qkv/qkverror"
@@ -34,7 +35,11 @@ func (tidis *Tidis) Get(txn interface{}, key []byte) (data []byte, err error) {
 		if err != nil {
 			return
 		}
-		if dataType != utils.STRING_TYPE {
+		if dataType == utils.BITMAP_TYPE {
+			return tidis.getBitmapValue(txn, key)
+		}
+		//a HyperLogLog is read as its string value
+		if dataType != utils.STRING_TYPE && dataType != utils.HLL_TYPE {
 			err = qkverror.ErrorWrongType
 			return
 		}
@@ -42,8 +47,24 @@ func (tidis *Tidis) Get(txn interface{}, key []byte) (data []byte, err error) {
 	return
 }
 
-//Set set key to hold the string value.
+//SetOptions the options of SET. ExpireAt is a unix time in milliseconds, 0 for no expire.
+type SetOptions struct {
+	NX       bool
+	XX       bool
+	Get      bool
+	KeepTTL  bool
+	ExpireAt int64
+}
+
+//Set set key to hold the string value, the time to live of key is discarded.
 func (tidis *Tidis) Set(txn interface{}, key, value []byte) (err error) {
+	_, _, err = tidis.SetWithOptions(txn, key, value, SetOptions{})
+	return
+}
+
+//SetWithOptions set key to hold the string value with the options of SET, ok is false if NX or XX prevented it.
+//old is the previous value of key with the Get option.
+func (tidis *Tidis) SetWithOptions(txn interface{}, key, value []byte, opts SetOptions) (old []byte, ok bool, err error) {
 	var (
 		dataType byte
 		rawData  []byte
@@ -52,27 +73,69 @@ func (tidis *Tidis) Set(txn interface{}, key, value []byte) (err error) {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			old, ok, err = tidis.SetWithOptions(txn, key, value, opts)
+			return
+		})
+		return
+	}
+	if opts.Get {
+		//Get deletes key if expired
+		old, err = tidis.Get(txn, key)
+	} else {
+		err = tidis.DeleteIfExpired(txn, key, true)
+	}
+	if err != nil {
+		return
+	}
 	//get old data and data type
 	rawData, err = tidis.db.Get(txn, key)
 	if err != nil {
 		return
-	} else if rawData != nil {
+	}
+	if (opts.NX && rawData != nil) || (opts.XX && rawData == nil) {
+		return
+	}
+	if rawData != nil {
 		//check data type
 		dataType, _, err = utils.DecodeData(rawData)
 		if err != nil {
 			return
 		}
-		if dataType != utils.STRING_TYPE {
-			err = qkverror.ErrorWrongType
+		err = tidis.overwriteString(txn, key, dataType)
+		if err != nil {
 			return
 		}
 	}
 	//encode string data
-	value = utils.EncodeData(utils.STRING_TYPE, value)
-	err = tidis.db.Set(txn, key, value)
-	if err == nil {
-		tidis.DeleteIfExpired(txn, key, false)
+	err = tidis.db.Set(txn, key, utils.EncodeData(utils.STRING_TYPE, value))
+	if err != nil {
+		return
 	}
+	if !opts.KeepTTL {
+		err = tidis.removeMetaKey(txn, key)
+		if err != nil {
+			return
+		}
+	}
+	if opts.ExpireAt > 0 {
+		_, err = tidis.db.PExipre(txn, key, opts.ExpireAt)
+		if err != nil {
+			return
+		}
+	}
+	err = tidis.notify(txn, notifyString, "set", key)
+	if err != nil {
+		return
+	}
+	if opts.ExpireAt > 0 {
+		err = tidis.notify(txn, notifyGeneric, "expire", key)
+		if err != nil {
+			return
+		}
+	}
+	ok = true
 	return
 }
 
@@ -96,7 +159,12 @@ func (tidis *Tidis) MGet(txn interface{}, keys [][]byte) (resp []interface{}, er
 				return
 			}
 			//check data type
-			if dataType != utils.STRING_TYPE {
+			if dataType == utils.BITMAP_TYPE {
+				value, err = tidis.getBitmapValue(txn, key)
+				if err != nil {
+					return
+				}
+			} else if dataType != utils.STRING_TYPE && dataType != utils.HLL_TYPE {
 				err = qkverror.ErrorWrongType
 				return
 			}
@@ -117,41 +185,50 @@ func (tidis *Tidis) MSet(txn interface{}, kvs [][]byte) (resp int, err error) {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			resp, err = tidis.MSet(txn, kvs)
+			return
+		})
+		return
+	}
 	for i := 0; i < len(kvs)-1; i += 2 {
 		k := string(kvs[i])
 		v := utils.EncodeData(utils.STRING_TYPE, kvs[i+1])
 		kvm[k] = v
 	}
 	resp, err = tidis.db.MSet(txn, kvm)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(kvs)-1; i += 2 {
+		err = tidis.notify(txn, notifyString, "set", kvs[i])
+		if err != nil {
+			return
+		}
+	}
 	return
 }
 
+//StringMaxSize the greatest size of a string like redis, 512MB
+const StringMaxSize = 512 * 1024 * 1024
+
 //Delete removes the specified keys. A key is ignored if it does not exist.
 func (tidis *Tidis) Delete(txn interface{}, keys [][]byte) (resp int64, err error) {
 	var (
-		key            []byte
-		ret            int64
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
+		key []byte
+		ret int64
 	)
 	if len(keys) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			resp, err = tidis.Delete(txn, keys)
 			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
+		})
+		return
 	}
 	for _, key = range keys {
 		// clear expire meta
@@ -160,87 +237,350 @@ func (tidis *Tidis) Delete(txn interface{}, keys [][]byte) (resp int64, err erro
 			return
 		}
 	}
-	ret, err = tidis.DeleteWithTxn(txn, keys)
-	if err != nil {
-		return
-	} else {
-		if notTransaction {
-			err = tikv_txn.Commit(context.Background())
-			if err != nil {
-				return
-			}
+	for _, key = range keys {
+		ret, err = tidis.DeleteWithTxn(txn, [][]byte{key})
+		if err != nil {
+			resp = 0
+			return
+		}
+		if ret == 0 {
+			continue
+		}
+		resp += ret
+		err = tidis.notify(txn, notifyGeneric, "del", key)
+		if err != nil {
+			return
 		}
 	}
-	resp = ret
 	return
 }
 
 //SetEX set key to hold the string value and set key to timeout after a given number of seconds
 func (tidis *Tidis) SetEX(txn interface{}, key []byte, seconds int64, value []byte) (err error) {
+	return tidis.PSetEX(txn, key, seconds*1000, value)
+}
+
+//PSetEX works exactly like SetEX with the time to live in milliseconds
+func (tidis *Tidis) PSetEX(txn interface{}, key []byte, ms int64, value []byte) (err error) {
+	_, _, err = tidis.SetWithOptions(txn, key, value, SetOptions{ExpireAt: ms + time.Now().UnixNano()/1000/1000})
+	return
+}
+
+//SetNX set key to hold the string value if key does not exist, returns 1 if key was set.
+func (tidis *Tidis) SetNX(txn interface{}, key, value []byte) (ret int64, err error) {
 	var (
-		rawData  []byte
-		dataType byte
+		ok bool
 	)
+	_, ok, err = tidis.SetWithOptions(txn, key, value, SetOptions{NX: true})
+	if ok {
+		ret = 1
+	}
+	return
+}
+
+//GetSet set key to hold the string value and returns its old value.
+func (tidis *Tidis) GetSet(txn interface{}, key, value []byte) (old []byte, err error) {
+	old, _, err = tidis.SetWithOptions(txn, key, value, SetOptions{Get: true})
+	return
+}
+
+//GetDel get the value of key and delete the key.
+func (tidis *Tidis) GetDel(txn interface{}, key []byte) (value []byte, err error) {
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
-	//get old data and data type
-	rawData, err = tidis.db.Get(txn, key)
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			value, err = tidis.GetDel(txn, key)
+			return
+		})
+		return
+	}
+	value, err = tidis.Get(txn, key)
+	if err != nil || value == nil {
+		return
+	}
+	err = tidis.removeMetaKey(txn, key)
 	if err != nil {
 		return
-	} else if rawData != nil {
-		//check data type
-		dataType, _, err = utils.DecodeData(rawData)
+	}
+	_, err = tidis.Delete(txn, [][]byte{key})
+	return
+}
+
+//GetEX get the value of key and set its expiration to expireAt (unix time in milliseconds), or remove it with persist.
+//The expiration is left as it is if expireAt is 0 and persist is not set.
+func (tidis *Tidis) GetEX(txn interface{}, key []byte, expireAt int64, persist bool) (value []byte, err error) {
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			value, err = tidis.GetEX(txn, key, expireAt, persist)
+			return
+		})
+		return
+	}
+	value, err = tidis.Get(txn, key)
+	if err != nil || value == nil {
+		return
+	}
+	if expireAt > 0 {
+		_, err = tidis.db.PExipre(txn, key, expireAt)
 		if err != nil {
 			return
 		}
-		if dataType != utils.STRING_TYPE {
-			err = qkverror.ErrorWrongType
+		err = tidis.notify(txn, notifyGeneric, "expire", key)
+		return
+	}
+	if persist {
+		_, err = tidis.Persist(txn, key)
+	}
+	return
+}
+
+//Append appends value at the end of the string stored at key, returns the length of the string.
+func (tidis *Tidis) Append(txn interface{}, key, value []byte) (length int64, err error) {
+	var (
+		old []byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			length, err = tidis.Append(txn, key, value)
 			return
-		}
+		})
+		return
 	}
-	value = utils.EncodeData(utils.STRING_TYPE, value)
-	err = tidis.db.SetEX(txn, key, seconds, value)
+	old, err = tidis.Get(txn, key)
+	if err != nil {
+		return
+	}
+	value = append(old[:len(old):len(old)], value...)
+	err = tidis.updateString(txn, key, value, "append")
+	if err != nil {
+		return
+	}
+	length = int64(len(value))
 	return
 }
 
-//Incr increments the number stored at key by increment.
-func (tidis *Tidis) Incr(txn interface{}, key []byte, step int64) (ret int64, err error) {
+//GetRange returns the substring of the string stored at key between start and end (inclusive),
+//negative offsets count from the end of the string.
+func (tidis *Tidis) GetRange(txn interface{}, key []byte, start, end int64) (value []byte, err error) {
+	var (
+		size int64
+	)
+	value, err = tidis.Get(txn, key)
+	if err != nil {
+		return
+	}
+	size = int64(len(value))
+	if start < 0 {
+		start = start + size
+	}
+	if end < 0 {
+		end = end + size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if size == 0 || start > end {
+		value = []byte{}
+		return
+	}
+	value = value[start : end+1]
+	return
+}
+
+//SetRange overwrites part of the string stored at key starting at offset, the string is padded with zero bytes
+//if it is shorter than offset. Returns the length of the string.
+func (tidis *Tidis) SetRange(txn interface{}, key []byte, offset int64, value []byte) (length int64, err error) {
+	var (
+		old []byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if offset < 0 {
+		err = qkverror.ErrorOffsetRange
+		return
+	}
+	if offset+int64(len(value)) > StringMaxSize {
+		err = qkverror.ErrorStringSize
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			length, err = tidis.SetRange(txn, key, offset, value)
+			return
+		})
+		return
+	}
+	old, err = tidis.Get(txn, key)
+	if err != nil {
+		return
+	}
+	length = int64(len(old))
+	//nothing is created for an empty value
+	if len(value) == 0 {
+		return
+	}
+	if offset+int64(len(value)) > length {
+		old = append(old[:len(old):len(old)], make([]byte, offset+int64(len(value))-length)...)
+	} else {
+		old = append([]byte(nil), old...)
+	}
+	copy(old[offset:], value)
+	err = tidis.updateString(txn, key, old, "setrange")
+	if err != nil {
+		return
+	}
+	length = int64(len(old))
+	return
+}
+
+//IncrByFloat increments the floating point number stored at key by increment.
+func (tidis *Tidis) IncrByFloat(txn interface{}, key []byte, step float64) (ret []byte, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		rawData        []byte
-		dataType       byte
-		value          []byte
-		oldStep        int64
-		newStep        int64
+		value []byte
+		old   float64
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.IncrByFloat(txn, key, step)
+			return
+		})
+		return
+	}
+	value, err = tidis.Get(txn, key)
+	if err != nil {
+		return
+	}
+	if value != nil {
+		old, err = utils.StrBytesToFloat64(value)
+		if err != nil {
+			err = qkverror.ErrorNotFloat
+			return
+		}
+	}
+	old = old + step
+	if math.IsNaN(old) || math.IsInf(old, 0) {
+		err = qkverror.ErrorIncrNaN
+		return
+	}
+	//no exponent like redis
+	ret = strconv.AppendFloat(nil, old, 'f', -1, 64)
+	err = tidis.updateString(txn, key, ret, "incrbyfloat")
+	return
+}
 
-	if txn != nil {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+//MSetNX sets the given keys to their respective values if none of them exists, returns 1 if they were set.
+func (tidis *Tidis) MSetNX(txn interface{}, kvs [][]byte) (ret int64, err error) {
+	var (
+		count int64
+	)
+	if len(kvs) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.MSetNX(txn, kvs)
+			return
+		})
+		return
+	}
+	for i := 0; i < len(kvs)-1; i += 2 {
+		//an expired key is deleted with its expire meta
+		err = tidis.DeleteIfExpired(txn, kvs[i], true)
+		if err != nil {
 			return
 		}
-	} else {
-		notTransaction = true
-		txn, err = tidis.db.NewTxn()
+		count, err = tidis.Exists(txn, kvs[i])
+		if err != nil || count > 0 {
+			return
+		}
+	}
+	_, err = tidis.MSet(txn, kvs)
+	if err != nil {
+		return
+	}
+	ret = 1
+	return
+}
+
+//updateString write the new string value of key and keep its time to live
+func (tidis *Tidis) updateString(txn interface{}, key, value []byte, event string) (err error) {
+	var (
+		rawData  []byte
+		dataType byte
+	)
+	rawData, err = tidis.db.Get(txn, key)
+	if err != nil {
+		return
+	}
+	if rawData != nil {
+		dataType, _, err = utils.DecodeData(rawData)
 		if err != nil {
-			err = qkverror.ErrorServerInternal
 			return
 		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.overwriteString(txn, key, dataType)
+		if err != nil {
 			return
 		}
-		defer tikv_txn.Rollback()
+	}
+	err = tidis.db.Set(txn, key, utils.EncodeData(utils.STRING_TYPE, value))
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyString, event, key)
+	return
+}
+
+//Incr increments the number stored at key by increment.
+func (tidis *Tidis) Incr(txn interface{}, key []byte, step int64) (ret int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		rawData  []byte
+		dataType byte
+		value    []byte
+		oldStep  int64
+		newStep  int64
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.Incr(txn, key, step)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
 	rawData, err = tidis.db.Get(txn, key)
 	if err != nil {
@@ -251,8 +591,14 @@ func (tidis *Tidis) Incr(txn interface{}, key []byte, step int64) (ret int64, er
 		if err != nil {
 			return
 		}
-		if dataType != utils.STRING_TYPE {
-			err = qkverror.ErrorWrongType
+		if dataType == utils.BITMAP_TYPE {
+			value, err = tidis.getBitmapValue(txn, key)
+			if err != nil {
+				return
+			}
+		}
+		err = tidis.overwriteString(txn, key, dataType)
+		if err != nil {
 			return
 		}
 		//old value
@@ -273,11 +619,9 @@ func (tidis *Tidis) Incr(txn interface{}, key []byte, step int64) (ret int64, er
 	if err != nil {
 		return
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
+	err = tidis.notify(txn, notifyString, "incrby", key)
+	if err != nil {
+		return
 	}
 	ret = newStep
 	return
@@ -291,144 +635,94 @@ func (tidis *Tidis) Decr(txn interface{}, key []byte, step int64) (ret int64, er
 //Expire set a key's time to live in seconds
 func (tidis *Tidis) Expire(txn interface{}, key []byte, seconds int64) (ret int, err error) {
 	var (
-		ts             int64
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
+		ts int64
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.Expire(txn, key, seconds)
 			return
-		}
-		defer tikv_txn.Rollback()
+		})
+		return
 	}
 	ts = seconds*1000 + (time.Now().UnixNano() / 1000 / 1000)
 	ret, err = tidis.db.PExipre(txn, key, ts)
-	if err == nil && notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
+	if err != nil || ret == 0 {
+		return
 	}
+	err = tidis.notify(txn, notifyGeneric, "expire", key)
 	return
 }
 
 //PExipre this command works exactly like EXPIRE but the time to live of the key is specified in milliseconds instead of seconds.
 func (tidis *Tidis) PExpire(txn interface{}, key []byte, ms int64) (ret int, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		ts             int64
+		ts int64
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.PExpire(txn, key, ms)
 			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
+		})
+		return
 	}
 	ts = ms + (time.Now().UnixNano() / 1000 / 1000)
 	ret, err = tidis.db.PExipre(txn, key, ts)
-	if err == nil && notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
+	if err != nil || ret == 0 {
+		return
 	}
+	err = tidis.notify(txn, notifyGeneric, "expire", key)
 	return
 }
 
 //ExpireAt set the expiration for a key as a UNIX timestamp
 func (tidis *Tidis) ExpireAt(txn interface{}, key []byte, ts int64) (ret int, err error) {
-	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-	)
+	var ()
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.ExpireAt(txn, key, ts)
 			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
+		})
+		return
 	}
 	ts = ts * 1000
 	ret, err = tidis.db.PExipre(txn, key, ts)
-	if err == nil && notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
+	if err != nil || ret == 0 {
+		return
 	}
+	err = tidis.notify(txn, notifyGeneric, "expire", key)
 	return
 }
 
 //PExpireAt set the expiration for a key as a UNIX timestamp specified in milliseconds
 func (tidis *Tidis) PExpireAt(txn interface{}, key []byte, ts int64) (ret int, err error) {
-	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-	)
+	var ()
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			ret, err = tidis.PExpireAt(txn, key, ts)
 			return
-		}
-		defer tikv_txn.Rollback()
+		})
+		return
 	}
 	ret, err = tidis.db.PExipre(txn, key, ts)
-	if err == nil && notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
+	if err != nil || ret == 0 {
+		return
 	}
+	err = tidis.notify(txn, notifyGeneric, "expire", key)
 	return
 }
@@ -1,8 +1,9 @@
 package tidis
 
 import (
-	"context"
-	"strconv"
+	"bytes"
+	"math"
+	"sort"
 
 	"github.com/chuangyou/qkv/qkverror"
 	"github.com/chuangyou/qkv/utils"
@@ -10,66 +11,76 @@ import (
 )
 
 type ZSetPair struct {
-	Score int64
+	Score float64
 	Key   []byte
 }
 
+//ZScoreBound one end of a score range, Exclusive when given with the ( prefix
+type ZScoreBound struct {
+	Score     float64
+	Exclusive bool
+}
+
 //ZAdd adds all the specified members with the specified scores to the sorted set stored at key.
 func (tidis *Tidis) ZAdd(txn interface{}, key []byte, zks ...*ZSetPair) (added int64, err error) {
-	var (
-		zk             *ZSetPair
-		ttl            uint64
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		zsize          uint64
-		zSetData       []byte
-		zSetScore      []byte
-		value          []byte
-		encodeScore    []byte
-		oldScore       int64
-		oldScoreKey    []byte
-		zSetValue      []byte
-	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			added, err = tidis.ZAdd(txn, key, zks...)
 			return
-		}
+		})
+		return
+	}
+	added, err = tidis.zAdd(txn, key, zks...)
+	if err != nil {
+		return
+	}
+	err = tidis.notify(txn, notifyZSet, "zadd", key)
+	return
+}
+
+//zAdd ZAdd without the keyspace notification
+func (tidis *Tidis) zAdd(txn interface{}, key []byte, zks ...*ZSetPair) (added int64, err error) {
+	var (
+		zk          *ZSetPair
+		ttl         uint64
+		tikv_txn    kv.Transaction
+		ok          bool
+		zsize       uint64
+		zSetData    []byte
+		zSetScore   []byte
+		value       []byte
+		encodeScore []byte
+		oldScore    float64
+		oldScoreKey []byte
+		zSetValue   []byte
+		version     uint64
+		rank        *rankIndex
+	)
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
 	//get zset meta
-	zsize, ttl, _, err = tidis.getZSetMeta(txn, key)
+	zsize, ttl, _, version, err = tidis.getZSetMeta(txn, key)
 	if err != nil {
 		return
 	}
+	if zsize == 0 {
+		version = tidis.newVersion(txn)
+	}
+	rank = zsetRank(tikv_txn, key, version)
 	for _, zk = range zks {
 		//encode zset member
-		zSetData = utils.EncodeZSetData(key, zk.Key)
+		zSetData = utils.EncodeZSetData(key, version, zk.Key)
 		//encode zset member's score
-		zSetScore = utils.EncodeZSetScore(key, zk.Key, zk.Score)
+		zSetScore = utils.EncodeZSetScore(key, version, zk.Key, zk.Score)
 		//encode this score
-		encodeScore, err = utils.Int64ToBytes(zk.Score)
-		if err != nil {
-			return
-		}
+		encodeScore = utils.Float64ToBytes(zk.Score)
 		//get old score
 		value, err = tidis.db.Get(txn, zSetData)
 		if err != nil {
@@ -81,15 +92,27 @@ func (tidis *Tidis) ZAdd(txn interface{}, key []byte, zks ...*ZSetPair) (added i
 			added++
 		} else {
 			//delete old score key
-			oldScore, err = utils.BytesToInt64(value)
+			oldScore, err = utils.BytesToFloat64(value)
 			if err != nil {
 				return
 			}
-			oldScoreKey = utils.EncodeZSetScore(key, zk.Key, oldScore)
+			oldScoreKey = utils.EncodeZSetScore(key, version, zk.Key, oldScore)
+			if bytes.Equal(oldScoreKey, zSetScore) {
+				//same score, same rank
+				err = tikv_txn.Set(zSetData, encodeScore)
+				if err != nil {
+					return
+				}
+				continue
+			}
 			err = tikv_txn.Delete(oldScoreKey)
 			if err != nil {
 				return
 			}
+			err = rank.remove(oldScoreKey)
+			if err != nil {
+				return
+			}
 		}
 		//set zset member
 		err = tikv_txn.Set(zSetData, encodeScore)
@@ -101,21 +124,16 @@ func (tidis *Tidis) ZAdd(txn interface{}, key []byte, zks ...*ZSetPair) (added i
 		if err != nil {
 			return
 		}
+		err = rank.insert(zSetScore)
+		if err != nil {
+			return
+		}
 	}
 	// update zset
-	zSetValue = tidis.createZSetMeta(zsize, ttl, utils.FLAG_NORMAL)
+	zSetValue = tidis.createZSetMeta(zsize, ttl, utils.FLAG_NORMAL, version)
 	// encode zset_type
 	zSetValue = utils.EncodeData(utils.ZSET_TYPE, zSetValue)
 	err = tikv_txn.Set(key, zSetValue)
-	if err != nil {
-		return
-	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
-	}
 	return
 }
 
@@ -128,7 +146,7 @@ func (tidis *Tidis) ZCard(txn interface{}, key []byte) (count int64, err error)
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
-	zsize, _, _, err = tidis.getZSetMeta(txn, key)
+	zsize, _, _, _, err = tidis.getZSetMeta(txn, key)
 	if err != nil {
 		return
 	}
@@ -137,27 +155,27 @@ func (tidis *Tidis) ZCard(txn interface{}, key []byte) (count int64, err error)
 }
 
 //ZCount returns the number of elements in the sorted set at key with a score between min and max.
-func (tidis *Tidis) ZCount(txn interface{}, key []byte, min, max int64) (count int64, err error) {
+func (tidis *Tidis) ZCount(txn interface{}, key []byte, min, max ZScoreBound) (count int64, err error) {
 	var (
 		zsize     uint64 = 0
 		startKey  []byte
 		endKey    []byte
 		tempCount uint64
+		version   uint64
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
-	zsize, _, _, err = tidis.getZSetMeta(txn, key)
+	zsize, _, _, version, err = tidis.getZSetMeta(txn, key)
 	if err != nil {
 		return
 	}
 	if zsize == 0 {
 		return
 	}
-	startKey = utils.EncodeZSetScore(key, []byte{0}, min)
-	endKey = utils.EncodeZSetScore(key, []byte{0}, max+1)
-	_, tempCount, err = tidis.db.GetRangeKeys(txn, startKey, true, endKey, true, 0, zsize, true)
+	startKey, endKey = zScoreRangeKeys(key, version, min, max)
+	_, tempCount, err = tidis.db.GetRangeKeys(txn, startKey, true, endKey, false, 0, zsize, true)
 	if err != nil {
 		return
 	}
@@ -167,51 +185,46 @@ func (tidis *Tidis) ZCount(txn interface{}, key []byte, min, max int64) (count i
 }
 
 //ZIncrby increments the score of member in the sorted set stored at key by increment.
-func (tidis *Tidis) ZIncrby(txn interface{}, key []byte, step int64, member []byte) (resp int64, err error) {
+func (tidis *Tidis) ZIncrby(txn interface{}, key []byte, step float64, member []byte) (resp float64, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		zsize          uint64 = 0
-		ttl            uint64 = 0
-		newScore       int64
-		oldScore       int64
-		zSetValue      []byte
-		oldScoreRaw    []byte
-		newScoreRaw    []byte
-		zSetMetaKey    []byte
-		zScoreKey      []byte
+		tikv_txn    kv.Transaction
+		ok          bool
+		zsize       uint64 = 0
+		ttl         uint64 = 0
+		newScore    float64
+		oldScore    float64
+		zSetValue   []byte
+		oldScoreRaw []byte
+		newScoreRaw []byte
+		zSetMetaKey []byte
+		zScoreKey   []byte
+		version     uint64
 	)
 	if len(key) == 0 || len(member) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			resp, err = tidis.ZIncrby(txn, key, step, member)
 			return
-		}
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
-	zsize, ttl, _, err = tidis.getZSetMeta(txn, key)
+	zsize, ttl, _, version, err = tidis.getZSetMeta(txn, key)
 	if err != nil {
 		return
 	}
+	if zsize == 0 {
+		version = tidis.newVersion(txn)
+	}
 	//member data
-	zSetMetaKey = utils.EncodeZSetData(key, member)
+	zSetMetaKey = utils.EncodeZSetData(key, version, member)
 	//old score bytes
 	oldScoreRaw, err = tidis.db.Get(txn, zSetMetaKey)
 	if err != nil {
@@ -223,9 +236,9 @@ func (tidis *Tidis) ZIncrby(txn interface{}, key []byte, step int64, member []by
 		//new score
 		newScore = step
 		//score key
-		zScoreKey = utils.EncodeZSetScore(key, member, newScore)
+		zScoreKey = utils.EncodeZSetScore(key, version, member, newScore)
 		//set zset meta data
-		newScoreRaw, _ = utils.Int64ToBytes(newScore)
+		newScoreRaw = utils.Float64ToBytes(newScore)
 		err = tikv_txn.Set(zSetMetaKey, newScoreRaw)
 		if err != nil {
 			return
@@ -235,8 +248,12 @@ func (tidis *Tidis) ZIncrby(txn interface{}, key []byte, step int64, member []by
 		if err != nil {
 			return
 		}
+		err = zsetRank(tikv_txn, key, version).insert(zScoreKey)
+		if err != nil {
+			return
+		}
 		//set zset meta data
-		zSetValue = tidis.createSetMeta(zsize, ttl, utils.FLAG_NORMAL)
+		zSetValue = tidis.createZSetMeta(zsize, ttl, utils.FLAG_NORMAL, version)
 		//encode zset type
 		zSetValue = utils.EncodeData(utils.ZSET_TYPE, zSetValue)
 		err = tikv_txn.Set(key, zSetValue)
@@ -245,34 +262,44 @@ func (tidis *Tidis) ZIncrby(txn interface{}, key []byte, step int64, member []by
 		}
 	} else {
 		//get oldscore
-		oldScore, _ = utils.BytesToInt64(oldScoreRaw)
+		oldScore, _ = utils.BytesToFloat64(oldScoreRaw)
 		//new score
 		newScore = oldScore + step
+		if math.IsNaN(newScore) {
+			err = qkverror.ErrorScoreNaN
+			return
+		}
 		//set zset data
-		newScoreRaw, _ = utils.Int64ToBytes(newScore)
+		newScoreRaw = utils.Float64ToBytes(newScore)
 		err = tikv_txn.Set(zSetMetaKey, newScoreRaw)
 		if err != nil {
 			return
 		}
 		// delete old score key
-		zScoreKey = utils.EncodeZSetScore(key, member, oldScore)
+		zScoreKey = utils.EncodeZSetScore(key, version, member, oldScore)
 		err = tikv_txn.Delete(zScoreKey)
 		if err != nil {
 			return
 		}
+		err = zsetRank(tikv_txn, key, version).remove(zScoreKey)
+		if err != nil {
+			return
+		}
 		//set zset score key
-		zScoreKey = utils.EncodeZSetScore(key, member, newScore)
+		zScoreKey = utils.EncodeZSetScore(key, version, member, newScore)
 		err = tikv_txn.Set(zScoreKey, []byte{0})
 		if err != nil {
 			return
 		}
-	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
+		err = zsetRank(tikv_txn, key, version).insert(zScoreKey)
 		if err != nil {
 			return
 		}
 	}
+	err = tidis.notify(txn, notifyZSet, "zincr", key)
+	if err != nil {
+		return
+	}
 	resp = newScore
 	return
 }
@@ -286,6 +313,7 @@ func (tidis *Tidis) ZLexcount(txn interface{}, key, start, stop []byte) (count i
 		endKey    []byte
 		withEnd   bool
 		tempCount uint64
+		version   uint64
 	)
 	if len(key) == 0 || len(start) == 0 || len(stop) == 0 {
 		err = qkverror.ErrorKeyEmpty
@@ -295,15 +323,15 @@ func (tidis *Tidis) ZLexcount(txn interface{}, key, start, stop []byte) (count i
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
-	zsize, _, _, err = tidis.getZSetMeta(txn, key)
+	zsize, _, _, version, err = tidis.getZSetMeta(txn, key)
 	if err != nil {
 		return
 	}
 	if zsize == 0 {
 		return
 	}
-	startKey, withStart = tidis.zlexParse(key, start)
-	endKey, withEnd = tidis.zlexParse(key, stop)
+	startKey, withStart = tidis.zlexParse(key, version, start)
+	endKey, withEnd = tidis.zlexParse(key, version, stop)
 	_, tempCount, err = tidis.db.GetRangeKeys(txn, startKey, withStart, endKey, withEnd, 0, zsize, true)
 	if err != nil {
 		return
@@ -321,7 +349,8 @@ func (tidis *Tidis) ZRange(txn interface{}, key []byte, start, stop int64, withs
 		count    int64
 		members  [][]byte
 		respLen  int
-		score    int64
+		score    float64
+		version  uint64
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
@@ -332,12 +361,8 @@ func (tidis *Tidis) ZRange(txn interface{}, key []byte, start, stop int64, withs
 		return
 	}
 
-	//start key
-	startKey = utils.EncodeZSetScore(key, []byte{0}, utils.SCORE_MIN)
-	//end key
-	endKey = utils.EncodeZSetScore(key, []byte{0}, utils.SCORE_MAX)
 	//offset count
-	offset, count, err = tidis.zRangeParse(txn, key, start, stop, reverse)
+	offset, count, version, err = tidis.zRangeParse(txn, key, start, stop, reverse)
 	if err != nil {
 		return
 	}
@@ -345,7 +370,9 @@ func (tidis *Tidis) ZRange(txn interface{}, key []byte, start, stop int64, withs
 		resp = utils.EmptyListInterfaces
 		return
 	}
-	members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, endKey, true, uint64(offset), uint64(count), false)
+	//start and end key
+	startKey, endKey = zScoreRangeKeys(key, version, ZScoreBound{Score: utils.SCORE_MIN}, ZScoreBound{Score: utils.SCORE_MAX})
+	members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, endKey, false, uint64(offset), uint64(count), false)
 	if err != nil {
 		return
 	}
@@ -358,13 +385,13 @@ func (tidis *Tidis) ZRange(txn interface{}, key []byte, start, stop int64, withs
 		if !reverse {
 			for i, idx := 0, 0; i < respLen; i, idx = i+2, idx+1 {
 				_, resp[i], score, _ = utils.DecodeZSetScore(members[idx])
-				resp[i+1] = []byte(strconv.FormatInt(score, 10))
+				resp[i+1] = utils.Float64ToStrBytes(score)
 			}
 		} else {
 			for i, idx := respLen-2, 0; i >= 0; i, idx = i-2, idx+1 {
 				_, resp[i], score, _ = utils.DecodeZSetScore(members[idx])
 
-				resp[i+1] = []byte(strconv.FormatInt(score, 10))
+				resp[i+1] = utils.Float64ToStrBytes(score)
 			}
 		}
 	} else {
@@ -391,27 +418,28 @@ func (tidis *Tidis) ZRangeByLex(txn interface{}, key []byte, start, stop []byte,
 		withEnd   bool = true
 		zsize     uint64
 		members   [][]byte
+		version   uint64
 	)
 	if len(key) == 0 || len(start) == 0 || len(stop) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
-	zsize, _, _, err = tidis.getZSetMeta(txn, key)
+	zsize, _, _, version, err = tidis.getZSetMeta(txn, key)
 	if err != nil {
 		return
 	}
-	startKey, withStart = tidis.zlexParse(key, start)
-	endKey, withEnd = tidis.zlexParse(key, start)
+	startKey, withStart = tidis.zlexParse(key, version, start)
+	endKey, withEnd = tidis.zlexParse(key, version, start)
 	switch stop[0] {
 	case '-':
-		endKey = utils.EncodeZSetData(key, []byte{0})
+		endKey = utils.EncodeZSetData(key, version, []byte{0})
 	case '+':
-		endKey = utils.EncodeZSetDataEnd(key)
+		endKey = utils.EncodeZSetDataEnd(key, version)
 	case '(':
-		endKey = utils.EncodeZSetData(key, stop[1:])
+		endKey = utils.EncodeZSetData(key, version, stop[1:])
 		withEnd = false
 	case '[':
-		endKey = utils.EncodeZSetData(key, stop[1:])
+		endKey = utils.EncodeZSetData(key, version, stop[1:])
 		withEnd = true
 	}
 	if count < 0 {
@@ -422,28 +450,32 @@ func (tidis *Tidis) ZRangeByLex(txn interface{}, key []byte, start, stop []byte,
 		return
 	}
 	if reverse {
-		offset = int(zsize) - offset - count
-		if offset < 0 {
-			count = count + offset
-			offset = 0
+		//the offset counts from the end of the range, the whole range is read
+		members, _, err = tidis.db.GetRangeKeys(txn, startKey, withStart, endKey, withEnd, 0, zsize, false)
+		if err != nil {
+			return
+		}
+		resp = make([]interface{}, 0)
+		for idx := len(members) - 1 - offset; idx >= 0 && len(resp) < count; idx-- {
+			_, member, _ := utils.DecodeZSetData(members[idx])
+			resp = append(resp, member)
 		}
+		return
 	}
 	members, _, err = tidis.db.GetRangeKeys(txn, startKey, withStart, endKey, withEnd, uint64(offset), uint64(count), false)
+	if err != nil {
+		return
+	}
 	resp = make([]interface{}, len(members))
-	if reverse {
-		for i, idx := 0, len(members)-1; idx >= 0; i, idx = i+1, idx-1 {
-			_, resp[i], _ = utils.DecodeZSetData(members[idx])
-		}
-	} else {
-		for i, member := range members {
-			_, resp[i], _ = utils.DecodeZSetData(member)
-		}
+	for i, member := range members {
+		_, resp[i], _ = utils.DecodeZSetData(member)
 	}
 	return
 }
 
 //ZRangeByScore returns all the elements in the sorted set at key with a score between min and max (including elements with score equal to min or max).
-func (tidis *Tidis) ZRangeByScore(txn interface{}, key []byte, min, max int64, withscores bool, offset, count int, reverse bool) (resp []interface{}, err error) {
+//With reverse min is the upper end and max the lower end of the range.
+func (tidis *Tidis) ZRangeByScore(txn interface{}, key []byte, min, max ZScoreBound, withscores bool, offset, count int, reverse bool) (resp []interface{}, err error) {
 	var (
 		zsize    uint64
 		startKey []byte
@@ -451,17 +483,18 @@ func (tidis *Tidis) ZRangeByScore(txn interface{}, key []byte, min, max int64, w
 		members  [][]byte
 		end      int
 		respLen  int
-		score    int64
+		score    float64
+		version  uint64
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
-	if (!reverse && min > max) || (reverse && min < max) {
+	if (!reverse && min.Score > max.Score) || (reverse && min.Score < max.Score) {
 		resp = utils.EmptyListInterfaces
 		return
 	}
-	zsize, _, _, err = tidis.getZSetMeta(txn, key)
+	zsize, _, _, version, err = tidis.getZSetMeta(txn, key)
 	if err != nil {
 		return
 	}
@@ -470,13 +503,14 @@ func (tidis *Tidis) ZRangeByScore(txn interface{}, key []byte, min, max int64, w
 		return
 	}
 	if reverse {
-		startKey = utils.EncodeZSetScore(key, []byte{0}, max)
-		endKey = utils.EncodeZSetScore(key, []byte{0}, min+1)
+		startKey, endKey = zScoreRangeKeys(key, version, max, min)
 	} else {
-		startKey = utils.EncodeZSetScore(key, []byte{0}, min)
-		endKey = utils.EncodeZSetScore(key, []byte{0}, max+1)
+		startKey, endKey = zScoreRangeKeys(key, version, min, max)
+	}
+	members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, endKey, false, 0, zsize, false)
+	if err != nil {
+		return
 	}
-	members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, endKey, true, 0, zsize, false)
 	if offset >= 0 {
 		if offset < len(members) {
 			if reverse {
@@ -506,12 +540,12 @@ func (tidis *Tidis) ZRangeByScore(txn interface{}, key []byte, min, max int64, w
 		if reverse {
 			for i, idx := respLen-2, 0; i >= 0; i, idx = i-2, idx+1 {
 				_, resp[i], score, _ = utils.DecodeZSetScore(members[idx])
-				resp[i+1] = []byte(strconv.FormatInt(score, 10))
+				resp[i+1] = utils.Float64ToStrBytes(score)
 			}
 		} else {
 			for i, idx := 0, 0; i < respLen; i, idx = i+2, idx+1 {
 				_, resp[i], score, _ = utils.DecodeZSetScore(members[idx])
-				resp[i+1] = []byte(strconv.FormatInt(score, 10))
+				resp[i+1] = utils.Float64ToStrBytes(score)
 			}
 		}
 	} else {
@@ -530,49 +564,51 @@ func (tidis *Tidis) ZRangeByScore(txn interface{}, key []byte, min, max int64, w
 
 //ZRem removes the specified members from the sorted set stored at key. Non existing members are ignored.
 func (tidis *Tidis) ZRem(txn interface{}, key []byte, members ...[]byte) (deleted int64, err error) {
-	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		zsize          uint64
-		ttl            uint64
-		score          int64
-		zSetValue      []byte
-		member         []byte
-		zSetMetaKey    []byte
-		zSetScoreKey   []byte
-		scoreBytes     []byte
-	)
 	if len(key) == 0 || len(members) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			deleted, err = tidis.ZRem(txn, key, members...)
 			return
-		}
+		})
+		return
+	}
+	deleted, err = tidis.zRem(txn, key, members...)
+	if err != nil || deleted == 0 {
+		return
+	}
+	err = tidis.notify(txn, notifyZSet, "zrem", key)
+	return
+}
+
+//zRem ZRem without the keyspace notification
+func (tidis *Tidis) zRem(txn interface{}, key []byte, members ...[]byte) (deleted int64, err error) {
+	var (
+		tikv_txn     kv.Transaction
+		ok           bool
+		zsize        uint64
+		ttl          uint64
+		score        float64
+		zSetValue    []byte
+		member       []byte
+		zSetMetaKey  []byte
+		zSetScoreKey []byte
+		scoreBytes   []byte
+		version      uint64
+	)
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
-	zsize, ttl, _, err = tidis.getZSetMeta(txn, key)
+	zsize, ttl, _, version, err = tidis.getZSetMeta(txn, key)
 	if err != nil {
 		return
 	}
 	for _, member = range members {
-		zSetMetaKey = utils.EncodeZSetData(key, member)
+		zSetMetaKey = utils.EncodeZSetData(key, version, member)
 		scoreBytes, err = tidis.db.Get(txn, zSetMetaKey)
 		if err != nil {
 			return
@@ -581,11 +617,11 @@ func (tidis *Tidis) ZRem(txn interface{}, key []byte, members ...[]byte) (delete
 			continue
 		}
 		deleted++
-		score, err = utils.BytesToInt64(scoreBytes)
+		score, err = utils.BytesToFloat64(scoreBytes)
 		if err != nil {
 			return
 		}
-		zSetScoreKey = utils.EncodeZSetScore(key, member, score)
+		zSetScoreKey = utils.EncodeZSetScore(key, version, member, score)
 		//delete meta data
 		err = tikv_txn.Delete(zSetMetaKey)
 		if err != nil {
@@ -596,82 +632,78 @@ func (tidis *Tidis) ZRem(txn interface{}, key []byte, members ...[]byte) (delete
 		if err != nil {
 			return
 		}
+		err = zsetRank(tikv_txn, key, version).remove(zSetScoreKey)
+		if err != nil {
+			return
+		}
 	}
 	if zsize < uint64(deleted) {
 		err = qkverror.ErrorInvalidMeta
 		return
 	}
-	//update meta
 	zsize = zsize - uint64(deleted)
-	zSetValue = tidis.createZSetMeta(zsize, ttl, utils.FLAG_NORMAL)
-	//encode zset type
-	zSetValue = utils.EncodeData(utils.ZSET_TYPE, zSetValue)
-	err = tikv_txn.Set(key, zSetValue)
-	if err != nil {
-		return
-	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
+	if zsize == 0 {
+		err = zsetRank(tikv_txn, key, version).clear()
 		if err != nil {
 			return
 		}
+		//delete key
+		err = tikv_txn.Delete(key)
+		return
 	}
+	//update meta
+	zSetValue = tidis.createZSetMeta(zsize, ttl, utils.FLAG_NORMAL, version)
+	//encode zset type
+	zSetValue = utils.EncodeData(utils.ZSET_TYPE, zSetValue)
+	err = tikv_txn.Set(key, zSetValue)
 	return
 }
 
 //ZRemRangeByLex remove all members in a sorted set between the given lexicographical range
 func (tidis *Tidis) ZRemRangeByLex(txn interface{}, key, start, stop []byte) (deleted int64, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		zsize          uint64
-		ttl            uint64
-		startKey       []byte
-		endKey         []byte
-		withStart      bool = true
-		withEnd        bool = true
-		members        [][]byte
-		member         []byte
-		dMember        []byte
-		scoreBytes     []byte
-		score          int64
-		zSetScoreKey   []byte
-		zSetValue      []byte
+		tikv_txn     kv.Transaction
+		ok           bool
+		zsize        uint64
+		ttl          uint64
+		startKey     []byte
+		endKey       []byte
+		withStart    bool = true
+		withEnd      bool = true
+		members      [][]byte
+		member       []byte
+		dMember      []byte
+		scoreBytes   []byte
+		score        float64
+		zSetScoreKey []byte
+		zSetValue    []byte
+		version      uint64
 	)
 	if len(key) == 0 || len(start) == 0 || len(stop) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			deleted, err = tidis.ZRemRangeByLex(txn, key, start, stop)
 			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
-	zsize, ttl, _, err = tidis.getZSetMeta(txn, key)
+	zsize, ttl, _, version, err = tidis.getZSetMeta(txn, key)
 	if err != nil {
 		return
 	}
 	if zsize == 0 {
 		return
 	}
-	startKey, withStart = tidis.zlexParse(key, start)
-	endKey, withEnd = tidis.zlexParse(key, stop)
+	startKey, withStart = tidis.zlexParse(key, version, start)
+	endKey, withEnd = tidis.zlexParse(key, version, stop)
 	members, _, err = tidis.db.GetRangeKeys(txn, startKey, withStart, endKey, withEnd, 0, zsize, false)
 	if err != nil {
 		return
@@ -686,12 +718,12 @@ func (tidis *Tidis) ZRemRangeByLex(txn interface{}, key, start, stop []byte) (de
 		if err != nil {
 			return
 		}
-		scoreBytes, err = tidis.Get(txn, member)
+		scoreBytes, err = tidis.db.Get(txn, member)
 		if err != nil {
 			return
 		}
-		score, _ = utils.BytesToInt64(scoreBytes)
-		zSetScoreKey = utils.EncodeZSetScore(key, dMember, score)
+		score, _ = utils.BytesToFloat64(scoreBytes)
+		zSetScoreKey = utils.EncodeZSetScore(key, version, dMember, score)
 		err = tikv_txn.Delete(member)
 		if err != nil {
 			return
@@ -700,9 +732,17 @@ func (tidis *Tidis) ZRemRangeByLex(txn interface{}, key, start, stop []byte) (de
 		if err != nil {
 			return
 		}
+		err = zsetRank(tikv_txn, key, version).remove(zSetScoreKey)
+		if err != nil {
+			return
+		}
 	}
 	zsize = zsize - uint64(deleted)
 	if zsize == 0 {
+		err = zsetRank(tikv_txn, key, version).clear()
+		if err != nil {
+			return
+		}
 		//delete key
 		err = tikv_txn.Delete(key)
 		if err != nil {
@@ -710,7 +750,7 @@ func (tidis *Tidis) ZRemRangeByLex(txn interface{}, key, start, stop []byte) (de
 		}
 	} else {
 		//update meta
-		zSetValue = tidis.createZSetMeta(zsize, ttl, utils.FLAG_NORMAL)
+		zSetValue = tidis.createZSetMeta(zsize, ttl, utils.FLAG_NORMAL, version)
 		//encode zset type
 		zSetValue = utils.EncodeData(utils.ZSET_TYPE, zSetValue)
 		err = tikv_txn.Set(key, zSetValue)
@@ -718,65 +758,58 @@ func (tidis *Tidis) ZRemRangeByLex(txn interface{}, key, start, stop []byte) (de
 			return
 		}
 	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
-		if err != nil {
-			return
-		}
+	if deleted == 0 {
+		return
+	}
+	err = tidis.notify(txn, notifyZSet, "zremrangebylex", key)
+	if err != nil || zsize > 0 {
+		return
 	}
+	err = tidis.notify(txn, notifyGeneric, "del", key)
 	return
 }
 
 //ZRemRangeByScore removes all elements in the sorted set stored at key with a score between min and max (inclusive).
-func (tidis *Tidis) ZRemRangeByScore(txn interface{}, key []byte, min, max int64) (deleted int64, err error) {
+func (tidis *Tidis) ZRemRangeByScore(txn interface{}, key []byte, min, max ZScoreBound) (deleted int64, err error) {
 	var (
-		tikv_txn       kv.Transaction
-		ok             bool
-		notTransaction bool
-		zsize          uint64
-		ttl            uint64
-		startKey       []byte
-		endKey         []byte
-		members        [][]byte
-		member         []byte
-		dMember        []byte
-		zSetMetaKey    []byte
-		zSetValue      []byte
+		tikv_txn    kv.Transaction
+		ok          bool
+		zsize       uint64
+		ttl         uint64
+		startKey    []byte
+		endKey      []byte
+		members     [][]byte
+		member      []byte
+		dMember     []byte
+		zSetMetaKey []byte
+		zSetValue   []byte
+		version     uint64
 	)
 	if len(key) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
 	if txn == nil {
-		//start transaction
-		notTransaction = true
-		txn, err = tidis.NewTxn()
-		if err != nil {
-			return
-		}
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
-			return
-		}
-		defer tikv_txn.Rollback()
-	} else {
-		tikv_txn, ok = txn.(kv.Transaction)
-		if !ok {
-			err = qkverror.ErrorServerInternal
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			deleted, err = tidis.ZRemRangeByScore(txn, key, min, max)
 			return
-		}
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
 	}
-	zsize, ttl, _, err = tidis.getZSetMeta(txn, key)
+	zsize, ttl, _, version, err = tidis.getZSetMeta(txn, key)
 	if err != nil {
 		return
 	}
 	if zsize == 0 {
 		return
 	}
-	startKey = utils.EncodeZSetScore(key, []byte{0}, min)
-	endKey = utils.EncodeZSetScore(key, []byte{0}, max+1)
-	members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, endKey, true, 0, zsize, false)
+	startKey, endKey = zScoreRangeKeys(key, version, min, max)
+	members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, endKey, false, 0, zsize, false)
 	if err != nil {
 		return
 	}
@@ -785,7 +818,7 @@ func (tidis *Tidis) ZRemRangeByScore(txn interface{}, key []byte, min, max int64
 		if err != nil {
 			return
 		}
-		zSetMetaKey = utils.EncodeZSetData(key, dMember)
+		zSetMetaKey = utils.EncodeZSetData(key, version, dMember)
 		err = tikv_txn.Delete(member)
 		if err != nil {
 			return
@@ -794,6 +827,10 @@ func (tidis *Tidis) ZRemRangeByScore(txn interface{}, key []byte, min, max int64
 		if err != nil {
 			return
 		}
+		err = zsetRank(tikv_txn, key, version).remove(member)
+		if err != nil {
+			return
+		}
 	}
 	deleted = int64(len(members))
 	if zsize < uint64(deleted) {
@@ -803,52 +840,68 @@ func (tidis *Tidis) ZRemRangeByScore(txn interface{}, key []byte, min, max int64
 	zsize = zsize - uint64(deleted)
 	if zsize != 0 {
 		//update meta
-		zSetValue = tidis.createZSetMeta(zsize, ttl, utils.FLAG_NORMAL)
+		zSetValue = tidis.createZSetMeta(zsize, ttl, utils.FLAG_NORMAL, version)
 		zSetValue = utils.EncodeData(utils.ZSET_TYPE, zSetValue)
 		err = tikv_txn.Set(key, zSetValue)
 		if err != nil {
 			return
 		}
 	} else {
-		// delete meta
-		err = tikv_txn.Delete(key)
+		err = zsetRank(tikv_txn, key, version).clear()
 		if err != nil {
 			return
 		}
-	}
-	if notTransaction {
-		err = tikv_txn.Commit(context.Background())
+		// delete meta
+		err = tikv_txn.Delete(key)
 		if err != nil {
 			return
 		}
 	}
+	if deleted == 0 {
+		return
+	}
+	err = tidis.notify(txn, notifyZSet, "zremrangebyscore", key)
+	if err != nil || zsize > 0 {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "del", key)
 	return
 }
 
-//ZScore Returns the score of member in the sorted set at key.
-func (tidis *Tidis) ZScore(txn interface{}, key, member []byte) (score int64, err error) {
+//ZScore Returns the score of member in the sorted set at key, exists is false if member does not exist.
+func (tidis *Tidis) ZScore(txn interface{}, key, member []byte) (score float64, exists bool, err error) {
 	var (
+		zsize       uint64
 		zSetMetaKey []byte
 		scoreBytes  []byte
+		version     uint64
 	)
 	if len(key) == 0 || len(member) == 0 {
 		err = qkverror.ErrorKeyEmpty
 		return
 	}
-	zSetMetaKey = utils.EncodeZSetData(key, member)
+	zsize, _, _, version, err = tidis.getZSetMeta(txn, key)
+	if err != nil || zsize == 0 {
+		return
+	}
+	zSetMetaKey = utils.EncodeZSetData(key, version, member)
 	scoreBytes, err = tidis.db.Get(txn, zSetMetaKey)
-	score, err = utils.BytesToInt64(scoreBytes)
+	if err != nil || scoreBytes == nil {
+		return
+	}
+	score, err = utils.BytesToFloat64(scoreBytes)
+	exists = err == nil
 	return
 }
 
 //zRangeParse zrange key offset count
-func (tidis *Tidis) zRangeParse(txn interface{}, key []byte, start, stop int64, reverse bool) (offset int64, count int64, err error) {
+func (tidis *Tidis) zRangeParse(txn interface{}, key []byte, start, stop int64, reverse bool) (offset int64, count int64, version uint64, err error) {
 	var (
 		zsize uint64
 		index int64
 	)
 	//get zset meta
-	zsize, _, _, err = tidis.getZSetMeta(txn, key)
+	zsize, _, _, version, err = tidis.getZSetMeta(txn, key)
 	if err != nil {
 		return
 	}
@@ -880,30 +933,32 @@ func (tidis *Tidis) zRangeParse(txn interface{}, key []byte, start, stop int64,
 		}
 	}
 	if reverse {
-		start = index - stop - 1
-		stop = index - start
-		offset = start
-		count = stop - start
+		//the ranks counted from the highest score
+		offset = index - stop - 1
+		count = stop - start + 1
 	} else {
 		offset = start
 		count = stop - start + 1
 	}
+	if count <= 0 {
+		offset, count = 0, 0
+	}
 	return
 }
-func (tidis *Tidis) zlexParse(key, lex []byte) (lexKey []byte, ok bool) {
+func (tidis *Tidis) zlexParse(key []byte, version uint64, lex []byte) (lexKey []byte, ok bool) {
 	if len(lex) == 0 {
 		return
 	}
 	switch lex[0] {
 	case '-':
-		lexKey = utils.EncodeZSetData(key, []byte{0})
+		lexKey = utils.EncodeZSetData(key, version, []byte{0})
 	case '+':
-		lexKey = utils.EncodeZSetDataEnd(key)
+		lexKey = utils.EncodeZSetDataEnd(key, version)
 	case '(':
-		lexKey = utils.EncodeZSetData(key, lex[1:])
+		lexKey = utils.EncodeZSetData(key, version, lex[1:])
 		ok = false
 	case '[':
-		lexKey = utils.EncodeZSetData(key, lex[1:])
+		lexKey = utils.EncodeZSetData(key, version, lex[1:])
 		ok = true
 	default:
 		return
@@ -911,20 +966,729 @@ func (tidis *Tidis) zlexParse(key, lex []byte) (lexKey []byte, ok bool) {
 
 	return
 }
-func (tidis *Tidis) createZSetMeta(size, ttl uint64, flag byte) []byte {
-	return tidis.createHashMeta(size, ttl, flag)
+
+//zScoreRangeKeys start key(inclusive) and end key(exclusive) of the score keys between min and max
+func zScoreRangeKeys(key []byte, version uint64, min, max ZScoreBound) (startKey, endKey []byte) {
+	var (
+		startOffset uint64
+		endOffset   uint64
+	)
+	startOffset = utils.ZScoreOffset(min.Score)
+	if min.Exclusive {
+		startOffset++
+	}
+	endOffset = utils.ZScoreOffset(max.Score)
+	if !max.Exclusive {
+		endOffset++
+	}
+	startKey = utils.EncodeZSetScorePrefix(key, version, startOffset)
+	endKey = utils.EncodeZSetScorePrefix(key, version, endOffset)
+	return
+}
+
+//createZSetMeta hash meta with the score encoding appended
+func (tidis *Tidis) createZSetMeta(size, ttl uint64, flag byte, version uint64) []byte {
+	return append(tidis.createHashMeta(size, ttl, flag, version), utils.ZSCORE_FLOAT64_RANK)
+}
+
+//zsetRank the rank index of the score keys of the sorted set at key
+func zsetRank(txn kv.Transaction, key []byte, version uint64) *rankIndex {
+	return newRankIndex(txn, key, version, utils.EncodeMemberPrefix(utils.ZSET_SCORE, key, version))
+}
+
+//getZSetMeta zset meta, integer scores written by older versions are converted to float scores first, and the rank
+//index of the sorted sets written before it is built.
+func (tidis *Tidis) getZSetMeta(txn interface{}, key []byte) (ssize uint64, ttl uint64, flag byte, version uint64, err error) {
+	var (
+		dataType byte
+		encoding byte
+	)
+	dataType, ssize, ttl, flag, version, encoding, err = tidis.getZSetMetaWithEncoding(txn, key)
+	if err != nil || ssize == 0 {
+		return
+	}
+	if dataType != utils.ZSET_TYPE {
+		err = qkverror.ErrorWrongType
+		return
+	}
+	if encoding != utils.ZSCORE_FLOAT64_RANK {
+		err = tidis.migrateZSetScores(txn, key)
+	}
+	return
+}
+func (tidis *Tidis) getZSetMetaWithEncoding(txn interface{}, key []byte) (dataType byte, ssize uint64, ttl uint64, flag byte, version uint64, encoding byte, err error) {
+	var (
+		rawData []byte
+		value   []byte
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	rawData, err = tidis.db.Get(txn, key)
+	if err != nil || rawData == nil {
+		return
+	}
+	dataType, value, err = utils.DecodeData(rawData)
+	if err != nil {
+		return
+	}
+	//the meta of the other types may not decode as a zset meta
+	if dataType != utils.ZSET_TYPE {
+		err = qkverror.ErrorWrongType
+		return
+	}
+	ssize, ttl, flag, version, err = decodeHashMeta(value)
+	if err != nil {
+		return
+	}
+	switch len(value) {
+	case 18:
+		//float meta written before versioned meta
+		encoding = value[17]
+	case 26:
+		encoding = value[25]
+	}
+	return
+}
+
+//MigrateZSetScores converts all the zsets stored with integer scores to float scores and builds the rank index of the
+//zsets written before it. Zsets are also converted when they are first accessed, this walks the whole key space at once.
+func (tidis *Tidis) MigrateZSetScores() (migrated int64, err error) {
+	var (
+		cursor   = []byte("0")
+		keys     []interface{}
+		key      []byte
+		encoding byte
+	)
+	for {
+		//zsets with integer scores were written before the other databases
+		cursor, keys, err = tidis.Scan(nil, nil, cursor, nil, 1000, utils.TypeName(utils.ZSET_TYPE))
+		if err != nil {
+			return
+		}
+		for _, k := range keys {
+			key = k.([]byte)
+			_, _, _, _, _, encoding, err = tidis.getZSetMetaWithEncoding(nil, key)
+			if err != nil {
+				return
+			}
+			if encoding == utils.ZSCORE_FLOAT64_RANK {
+				continue
+			}
+			err = tidis.migrateZSetScores(nil, key)
+			if err != nil {
+				return
+			}
+			migrated++
+		}
+		if string(cursor) == "0" {
+			return
+		}
+	}
 }
 
-func (tidis *Tidis) getZSetMeta(txn interface{}, key []byte) (ssize uint64, ttl uint64, flag byte, err error) {
+//migrateZSetScores rewrites a zset stored with integer scores to float scores, then builds its rank index.
+func (tidis *Tidis) migrateZSetScores(txn interface{}, key []byte) (err error) {
 	var (
+		tikv_txn kv.Transaction
+		ok       bool
 		dataType byte
+		zsize    uint64
+		ttl      uint64
+		encoding byte
+		members  [][]byte
+		member   []byte
+		oldScore int64
+		score    float64
+		version  uint64
 	)
-	dataType, ssize, ttl, flag, err = tidis.getHashMeta(txn, key)
-	if ssize > 0 {
-		if dataType != utils.ZSET_TYPE {
-			err = qkverror.ErrorWrongType
+	if txn == nil {
+		return tidis.RetryTxn(func(txn interface{}) error {
+			return tidis.migrateZSetScores(txn, key)
+		})
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	//read meta again, another client may have converted it
+	dataType, zsize, ttl, _, version, encoding, err = tidis.getZSetMetaWithEncoding(txn, key)
+	if err != nil || dataType != utils.ZSET_TYPE || zsize == 0 || encoding == utils.ZSCORE_FLOAT64_RANK {
+		return
+	}
+	if encoding != utils.ZSCORE_INT64 {
+		return tidis.buildZSetRank(tikv_txn, key, zsize, ttl, version)
+	}
+	//member -> score pairs
+	members, err = tidis.db.GetRangeKeysValues(txn, utils.EncodeZSetDataPrefix(key, version), nil, zsize, true)
+	if err != nil {
+		return
+	}
+	//old score keys
+	_, err = tidis.db.DeleteRangeWithTxn(txn, utils.EncodeZSetScorePrefix(key, version, 0), utils.EncodeZSetScorePrefix(key, version, math.MaxUint64), zsize)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(members)-1; i = i + 2 {
+		_, member, err = utils.DecodeZSetData(members[i])
+		if err != nil {
+			return
+		}
+		oldScore, err = utils.BytesToInt64(members[i+1])
+		if err != nil {
 			return
 		}
+		score = float64(oldScore)
+		err = tikv_txn.Set(members[i], utils.Float64ToBytes(score))
+		if err != nil {
+			return
+		}
+		err = tikv_txn.Set(utils.EncodeZSetScore(key, version, member, score), []byte{0})
+		if err != nil {
+			return
+		}
+	}
+	return tidis.buildZSetRank(tikv_txn, key, zsize, ttl, version)
+}
+
+//buildZSetRank builds the rank index of a zset written before it, and marks it in the meta.
+func (tidis *Tidis) buildZSetRank(txn kv.Transaction, key []byte, zsize, ttl, version uint64) (err error) {
+	err = zsetRank(txn, key, version).build()
+	if err != nil {
+		return
+	}
+	return txn.Set(key, utils.EncodeData(utils.ZSET_TYPE, tidis.createZSetMeta(zsize, ttl, utils.FLAG_NORMAL, version)))
+}
+
+//aggregate of the scores of a member in ZUNIONSTORE and ZINTERSTORE
+const (
+	ZAggregateSum byte = iota
+	ZAggregateMin
+	ZAggregateMax
+)
+
+//kind of the range of ZRANGESTORE
+const (
+	ZRangeByIndex byte = iota
+	ZRangeByScore
+	ZRangeByLex
+)
+
+//ZRangeSpec the range of ZRangeStore, Start and Stop are indexes, Min and Max scores, LexMin and LexMax lex bounds.
+//Offset and Count apply to the score and lex ranges, a negative Count returns all the members after Offset.
+type ZRangeSpec struct {
+	By      byte
+	Start   int64
+	Stop    int64
+	Min     ZScoreBound
+	Max     ZScoreBound
+	LexMin  []byte
+	LexMax  []byte
+	Reverse bool
+	Offset  int
+	Count   int
+}
+
+//ZRank returns the rank of member in the sorted set stored at key, ordered from the lowest score or from the highest
+//score with reverse. The rank is counted with the rank index of the sorted set in O(log n) reads.
+func (tidis *Tidis) ZRank(txn interface{}, key, member []byte, reverse bool) (rank int64, score float64, exists bool, err error) {
+	var (
+		tikv_txn   kv.Transaction
+		ok         bool
+		zsize      uint64
+		version    uint64
+		scoreBytes []byte
+		before     uint64
+	)
+	if len(key) == 0 || len(member) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			rank, score, exists, err = tidis.ZRank(txn, key, member, reverse)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	zsize, _, _, version, err = tidis.getZSetMeta(txn, key)
+	if err != nil || zsize == 0 {
+		return
+	}
+	scoreBytes, err = tidis.db.Get(txn, utils.EncodeZSetData(key, version, member))
+	if err != nil || scoreBytes == nil {
+		return
+	}
+	score, err = utils.BytesToFloat64(scoreBytes)
+	if err != nil {
+		return
+	}
+	before, err = zsetRank(tikv_txn, key, version).rank(utils.EncodeZSetScore(key, version, member, score))
+	if err != nil {
+		return
+	}
+	if before >= zsize {
+		err = qkverror.ErrorInvalidMeta
+		return
+	}
+	rank, exists = int64(before), true
+	if reverse {
+		rank = int64(zsize) - rank - 1
+	}
+	return
+}
+
+//ZMScore returns the scores of the members of the sorted set stored at key, nil for a missing member.
+func (tidis *Tidis) ZMScore(txn interface{}, key []byte, members ...[]byte) (resp []interface{}, err error) {
+	var (
+		score  float64
+		exists bool
+	)
+	resp = make([]interface{}, len(members))
+	for i, member := range members {
+		score, exists, err = tidis.ZScore(txn, key, member)
+		if err != nil {
+			return
+		}
+		if exists {
+			resp[i] = utils.Float64ToStrBytes(score)
+		}
+	}
+	return
+}
+
+//ZPop removes and returns up to count members with the lowest scores, or the highest scores with max,
+//from the sorted set stored at key, each member followed by its score.
+func (tidis *Tidis) ZPop(txn interface{}, key []byte, count int64, max bool) (resp []interface{}, err error) {
+	var (
+		members [][]byte
+		zsize   int64
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			resp, err = tidis.ZPop(txn, key, count, max)
+			return
+		})
+		return
+	}
+	if count <= 0 {
+		resp = utils.EmptyListInterfaces
+		return
+	}
+	resp, err = tidis.ZRange(txn, key, 0, count-1, true, max)
+	if err != nil || len(resp) == 0 {
+		return
+	}
+	members = make([][]byte, 0, len(resp)/2)
+	for i := 0; i < len(resp); i += 2 {
+		members = append(members, resp[i].([]byte))
+	}
+	_, err = tidis.zRem(txn, key, members...)
+	if err != nil {
+		return
+	}
+	if max {
+		err = tidis.notify(txn, notifyZSet, "zpopmax", key)
+	} else {
+		err = tidis.notify(txn, notifyZSet, "zpopmin", key)
+	}
+	if err != nil {
+		return
+	}
+	zsize, err = tidis.ZCard(txn, key)
+	if err != nil || zsize > 0 {
+		return
+	}
+	err = tidis.notify(txn, notifyGeneric, "del", key)
+	return
+}
+
+//ZRandMember returns count random members of the sorted set stored at key, each followed by its score with withScores.
+//A positive count returns distinct members, a negative count may return the same member several times.
+//The members are read by a single scan up to the greatest rank picked.
+func (tidis *Tidis) ZRandMember(txn interface{}, key []byte, count int64, withScores bool) (resp []interface{}, err error) {
+	var (
+		zsize            uint64
+		version          uint64
+		ranks            []int64
+		first, last      int64
+		startKey, endKey []byte
+		members          [][]byte
+		member           []byte
+		score            float64
+	)
+	if len(key) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	zsize, _, _, version, err = tidis.getZSetMeta(txn, key)
+	if err != nil {
+		return
+	}
+	if zsize == 0 || count == 0 {
+		resp = utils.EmptyListInterfaces
+		return
+	}
+	if count >= int64(zsize) {
+		return tidis.ZRange(txn, key, 0, -1, withScores, false)
+	}
+	ranks, first, last = randomRanks(zsize, count)
+	startKey, endKey = zScoreRangeKeys(key, version, ZScoreBound{Score: utils.SCORE_MIN}, ZScoreBound{Score: utils.SCORE_MAX})
+	members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, endKey, false, uint64(first), uint64(last-first+1), false)
+	if err != nil {
+		return
+	}
+	if int64(len(members)) != last-first+1 {
+		err = qkverror.ErrorInvalidMeta
+		return
+	}
+	for _, rank := range ranks {
+		_, member, score, err = utils.DecodeZSetScore(members[rank-first])
+		if err != nil {
+			return
+		}
+		resp = append(resp, member)
+		if withScores {
+			resp = append(resp, utils.Float64ToStrBytes(score))
+		}
+	}
+	return
+}
+
+//ZStoreAction computes the union or the intersection of the sorted sets stored at keys and stores it in dest,
+//the score of each key is multiplied by its weight and aggregated by sum, min or max. Sets count as sorted sets
+//with the score 1. Returns the number of members in dest.
+func (tidis *Tidis) ZStoreAction(txn interface{}, actionType int, dest []byte, keys [][]byte, weights []float64, aggregate byte) (stored int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		pairs    []*ZSetPair
+	)
+	if len(dest) == 0 || len(keys) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			stored, err = tidis.ZStoreAction(txn, actionType, dest, keys, weights, aggregate)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	pairs, err = tidis.zAction(txn, actionType, keys, weights, aggregate)
+	if err != nil {
+		return
+	}
+	if actionType == Union {
+		stored, err = tidis.zStore(tikv_txn, dest, pairs, "zunionstore")
+	} else {
+		stored, err = tidis.zStore(tikv_txn, dest, pairs, "zinterstore")
+	}
+	return
+}
+
+//ZDiff returns the members of the first sorted set that are not in the successive ones, ordered by score,
+//each member followed by its score with withScores.
+func (tidis *Tidis) ZDiff(txn interface{}, keys [][]byte, withScores bool) (resp []interface{}, err error) {
+	var (
+		pairs []*ZSetPair
+	)
+	if len(keys) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	pairs, err = tidis.zAction(txn, Diff, keys, nil, ZAggregateSum)
+	if err != nil {
+		return
+	}
+	resp = make([]interface{}, 0, len(pairs)*2)
+	for _, pair := range pairs {
+		resp = append(resp, pair.Key)
+		if withScores {
+			resp = append(resp, utils.Float64ToStrBytes(pair.Score))
+		}
+	}
+	return
+}
+
+//ZRangeStore stores the range spec of the sorted set stored at src in dest, returns the number of members stored.
+func (tidis *Tidis) ZRangeStore(txn interface{}, dest, src []byte, spec ZRangeSpec) (stored int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		ok       bool
+		resp     []interface{}
+		pairs    []*ZSetPair
+		score    float64
+		exists   bool
+		end      int
+	)
+	if len(dest) == 0 || len(src) == 0 {
+		err = qkverror.ErrorKeyEmpty
+		return
+	}
+	if txn == nil {
+		err = tidis.RetryTxn(func(txn interface{}) (err error) {
+			stored, err = tidis.ZRangeStore(txn, dest, src, spec)
+			return
+		})
+		return
+	}
+	tikv_txn, ok = txn.(kv.Transaction)
+	if !ok {
+		err = qkverror.ErrorServerInternal
+		return
+	}
+	switch spec.By {
+	case ZRangeByIndex:
+		resp, err = tidis.ZRange(txn, src, spec.Start, spec.Stop, true, spec.Reverse)
+		if err != nil {
+			return
+		}
+		pairs, err = zPairsFromResp(resp)
+	case ZRangeByScore:
+		//with Reverse Min is the upper end like ZRangeByScore
+		resp, err = tidis.ZRangeByScore(txn, src, spec.Min, spec.Max, true, -1, -1, spec.Reverse)
+		if err != nil {
+			return
+		}
+		pairs, err = zPairsFromResp(resp)
+	case ZRangeByLex:
+		resp, err = tidis.ZRangeByLex(txn, src, spec.LexMin, spec.LexMax, 0, -1, false)
+		if err != nil {
+			return
+		}
+		pairs = make([]*ZSetPair, 0, len(resp))
+		for _, member := range resp {
+			score, exists, err = tidis.ZScore(txn, src, member.([]byte))
+			if err != nil {
+				return
+			}
+			if exists {
+				pairs = append(pairs, &ZSetPair{Score: score, Key: member.([]byte)})
+			}
+		}
+		if spec.Reverse {
+			for i, j := 0, len(pairs)-1; i < j; i, j = i+1, j-1 {
+				pairs[i], pairs[j] = pairs[j], pairs[i]
+			}
+		}
+	}
+	if err != nil {
+		return
+	}
+	if spec.By != ZRangeByIndex && (spec.Offset > 0 || spec.Count >= 0) {
+		if spec.Offset >= len(pairs) {
+			pairs = nil
+		} else {
+			end = len(pairs)
+			if spec.Count >= 0 && spec.Offset+spec.Count < end {
+				end = spec.Offset + spec.Count
+			}
+			pairs = pairs[spec.Offset:end]
+		}
+	}
+	stored, err = tidis.zStore(tikv_txn, dest, pairs, "zrangestore")
+	return
+}
+
+//zAction union, intersection or difference of the sorted sets or sets stored at keys, ordered by score and member
+func (tidis *Tidis) zAction(txn interface{}, actionType int, keys [][]byte, weights []float64, aggregate byte) (pairs []*ZSetPair, err error) {
+	var (
+		result  map[string]float64
+		current map[string]float64
+		members []*ZSetPair
+		weight  float64
+		score   float64
+		old     float64
+		found   bool
+		member  string
+	)
+	for i, key := range keys {
+		members, err = tidis.zsetPairs(txn, key)
+		if err != nil {
+			return
+		}
+		weight = 1
+		if i < len(weights) {
+			weight = weights[i]
+		}
+		current = make(map[string]float64, len(members))
+		for _, pair := range members {
+			score = pair.Score * weight
+			//inf * 0
+			if math.IsNaN(score) {
+				score = 0
+			}
+			current[string(pair.Key)] = score
+		}
+		if i == 0 {
+			result = current
+			continue
+		}
+		switch actionType {
+		case Diff:
+			for member = range current {
+				delete(result, member)
+			}
+		case Inter:
+			for member, old = range result {
+				if score, found = current[member]; !found {
+					delete(result, member)
+					continue
+				}
+				result[member] = zAggregate(old, score, aggregate)
+			}
+		case Union:
+			for member, score = range current {
+				if old, found = result[member]; found {
+					score = zAggregate(old, score, aggregate)
+				}
+				result[member] = score
+			}
+		}
+	}
+	pairs = make([]*ZSetPair, 0, len(result))
+	for member, score = range result {
+		pairs = append(pairs, &ZSetPair{Score: score, Key: []byte(member)})
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score < pairs[j].Score
+		}
+		return bytes.Compare(pairs[i].Key, pairs[j].Key) < 0
+	})
+	return
+}
+
+//zAggregate the score of a member found in two sorted sets
+func zAggregate(a, b float64, aggregate byte) (score float64) {
+	switch aggregate {
+	case ZAggregateMin:
+		score = math.Min(a, b)
+	case ZAggregateMax:
+		score = math.Max(a, b)
+	default:
+		score = a + b
+		//-inf + inf
+		if math.IsNaN(score) {
+			score = 0
+		}
+	}
+	return
+}
+
+//zsetPairs all the members of the sorted set stored at key, the members of a set have the score 1
+func (tidis *Tidis) zsetPairs(txn interface{}, key []byte) (pairs []*ZSetPair, err error) {
+	var (
+		dataType         byte
+		zsize            uint64
+		version          uint64
+		startKey, endKey []byte
+		members          [][]byte
+		setMembers       []interface{}
+	)
+	dataType, err = tidis.getType(txn, key)
+	if err != nil {
+		return
+	}
+	switch dataType {
+	case utils.NONE_TYPE:
+	case utils.SET_TYPE:
+		setMembers, err = tidis.SMembers(txn, key)
+		if err != nil {
+			return
+		}
+		pairs = make([]*ZSetPair, len(setMembers))
+		for i, member := range setMembers {
+			pairs[i] = &ZSetPair{Score: 1, Key: member.([]byte)}
+		}
+	case utils.ZSET_TYPE:
+		zsize, _, _, version, err = tidis.getZSetMeta(txn, key)
+		if err != nil || zsize == 0 {
+			return
+		}
+		startKey, endKey = zScoreRangeKeys(key, version, ZScoreBound{Score: utils.SCORE_MIN}, ZScoreBound{Score: utils.SCORE_MAX})
+		members, _, err = tidis.db.GetRangeKeys(txn, startKey, true, endKey, false, 0, zsize, false)
+		if err != nil {
+			return
+		}
+		pairs = make([]*ZSetPair, len(members))
+		for i, member := range members {
+			pairs[i] = new(ZSetPair)
+			_, pairs[i].Key, pairs[i].Score, err = utils.DecodeZSetScore(member)
+			if err != nil {
+				return
+			}
+		}
+	default:
+		err = qkverror.ErrorWrongType
+	}
+	return
+}
+
+//zPairsFromResp the members and scores of a zset range reply with scores
+func zPairsFromResp(resp []interface{}) (pairs []*ZSetPair, err error) {
+	pairs = make([]*ZSetPair, 0, len(resp)/2)
+	for i := 0; i+1 < len(resp); i += 2 {
+		pair := &ZSetPair{Key: resp[i].([]byte)}
+		pair.Score, err = utils.StrBytesToFloat64(resp[i+1].([]byte))
+		if err != nil {
+			return
+		}
+		pairs = append(pairs, pair)
+	}
+	return
+}
+
+//zStore replaces dest by a sorted set of pairs, dest is deleted if pairs is empty. Returns the size of dest.
+func (tidis *Tidis) zStore(txn kv.Transaction, dest []byte, pairs []*ZSetPair, event string) (stored int64, err error) {
+	var (
+		destType byte
+	)
+	err = tidis.DeleteIfExpired(txn, dest, true)
+	if err != nil {
+		return
+	}
+	destType, err = tidis.getType(txn, dest)
+	if err != nil {
+		return
+	}
+	if destType != utils.NONE_TYPE {
+		err = tidis.removeMetaKey(txn, dest)
+		if err != nil {
+			return
+		}
+		_, err = tidis.DeleteWithTxn(txn, [][]byte{dest})
+		if err != nil {
+			return
+		}
+	}
+	if len(pairs) == 0 {
+		if destType != utils.NONE_TYPE {
+			err = tidis.notify(txn, notifyGeneric, "del", dest)
+		}
+		return
+	}
+	stored, err = tidis.zAdd(txn, dest, pairs...)
+	if err != nil {
+		return
 	}
+	err = tidis.notify(txn, notifyZSet, event, dest)
 	return
 }
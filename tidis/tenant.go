@@ -0,0 +1,423 @@
+package tidis
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/chuangyou/qkv/config"
+	"github.com/chuangyou/qkv/metrics"
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	//maxTenantName the length of a tenant name is stored in 1 byte of its prefix
+	maxTenantName        = 255
+	defaultQuotaInterval = 60000
+	//quotaBatch keys read by an iterator when a tenant is measured
+	quotaBatch = 4096
+	//usageShards the usage counters of a tenant updated by the writes
+	usageShards = 16
+)
+
+var (
+	quotaLease = []byte("quota")
+)
+
+//Tenant a tenant of the config. Its keys are stored under its own prefix, it has its own databases
+//and its usage is limited by its quotas.
+type Tenant struct {
+	Name     string
+	password string
+	prefix   []byte
+	maxKeys  int64
+	maxBytes int64
+}
+
+//newTenants check the tenants of the config, their names are part of their keys and channels
+func newTenants(confs []config.TenantConfig, auth string) (tenants []*Tenant, err error) {
+	var (
+		names     = make(map[string]bool)
+		passwords = make(map[string]bool)
+	)
+	for _, conf := range confs {
+		//"default" is the user of the password of the config
+		if conf.Name == "" || conf.Name == "default" || len(conf.Name) > maxTenantName || !validTenantName(conf.Name) || names[conf.Name] {
+			return nil, qkverror.ErrorTenantName
+		}
+		//AUTH password finds the tenant by its password
+		if conf.Password == "" || conf.Password == auth || passwords[conf.Password] {
+			return nil, qkverror.ErrorTenantPassword
+		}
+		names[conf.Name] = true
+		passwords[conf.Password] = true
+		tenants = append(tenants, &Tenant{
+			Name:     conf.Name,
+			password: conf.Password,
+			prefix:   utils.EncodeTenantPrefix([]byte(conf.Name)),
+			maxKeys:  conf.MaxKeys,
+			maxBytes: conf.MaxBytes,
+		})
+	}
+	return
+}
+
+//validTenantName letters, digits, '_' and '-'
+func validTenantName(name string) bool {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+//Tenants the tenants of the config
+func (tidis *Tidis) Tenants() []*Tenant {
+	return tidis.tenants
+}
+
+//Authenticate the tenant whose password is password, name may be empty to find it by its password only
+func (tidis *Tidis) Authenticate(name, password string) (tenant *Tenant, ok bool) {
+	for _, t := range tidis.tenants {
+		if (name == "" || name == t.Name) && password == t.password {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+//Namespace the prefix of the keys of the database db of tenant, tenant is nil for the keys outside of the tenants.
+//The prefix is the one of the physical database of db, see SwapDB.
+func (tidis *Tidis) Namespace(tenant *Tenant, db int) []byte {
+	db = tidis.physical(tenant, db)
+	if tenant == nil {
+		return utils.EncodeDBKey(db, nil)
+	}
+	return utils.EncodeTenantKey([]byte(tenant.Name), db, nil)
+}
+
+//Key the key of key in the database db of tenant
+func (tidis *Tidis) Key(tenant *Tenant, db int, key []byte) []byte {
+	db = tidis.physical(tenant, db)
+	if tenant == nil {
+		return utils.EncodeDBKey(db, key)
+	}
+	return utils.EncodeTenantKey([]byte(tenant.Name), db, key)
+}
+
+//Prefix the prefix of the keys and channels of tenant, empty for no tenant
+func (tenant *Tenant) Prefix() []byte {
+	if tenant == nil {
+		return nil
+	}
+	return tenant.prefix
+}
+
+//tenantUsage the keys and bytes a transaction adds to a tenant, negative when it removes them
+type tenantUsage struct {
+	keys  int64
+	bytes int64
+}
+
+//countUsage add the change of key to the usage of its tenant in txn, value is the new value of key or nil once deleted.
+//The bytes are the lengths of the keys and values, the keys are the keys of the users, see isUserKey.
+func (txn *blockingTxn) countUsage(key kv.Key, value []byte) (err error) {
+	var (
+		prefix []byte
+		old    []byte
+		usage  *tenantUsage
+	)
+	prefix, _ = utils.SplitTenant(key)
+	if len(prefix) == 0 {
+		return
+	}
+	old, err = txn.Transaction.Get(key)
+	if kv.IsErrNotFound(err) {
+		old, err = nil, nil
+	}
+	if err != nil {
+		return
+	}
+	if txn.usage == nil {
+		txn.usage = make(map[string]*tenantUsage)
+	}
+	usage = txn.usage[string(prefix[2:])]
+	if usage == nil {
+		usage = new(tenantUsage)
+		txn.usage[string(prefix[2:])] = usage
+	}
+	if old != nil {
+		usage.bytes -= int64(len(key) + len(old))
+		if isUserKey(key, old) {
+			usage.keys--
+		}
+	}
+	if value != nil {
+		usage.bytes += int64(len(key) + len(value))
+		if isUserKey(key, value) {
+			usage.keys++
+		}
+	}
+	return
+}
+
+//isUserKey whether key holding value is a key of the users, not a member, an index entry or the ttl of a key
+func isUserKey(key, value []byte) bool {
+	var (
+		bare []byte
+	)
+	_, bare = utils.SplitDBKey(key)
+	if len(bare) == 0 || len(value) == 0 || isMemberPrefix(bare[0]) {
+		return false
+	}
+	//the ttl of a key is its expire time in ms
+	return !(bare[0] == utils.TTL_TYPE && len(value) == 8)
+}
+
+//updateUsage add the usage of the tenants changed by txn to their counters before txn commits. The write is refused with
+//ErrorQuotaExceeded when it adds keys or bytes over a quota of its tenant, 0 is no quota. The counters are split in
+//usageShards keys and a transaction updates one of them picked at random, so the writes of a tenant rarely conflict
+//on them; the transactions committing at the same time may go over a quota together by what they add.
+func (tidis *Tidis) updateUsage(txn kv.Transaction, usage map[string]*tenantUsage) (err error) {
+	var (
+		delta      *tenantUsage
+		keys, size int64
+	)
+	for _, tenant := range tidis.tenants {
+		delta = usage[tenant.Name]
+		if delta == nil || (delta.keys == 0 && delta.bytes == 0) {
+			continue
+		}
+		if (tenant.maxKeys > 0 && delta.keys > 0) || (tenant.maxBytes > 0 && delta.bytes > 0) {
+			keys, size, err = tenantUsageOf(txn, tenant)
+			if err != nil {
+				return
+			}
+			if (tenant.maxKeys > 0 && delta.keys > 0 && keys+delta.keys > tenant.maxKeys) ||
+				(tenant.maxBytes > 0 && delta.bytes > 0 && size+delta.bytes > tenant.maxBytes) {
+				return qkverror.ErrorQuotaExceeded
+			}
+		}
+		err = addUsage(txn, usageKey(tenant, rand.Intn(usageShards)), delta.keys, delta.bytes)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+//Usage the keys and bytes of tenant, the sum of its counters
+func (tidis *Tidis) Usage(tenant *Tenant) (keys, size int64, err error) {
+	err = tidis.RetryTxn(func(txn interface{}) (err error) {
+		keys, size, err = tenantUsageOf(txn.(kv.Transaction), tenant)
+		return
+	})
+	return
+}
+
+//QuotaRun repair the usage counters of the tenants in the background. They are updated by the writes, but the keys
+//removed by FLUSHDB and FLUSHALL are deleted outside of the transactions and the counters of a previous release may be
+//missing. The instance holding the quota lease walks the keys of each tenant and corrects its counters.
+func QuotaRun(tdb *Tidis, interval, lease int) {
+	var (
+		c       <-chan time.Time
+		err     error
+		timeout int64
+		leader  bool
+	)
+	if len(tdb.tenants) == 0 {
+		return
+	}
+	if interval <= 0 {
+		interval = defaultQuotaInterval
+	}
+	timeout = leaseTimeout(lease, interval)
+	c = time.Tick(time.Duration(interval) * time.Millisecond)
+	for _ = range c {
+		leader, err = tdb.AcquireLease(quotaLease, timeout)
+		if err != nil {
+			log.Warnf("quota acquire lease failed, %s", err.Error())
+		}
+		if !leader {
+			metrics.WorkerLeaderGauge.WithLabelValues("quota").Set(0)
+			continue
+		}
+		metrics.WorkerLeaderGauge.WithLabelValues("quota").Set(1)
+		for _, tenant := range tdb.tenants {
+			if err = tdb.repairUsage(tenant); err != nil {
+				log.Warnf("quota repair tenant %s usage failed, %s", tenant.Name, err.Error())
+			}
+		}
+	}
+}
+
+//repairUsage count the keys and bytes of tenant and correct its counters by the difference. The keys and the counters
+//are read in the snapshot of one transaction, which writes the correction to the repair counter, written by no
+//other transaction but the repairs: the transactions changing the tenant meanwhile update the other counters, and two
+//repairs at the same time conflict instead of correcting twice.
+func (tidis *Tidis) repairUsage(tenant *Tenant) (err error) {
+	var (
+		tikv_txn           kv.Transaction
+		keys, size         int64
+		counted, countedSz int64
+	)
+	tikv_txn, err = tidis.NewTxn()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tikv_txn.Rollback()
+		}
+	}()
+	keys, size, err = tidis.measureTenant(tikv_txn.GetSnapshot(), tenant)
+	if err != nil {
+		return
+	}
+	counted, countedSz, err = tenantUsageOf(tikv_txn, tenant)
+	if err != nil {
+		return
+	}
+	metrics.TenantKeysGauge.WithLabelValues(tenant.Name).Set(float64(keys))
+	metrics.TenantBytesGauge.WithLabelValues(tenant.Name).Set(float64(size))
+	if keys == counted && size == countedSz {
+		tikv_txn.Rollback()
+		return
+	}
+	log.Infof("quota tenant %s counted %d keys and %d bytes, measured %d keys and %d bytes", tenant.Name, counted, countedSz, keys, size)
+	err = addUsage(tikv_txn, usageKey(tenant, usageShards), keys-counted, size-countedSz)
+	if err != nil {
+		return
+	}
+	return tikv_txn.Commit(context.Background())
+}
+
+//measureTenant count the user keys of tenant and the bytes of all its keys and values in snapshot, reading quotaBatch
+//keys at a time
+func (tidis *Tidis) measureTenant(snapshot kv.Snapshot, tenant *Tenant) (keys, size int64, err error) {
+	var (
+		it       kv.Iterator
+		key      kv.Key
+		start    = tenant.prefix
+		end      = utils.PrefixEnd(tenant.prefix)
+		examined int
+		done     bool
+	)
+	for !done {
+		it, err = snapshot.Seek(start)
+		if err != nil {
+			return
+		}
+		done = true
+		for examined = 0; it.Valid(); examined++ {
+			key = it.Key()
+			if bytes.Compare(key, end) >= 0 {
+				break
+			}
+			if examined == quotaBatch {
+				done = false
+				break
+			}
+			start = key.Next()
+			size += int64(len(key) + len(it.Value()))
+			if isUserKey(key, it.Value()) {
+				keys++
+			}
+			if err = it.Next(); err != nil {
+				break
+			}
+		}
+		it.Close()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+//tenantUsageOf the sum of the counters of tenant, the repair counter included
+func tenantUsageOf(txn kv.Transaction, tenant *Tenant) (keys, size int64, err error) {
+	var (
+		it     kv.Iterator
+		prefix = usageKey(tenant, -1)
+		k, s   int64
+	)
+	it, err = txn.Seek(prefix)
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for it.Valid() && it.Key().HasPrefix(prefix) {
+		k, s, err = decodeUsage(it.Value())
+		if err != nil {
+			return
+		}
+		keys, size = keys+k, size+s
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+//addUsage add keys and size to the counter at key
+func addUsage(txn kv.Transaction, key []byte, keys, size int64) (err error) {
+	var (
+		raw  []byte
+		k, s int64
+	)
+	raw, err = txn.Get(key)
+	if kv.IsErrNotFound(err) {
+		raw, err = nil, nil
+	}
+	if err != nil {
+		return
+	}
+	if raw != nil {
+		k, s, err = decodeUsage(raw)
+		if err != nil {
+			return
+		}
+	}
+	return txn.Set(key, encodeUsage(k+keys, s+size))
+}
+
+//usageKey the sys key of the counter shard of tenant, shard usageShards is the counter of the repairs and a negative
+//shard the prefix of all the counters
+func usageKey(tenant *Tenant, shard int) []byte {
+	key := append(append([]byte("usage:"), tenant.Name...), ':')
+	if shard >= 0 {
+		key = append(key, byte(shard))
+	}
+	return utils.EncodeSysKey(key)
+}
+
+//encodeUsage type(sys)|keys(8)|bytes(8), a counter may be negative
+func encodeUsage(keys, size int64) []byte {
+	buf := make([]byte, 17)
+	buf[0] = utils.SYS_TYPE
+	utils.Uint64ToBytesExt(buf[1:], uint64(keys))
+	utils.Uint64ToBytesExt(buf[9:], uint64(size))
+	return buf
+}
+func decodeUsage(raw []byte) (keys, size int64, err error) {
+	var (
+		k, s uint64
+	)
+	if len(raw) != 17 || raw[0] != utils.SYS_TYPE {
+		err = qkverror.ErrorInvalidRawData
+		return
+	}
+	if k, err = utils.BytesToUint64(raw[1:9]); err != nil {
+		return
+	}
+	if s, err = utils.BytesToUint64(raw[9:]); err != nil {
+		return
+	}
+	return int64(k), int64(s), nil
+}
@@ -0,0 +1,110 @@
+package tidis
+
+import (
+	"testing"
+
+	"github.com/chuangyou/qkv/config"
+	"github.com/chuangyou/qkv/qkverror"
+	"github.com/pingcap/tidb/kv"
+)
+
+//checkUsage compare the counters of tenant with a measure of its keys
+func checkUsage(t *testing.T, tdb *Tidis, tenant *Tenant) (keys, size int64) {
+	t.Helper()
+	keys, size, err := tdb.Usage(tenant)
+	if err != nil {
+		t.Fatalf("usage: %v", err)
+	}
+	txn, err := tdb.NewTxn()
+	if err != nil {
+		t.Fatalf("new txn: %v", err)
+	}
+	defer txn.Rollback()
+	measuredKeys, measuredSize, err := tdb.measureTenant(txn.GetSnapshot(), tenant)
+	if err != nil {
+		t.Fatalf("measure: %v", err)
+	}
+	if keys != measuredKeys || size != measuredSize {
+		t.Fatalf("counted %d keys and %d bytes, measured %d keys and %d bytes", keys, size, measuredKeys, measuredSize)
+	}
+	return
+}
+
+func TestTenantUsage(t *testing.T) {
+	tdb := newTestTidis(t, func(conf *config.Config) {
+		conf.Tenants = []config.TenantConfig{{Name: "t", Password: "pw", MaxKeys: 4}}
+	})
+	tenant := tdb.Tenants()[0]
+	key := func(k string) []byte {
+		return tdb.Key(tenant, 0, []byte(k))
+	}
+	if err := tdb.Set(nil, key("s"), []byte("v")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if _, err := tdb.HSet(nil, key("h"), []byte("f"), []byte("v")); err != nil {
+		t.Fatalf("hset: %v", err)
+	}
+	if _, err := tdb.SAdd(nil, key("set"), []byte("a"), []byte("b"), []byte("c")); err != nil {
+		t.Fatalf("sadd: %v", err)
+	}
+	if _, err := tdb.Expire(nil, key("s"), 100); err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	if _, err := tdb.SRem(nil, key("set"), []byte("b")); err != nil {
+		t.Fatalf("srem: %v", err)
+	}
+	//the members, the rank index and the ttl are bytes of the tenant but not keys
+	if keys, _ := checkUsage(t, tdb, tenant); keys != 3 {
+		t.Fatalf("keys: %d", keys)
+	}
+
+	//the transaction going over the quota is refused as a whole
+	err := tdb.RetryTxn(func(txn interface{}) error {
+		if _, err := tdb.Delete(txn, [][]byte{key("h")}); err != nil {
+			return err
+		}
+		for _, k := range []string{"a", "b", "c"} {
+			if err := tdb.Set(txn, key(k), []byte("1")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != qkverror.ErrorQuotaExceeded {
+		t.Fatalf("write over the quota: %v", err)
+	}
+	if v := mustGet(t, tdb, key("h")); v == nil {
+		t.Fatalf("key deleted by the refused transaction")
+	}
+	if err = tdb.Set(nil, key("a"), []byte("1")); err != nil {
+		t.Fatalf("set up to the quota: %v", err)
+	}
+	if err = tdb.Set(nil, key("b"), []byte("2")); err != qkverror.ErrorQuotaExceeded {
+		t.Fatalf("set over the quota: %v", err)
+	}
+	//a write of an existing key adds no key
+	if err = tdb.Set(nil, key("a"), []byte("10")); err != nil {
+		t.Fatalf("overwrite: %v", err)
+	}
+	checkUsage(t, tdb, tenant)
+
+	//the repair corrects drifted counters, in the repair counter only
+	err = tdb.RetryTxn(func(txn interface{}) error {
+		return addUsage(txn.(kv.Transaction), usageKey(tenant, 0), 5, -7)
+	})
+	if err != nil {
+		t.Fatalf("drift: %v", err)
+	}
+	if err = tdb.repairUsage(tenant); err != nil {
+		t.Fatalf("repair: %v", err)
+	}
+	checkUsage(t, tdb, tenant)
+
+	//the keys deleted by FLUSHALL are not counted
+	if err = tdb.FlushAll(tenant); err != nil {
+		t.Fatalf("flushall: %v", err)
+	}
+	if keys, size := checkUsage(t, tdb, tenant); keys != 0 || size != 0 {
+		t.Fatalf("usage after flushall: %d keys %d bytes", keys, size)
+	}
+}
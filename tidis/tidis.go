@@ -8,8 +8,19 @@ import (
 )
 
 type Tidis struct {
-	conf *config.Config
-	db   store.DB
+	conf             *config.Config
+	db               store.DB
+	retryLimit       int
+	retryBaseBackoff int
+	retryMaxBackoff  int
+	instanceID       []byte
+	waiters          *keyWaiters
+	pubsub           *pubsubState
+	notifyFlags      int
+	databases        int
+	tenants          []*Tenant
+	aclUsers         *aclUsers
+	dbMaps           *dbMaps
 }
 
 func NewTidis(conf *config.Config) (*Tidis, error) {
@@ -19,7 +30,42 @@ func NewTidis(conf *config.Config) (*Tidis, error) {
 		return nil, err
 	}
 	tidis.conf = conf
+	tidis.notifyFlags, err = parseNotifyFlags(conf.QKV.NotifyKeyspaceEvents)
+	if err != nil {
+		return nil, err
+	}
 	tidis.db = db
+	tidis.retryLimit = conf.QKV.TxnRetryLimit
+	if tidis.retryLimit <= 0 {
+		tidis.retryLimit = defaultTxnRetryLimit
+	}
+	tidis.retryBaseBackoff = conf.QKV.TxnRetryBackoff
+	if tidis.retryBaseBackoff <= 0 {
+		tidis.retryBaseBackoff = defaultTxnRetryBackoff
+	}
+	tidis.retryMaxBackoff = conf.QKV.TxnRetryMaxBackoff
+	if tidis.retryMaxBackoff <= 0 {
+		tidis.retryMaxBackoff = defaultTxnRetryMaxBackoff
+	}
+	//the backoff starts at the base, a smaller max would be ignored
+	if tidis.retryMaxBackoff < tidis.retryBaseBackoff {
+		tidis.retryMaxBackoff = tidis.retryBaseBackoff
+	}
+	tidis.databases = conf.QKV.Databases
+	if tidis.databases <= 0 {
+		tidis.databases = defaultDatabases
+	} else if tidis.databases > maxDatabases {
+		tidis.databases = maxDatabases
+	}
+	tidis.tenants, err = newTenants(conf.Tenants, conf.QKV.Auth)
+	if err != nil {
+		return nil, err
+	}
+	tidis.instanceID = newInstanceID(conf.QKV.Address)
+	tidis.waiters = newKeyWaiters()
+	tidis.pubsub = newPubSubState()
+	tidis.aclUsers = newACLUsers()
+	tidis.dbMaps = newDBMaps()
 	return tidis, nil
 }
 func (tidis *Tidis) NewTxn() (tikvTxn kv.Transaction, err error) {
@@ -33,5 +79,6 @@ func (tidis *Tidis) NewTxn() (tikvTxn kv.Transaction, err error) {
 		err = qkverror.ErrorServerInternal
 		return
 	}
+	tikvTxn = &blockingTxn{Transaction: tikvTxn, tidis: tidis}
 	return
 }
@@ -0,0 +1,54 @@
+package tidis
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/chuangyou/qkv/config"
+)
+
+//newTestTidis a tidis over the in-process mocktikv store, setup may change the config
+func newTestTidis(t *testing.T, setup func(conf *config.Config)) *Tidis {
+	conf := &config.Config{
+		QKV:  config.QKVConfig{Databases: 16},
+		Tikv: config.TikvConfig{Pds: "mocktikv://"},
+	}
+	if setup != nil {
+		setup(conf)
+	}
+	tdb, err := NewTidis(conf)
+	if err != nil {
+		t.Fatalf("new tidis: %v", err)
+	}
+	return tdb
+}
+
+//countPrefix the number of keys starting with prefix
+func countPrefix(t *testing.T, tdb *Tidis, prefix []byte) (n int) {
+	txn, err := tdb.NewTxn()
+	if err != nil {
+		t.Fatalf("new txn: %v", err)
+	}
+	defer txn.Rollback()
+	it, err := txn.Seek(prefix)
+	if err != nil {
+		t.Fatalf("seek: %v", err)
+	}
+	defer it.Close()
+	for it.Valid() && bytes.HasPrefix(it.Key(), prefix) {
+		n++
+		if err = it.Next(); err != nil {
+			t.Fatalf("next: %v", err)
+		}
+	}
+	return
+}
+
+//mustGet the value of key, nil if there is none
+func mustGet(t *testing.T, tdb *Tidis, key []byte) []byte {
+	v, err := tdb.db.Get(nil, key)
+	if err != nil {
+		t.Fatalf("get %q: %v", key, err)
+	}
+	return v
+}
@@ -1,46 +1,154 @@
 package tidis
 
 import (
+	"bytes"
 	"context"
 	"math"
 	"time"
 
+	"github.com/chuangyou/qkv/metrics"
 	ti "github.com/chuangyou/qkv/store/tikv"
 	"github.com/chuangyou/qkv/utils"
 	"github.com/pingcap/tidb/kv"
 	log "github.com/sirupsen/logrus"
 )
 
-func TTLCheckerRun(tdb *Tidis, maxLoops, interval int) {
+const (
+	//maxExpireBacklog expired keys counted for the backlog metric
+	maxExpireBacklog = 10000
+)
+
+var (
+	ttlCheckerLease = []byte("ttl_checker")
+	//expireIndexMigrated set once the expire keys of db 0 written by the previous releases are moved to the expire index
+	expireIndexMigrated = []byte("expire_index_migrated")
+)
+
+//TTLCheckerRun remove expired keys in the background. Only the instance holding the ttl checker lease
+//removes keys, it keeps removing batches of maxLoops keys while it holds the lease.
+func TTLCheckerRun(tdb *Tidis, maxLoops, interval, lease int) {
 	var (
-		c        <-chan time.Time
-		startKey []byte
-		endKey   []byte
-		err      error
-		ret      int
-		tikv_txn kv.Transaction
+		c         <-chan time.Time
+		startKey  []byte
+		endKey    []byte
+		err       error
+		ret       int
+		tikv_txn  kv.Transaction
+		timeout   int64
+		leader    bool
+		startTime time.Time
+		backlog   int
+		lag       int64
+		more      bool
+		migrated  bool
 	)
+	timeout = leaseTimeout(lease, interval)
 	c = time.Tick(time.Duration(interval) * time.Millisecond)
 	for _ = range c {
-		startKey = utils.EncodeExpireKey([]byte{0}, 0)
-		endKey = utils.EncodeExpireKey([]byte{0}, math.MaxInt64)
-		tikv_txn, err = tdb.NewTxn()
+		leader, err = tdb.AcquireLease(ttlCheckerLease, timeout)
 		if err != nil {
-			log.Warnf("ttl checker start transation failed, %s", err.Error())
+			log.Warnf("ttl checker acquire lease failed, %s", err.Error())
+		}
+		if !leader {
+			metrics.WorkerLeaderGauge.WithLabelValues("ttl_checker").Set(0)
 			continue
 		}
-		ret, err = delExpireKey(tdb, tikv_txn, startKey, endKey, maxLoops)
-		if err != nil {
-			log.Warnf("string ttl checker decode key failed, %s", err.Error())
-		} else {
-			if ret == -1 {
-				//log.Debugf("string ttl checker execute none")
-			} else {
+		metrics.WorkerLeaderGauge.WithLabelValues("ttl_checker").Set(1)
+		startTime = time.Now()
+		if !migrated {
+			if err = tdb.migrateExpireIndex(); err != nil {
+				log.Warnf("ttl checker migrate the expire index failed, %s", err.Error())
+				continue
+			}
+			migrated = true
+		}
+		//the expire keys of all the databases of all the tenants are in one index ordered by time, so the oldest
+		//ones are removed first whatever their database
+		startKey = utils.EncodeExpireKey(nil, 0)
+		endKey = utils.EncodeExpireKey(nil, math.MaxInt64)
+		more = true
+		//leave half of the lease to renew it
+		for more && time.Since(startTime) < time.Duration(timeout/2)*time.Millisecond {
+			more = false
+			tikv_txn, err = tdb.NewTxn()
+			if err != nil {
+				log.Warnf("ttl checker start transation failed, %s", err.Error())
+				break
+			}
+			ret, err = delExpireKey(tdb, tikv_txn, startKey, endKey, maxLoops)
+			if err != nil {
+				log.Warnf("string ttl checker decode key failed, %s", err.Error())
+				break
+			}
+			if ret > 0 {
 				log.Debugf("string ttl checker execute %d keys", ret)
+				metrics.ExpiredKeysCounter.Add(float64(ret))
+				more = ret == maxLoops
+			}
+			//expired hash fields
+			tikv_txn, err = tdb.NewTxn()
+			if err != nil {
+				log.Warnf("ttl checker start transation failed, %s", err.Error())
+				break
+			}
+			ret, err = delExpireHashFields(tdb, tikv_txn, maxLoops)
+			if err != nil {
+				log.Warnf("hash field ttl checker failed, %s", err.Error())
+				break
+			}
+			if ret > 0 {
+				log.Debugf("hash field ttl checker execute %d fields", ret)
+				more = more || ret == maxLoops
 			}
 		}
+		backlog, lag, err = tdb.expireBacklog()
+		if err != nil {
+			log.Warnf("ttl checker count backlog failed, %s", err.Error())
+			continue
+		}
+		metrics.ExpireBacklogGauge.Set(float64(backlog))
+		metrics.ExpireLagGauge.Set(float64(lag) / 1000)
 	}
 }
+
+//expireBacklog expired keys of all the databases of all the tenants not removed yet, counted up to maxExpireBacklog,
+//and the age of the oldest one in ms
+func (tidis *Tidis) expireBacklog() (backlog int, lag int64, err error) {
+	var (
+		tikv_txn kv.Transaction
+		it       *ti.Iterator
+		now      uint64
+		ts       uint64
+	)
+	tikv_txn, err = tidis.NewTxn()
+	if err != nil {
+		return
+	}
+	defer tikv_txn.Rollback()
+	now = uint64(time.Now().UnixNano() / 1000 / 1000)
+	it, err = ti.NewIterator(utils.EncodeExpireKey(nil, 0), utils.EncodeExpireKey(nil, int64(now)), tikv_txn.GetSnapshot(), false)
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	for backlog < maxExpireBacklog && it.Valid() {
+		_, ts, err = utils.DecodeExpireKey(it.Key())
+		if err != nil {
+			return
+		}
+		if ts > now {
+			break
+		}
+		if backlog == 0 {
+			lag = int64(now - ts)
+		}
+		backlog++
+		if err = it.Next(); err != nil {
+			return
+		}
+	}
+	return
+}
 func delExpireKey(tdb *Tidis, tikv_txn kv.Transaction, startKey, endKey []byte, maxLoops int) (ret int, err error) {
 	var (
 		loops    int
@@ -49,6 +157,7 @@ func delExpireKey(tdb *Tidis, tikv_txn kv.Transaction, startKey, endKey []byte,
 		key      []byte
 		ts       uint64
 		ttlKey   []byte
+		rawTTL   []byte
 		rawData  []byte
 		dataType byte
 	)
@@ -74,6 +183,18 @@ func delExpireKey(tdb *Tidis, tikv_txn kv.Transaction, startKey, endKey []byte,
 		if err = tikv_txn.Delete(it.Key()); err != nil {
 			return
 		}
+		//the entry left by a flushed database or a key whose ttl was changed, the key is not expired
+		rawTTL, err = tdb.db.Get(tikv_txn, ttlKey)
+		if err != nil {
+			return
+		}
+		if rawTTL == nil || !bytes.Equal(rawTTL, it.Key()[2:10]) {
+			if err = it.Next(); err != nil {
+				return
+			}
+			loops--
+			continue
+		}
 		//delete ttl key
 		if err = tikv_txn.Delete(ttlKey); err != nil {
 			return
@@ -88,36 +209,118 @@ func delExpireKey(tdb *Tidis, tikv_txn kv.Transaction, startKey, endKey []byte,
 				return
 			}
 		}
-		switch dataType {
-		//delete set member
-		case utils.SET_TYPE:
-			if _, err = tdb.ClearSetMembers(tikv_txn, key); err != nil {
+		if rawData != nil {
+			//members of a collection are purged by the background gc
+			if err = tdb.clearMembers(tikv_txn, key, dataType); err != nil {
 				return
 			}
-		//delete zset member
-		case utils.ZSET_TYPE:
-			if _, err = tdb.ZRemRangeByScore(tikv_txn, key, utils.SCORE_MIN, utils.SCORE_MAX); err != nil {
+		}
+
+		//delete key
+		if err = tikv_txn.Delete(key); err != nil {
+			return
+		}
+		if rawData != nil {
+			if err = tdb.notify(tikv_txn, notifyExpired, "expired", key); err != nil {
 				return
 			}
-		//delete hash
-		case utils.HASH_TYPE:
-			if _, err = tdb.ClearHash(tikv_txn, key); err != nil {
+		}
+		if err = it.Next(); err != nil {
+			return
+		}
+		loops--
+		log.Debug(loops)
+	}
+	err = tikv_txn.Commit(context.Background())
+	if maxLoops == loops {
+		//no action
+		ret = -1
+	} else {
+		ret = maxLoops - loops
+	}
+	return
+}
+
+//delExpireHashFields remove at most maxLoops hash fields whose expire time has passed. An index entry left by
+//a hash that was deleted or whose field ttl was changed does not match the meta or the field ttl, it is just dropped.
+func delExpireHashFields(tdb *Tidis, tikv_txn kv.Transaction, maxLoops int) (ret int, err error) {
+	var (
+		loops    int
+		it       *ti.Iterator
+		key      []byte
+		field    []byte
+		version  uint64
+		ts       uint64
+		hsize    uint64
+		ttl      uint64
+		current  uint64
+		raw      []byte
+		dataType byte
+		expireAt uint64
+	)
+	defer tikv_txn.Rollback()
+	it, err = ti.NewIterator(utils.EncodeSystemPrefix(utils.HASH_FIELD_EXPTIME), utils.EncodeSystemPrefix(utils.HASH_FIELD_EXPTIME+1), tikv_txn.GetSnapshot(), false)
+	if err != nil {
+		return
+	}
+	defer it.Close()
+	loops = maxLoops
+	for loops > 0 && it.Valid() {
+		key, version, field, ts, err = utils.DecodeHashFieldExpire(it.Key())
+		if err != nil {
+			return
+		}
+		if ts > uint64(time.Now().UnixNano()/1000/1000) {
+			// no field expired
+			break
+		}
+		if err = tikv_txn.Delete(it.Key()); err != nil {
+			return
+		}
+		loops--
+		dataType, err = tdb.getType(tikv_txn, key)
+		if err != nil {
+			return
+		}
+		if dataType == utils.HASH_TYPE {
+			hsize, ttl, _, current, err = tdb.getHashMetaWithType(tikv_txn, key)
+			if err != nil {
 				return
 			}
-		//delete list member
-		case utils.LIST_TYPE:
-			if _, err = tdb.ClearListMembers(tikv_txn, key); err != nil {
+			raw, err = tdb.db.Get(tikv_txn, utils.EncodeHashFieldTTL(key, version, field))
+			if err != nil {
 				return
 			}
+			if raw != nil {
+				expireAt, err = utils.BytesToUint64(raw)
+				if err != nil {
+					return
+				}
+			}
+			if hsize > 0 && current == version && raw != nil && expireAt == ts {
+				if err = tikv_txn.Delete(utils.EncodeHashFieldTTL(key, version, field)); err != nil {
+					return
+				}
+				if err = tikv_txn.Delete(utils.EncodeHashData(key, version, field)); err != nil {
+					return
+				}
+				hsize--
+				if err = tdb.updateHashSize(tikv_txn, key, hsize, ttl, version); err != nil {
+					return
+				}
+				if err = tdb.notify(tikv_txn, notifyHash, "hexpired", key); err != nil {
+					return
+				}
+				if hsize == 0 {
+					if err = tdb.notify(tikv_txn, notifyGeneric, "del", key); err != nil {
+						return
+					}
+				}
+			}
 		}
-
-		//delete key
-		if err = tikv_txn.Delete(key); err != nil {
+		if err = it.Next(); err != nil {
 			return
 		}
-		it.Next()
-		loops--
-		log.Debug(loops)
 	}
 	err = tikv_txn.Commit(context.Background())
 	if maxLoops == loops {
@@ -128,3 +331,47 @@ func delExpireKey(tdb *Tidis, tikv_txn kv.Transaction, startKey, endKey []byte,
 	}
 	return
 }
+
+//migrateExpireIndex move the expire keys of db 0 written by the previous releases, type(exptime)|timestamp(8 bytes)|key,
+//to the expire index. It runs until it finds none, then it is not run again.
+func (tidis *Tidis) migrateExpireIndex() (err error) {
+	var (
+		raw []byte
+	)
+	raw, err = tidis.db.Get(nil, utils.EncodeSysKey(expireIndexMigrated))
+	if err != nil || raw != nil {
+		return
+	}
+	//the keys of db 0 are stored bare, only the entries whose ttl key matches are moved, the user keys
+	//sharing their first byte are kept
+	err = tidis.moveRange([]byte{utils.EXPTIME_TYPE}, []byte{utils.EXPTIME_TYPE + 1}, func(txn kv.Transaction, key, value []byte) ([]byte, error) {
+		return tidis.legacyExpireEntry(txn, key, value)
+	})
+	if err != nil {
+		return
+	}
+	return tidis.RetryTxn(func(txn interface{}) error {
+		return txn.(kv.Transaction).Set(utils.EncodeSysKey(expireIndexMigrated), []byte{1})
+	})
+}
+
+//legacyExpireEntry the entry of the expire index for an expire key of db 0 written by the previous releases,
+//nil if key is a user key
+func (tidis *Tidis) legacyExpireEntry(txn interface{}, key, value []byte) (entry []byte, err error) {
+	var (
+		ts  uint64
+		raw []byte
+	)
+	if len(key) < 9 || key[0] != utils.EXPTIME_TYPE || len(value) != 1 || value[0] != 0 {
+		return
+	}
+	raw, err = tidis.db.Get(txn, utils.EncodeTTLKey(key[9:]))
+	if err != nil || !bytes.Equal(raw, key[1:9]) {
+		return
+	}
+	ts, err = utils.BytesToUint64(key[1:9])
+	if err != nil {
+		return
+	}
+	return utils.EncodeExpireKey(key[9:], int64(ts)), nil
+}
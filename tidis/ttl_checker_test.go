@@ -0,0 +1,121 @@
+package tidis
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/chuangyou/qkv/utils"
+	"github.com/pingcap/tidb/kv"
+)
+
+//expireAll run the ttl checker over the whole expire index once
+func expireAll(t *testing.T, tdb *Tidis) {
+	txn, err := tdb.NewTxn()
+	if err != nil {
+		t.Fatalf("new txn: %v", err)
+	}
+	if _, err = delExpireKey(tdb, txn, utils.EncodeExpireKey(nil, 0), utils.EncodeExpireKey(nil, math.MaxInt64), 100); err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+}
+
+func TestExpireIndex(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	keys := [][]byte{[]byte("a"), utils.EncodeDBKey(1, []byte("b")), utils.EncodeDBKey(2, []byte("c"))}
+	for i, key := range keys {
+		if err := tdb.Set(nil, key, []byte("v")); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+		ms := int64(1)
+		if i == 2 {
+			ms = 3600 * 1000
+		}
+		if _, err := tdb.PExpire(nil, key, ms); err != nil {
+			t.Fatalf("pexpire: %v", err)
+		}
+	}
+	//one index for all the databases
+	if n := countPrefix(t, tdb, utils.EncodeSystemPrefix(utils.EXPTIME_TYPE)); n != 3 {
+		t.Fatalf("expire index entries: %d", n)
+	}
+	time.Sleep(5 * time.Millisecond)
+	expireAll(t, tdb)
+	for i, key := range keys {
+		if v := mustGet(t, tdb, key); (v != nil) != (i == 2) {
+			t.Fatalf("%q after the ttl checker: %q", key, v)
+		}
+	}
+	if n := countPrefix(t, tdb, utils.EncodeSystemPrefix(utils.EXPTIME_TYPE)); n != 1 {
+		t.Fatalf("expire index entries after the ttl checker: %d", n)
+	}
+}
+
+//the entry left by a flushed database does not expire the key written again without a ttl
+func TestExpireIndexStaleEntry(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	key := utils.EncodeDBKey(2, []byte("c"))
+	if err := tdb.Set(nil, key, []byte("v")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if _, err := tdb.PExpire(nil, key, 1); err != nil {
+		t.Fatalf("pexpire: %v", err)
+	}
+	if err := tdb.FlushDB(utils.EncodeDBKey(2, nil)); err != nil {
+		t.Fatalf("flushdb: %v", err)
+	}
+	if err := tdb.Set(nil, key, []byte("v2")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	expireAll(t, tdb)
+	if v := mustGet(t, tdb, key); v == nil {
+		t.Fatalf("key expired by a stale entry")
+	}
+	if n := countPrefix(t, tdb, utils.EncodeSystemPrefix(utils.EXPTIME_TYPE)); n != 0 {
+		t.Fatalf("stale entries: %d", n)
+	}
+}
+
+func TestMigrateExpireIndex(t *testing.T) {
+	tdb := newTestTidis(t, nil)
+	ts := uint64(time.Now().UnixNano()/int64(time.Millisecond)) + 3600*1000
+	tsRaw, _ := utils.Uint64ToBytes(ts)
+	userKey := append([]byte{utils.EXPTIME_TYPE}, []byte("12345678:user")...)
+	//a key of db 0 with a ttl written by a previous release
+	err := tdb.RetryTxn(func(txn interface{}) error {
+		tikv_txn := txn.(kv.Transaction)
+		if err := tikv_txn.Set([]byte("k"), utils.EncodeData(utils.STRING_TYPE, []byte("v"))); err != nil {
+			return err
+		}
+		if err := tikv_txn.Set(utils.EncodeTTLKey([]byte("k")), tsRaw); err != nil {
+			return err
+		}
+		return tikv_txn.Set(append([]byte{utils.EXPTIME_TYPE}, append(tsRaw, 'k')...), []byte{0})
+	})
+	if err != nil {
+		t.Fatalf("write legacy entries: %v", err)
+	}
+	//a user key of db 0 sharing the first byte of the legacy entries
+	if err = tdb.Set(nil, userKey, []byte("v")); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if err = tdb.migrateExpireIndex(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if n := countPrefix(t, tdb, utils.EncodeSystemPrefix(utils.EXPTIME_TYPE)); n != 1 {
+		t.Fatalf("expire index entries: %d", n)
+	}
+	if n := countPrefix(t, tdb, []byte{utils.EXPTIME_TYPE}); n != 1 {
+		t.Fatalf("keys left in the legacy range: %d", n)
+	}
+	if v, err := tdb.Get(nil, userKey); err != nil || string(v) != "v" {
+		t.Fatalf("user key after the migration: %q, %v", v, err)
+	}
+	if ttl, err := tdb.PTTL(nil, []byte("k")); err != nil || ttl <= 0 {
+		t.Fatalf("pttl after the migration: %d, %v", ttl, err)
+	}
+	if mustGet(t, tdb, utils.EncodeSysKey(expireIndexMigrated)) == nil {
+		t.Fatalf("migration not recorded")
+	}
+}
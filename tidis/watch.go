@@ -0,0 +1,37 @@
+package tidis
+
+import (
+	"bytes"
+
+	"github.com/pingcap/tidb/kv"
+)
+
+//KeysModified reports whether the meta of any of keys was written after txn started. A write leaving the meta
+//unchanged is not seen here, it conflicts with the lock of the meta when txn commits, see notify.
+func (tidis *Tidis) KeysModified(txn kv.Transaction, keys [][]byte) (modified bool, err error) {
+	var (
+		snapshot kv.Snapshot
+		old      []byte
+		latest   []byte
+		key      []byte
+	)
+	snapshot = txn.GetSnapshot()
+	for _, key = range keys {
+		old, err = snapshot.Get(key)
+		if err != nil {
+			if !kv.IsErrNotFound(err) {
+				return
+			}
+			old, err = nil, nil
+		}
+		latest, err = tidis.db.Get(nil, key)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(old, latest) {
+			modified = true
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"math/big"
+
+	"github.com/chuangyou/qkv/qkverror"
+)
+
+//cursorMark keeps leading zero bytes of the key when it is turned into a number
+const cursorMark byte = 1
+
+//EncodeCursor encode the last seen key as a decimal cursor, clients that parse the cursor as an integer keep working.
+//A nil key is the "0" cursor which starts and ends an iteration.
+func EncodeCursor(key []byte) []byte {
+	var (
+		buf []byte
+		n   big.Int
+	)
+	if key == nil {
+		return []byte("0")
+	}
+	buf = make([]byte, len(key)+1)
+	buf[0] = cursorMark
+	copy(buf[1:], key)
+	n.SetBytes(buf)
+	return []byte(n.String())
+}
+
+//DecodeCursor decode a cursor returned by EncodeCursor, "0" returns a nil key
+func DecodeCursor(cursor []byte) (key []byte, err error) {
+	var (
+		n  big.Int
+		ok bool
+	)
+	if string(cursor) == "0" {
+		return
+	}
+	if _, ok = n.SetString(string(cursor), 10); !ok || n.Sign() <= 0 {
+		err = qkverror.ErrorInvalidCursor
+		return
+	}
+	key = n.Bytes()
+	if key[0] != cursorMark {
+		key = nil
+		err = qkverror.ErrorInvalidCursor
+		return
+	}
+	key = key[1:]
+	return
+}
@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"math"
+)
+
+//geohash of redis: 26 bits of longitude and 26 bits of latitude interleaved into a 52 bits zset score,
+//latitude on the even bits. Latitudes are limited to the range of the web mercator projection.
+const (
+	GEO_STEP_MAX  = 26
+	GEO_LAT_MIN   = -85.05112878
+	GEO_LAT_MAX   = 85.05112878
+	GEO_LONG_MIN  = -180.0
+	GEO_LONG_MAX  = 180.0
+	EARTH_RADIUS  = 6372797.560856
+	mercatorMax   = 20037726.37
+	geoCellsLimit = 9
+)
+
+//GeoValid check a longitude, latitude pair
+func GeoValid(longitude, latitude float64) bool {
+	return longitude >= GEO_LONG_MIN && longitude <= GEO_LONG_MAX && latitude >= GEO_LAT_MIN && latitude <= GEO_LAT_MAX
+}
+
+//GeoEncode the 52 bits geohash of a position
+func GeoEncode(longitude, latitude float64) uint64 {
+	var (
+		latOffset  float64
+		longOffset float64
+	)
+	latOffset = (latitude - GEO_LAT_MIN) / (GEO_LAT_MAX - GEO_LAT_MIN) * (1 << GEO_STEP_MAX)
+	longOffset = (longitude - GEO_LONG_MIN) / (GEO_LONG_MAX - GEO_LONG_MIN) * (1 << GEO_STEP_MAX)
+	//the maximum latitude and longitude belong to the last cell
+	latOffset = math.Min(latOffset, 1<<GEO_STEP_MAX-1)
+	longOffset = math.Min(longOffset, 1<<GEO_STEP_MAX-1)
+	return interleave64(uint32(latOffset), uint32(longOffset))
+}
+
+//GeoDecode the position at the center of the cell of a 52 bits geohash
+func GeoDecode(hash uint64) (longitude, latitude float64) {
+	var (
+		ilat, ilong uint32
+		scale       float64 = 1 << GEO_STEP_MAX
+	)
+	ilat, ilong = deinterleave64(hash)
+	latitude = GEO_LAT_MIN + (float64(ilat)+0.5)/scale*(GEO_LAT_MAX-GEO_LAT_MIN)
+	longitude = GEO_LONG_MIN + (float64(ilong)+0.5)/scale*(GEO_LONG_MAX-GEO_LONG_MIN)
+	longitude = math.Max(GEO_LONG_MIN, math.Min(GEO_LONG_MAX, longitude))
+	latitude = math.Max(GEO_LAT_MIN, math.Min(GEO_LAT_MAX, latitude))
+	return
+}
+
+//GeoDistance the distance in meters between two positions by the haversine formula
+func GeoDistance(long1, lat1, long2, lat2 float64) float64 {
+	var (
+		u, v float64
+		a    float64
+	)
+	lat1, long1 = degRad(lat1), degRad(long1)
+	lat2, long2 = degRad(lat2), degRad(long2)
+	u = math.Sin((lat2 - lat1) / 2)
+	v = math.Sin((long2 - long1) / 2)
+	a = u*u + math.Cos(lat1)*math.Cos(lat2)*v*v
+	return 2 * EARTH_RADIUS * math.Asin(math.Sqrt(a))
+}
+
+//GeoInBox check that a position is inside the box of width and height meters centered at the given position,
+//returns the distance to the center
+func GeoInBox(width, height, centerLong, centerLat, longitude, latitude float64) (dist float64, ok bool) {
+	//the latitude distance is cheaper, check it first
+	if EARTH_RADIUS*math.Abs(degRad(latitude)-degRad(centerLat)) > height/2 {
+		return
+	}
+	if GeoDistance(longitude, latitude, centerLong, latitude) > width/2 {
+		return
+	}
+	return GeoDistance(centerLong, centerLat, longitude, latitude), true
+}
+
+//GeoRanges the geohash ranges [min, max) of the cells covering the area of width and height meters
+//centered at the given position, using the largest cells that cover it with at most 9 cells.
+func GeoRanges(longitude, latitude, width, height float64) (ranges [][2]uint64) {
+	var (
+		step                uint
+		latDelta, longDelta float64
+		latMin, latMax      float64
+		longMin, longMax    float64
+		latFrom, latTo      int64
+		longFrom, longTo    int64
+		cells               int64
+		shift               uint
+		ilong               uint32
+		hash                uint64
+	)
+	latDelta = radDeg(height / 2 / EARTH_RADIUS)
+	latMin = math.Max(GEO_LAT_MIN, latitude-latDelta)
+	latMax = math.Min(GEO_LAT_MAX, latitude+latDelta)
+	//longitude degrees are the shortest on the side nearer to a pole
+	longDelta = radDeg(width / 2 / EARTH_RADIUS / math.Cos(degRad(math.Max(math.Abs(latMin), math.Abs(latMax)))))
+	longMin = longitude - longDelta
+	longMax = longitude + longDelta
+	if longDelta >= 180 || math.IsNaN(longDelta) {
+		longMin, longMax = GEO_LONG_MIN, GEO_LONG_MAX
+	}
+	for step = geoEstimateStep(math.Max(width, height)/2, latitude); ; step-- {
+		cells = int64(1) << step
+		latFrom = geoClamp(geoCellIndex(latMin, GEO_LAT_MIN, GEO_LAT_MAX, cells), cells)
+		latTo = geoClamp(geoCellIndex(latMax, GEO_LAT_MIN, GEO_LAT_MAX, cells), cells)
+		longFrom = geoCellIndex(longMin, GEO_LONG_MIN, GEO_LONG_MAX, cells)
+		longTo = geoCellIndex(longMax, GEO_LONG_MIN, GEO_LONG_MAX, cells)
+		if longTo-longFrom+1 > cells {
+			longFrom, longTo = 0, cells-1
+		}
+		if step == 0 || (latTo-latFrom+1)*(longTo-longFrom+1) <= geoCellsLimit {
+			break
+		}
+	}
+	shift = 2 * (GEO_STEP_MAX - step)
+	for ilat := latFrom; ilat <= latTo; ilat++ {
+		for i := longFrom; i <= longTo; i++ {
+			//wrap around the antimeridian
+			ilong = uint32((i%cells + cells) % cells)
+			hash = interleave64(uint32(ilat), ilong) << shift
+			ranges = append(ranges, [2]uint64{hash, hash + 1<<shift})
+		}
+	}
+	return
+}
+
+//geoEstimateStep the precision of cells about the size of radius, the same estimation as redis
+func geoEstimateStep(radius, latitude float64) uint {
+	var (
+		step = 1
+	)
+	if radius == 0 {
+		return GEO_STEP_MAX
+	}
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	//make sure the range is included in most of the base cases
+	step -= 2
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > GEO_STEP_MAX {
+		step = GEO_STEP_MAX
+	}
+	return uint(step)
+}
+
+//geoCellIndex index of the cell holding value out of cells cells between min and max, not wrapped
+func geoCellIndex(value, min, max float64, cells int64) int64 {
+	return int64(math.Floor((value - min) / (max - min) * float64(cells)))
+}
+
+//geoClamp keep a latitude cell index inside the grid
+func geoClamp(index, cells int64) int64 {
+	if index < 0 {
+		return 0
+	}
+	if index >= cells {
+		return cells - 1
+	}
+	return index
+}
+func degRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+func radDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+//interleave64 x on the even bits and y on the odd bits
+func interleave64(x, y uint32) uint64 {
+	return spread32(x) | spread32(y)<<1
+}
+
+//deinterleave64 the even bits and the odd bits
+func deinterleave64(interleaved uint64) (x, y uint32) {
+	return squash64(interleaved), squash64(interleaved >> 1)
+}
+func spread32(v uint32) uint64 {
+	var (
+		x = uint64(v)
+	)
+	x = (x | x<<16) & 0x0000FFFF0000FFFF
+	x = (x | x<<8) & 0x00FF00FF00FF00FF
+	x = (x | x<<4) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+func squash64(x uint64) uint32 {
+	x &= 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0F0F0F0F0F0F0F0F
+	x = (x | x>>4) & 0x00FF00FF00FF00FF
+	x = (x | x>>8) & 0x0000FFFF0000FFFF
+	x = (x | x>>16) & 0x00000000FFFFFFFF
+	return uint32(x)
+}
@@ -0,0 +1,335 @@
+package utils
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/chuangyou/qkv/qkverror"
+)
+
+//HyperLogLog in the string format of redis: a 16 bytes header "HYLL", the encoding, 3 unused bytes
+//and the cached cardinality (little endian, the highest bit set when it is stale), followed by the
+//registers in the dense or sparse encoding.
+const (
+	HLL_P                 = 14
+	HLL_Q                 = 64 - HLL_P
+	HLL_REGISTERS         = 1 << HLL_P
+	HLL_P_MASK            = HLL_REGISTERS - 1
+	HLL_BITS              = 6
+	HLL_REGISTER_MAX      = 1<<HLL_BITS - 1
+	HLL_HDR_SIZE          = 16
+	HLL_DENSE_SIZE        = HLL_HDR_SIZE + (HLL_REGISTERS*HLL_BITS+7)/8
+	HLL_DENSE        byte = 0
+	HLL_SPARSE       byte = 1
+	//HLL_SPARSE_MAX_BYTES the default hll-sparse-max-bytes of redis
+	HLL_SPARSE_MAX_BYTES = 3000
+
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+	hllSparseZeroMaxLen  = 64
+	hllSparseXZeroMaxLen = 16384
+	hllAlphaInf          = 0.721347520444481703680
+)
+
+//HLLRegisters the registers of a HyperLogLog
+type HLLRegisters [HLL_REGISTERS]uint8
+
+//NewHLL an empty HyperLogLog in the sparse encoding
+func NewHLL() []byte {
+	var (
+		regs HLLRegisters
+	)
+	value, _ := EncodeHLL(&regs, HLL_SPARSE, 0, false)
+	return value
+}
+
+//IsHLL check the header of a HyperLogLog string value
+func IsHLL(value []byte) bool {
+	if len(value) < HLL_HDR_SIZE || string(value[:4]) != "HYLL" {
+		return false
+	}
+	switch value[4] {
+	case HLL_DENSE:
+		return len(value) == HLL_DENSE_SIZE
+	case HLL_SPARSE:
+		return true
+	}
+	return false
+}
+
+//HLLCachedCard the cached cardinality of a HyperLogLog, ok is false when it is stale
+func HLLCachedCard(value []byte) (card uint64, ok bool) {
+	if value[HLL_HDR_SIZE-1]&(1<<7) != 0 {
+		return
+	}
+	return binary.LittleEndian.Uint64(value[8:HLL_HDR_SIZE]), true
+}
+
+//SetHLLCachedCard store the cardinality in the header of a HyperLogLog
+func SetHLLCachedCard(value []byte, card uint64) {
+	binary.LittleEndian.PutUint64(value[8:HLL_HDR_SIZE], card)
+}
+
+//DecodeHLL merge the registers of a HyperLogLog into regs, keeping the greatest value of each register
+func DecodeHLL(value []byte, regs *HLLRegisters) (encoding byte, err error) {
+	var (
+		idx      int
+		runLen   int
+		runValue uint8
+		b        byte
+	)
+	if !IsHLL(value) {
+		err = qkverror.ErrorInvalidHLL
+		return
+	}
+	encoding = value[4]
+	if encoding == HLL_DENSE {
+		for i := 0; i < HLL_REGISTERS; i++ {
+			if r := hllDenseGet(value[HLL_HDR_SIZE:], i); r > regs[i] {
+				regs[i] = r
+			}
+		}
+		return
+	}
+	for p := HLL_HDR_SIZE; p < len(value); p++ {
+		b = value[p]
+		runValue = 0
+		switch {
+		case b&0xc0 == 0:
+			//ZERO 00xxxxxx
+			runLen = int(b&0x3f) + 1
+		case b&0xc0 == 0x40:
+			//XZERO 01xxxxxx yyyyyyyy
+			if p+1 >= len(value) {
+				err = qkverror.ErrorCorruptedHLL
+				return
+			}
+			runLen = (int(b&0x3f)<<8 | int(value[p+1])) + 1
+			p++
+		default:
+			//VAL 1vvvvvxx
+			runValue = (b>>2)&0x1f + 1
+			runLen = int(b&0x3) + 1
+		}
+		if idx+runLen > HLL_REGISTERS {
+			err = qkverror.ErrorCorruptedHLL
+			return
+		}
+		if runValue != 0 {
+			for i := idx; i < idx+runLen; i++ {
+				if runValue > regs[i] {
+					regs[i] = runValue
+				}
+			}
+		}
+		idx += runLen
+	}
+	if idx != HLL_REGISTERS {
+		err = qkverror.ErrorCorruptedHLL
+	}
+	return
+}
+
+//EncodeHLL encode the registers with the cardinality card cached, or marked stale.
+//The sparse encoding falls back to dense when a register is too large or it is longer than HLL_SPARSE_MAX_BYTES.
+func EncodeHLL(regs *HLLRegisters, encoding byte, card uint64, stale bool) (value []byte, dense bool) {
+	if encoding == HLL_SPARSE {
+		value = hllSparseEncode(regs)
+	}
+	if value == nil {
+		value = make([]byte, HLL_DENSE_SIZE)
+		for i := 0; i < HLL_REGISTERS; i++ {
+			hllDenseSet(value[HLL_HDR_SIZE:], i, regs[i])
+		}
+		value[4] = HLL_DENSE
+		dense = true
+	} else {
+		value[4] = HLL_SPARSE
+	}
+	copy(value, "HYLL")
+	SetHLLCachedCard(value, card)
+	if stale {
+		value[HLL_HDR_SIZE-1] |= 1 << 7
+	}
+	return
+}
+
+//HLLAdd add an element to the registers, returns true if a register changed
+func HLLAdd(regs *HLLRegisters, element []byte) bool {
+	var (
+		hash  uint64
+		index uint64
+		count uint8 = 1
+	)
+	hash = murmurHash64A(element, 0xadc83b19)
+	index = hash & HLL_P_MASK
+	hash >>= HLL_P
+	//make sure the loop terminates
+	hash |= 1 << HLL_Q
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	if count > regs[index] {
+		regs[index] = count
+		return true
+	}
+	return false
+}
+
+//HLLCount the estimated cardinality of the registers, the same estimator as redis
+func HLLCount(regs *HLLRegisters) uint64 {
+	var (
+		m     = float64(HLL_REGISTERS)
+		histo [64]int
+		z     float64
+	)
+	for _, r := range regs {
+		histo[r]++
+	}
+	z = m * hllTau((m-float64(histo[HLL_Q+1]))/m)
+	for j := HLL_Q; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return uint64(math.Round(hllAlphaInf * m * m / z))
+}
+
+//hllSparseEncode the registers in the sparse encoding, nil if they do not fit in it
+func hllSparseEncode(regs *HLLRegisters) (value []byte) {
+	var (
+		runLen int
+		n      int
+	)
+	value = make([]byte, HLL_HDR_SIZE, HLL_HDR_SIZE+64)
+	for i := 0; i < HLL_REGISTERS; i += runLen {
+		runLen = 1
+		for i+runLen < HLL_REGISTERS && regs[i+runLen] == regs[i] {
+			runLen++
+		}
+		if regs[i] > hllSparseValMaxValue {
+			return nil
+		}
+		for left := runLen; left > 0; left -= n {
+			switch {
+			case regs[i] != 0:
+				n = left
+				if n > hllSparseValMaxLen {
+					n = hllSparseValMaxLen
+				}
+				value = append(value, 0x80|(regs[i]-1)<<2|byte(n-1))
+			case left > hllSparseZeroMaxLen:
+				n = left
+				if n > hllSparseXZeroMaxLen {
+					n = hllSparseXZeroMaxLen
+				}
+				value = append(value, 0x40|byte((n-1)>>8), byte(n-1))
+			default:
+				n = left
+				value = append(value, byte(n-1))
+			}
+		}
+		if len(value)-HLL_HDR_SIZE > HLL_SPARSE_MAX_BYTES {
+			return nil
+		}
+	}
+	return
+}
+
+//hllDenseGet the register at index of the dense registers, 6 bits each, least significant bits first
+func hllDenseGet(p []byte, index int) uint8 {
+	var (
+		b  = index * HLL_BITS / 8
+		fb = uint(index * HLL_BITS & 7)
+		b1 byte
+	)
+	if b+1 < len(p) {
+		b1 = p[b+1]
+	}
+	return (p[b]>>fb | b1<<(8-fb)) & HLL_REGISTER_MAX
+}
+func hllDenseSet(p []byte, index int, value uint8) {
+	var (
+		b  = index * HLL_BITS / 8
+		fb = uint(index * HLL_BITS & 7)
+	)
+	p[b] &^= HLL_REGISTER_MAX << fb
+	p[b] |= value << fb
+	if b+1 < len(p) {
+		p[b+1] &^= HLL_REGISTER_MAX >> (8 - fb)
+		p[b+1] |= value >> (8 - fb)
+	}
+}
+
+//hllSigma and hllTau of the estimator by Otmar Ertl used by redis
+func hllSigma(x float64) float64 {
+	var (
+		y      = 1.0
+		z      = x
+		zPrime float64
+	)
+	if x == 1 {
+		return math.Inf(1)
+	}
+	for {
+		x *= x
+		zPrime = z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+func hllTau(x float64) float64 {
+	var (
+		y      = 1.0
+		z      = 1 - x
+		zPrime float64
+	)
+	if x == 0 || x == 1 {
+		return 0
+	}
+	for {
+		x = math.Sqrt(x)
+		zPrime = z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+//murmurHash64A the hash of the HyperLogLog of redis, blocks are read little endian
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const (
+		m = 0xc6a4a7935bd1e995
+		r = 47
+	)
+	var (
+		h    = seed ^ uint64(len(key))*m
+		k    uint64
+		tail []byte
+	)
+	for len(key) >= 8 {
+		k = binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		key = key[8:]
+	}
+	tail = key
+	if len(tail) > 0 {
+		for i := len(tail) - 1; i >= 0; i-- {
+			h ^= uint64(tail[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -7,8 +7,8 @@ import (
 )
 
 var (
-	SCORE_MIN int64 = math.MinInt64 + 2
-	SCORE_MAX int64 = math.MaxInt64 - 1
+	SCORE_MIN float64 = math.Inf(-1)
+	SCORE_MAX float64 = math.Inf(1)
 )
 
 func EncodeData(dataType byte, rawData []byte) (buf []byte) {
@@ -28,45 +28,255 @@ func DecodeData(rawData []byte) (dataType byte, data []byte, err error) {
 	return
 }
 
+//IsReservedKey whether a key of db 0 starts like the internal keys, the keys of the other databases or of the tenants
+func IsReservedKey(key []byte) bool {
+	return len(key) > 0 && key[0] >= SYSTEM_PREFIX && key[0] < NONE_TYPE
+}
+
+//EncodeSystemPrefix type(system)|type, the prefix of the internal keys of type
+func EncodeSystemPrefix(dataType byte) []byte {
+	return []byte{SYSTEM_PREFIX, dataType}
+}
+
+//EncodeDBKey type(db)|db(2)|key, the key of key in the database db. The keys of db 0 are stored bare,
+//the encoded keys of the other databases are all prefixed by the namespace of their database.
+func EncodeDBKey(db int, key []byte) []byte {
+	var (
+		buf []byte
+	)
+	if db == 0 {
+		return key
+	}
+	buf = make([]byte, 3+len(key))
+	buf[0] = DB_PREFIX
+	Uint16ToBytesExt(buf[1:], uint16(db))
+	copy(buf[3:], key)
+	return buf
+}
+
+//EncodeTenantPrefix type(tenant)|len(1)|tenant, the prefix of the keys and channels of tenant
+func EncodeTenantPrefix(tenant []byte) []byte {
+	buf := make([]byte, len(tenant)+2)
+	buf[0] = TENANT_PREFIX
+	buf[1] = byte(len(tenant))
+	copy(buf[2:], tenant)
+	return buf
+}
+
+//EncodeTenantKey type(tenant)|len(1)|tenant|db(2)|key, the key of key in the database db of tenant.
+//The keys of no tenant are the keys of EncodeDBKey.
+func EncodeTenantKey(tenant []byte, db int, key []byte) []byte {
+	var (
+		prefix []byte
+		buf    []byte
+	)
+	if len(tenant) == 0 {
+		return EncodeDBKey(db, key)
+	}
+	prefix = EncodeTenantPrefix(tenant)
+	buf = make([]byte, len(prefix)+2+len(key))
+	copy(buf, prefix)
+	Uint16ToBytesExt(buf[len(prefix):], uint16(db))
+	copy(buf[len(prefix)+2:], key)
+	return buf
+}
+
+//SplitTenant the tenant prefix of a key or a channel, empty when it has none, and the rest of it
+func SplitTenant(key []byte) (prefix, rest []byte) {
+	if len(key) >= 2 && key[0] == TENANT_PREFIX && len(key) >= int(key[1])+2 {
+		return key[:int(key[1])+2], key[int(key[1])+2:]
+	}
+	return nil, key
+}
+
+//DecodeDBKey the database and the user key of a key encoded by EncodeDBKey or EncodeTenantKey
+func DecodeDBKey(key []byte) (db int, userKey []byte) {
+	var (
+		ns []byte
+	)
+	ns, userKey = SplitDBKey(key)
+	if len(ns) > 0 {
+		db = int(uint16(ns[len(ns)-2])<<8 | uint16(ns[len(ns)-1]))
+	}
+	return
+}
+
+//SplitDBKey the namespace of the database of key, with the tenant prefix, empty for db 0 of no tenant, and the key without it
+func SplitDBKey(key []byte) (ns, rest []byte) {
+	var (
+		prefix []byte
+	)
+	if len(key) >= 3 && key[0] == DB_PREFIX {
+		return key[:3], key[3:]
+	}
+	prefix, rest = SplitTenant(key)
+	if len(prefix) > 0 && len(rest) >= 2 {
+		return key[:len(prefix)+2], key[len(prefix)+2:]
+	}
+	return nil, key
+}
+
+//PrefixEnd the first key greater than all the keys starting with prefix, nil if there is none
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		end[i]++
+		if end[i] != 0 {
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+//withDB prefix buf, a key encoded for a bare user key, by the namespace ns of its database
+func withDB(ns, buf []byte) []byte {
+	var (
+		full []byte
+	)
+	if len(ns) == 0 {
+		return buf
+	}
+	full = make([]byte, len(ns)+len(buf))
+	copy(full, ns)
+	copy(full[len(ns):], buf)
+	return full
+}
+
 // type(ttl)|key, value is unix timestamp(ms)
 func EncodeTTLKey(key []byte) []byte {
+	ns, key := SplitDBKey(key)
 	buf := make([]byte, len(key)+1)
 	buf[0] = TTL_TYPE
 	copy(buf[1:], key)
-	return buf
+	return withDB(ns, buf)
 }
 
-// type(ttl)|timestamp(8 bytes)|key
+// type(system)|type(exptime)|timestamp(8 bytes)|key, the expire index of all the databases and tenants,
+// key is prefixed by the namespace of its database
 func EncodeExpireKey(key []byte, ts int64) []byte {
-	var (
-		tsRaw []byte
-	)
-	buf := make([]byte, len(key)+9)
-	buf[0] = EXPTIME_TYPE
-	tsRaw, _ = Uint64ToBytes(uint64(ts))
-	copy(buf[1:], tsRaw)
-	copy(buf[9:], key)
+	buf := make([]byte, len(key)+10)
+	buf[0] = SYSTEM_PREFIX
+	buf[1] = EXPTIME_TYPE
+	Uint64ToBytesExt(buf[2:], uint64(ts))
+	copy(buf[10:], key)
 	return buf
 }
+
+//DecodeExpireKey the key, with the namespace of its database, and the timestamp of an expire key
 func DecodeExpireKey(key []byte) ([]byte, uint64, error) {
-	if len(key) < 9 || key[0] != EXPTIME_TYPE {
+	if len(key) < 10 || key[0] != SYSTEM_PREFIX || key[1] != EXPTIME_TYPE {
 		return nil, 0, qkverror.ErrorTypeNotMatch
 	}
 
-	ts, err := BytesToUint64(key[1:])
+	ts, err := BytesToUint64(key[2:])
 	if err != nil {
 		return nil, 0, err
 	}
 
-	return key[9:], ts, nil
+	return key[10:], ts, nil
+}
+
+//EncodeMemberKey key|version(8), the key part of the member keys of a versioned collection.
+//Collections written before versioned meta have version 0 and keep the bare key.
+//The key returned by the member key decoders is this encoded key, without the namespace of its database.
+func EncodeMemberKey(key []byte, version uint64) []byte {
+	var (
+		buf []byte
+	)
+	if version == 0 {
+		return key
+	}
+	buf = make([]byte, len(key)+8)
+	copy(buf, key)
+	Uint64ToBytesExt(buf[len(key):], version)
+	return buf
+}
+
+// type(system)|type(gc)|type|version(8)|key, member keys of the deleted collection waiting to be purged
+func EncodeGCKey(dataType byte, version uint64, key []byte) []byte {
+	buf := make([]byte, len(key)+11)
+	buf[0] = SYSTEM_PREFIX
+	buf[1] = GC_TYPE
+	buf[2] = dataType
+	Uint64ToBytesExt(buf[3:], version)
+	copy(buf[11:], key)
+	return buf
+}
+func DecodeGCKey(rawkey []byte) (dataType byte, version uint64, key []byte, err error) {
+	if len(rawkey) < 11 || rawkey[0] != SYSTEM_PREFIX || rawkey[1] != GC_TYPE {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	dataType = rawkey[2]
+	version, err = BytesToUint64(rawkey[3:])
+	if err != nil {
+		return
+	}
+	key = rawkey[11:]
+	return
+}
+
+// type(system)|type(sys)|name, internal keys such as the leases of the background workers
+func EncodeSysKey(name []byte) []byte {
+	buf := make([]byte, len(name)+2)
+	buf[0] = SYSTEM_PREFIX
+	buf[1] = SYS_TYPE
+	copy(buf[2:], name)
+	return buf
+}
+
+// type(system)|type(pubsub)|ts(8)|seq(8), a message of the pub/sub log
+func EncodePubSubKey(ts, seq uint64) []byte {
+	buf := make([]byte, 18)
+	buf[0] = SYSTEM_PREFIX
+	buf[1] = PUBSUB_TYPE
+	Uint64ToBytesExt(buf[2:], ts)
+	Uint64ToBytesExt(buf[10:], seq)
+	return buf
+}
+func DecodePubSubKey(rawkey []byte) (ts uint64, err error) {
+	if len(rawkey) != 18 || rawkey[0] != SYSTEM_PREFIX || rawkey[1] != PUBSUB_TYPE {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	return BytesToUint64(rawkey[2:])
+}
+
+// type(pubsub)|len(channel)(2)|channel|message
+func EncodePubSubValue(channel, message []byte) []byte {
+	buf := make([]byte, 3+len(channel)+len(message))
+	buf[0] = PUBSUB_TYPE
+	Uint16ToBytesExt(buf[1:], uint16(len(channel)))
+	copy(buf[3:], channel)
+	copy(buf[3+len(channel):], message)
+	return buf
+}
+func DecodePubSubValue(value []byte) (channel, message []byte, err error) {
+	var (
+		channelLen uint16
+	)
+	if len(value) < 3 || value[0] != PUBSUB_TYPE {
+		err = qkverror.ErrorInvalidRawData
+		return
+	}
+	channelLen, _ = BytesToUint16(value[1:])
+	if len(value) < 3+int(channelLen) {
+		err = qkverror.ErrorInvalidRawData
+		return
+	}
+	channel = value[3 : 3+channelLen]
+	message = value[3+channelLen:]
+	return
 }
 
 //type(set)|len(key)|key|member
-func EncodeSetData(key, member []byte) (buf []byte) {
+func EncodeSetData(key []byte, version uint64, member []byte) (buf []byte) {
 	var (
 		bufSize int
 		pos     int = 0
 	)
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
 	bufSize = 1 + 2 + len(key) + len(member)
 	buf = make([]byte, bufSize)
 	buf[0] = SET_DATA //SET_DATA 1 byte
@@ -79,7 +289,7 @@ func EncodeSetData(key, member []byte) (buf []byte) {
 	pos = pos + len(key)
 	copy(buf[pos:], member)
 
-	return
+	return withDB(ns, buf)
 }
 
 //type(set)|len(key)|key|member
@@ -88,6 +298,7 @@ func DecodeSetData(rawkey []byte) (key []byte, field []byte, err error) {
 		pos       uint16 = 0
 		keyLength uint16
 	)
+	_, rawkey = SplitDBKey(rawkey)
 	if rawkey[0] != SET_DATA {
 		err = qkverror.ErrorTypeNotMatch
 		return
@@ -105,10 +316,12 @@ func DecodeSetData(rawkey []byte) (key []byte, field []byte, err error) {
 }
 
 // type|len(key)|key|len(member)|member
-func EncodeZSetData(key, member []byte) (buf []byte) {
+func EncodeZSetData(key []byte, version uint64, member []byte) (buf []byte) {
 	var (
 		pos int = 0
 	)
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
 
 	buf = make([]byte, 1+4+len(key)+len(member))
 	buf[pos] = ZSET_DATA
@@ -125,7 +338,7 @@ func EncodeZSetData(key, member []byte) (buf []byte) {
 
 	copy(buf[pos:], member)
 
-	return
+	return withDB(ns, buf)
 }
 
 // type|len(key)|key|len(member)|member
@@ -135,6 +348,7 @@ func DecodeZSetData(rawKey []byte) (key []byte, member []byte, err error) {
 		keyLen    uint16
 		memberLen uint16
 	)
+	_, rawKey = SplitDBKey(rawKey)
 
 	if rawKey[pos] != ZSET_DATA {
 		err = qkverror.ErrorTypeNotMatch
@@ -157,10 +371,12 @@ func DecodeZSetData(rawKey []byte) (key []byte, member []byte, err error) {
 }
 
 // type|len(key)|key|score|member
-func EncodeZSetScore(key, member []byte, score int64) (buf []byte) {
+func EncodeZSetScore(key []byte, version uint64, member []byte, score float64) (buf []byte) {
 	var (
 		pos int = 0
 	)
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
 
 	buf = make([]byte, 1+2+len(key)+8+len(member))
 	buf[pos] = ZSET_SCORE
@@ -177,16 +393,17 @@ func EncodeZSetScore(key, member []byte, score int64) (buf []byte) {
 
 	copy(buf[pos:], member)
 
-	return
+	return withDB(ns, buf)
 }
 
 // type|len(key)|key|score|member
-func DecodeZSetScore(rawkey []byte) (key []byte, member []byte, score int64, err error) {
+func DecodeZSetScore(rawkey []byte) (key []byte, member []byte, score float64, err error) {
 	var (
 		pos       int = 0
 		keyLen    uint16
 		tempScore uint64
 	)
+	_, rawkey = SplitDBKey(rawkey)
 
 	if rawkey[pos] != ZSET_SCORE {
 		err = qkverror.ErrorTypeNotMatch
@@ -208,10 +425,23 @@ func DecodeZSetScore(rawkey []byte) (key []byte, member []byte, score int64, err
 
 	return
 }
-func EncodeZSetDataEnd(key []byte) (buf []byte) {
+
+// type|len(key)|key, prefix of all the member keys of key
+func EncodeZSetDataPrefix(key []byte, version uint64) (buf []byte) {
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
+	buf = make([]byte, 1+2+len(key))
+	buf[0] = ZSET_DATA
+	Uint16ToBytesExt(buf[1:], uint16(len(key)))
+	copy(buf[3:], key)
+	return withDB(ns, buf)
+}
+func EncodeZSetDataEnd(key []byte, version uint64) (buf []byte) {
 	var (
 		pos int = 0
 	)
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
 
 	buf = make([]byte, 1+4+len(key))
 	buf[pos] = ZSET_DATA
@@ -226,20 +456,51 @@ func EncodeZSetDataEnd(key []byte) (buf []byte) {
 	Uint16ToBytesExt(buf[pos:], uint16(a))
 	pos = pos + 2
 
-	return buf
+	return withDB(ns, buf)
 }
-func ZScoreOffset(score int64) uint64 {
-	return uint64(score + SCORE_MAX)
+
+// type|len(key)|key|score, prefix of the score keys of score, without member
+func EncodeZSetScorePrefix(key []byte, version uint64, offset uint64) (buf []byte) {
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
+	buf = make([]byte, 1+2+len(key)+8)
+	buf[0] = ZSET_SCORE
+	Uint16ToBytesExt(buf[1:], uint16(len(key)))
+	copy(buf[3:], key)
+	Uint64ToBytesExt(buf[3+len(key):], offset)
+	return withDB(ns, buf)
 }
-func ZScoreRestore(rscore uint64) int64 {
-	return int64(rscore - uint64(SCORE_MAX))
+
+//ZScoreOffset order-preserving encoding of a float64 score,
+//the sign bit of positive scores is flipped and all the bits of negative scores are flipped.
+func ZScoreOffset(score float64) uint64 {
+	var (
+		bits uint64
+	)
+	if score == 0 {
+		//-0 and +0 are the same score
+		score = 0
+	}
+	bits = math.Float64bits(score)
+	if bits&(1<<63) == 0 {
+		return bits | 1<<63
+	}
+	return ^bits
+}
+func ZScoreRestore(rscore uint64) float64 {
+	if rscore&(1<<63) != 0 {
+		return math.Float64frombits(rscore &^ (1 << 63))
+	}
+	return math.Float64frombits(^rscore)
 }
 
 // type(1)|keylen(2)|key|field
-func EncodeHashData(key, field []byte) (buf []byte) {
+func EncodeHashData(key []byte, version uint64, field []byte) (buf []byte) {
 	var (
 		pos = 0
 	)
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
 	buf = make([]byte, 1+2+len(key)+len(field))
 	buf[0] = HASH_DATA
 	pos++
@@ -248,7 +509,7 @@ func EncodeHashData(key, field []byte) (buf []byte) {
 	copy(buf[pos:], key)
 	pos = pos + len(key)
 	copy(buf[pos:], field)
-	return buf
+	return withDB(ns, buf)
 }
 
 // type(1)|keylen(2)|key|field
@@ -257,6 +518,7 @@ func DecodeHashData(rawkey []byte) (key []byte, field []byte, err error) {
 		pos    uint16 = 0
 		keyLen uint16
 	)
+	_, rawkey = SplitDBKey(rawkey)
 
 	if rawkey[0] != HASH_DATA {
 		err = qkverror.ErrorTypeNotMatch
@@ -271,11 +533,77 @@ func DecodeHashData(rawkey []byte) (key []byte, field []byte, err error) {
 	return
 }
 
+//EncodeHashFieldTTL type(hash field ttl)|keyLen(2)|key|field, value is the unix timestamp(ms) the field expires at
+func EncodeHashFieldTTL(key []byte, version uint64, field []byte) (buf []byte) {
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
+	buf = make([]byte, 1+2+len(key)+len(field))
+	buf[0] = HASH_FIELD_TTL
+	Uint16ToBytesExt(buf[1:], uint16(len(key)))
+	copy(buf[3:], key)
+	copy(buf[3+len(key):], field)
+	return withDB(ns, buf)
+}
+func DecodeHashFieldTTL(rawkey []byte) (key []byte, field []byte, err error) {
+	var (
+		keyLen uint16
+	)
+	_, rawkey = SplitDBKey(rawkey)
+	if len(rawkey) < 3 || rawkey[0] != HASH_FIELD_TTL {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	keyLen, _ = BytesToUint16(rawkey[1:])
+	if len(rawkey) < 3+int(keyLen) {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	key = rawkey[3 : 3+keyLen]
+	field = rawkey[3+keyLen:]
+	return
+}
+
+//EncodeHashFieldExpire type(system)|type(hash field exptime)|timestamp(8)|version(8)|keyLen(2)|key|field,
+//the hash field ttls of all the databases and tenants ordered by time for the ttl checker, key is prefixed by
+//the namespace of its database
+func EncodeHashFieldExpire(key []byte, version uint64, field []byte, ts int64) (buf []byte) {
+	buf = make([]byte, 2+8+8+2+len(key)+len(field))
+	buf[0] = SYSTEM_PREFIX
+	buf[1] = HASH_FIELD_EXPTIME
+	Uint64ToBytesExt(buf[2:], uint64(ts))
+	Uint64ToBytesExt(buf[10:], version)
+	Uint16ToBytesExt(buf[18:], uint16(len(key)))
+	copy(buf[20:], key)
+	copy(buf[20+len(key):], field)
+	return
+}
+func DecodeHashFieldExpire(rawkey []byte) (key []byte, version uint64, field []byte, ts uint64, err error) {
+	var (
+		keyLen uint16
+	)
+	if len(rawkey) < 20 || rawkey[0] != SYSTEM_PREFIX || rawkey[1] != HASH_FIELD_EXPTIME {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	ts, _ = BytesToUint64(rawkey[2:])
+	version, _ = BytesToUint64(rawkey[10:])
+	keyLen, _ = BytesToUint16(rawkey[18:])
+	if len(rawkey) < 20+int(keyLen) {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	key = rawkey[20 : 20+keyLen]
+	field = rawkey[20+keyLen:]
+	return
+}
+
 // type(1)|keylen(2)|key|index(8)
-func EncodeListData(key []byte, idx uint64) (buf []byte) {
+func EncodeListData(key []byte, version uint64, idx uint64) (buf []byte) {
 	var (
 		pos int
 	)
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
 	buf = make([]byte, len(key)+1+2+8)
 	buf[pos] = LIST_DATA
 	pos++
@@ -284,7 +612,7 @@ func EncodeListData(key []byte, idx uint64) (buf []byte) {
 	copy(buf[pos:], key)
 	pos = pos + len(key)
 	Uint64ToBytesExt(buf[pos:], idx)
-	return
+	return withDB(ns, buf)
 }
 
 // type(1)|keylen(2)|key|index(8)
@@ -293,6 +621,7 @@ func DecodeListData(rawkey []byte) (key []byte, idx uint64, err error) {
 		pos    int
 		keyLen uint16
 	)
+	_, rawkey = SplitDBKey(rawkey)
 	if rawkey[0] != LIST_DATA {
 		err = qkverror.ErrorTypeNotMatch
 		return
@@ -305,3 +634,204 @@ func DecodeListData(rawkey []byte) (key []byte, idx uint64, err error) {
 	idx, _ = BytesToUint64(rawkey[pos:])
 	return
 }
+
+// type(1)|keylen(2)|key|ms(8)|seq(8), entries are ordered by id
+func EncodeStreamData(key []byte, version uint64, ms, seq uint64) (buf []byte) {
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
+	buf = make([]byte, 1+2+len(key)+16)
+	buf[0] = STREAM_DATA
+	Uint16ToBytesExt(buf[1:], uint16(len(key)))
+	copy(buf[3:], key)
+	Uint64ToBytesExt(buf[3+len(key):], ms)
+	Uint64ToBytesExt(buf[11+len(key):], seq)
+	return withDB(ns, buf)
+}
+
+// type(1)|keylen(2)|key|ms(8)|seq(8)
+func DecodeStreamData(rawkey []byte) (key []byte, ms, seq uint64, err error) {
+	var (
+		keyLen uint16
+	)
+	_, rawkey = SplitDBKey(rawkey)
+	if len(rawkey) < 19 || rawkey[0] != STREAM_DATA {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	keyLen, _ = BytesToUint16(rawkey[1:])
+	if len(rawkey) != 3+int(keyLen)+16 {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	key = rawkey[3 : 3+keyLen]
+	ms, _ = BytesToUint64(rawkey[3+keyLen:])
+	seq, _ = BytesToUint64(rawkey[11+keyLen:])
+	return
+}
+
+// len(field)(4)|field|len(value)(4)|value..., the fields and values of a stream entry
+func EncodeStreamFields(fields [][]byte) (buf []byte) {
+	var (
+		size int
+		pos  int
+	)
+	for _, field := range fields {
+		size = size + 4 + len(field)
+	}
+	buf = make([]byte, size)
+	for _, field := range fields {
+		Uint32ToBytesExt(buf[pos:], uint32(len(field)))
+		pos = pos + 4
+		copy(buf[pos:], field)
+		pos = pos + len(field)
+	}
+	return
+}
+func DecodeStreamFields(value []byte) (fields [][]byte, err error) {
+	var (
+		pos      int
+		fieldLen uint32
+	)
+	for pos < len(value) {
+		if len(value) < pos+4 {
+			err = qkverror.ErrorInvalidRawData
+			return
+		}
+		fieldLen, _ = BytesToUint32(value[pos:])
+		pos = pos + 4
+		if len(value) < pos+int(fieldLen) {
+			err = qkverror.ErrorInvalidRawData
+			return
+		}
+		fields = append(fields, value[pos:pos+int(fieldLen)])
+		pos = pos + int(fieldLen)
+	}
+	return
+}
+
+// type(1)|keylen(2)|key|group, value is the last delivered id
+func EncodeStreamGroup(key []byte, version uint64, group []byte) (buf []byte) {
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
+	buf = make([]byte, 1+2+len(key)+len(group))
+	buf[0] = STREAM_GROUP
+	Uint16ToBytesExt(buf[1:], uint16(len(key)))
+	copy(buf[3:], key)
+	copy(buf[3+len(key):], group)
+	return withDB(ns, buf)
+}
+
+// type(1)|keylen(2)|key|group
+func DecodeStreamGroup(rawkey []byte) (key []byte, group []byte, err error) {
+	var (
+		keyLen uint16
+	)
+	_, rawkey = SplitDBKey(rawkey)
+	if len(rawkey) < 3 || rawkey[0] != STREAM_GROUP {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	keyLen, _ = BytesToUint16(rawkey[1:])
+	key = rawkey[3 : 3+keyLen]
+	group = rawkey[3+keyLen:]
+	return
+}
+
+// type(1)|keylen(2)|key|len(group)(2)|group|ms(8)|seq(8), an entry delivered to a consumer of group and not acknowledged
+func EncodeStreamPEL(key []byte, version uint64, group []byte, ms, seq uint64) (buf []byte) {
+	var (
+		pos int
+	)
+	buf = EncodeStreamPELPrefix(key, version, group)
+	pos = len(buf)
+	buf = append(buf, make([]byte, 16)...)
+	Uint64ToBytesExt(buf[pos:], ms)
+	Uint64ToBytesExt(buf[pos+8:], seq)
+	return
+}
+
+// type(1)|keylen(2)|key|len(group)(2)|group|ms(8)|seq(8)
+func DecodeStreamPEL(rawkey []byte) (key []byte, group []byte, ms, seq uint64, err error) {
+	var (
+		pos      int
+		keyLen   uint16
+		groupLen uint16
+	)
+	_, rawkey = SplitDBKey(rawkey)
+	if len(rawkey) < 21 || rawkey[0] != STREAM_PEL {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	pos++
+	keyLen, _ = BytesToUint16(rawkey[pos:])
+	pos = pos + 2
+	key = rawkey[pos : pos+int(keyLen)]
+	pos = pos + int(keyLen)
+	groupLen, _ = BytesToUint16(rawkey[pos:])
+	pos = pos + 2
+	if len(rawkey) != pos+int(groupLen)+16 {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	group = rawkey[pos : pos+int(groupLen)]
+	pos = pos + int(groupLen)
+	ms, _ = BytesToUint64(rawkey[pos:])
+	seq, _ = BytesToUint64(rawkey[pos+8:])
+	return
+}
+
+// type(1)|keylen(2)|key|len(group)(2)|group, prefix of the pending entries of group
+func EncodeStreamPELPrefix(key []byte, version uint64, group []byte) (buf []byte) {
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
+	buf = make([]byte, 1+2+len(key)+2+len(group))
+	buf[0] = STREAM_PEL
+	Uint16ToBytesExt(buf[1:], uint16(len(key)))
+	copy(buf[3:], key)
+	Uint16ToBytesExt(buf[3+len(key):], uint16(len(group)))
+	copy(buf[5+len(key):], group)
+	return withDB(ns, buf)
+}
+
+// type(1)|keylen(2)|key|chunk(8), a fixed size chunk of a bitmap
+func EncodeBitmapData(key []byte, version uint64, chunk uint64) (buf []byte) {
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
+	buf = make([]byte, 1+2+len(key)+8)
+	buf[0] = BITMAP_DATA
+	Uint16ToBytesExt(buf[1:], uint16(len(key)))
+	copy(buf[3:], key)
+	Uint64ToBytesExt(buf[3+len(key):], chunk)
+	return withDB(ns, buf)
+}
+
+// type(1)|keylen(2)|key|chunk(8)
+func DecodeBitmapData(rawkey []byte) (key []byte, chunk uint64, err error) {
+	var (
+		keyLen uint16
+	)
+	_, rawkey = SplitDBKey(rawkey)
+	if len(rawkey) < 11 || rawkey[0] != BITMAP_DATA {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	keyLen, _ = BytesToUint16(rawkey[1:])
+	if len(rawkey) != 3+int(keyLen)+8 {
+		err = qkverror.ErrorTypeNotMatch
+		return
+	}
+	key = rawkey[3 : 3+keyLen]
+	chunk, _ = BytesToUint64(rawkey[3+keyLen:])
+	return
+}
+
+// type(1)|keylen(2)|key, prefix of all the member keys of a set, hash, list or zset (data or score) collection
+func EncodeMemberPrefix(dataType byte, key []byte, version uint64) (buf []byte) {
+	ns, key := SplitDBKey(key)
+	key = EncodeMemberKey(key, version)
+	buf = make([]byte, 1+2+len(key))
+	buf[0] = dataType
+	Uint16ToBytesExt(buf[1:], uint16(len(key)))
+	copy(buf[3:], key)
+	return withDB(ns, buf)
+}
@@ -0,0 +1,82 @@
+package utils
+
+//MatchPattern glob-style pattern match as redis does, supports *, ?, [abc], [^abc], [a-z] and \ escaping
+func MatchPattern(pattern, str []byte) bool {
+	var (
+		p, s int
+	)
+	for p < len(pattern) {
+		switch pattern[p] {
+		case '*':
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for ; s <= len(str); s++ {
+				if MatchPattern(pattern[p+1:], str[s:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s >= len(str) {
+				return false
+			}
+			s++
+		case '[':
+			var (
+				not   bool
+				match bool
+			)
+			if s >= len(str) {
+				return false
+			}
+			p++
+			if p < len(pattern) && pattern[p] == '^' {
+				not = true
+				p++
+			}
+			for p < len(pattern) && pattern[p] != ']' {
+				if pattern[p] == '\\' && p+1 < len(pattern) {
+					p++
+					if pattern[p] == str[s] {
+						match = true
+					}
+				} else if p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']' {
+					start, end := pattern[p], pattern[p+2]
+					if start > end {
+						start, end = end, start
+					}
+					if str[s] >= start && str[s] <= end {
+						match = true
+					}
+					p += 2
+				} else if pattern[p] == str[s] {
+					match = true
+				}
+				p++
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s++
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+		default:
+			if s >= len(str) || pattern[p] != str[s] {
+				return false
+			}
+			s++
+		}
+		p++
+	}
+	return s == len(str)
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"encoding/binary"
 	"errors"
+	"math"
 	"strconv"
 )
 
@@ -18,6 +19,43 @@ func StrBytesToInt64(n []byte) (i int64, err error) {
 	i, err = strconv.ParseInt(string(n), 10, 64)
 	return
 }
+
+//StrBytesToFloat64 parses a float, inf, +inf and -inf included, NaN is not a valid float.
+func StrBytesToFloat64(n []byte) (f float64, err error) {
+	if n == nil {
+		err = ErrParams
+		return
+	}
+	f, err = strconv.ParseFloat(string(n), 64)
+	if err == nil && math.IsNaN(f) {
+		err = ErrParams
+	}
+	return
+}
+
+//Float64ToStrBytes formats f the way redis replies scores
+func Float64ToStrBytes(f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return []byte("inf")
+	case math.IsInf(f, -1):
+		return []byte("-inf")
+	case f == 0 || (math.Abs(f) >= 1e-4 && math.Abs(f) < 1e21):
+		return strconv.AppendFloat(nil, f, 'f', -1, 64)
+	}
+	return strconv.AppendFloat(nil, f, 'g', -1, 64)
+}
+func BytesToFloat64(n []byte) (float64, error) {
+	if n == nil || len(n) < 8 {
+		return 0, ErrParams
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(n)), nil
+}
+func Float64ToBytes(f float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(f))
+	return b
+}
 func BytesToInt64(n []byte) (int64, error) {
 	if n == nil || len(n) < 8 {
 		return 0, ErrParams
@@ -74,3 +112,17 @@ func Uint16ToBytesExt(dst []byte, n uint16) error {
 	binary.BigEndian.PutUint16(dst, n)
 	return nil
 }
+func BytesToUint32(n []byte) (uint32, error) {
+	if n == nil || len(n) < 4 {
+		return 0, ErrParams
+	}
+
+	return binary.BigEndian.Uint32(n), nil
+}
+func Uint32ToBytesExt(dst []byte, n uint32) error {
+	if len(dst) < 4 {
+		return ErrParams
+	}
+	binary.BigEndian.PutUint32(dst, n)
+	return nil
+}
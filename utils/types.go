@@ -11,14 +11,48 @@ const (
 	HASH_DATA    byte = 7
 	LIST_TYPE    byte = 8
 	LIST_DATA    byte = 9
+	STREAM_TYPE  byte = 10
+	STREAM_DATA  byte = 11
+	STREAM_GROUP byte = 12
+	STREAM_PEL   byte = 13
+	BITMAP_TYPE  byte = 14
+	BITMAP_DATA  byte = 15
+	HLL_TYPE     byte = 16
+	//HASH_FIELD_TTL the expire time of a hash field, HASH_FIELD_EXPTIME the index of them by time
+	HASH_FIELD_TTL     byte = 17
+	HASH_FIELD_EXPTIME byte = 18
+	//MEMBER_RANK the rank index of the members of a sorted set or of a set
+	MEMBER_RANK  byte = 19
 	TTL_TYPE     byte = 109
 	EXPTIME_TYPE byte = 110
+	GC_TYPE      byte = 111
+	SYS_TYPE     byte = 112
+	PUBSUB_TYPE  byte = 113
+	//SYSTEM_PREFIX the first byte of the internal keys shared by all the databases and tenants, such as the gc keys,
+	//never the first byte of an utf-8 key
+	SYSTEM_PREFIX byte = 252
+	//TENANT_PREFIX the first byte of the keys of the tenants, never the first byte of an utf-8 key
+	TENANT_PREFIX byte = 253
+	//DB_PREFIX the first byte of the keys of the databases but 0, never the first byte of an utf-8 key
+	DB_PREFIX byte = 254
+	NONE_TYPE byte = 255
 )
 const (
 	FLAG_NORMAL byte = iota
 	FLAG_DELETED
 )
 
+//score encoding of a zset, kept in the zset meta
+const (
+	ZSCORE_INT64 byte = iota
+	ZSCORE_FLOAT64
+	//ZSCORE_FLOAT64_RANK float scores with the rank index of the members
+	ZSCORE_FLOAT64_RANK
+)
+
+//SET_MEMBER_RANK appended to the set meta once the set has the rank index of its members
+const SET_MEMBER_RANK byte = 1
+
 const (
 	LHeadDirection    uint8  = 0
 	LTailDirection    uint8  = 1
@@ -29,6 +63,27 @@ var (
 	EmptyListInterfaces []interface{} = make([]interface{}, 0)
 )
 
+//TypeName name of the data type as reported by TYPE
+func TypeName(dataType byte) string {
+	switch dataType {
+	case STRING_TYPE, BITMAP_TYPE, HLL_TYPE:
+		//a bitmap is a string stored in chunks, a HyperLogLog is a string in the format of redis
+		return "string"
+	case SET_TYPE:
+		return "set"
+	case ZSET_TYPE:
+		return "zset"
+	case HASH_TYPE:
+		return "hash"
+	case LIST_TYPE:
+		return "list"
+	case STREAM_TYPE:
+		return "stream"
+	default:
+		return "none"
+	}
+}
+
 func ChkPrefix(src []byte) bool {
 	if len(src) == 0 {
 		return false